@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"hermes/server"
 	"hermes/store"
 	"hermes/wal"
@@ -8,19 +9,53 @@ import (
 )
 
 func main() {
-	s := store.NewShardedStore(16)
-	w, err := wal.NewWAL(wal.Config{Path: "log.log", SyncPolicy: wal.SyncEveryWrite})
+	engine := flag.String("engine", "memory", "storage engine: memory (sharded, WAL-backed), ordered (ordered keys, WAL-backed), loops (one event loop per core, WAL-backed) or bitcask")
+	dataDir := flag.String("data", "data", "data directory of the bitcask engine")
+	compressAbove := flag.Int("compress", 0, "compress values of at least this many bytes (0 disables compression)")
+	flag.Parse()
+
+	clock := store.SystemClock()
+
+	var newStore store.DataStore
+	var err error
+	switch *engine {
+	case "memory":
+		newStore, err = openMemory(store.NewShardedStore(16, store.WithClock(clock)), clock)
+	case "ordered":
+		// Serves RANGE, REVRANGE and PREFIX, behind one global lock
+		newStore, err = openMemory(store.NewOrderedStore(store.WithClock(clock)), clock)
+	case "loops":
+		// Requests queue per loop; a client that hangs up stops waiting
+		newStore, err = openMemory(store.NewMultiLoopStore(0, 1024, store.WithClock(clock)), clock)
+	case "bitcask":
+		// The data files are the log: no WAL or snapshots needed
+		newStore, err = store.NewBitcaskStore(*dataDir, store.WithClock(clock))
+	default:
+		panic("unknown engine " + *engine)
+	}
 	if err != nil {
 		panic(err)
 	}
+	// Only new values depend on the flag: the server decodes values an
+	// earlier run stored compressed either way
+	if *compressAbove > 0 {
+		newStore = store.NewCompressedStore(newStore, *compressAbove)
+	}
 
-	path := "snanshot.log"
-	snapshotInterval := time.Duration(1 * time.Minute)
-	newStore, err := store.NewWalStore(s, w, path, snapshotInterval)
+	server := server.NewServer(":8080", newStore, server.WithClock(clock))
+	server.Start() // check by nc localhost 8080
+}
+
+/*
+openMemory makes the in-memory store s durable, behind the WAL.
+*/
+func openMemory(s store.DataStore, clock store.Clock) (store.DataStore, error) {
+	w, err := wal.NewWAL(wal.Config{Path: "log.log", SyncPolicy: wal.SyncEveryWrite})
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	server := server.NewServer(":8080", newStore)
-	server.Start() // check by nc localhost 8080
+	path := "snanshot.log"
+	snapshotInterval := time.Duration(1 * time.Minute)
+	return store.NewWalStore(s, w, path, snapshotInterval, store.WithClock(clock))
 }
@@ -1,7 +1,9 @@
 package protocol
 
 import (
+	"encoding/json"
 	"errors"
+	"math"
 	"strconv"
 )
 
@@ -33,3 +35,56 @@ func (a argTypeInt) Validate(val string) error {
 	}
 	return nil
 }
+
+/*
+argTypeUint represents an unsigned 64-bit integer, such as a scan cursor.
+*/
+type argTypeUint struct{}
+
+func (a argTypeUint) Validate(val string) error {
+	if _, err := strconv.ParseUint(val, 10, 64); err != nil {
+		return ErrInvalidArg
+	}
+	return nil
+}
+
+/*
+argTypeFloat represents a number, such as a sorted set score. inf and
+-inf are numbers; NaN is not.
+*/
+type argTypeFloat struct{}
+
+func (a argTypeFloat) Validate(val string) error {
+	f, err := strconv.ParseFloat(val, 64)
+	if err != nil || math.IsNaN(f) {
+		return ErrInvalidArg
+	}
+	return nil
+}
+
+/*
+argTypeTimeout represents a blocking timeout in seconds: a finite,
+non-negative number, 0 meaning no timeout.
+*/
+type argTypeTimeout struct{}
+
+func (a argTypeTimeout) Validate(val string) error {
+	f, err := strconv.ParseFloat(val, 64)
+	if err != nil || f < 0 || math.IsInf(f, 0) || math.IsNaN(f) {
+		return ErrInvalidArg
+	}
+	return nil
+}
+
+/*
+argTypeJSON represents a JSON value. The protocol splits arguments on
+whitespace, so it must be written without any.
+*/
+type argTypeJSON struct{}
+
+func (a argTypeJSON) Validate(val string) error {
+	if !json.Valid([]byte(val)) {
+		return ErrInvalidArg
+	}
+	return nil
+}
@@ -53,3 +53,51 @@ func TestArgTypeInt_InvalidIntegers(t *testing.T) {
 		}
 	}
 }
+
+func TestArgTypeUint(t *testing.T) {
+	arg := argTypeUint{}
+
+	for _, val := range []string{"0", "42", "18446744073709551615"} {
+		if err := arg.Validate(val); err != nil {
+			t.Fatalf("expected %q to be valid, got error: %v", val, err)
+		}
+	}
+
+	for _, val := range []string{"-1", "abc", "18446744073709551616", ""} {
+		if err := arg.Validate(val); err != ErrInvalidArg {
+			t.Fatalf("expected %q to be invalid, got %v", val, err)
+		}
+	}
+}
+
+func TestArgTypeTimeout(t *testing.T) {
+	arg := argTypeTimeout{}
+
+	for _, val := range []string{"0", "1", "0.25", "1e3"} {
+		if err := arg.Validate(val); err != nil {
+			t.Fatalf("expected %q to be valid, got error: %v", val, err)
+		}
+	}
+
+	for _, val := range []string{"-1", "-0.5", "abc", "inf", "NaN", ""} {
+		if err := arg.Validate(val); err != ErrInvalidArg {
+			t.Fatalf("expected %q to be invalid, got %v", val, err)
+		}
+	}
+}
+
+func TestArgTypeFloat(t *testing.T) {
+	arg := argTypeFloat{}
+
+	for _, val := range []string{"0", "-1.5", "1e3", "inf", "-inf", "+inf"} {
+		if err := arg.Validate(val); err != nil {
+			t.Fatalf("expected %q to be valid, got error: %v", val, err)
+		}
+	}
+
+	for _, val := range []string{"NaN", "abc", ""} {
+		if err := arg.Validate(val); err != ErrInvalidArg {
+			t.Fatalf("expected %q to be invalid, got %v", val, err)
+		}
+	}
+}
@@ -17,14 +17,135 @@ const (
 	CommandGet    = "GET"
 	CommandSet    = "SET"
 	CommandExpire = "EXPIRE"
+	CommandMGet   = "MGET"
+	CommandMSet   = "MSET"
+	CommandMSetNX = "MSETNX"
+	CommandScan   = "SCAN"
+	CommandKeys   = "KEYS"
+
+	CommandRange    = "RANGE"
+	CommandRevRange = "REVRANGE"
+	CommandPrefix   = "PREFIX"
+
+	CommandHistory = "HISTORY"
+
+	CommandType = "TYPE"
+
+	CommandLPush  = "LPUSH"
+	CommandRPush  = "RPUSH"
+	CommandLPop   = "LPOP"
+	CommandRPop   = "RPOP"
+	CommandLLen   = "LLEN"
+	CommandLRange = "LRANGE"
+	CommandLIndex = "LINDEX"
+	CommandLTrim  = "LTRIM"
+	CommandBLPop  = "BLPOP"
+	CommandBRPop  = "BRPOP"
+
+	CommandHSet    = "HSET"
+	CommandHGet    = "HGET"
+	CommandHMGet   = "HMGET"
+	CommandHDel    = "HDEL"
+	CommandHGetAll = "HGETALL"
+	CommandHIncrBy = "HINCRBY"
+	CommandHExists = "HEXISTS"
+	CommandHLen    = "HLEN"
+	CommandHScan   = "HSCAN"
+
+	CommandSAdd      = "SADD"
+	CommandSRem      = "SREM"
+	CommandSIsMember = "SISMEMBER"
+	CommandSMembers  = "SMEMBERS"
+	CommandSInter    = "SINTER"
+	CommandSUnion    = "SUNION"
+	CommandSDiff     = "SDIFF"
+
+	CommandZAdd          = "ZADD"
+	CommandZIncrBy       = "ZINCRBY"
+	CommandZRange        = "ZRANGE"
+	CommandZRangeByScore = "ZRANGEBYSCORE"
+	CommandZRank         = "ZRANK"
+	CommandZRem          = "ZREM"
+	CommandZCard         = "ZCARD"
+
+	CommandXAdd       = "XADD"
+	CommandXRange     = "XRANGE"
+	CommandXRevRange  = "XREVRANGE"
+	CommandXLen       = "XLEN"
+	CommandXTrim      = "XTRIM"
+	CommandXRead      = "XREAD"
+	CommandXGroup     = "XGROUP"
+	CommandXReadGroup = "XREADGROUP"
+	CommandXAck       = "XACK"
+	CommandXPending   = "XPENDING"
+	CommandXClaim     = "XCLAIM"
+
+	CommandJSONSet       = "JSON.SET"
+	CommandJSONGet       = "JSON.GET"
+	CommandJSONDel       = "JSON.DEL"
+	CommandJSONNumIncrBy = "JSON.NUMINCRBY"
+	CommandJSONArrAppend = "JSON.ARRAPPEND"
+
+	CommandMulti   = "MULTI"
+	CommandExec    = "EXEC"
+	CommandDiscard = "DISCARD"
+	CommandWatch   = "WATCH"
+	CommandUnwatch = "UNWATCH"
+)
+
+/*
+Option names used by commands with optional arguments.
+*/
+const (
+	OptionMatch = "MATCH"
+	OptionCount = "COUNT"
+	OptionLimit = "LIMIT"
+
+	// OptionAt and OptionVersion make GET read a key's past value.
+	OptionAt      = "AT"
+	OptionVersion = "VERSION"
+
+	// FlagWithScores makes sorted set ranges reply with scores.
+	FlagWithScores = "WITHSCORES"
+
+	// FlagNX and FlagXX make JSON.SET only add missing values, or only
+	// replace existing ones.
+	FlagNX = "NX"
+	FlagXX = "XX"
 )
 
 /*
-CommandSpec defines a command name and expected argument types
+CommandSpec defines a command name and expected argument types.
+
+RepeatArgTypes describes variadic commands: after the fixed ArgTypes,
+the group must appear one or more times (e.g. MSET key value [key value ...]).
+TrailingArgTypes then follow the repeated group (e.g. the timeout of
+BLPOP key [key ...] timeout).
+
+Options lists named optional arguments that may follow the fixed
+ones, each given at most once and followed by one value
+(e.g. SCAN cursor [MATCH pattern] [COUNT n]). Flags are named
+optional arguments given alone (e.g. ZRANGE key start stop
+[WITHSCORES]). Option and flag names are case-insensitive and
+normalized to upper case when parsed.
+
+Check validates arguments laid out in ways the fields above cannot
+describe, such as the subcommands of XGROUP or the STREAMS list of
+XREAD; the fixed ArgTypes are still validated first. It checks the
+argument count itself, and upper-cases keywords in place.
+
+Keys extracts the key arguments, which lets callers lock or watch
+them before execution. It is nil for commands that touch no keys.
 */
 type CommandSpec struct {
-	Name     string
-	ArgTypes []ArgType
+	Name             string
+	ArgTypes         []ArgType
+	RepeatArgTypes   []ArgType
+	TrailingArgTypes []ArgType
+	Options          map[string]ArgType
+	Flags            map[string]bool
+	Check            func(args []string) error
+	Keys             func(args []string) []string
 }
 
 /*
@@ -34,14 +155,374 @@ var commandSpec = map[string]CommandSpec{
 	CommandGet: {
 		Name:     CommandGet,
 		ArgTypes: []ArgType{argTypeString{}},
+		Options: map[string]ArgType{
+			OptionAt:      argTypeInt{},
+			OptionVersion: argTypeUint{},
+		},
+		Keys: firstKey,
 	},
 	CommandSet: {
 		Name:     CommandSet,
 		ArgTypes: []ArgType{argTypeString{}, argTypeString{}},
+		Keys:     firstKey,
 	},
 	CommandExpire: {
 		Name:     CommandExpire,
 		ArgTypes: []ArgType{argTypeString{}, argTypeInt{}},
+		Keys:     firstKey,
+	},
+	CommandMGet: {
+		Name:           CommandMGet,
+		RepeatArgTypes: []ArgType{argTypeString{}},
+		Keys:           everyKey(1),
+	},
+	CommandMSet: {
+		Name:           CommandMSet,
+		RepeatArgTypes: []ArgType{argTypeString{}, argTypeString{}},
+		Keys:           everyKey(2),
+	},
+	CommandMSetNX: {
+		Name:           CommandMSetNX,
+		RepeatArgTypes: []ArgType{argTypeString{}, argTypeString{}},
+		Keys:           everyKey(2),
+	},
+	CommandScan: {
+		Name:     CommandScan,
+		ArgTypes: []ArgType{argTypeUint{}},
+		Options: map[string]ArgType{
+			OptionMatch: argTypeString{},
+			OptionCount: argTypeInt{},
+		},
+	},
+	CommandKeys: {
+		Name:     CommandKeys,
+		ArgTypes: []ArgType{argTypeString{}},
+	},
+	CommandRange: {
+		Name:     CommandRange,
+		ArgTypes: []ArgType{argTypeString{}, argTypeString{}},
+		Options: map[string]ArgType{
+			OptionLimit: argTypeInt{},
+		},
+	},
+	CommandRevRange: {
+		Name:     CommandRevRange,
+		ArgTypes: []ArgType{argTypeString{}, argTypeString{}},
+		Options: map[string]ArgType{
+			OptionLimit: argTypeInt{},
+		},
+	},
+	CommandPrefix: {
+		Name:     CommandPrefix,
+		ArgTypes: []ArgType{argTypeString{}},
+		Options: map[string]ArgType{
+			OptionLimit: argTypeInt{},
+		},
+	},
+	CommandHistory: {
+		Name:     CommandHistory,
+		ArgTypes: []ArgType{argTypeString{}},
+		Keys:     firstKey,
+	},
+	CommandType: {
+		Name:     CommandType,
+		ArgTypes: []ArgType{argTypeString{}},
+		Keys:     firstKey,
+	},
+	CommandLPush: {
+		Name:           CommandLPush,
+		ArgTypes:       []ArgType{argTypeString{}},
+		RepeatArgTypes: []ArgType{argTypeString{}},
+		Keys:           firstKey,
+	},
+	CommandRPush: {
+		Name:           CommandRPush,
+		ArgTypes:       []ArgType{argTypeString{}},
+		RepeatArgTypes: []ArgType{argTypeString{}},
+		Keys:           firstKey,
+	},
+	CommandLPop: {
+		Name:     CommandLPop,
+		ArgTypes: []ArgType{argTypeString{}},
+		Keys:     firstKey,
+	},
+	CommandRPop: {
+		Name:     CommandRPop,
+		ArgTypes: []ArgType{argTypeString{}},
+		Keys:     firstKey,
+	},
+	CommandLLen: {
+		Name:     CommandLLen,
+		ArgTypes: []ArgType{argTypeString{}},
+		Keys:     firstKey,
+	},
+	CommandLRange: {
+		Name:     CommandLRange,
+		ArgTypes: []ArgType{argTypeString{}, argTypeInt{}, argTypeInt{}},
+		Keys:     firstKey,
+	},
+	CommandLIndex: {
+		Name:     CommandLIndex,
+		ArgTypes: []ArgType{argTypeString{}, argTypeInt{}},
+		Keys:     firstKey,
+	},
+	CommandLTrim: {
+		Name:     CommandLTrim,
+		ArgTypes: []ArgType{argTypeString{}, argTypeInt{}, argTypeInt{}},
+		Keys:     firstKey,
+	},
+	CommandBLPop: {
+		Name:             CommandBLPop,
+		RepeatArgTypes:   []ArgType{argTypeString{}},
+		TrailingArgTypes: []ArgType{argTypeTimeout{}},
+		Keys:             allButLast,
+	},
+	CommandBRPop: {
+		Name:             CommandBRPop,
+		RepeatArgTypes:   []ArgType{argTypeString{}},
+		TrailingArgTypes: []ArgType{argTypeTimeout{}},
+		Keys:             allButLast,
+	},
+	CommandHSet: {
+		Name:           CommandHSet,
+		ArgTypes:       []ArgType{argTypeString{}},
+		RepeatArgTypes: []ArgType{argTypeString{}, argTypeString{}},
+		Keys:           firstKey,
+	},
+	CommandHGet: {
+		Name:     CommandHGet,
+		ArgTypes: []ArgType{argTypeString{}, argTypeString{}},
+		Keys:     firstKey,
+	},
+	CommandHMGet: {
+		Name:           CommandHMGet,
+		ArgTypes:       []ArgType{argTypeString{}},
+		RepeatArgTypes: []ArgType{argTypeString{}},
+		Keys:           firstKey,
+	},
+	CommandHDel: {
+		Name:           CommandHDel,
+		ArgTypes:       []ArgType{argTypeString{}},
+		RepeatArgTypes: []ArgType{argTypeString{}},
+		Keys:           firstKey,
+	},
+	CommandHGetAll: {
+		Name:     CommandHGetAll,
+		ArgTypes: []ArgType{argTypeString{}},
+		Keys:     firstKey,
+	},
+	CommandHIncrBy: {
+		Name:     CommandHIncrBy,
+		ArgTypes: []ArgType{argTypeString{}, argTypeString{}, argTypeInt{}},
+		Keys:     firstKey,
+	},
+	CommandHExists: {
+		Name:     CommandHExists,
+		ArgTypes: []ArgType{argTypeString{}, argTypeString{}},
+		Keys:     firstKey,
+	},
+	CommandHLen: {
+		Name:     CommandHLen,
+		ArgTypes: []ArgType{argTypeString{}},
+		Keys:     firstKey,
+	},
+	CommandHScan: {
+		Name:     CommandHScan,
+		ArgTypes: []ArgType{argTypeString{}, argTypeUint{}},
+		Options: map[string]ArgType{
+			OptionMatch: argTypeString{},
+			OptionCount: argTypeInt{},
+		},
+		Keys: firstKey,
+	},
+	CommandSAdd: {
+		Name:           CommandSAdd,
+		ArgTypes:       []ArgType{argTypeString{}},
+		RepeatArgTypes: []ArgType{argTypeString{}},
+		Keys:           firstKey,
+	},
+	CommandSRem: {
+		Name:           CommandSRem,
+		ArgTypes:       []ArgType{argTypeString{}},
+		RepeatArgTypes: []ArgType{argTypeString{}},
+		Keys:           firstKey,
+	},
+	CommandSIsMember: {
+		Name:     CommandSIsMember,
+		ArgTypes: []ArgType{argTypeString{}, argTypeString{}},
+		Keys:     firstKey,
+	},
+	CommandSMembers: {
+		Name:     CommandSMembers,
+		ArgTypes: []ArgType{argTypeString{}},
+		Keys:     firstKey,
+	},
+	CommandSInter: {
+		Name:           CommandSInter,
+		RepeatArgTypes: []ArgType{argTypeString{}},
+		Keys:           everyKey(1),
+	},
+	CommandSUnion: {
+		Name:           CommandSUnion,
+		RepeatArgTypes: []ArgType{argTypeString{}},
+		Keys:           everyKey(1),
+	},
+	CommandSDiff: {
+		Name:           CommandSDiff,
+		RepeatArgTypes: []ArgType{argTypeString{}},
+		Keys:           everyKey(1),
+	},
+	CommandZAdd: {
+		Name:           CommandZAdd,
+		ArgTypes:       []ArgType{argTypeString{}},
+		RepeatArgTypes: []ArgType{argTypeFloat{}, argTypeString{}},
+		Keys:           firstKey,
+	},
+	CommandZIncrBy: {
+		Name:     CommandZIncrBy,
+		ArgTypes: []ArgType{argTypeString{}, argTypeFloat{}, argTypeString{}},
+		Keys:     firstKey,
+	},
+	CommandZRange: {
+		Name:     CommandZRange,
+		ArgTypes: []ArgType{argTypeString{}, argTypeInt{}, argTypeInt{}},
+		Flags:    map[string]bool{FlagWithScores: true},
+		Keys:     firstKey,
+	},
+	CommandZRangeByScore: {
+		Name:     CommandZRangeByScore,
+		ArgTypes: []ArgType{argTypeString{}, argTypeString{}, argTypeString{}},
+		Flags:    map[string]bool{FlagWithScores: true},
+		Keys:     firstKey,
+	},
+	CommandZRank: {
+		Name:     CommandZRank,
+		ArgTypes: []ArgType{argTypeString{}, argTypeString{}},
+		Keys:     firstKey,
+	},
+	CommandZRem: {
+		Name:           CommandZRem,
+		ArgTypes:       []ArgType{argTypeString{}},
+		RepeatArgTypes: []ArgType{argTypeString{}},
+		Keys:           firstKey,
+	},
+	CommandZCard: {
+		Name:     CommandZCard,
+		ArgTypes: []ArgType{argTypeString{}},
+		Keys:     firstKey,
+	},
+	CommandXAdd: {
+		Name:           CommandXAdd,
+		ArgTypes:       []ArgType{argTypeString{}, argTypeString{}},
+		RepeatArgTypes: []ArgType{argTypeString{}, argTypeString{}},
+		Keys:           firstKey,
+	},
+	CommandXRange: {
+		Name:     CommandXRange,
+		ArgTypes: []ArgType{argTypeString{}, argTypeString{}, argTypeString{}},
+		Options: map[string]ArgType{
+			OptionCount: argTypeUint{},
+		},
+		Keys: firstKey,
+	},
+	CommandXRevRange: {
+		Name:     CommandXRevRange,
+		ArgTypes: []ArgType{argTypeString{}, argTypeString{}, argTypeString{}},
+		Options: map[string]ArgType{
+			OptionCount: argTypeUint{},
+		},
+		Keys: firstKey,
+	},
+	CommandXLen: {
+		Name:     CommandXLen,
+		ArgTypes: []ArgType{argTypeString{}},
+		Keys:     firstKey,
+	},
+	CommandXTrim: {
+		Name:     CommandXTrim,
+		ArgTypes: []ArgType{argTypeString{}, argTypeString{}, argTypeString{}},
+		Check:    checkXTrim,
+		Keys:     firstKey,
+	},
+	CommandXRead: {
+		Name:  CommandXRead,
+		Check: checkStreamRead(false),
+		Keys:  streamReadKeys,
+	},
+	CommandXGroup: {
+		Name:     CommandXGroup,
+		ArgTypes: []ArgType{argTypeString{}, argTypeString{}, argTypeString{}},
+		Check:    checkXGroup,
+		Keys:     secondKey,
+	},
+	CommandXReadGroup: {
+		Name:  CommandXReadGroup,
+		Check: checkStreamRead(true),
+		Keys:  streamReadKeys,
+	},
+	CommandXAck: {
+		Name:           CommandXAck,
+		ArgTypes:       []ArgType{argTypeString{}, argTypeString{}},
+		RepeatArgTypes: []ArgType{argTypeString{}},
+		Keys:           firstKey,
+	},
+	CommandXPending: {
+		Name:     CommandXPending,
+		ArgTypes: []ArgType{argTypeString{}, argTypeString{}},
+		Check:    checkXPending,
+		Keys:     firstKey,
+	},
+	CommandXClaim: {
+		Name:           CommandXClaim,
+		ArgTypes:       []ArgType{argTypeString{}, argTypeString{}, argTypeString{}, argTypeUint{}},
+		RepeatArgTypes: []ArgType{argTypeString{}},
+		Keys:           firstKey,
+	},
+	CommandJSONSet: {
+		Name:     CommandJSONSet,
+		ArgTypes: []ArgType{argTypeString{}, argTypeString{}, argTypeJSON{}},
+		Flags:    map[string]bool{FlagNX: true, FlagXX: true},
+		Keys:     firstKey,
+	},
+	CommandJSONGet: {
+		Name:     CommandJSONGet,
+		ArgTypes: []ArgType{argTypeString{}},
+		Check:    maxArgs(-1),
+		Keys:     firstKey,
+	},
+	CommandJSONDel: {
+		Name:     CommandJSONDel,
+		ArgTypes: []ArgType{argTypeString{}},
+		Check:    maxArgs(2),
+		Keys:     firstKey,
+	},
+	CommandJSONNumIncrBy: {
+		Name:     CommandJSONNumIncrBy,
+		ArgTypes: []ArgType{argTypeString{}, argTypeString{}, argTypeFloat{}},
+		Keys:     firstKey,
+	},
+	CommandJSONArrAppend: {
+		Name:           CommandJSONArrAppend,
+		ArgTypes:       []ArgType{argTypeString{}, argTypeString{}},
+		RepeatArgTypes: []ArgType{argTypeJSON{}},
+		Keys:           firstKey,
+	},
+	CommandMulti: {
+		Name: CommandMulti,
+	},
+	CommandExec: {
+		Name: CommandExec,
+	},
+	CommandDiscard: {
+		Name: CommandDiscard,
+	},
+	CommandWatch: {
+		Name:           CommandWatch,
+		RepeatArgTypes: []ArgType{argTypeString{}},
+		Keys:           everyKey(1),
+	},
+	CommandUnwatch: {
+		Name: CommandUnwatch,
 	},
 }
 
@@ -53,6 +534,60 @@ type Command struct {
 	Args []string
 }
 
+/*
+Option returns the value of a named optional argument and whether it
+was given. name must be upper case.
+*/
+func (c Command) Option(name string) (string, bool) {
+	spec, ok := commandSpec[c.Name]
+	if !ok {
+		return "", false
+	}
+
+	for i := len(spec.ArgTypes); i+1 < len(c.Args); i++ {
+		if spec.Flags[c.Args[i]] {
+			continue
+		}
+		if c.Args[i] == name {
+			return c.Args[i+1], true
+		}
+		i++ // skip the option's value
+	}
+	return "", false
+}
+
+/*
+Flag reports whether a flag was given. name must be upper case.
+*/
+func (c Command) Flag(name string) bool {
+	spec, ok := commandSpec[c.Name]
+	if !ok || !spec.Flags[name] {
+		return false
+	}
+
+	for i := len(spec.ArgTypes); i < len(c.Args); i++ {
+		if spec.Flags[c.Args[i]] {
+			if c.Args[i] == name {
+				return true
+			}
+			continue
+		}
+		i++ // skip the option's value
+	}
+	return false
+}
+
+/*
+Keys returns the key arguments of the command, if any.
+*/
+func (c Command) Keys() []string {
+	spec, ok := commandSpec[c.Name]
+	if !ok || spec.Keys == nil {
+		return nil
+	}
+	return spec.Keys(c.Args)
+}
+
 /*
 ParseLine parses a single protocol line into a Command.
 
@@ -77,18 +612,156 @@ func ParseLine(line string) (Command, error) {
 		return Command{}, ErrInvalidCommand
 	}
 
-	if len(args) != len(spec.ArgTypes) {
+	if !spec.acceptsArgCount(len(args)) {
 		return Command{}, ErrInvalidCommand
 	}
 
-	for i, argType := range spec.ArgTypes {
-		if err := argType.Validate(args[i]); err != nil {
+	fixed := len(args)
+	switch {
+	case spec.takesNamedArgs():
+		fixed = len(spec.ArgTypes)
+		if err := spec.parseOptions(args[fixed:]); err != nil {
+			return Command{}, err
+		}
+	case spec.Check != nil:
+		fixed = len(spec.ArgTypes)
+	}
+
+	for i, arg := range args[:fixed] {
+		if err := spec.argType(i, fixed).Validate(arg); err != nil {
 			return Command{}, ErrInvalidArg
 		}
 	}
 
+	if spec.Check != nil {
+		if err := spec.Check(args); err != nil {
+			return Command{}, err
+		}
+	}
+
 	return Command{
 		Name: cmd,
 		Args: args,
 	}, nil
 }
+
+/*
+acceptsArgCount reports whether n arguments satisfy the spec.
+*/
+func (s CommandSpec) acceptsArgCount(n int) bool {
+	fixed := len(s.ArgTypes)
+	if s.takesNamedArgs() || s.Check != nil {
+		// Named arguments are checked as they are parsed, and the rest
+		// by Check
+		return n >= fixed
+	}
+	if len(s.RepeatArgTypes) == 0 {
+		return n == fixed
+	}
+
+	repeated := n - fixed - len(s.TrailingArgTypes)
+	return repeated > 0 && repeated%len(s.RepeatArgTypes) == 0
+}
+
+/*
+argType returns the expected type of the i-th of n arguments.
+*/
+func (s CommandSpec) argType(i, n int) ArgType {
+	if i < len(s.ArgTypes) {
+		return s.ArgTypes[i]
+	}
+	if trailing := i - (n - len(s.TrailingArgTypes)); trailing >= 0 {
+		return s.TrailingArgTypes[trailing]
+	}
+	return s.RepeatArgTypes[(i-len(s.ArgTypes))%len(s.RepeatArgTypes)]
+}
+
+/*
+takesNamedArgs reports whether options or flags may follow the fixed
+arguments.
+*/
+func (s CommandSpec) takesNamedArgs() bool {
+	return s.Options != nil || s.Flags != nil
+}
+
+/*
+parseOptions validates flags and name/value pairs against the spec's
+flags and options and upper-cases the names in place. Unknown or
+repeated names, and options without a value, make the command invalid.
+*/
+func (s CommandSpec) parseOptions(args []string) error {
+	seen := make(map[string]bool, len(args))
+	for i := 0; i < len(args); i++ {
+		name := strings.ToUpper(args[i])
+		if seen[name] {
+			return ErrInvalidCommand
+		}
+		seen[name] = true
+		args[i] = name
+
+		if s.Flags[name] {
+			continue
+		}
+
+		argType, ok := s.Options[name]
+		if !ok || i+1 == len(args) {
+			return ErrInvalidCommand
+		}
+		i++
+		if err := argType.Validate(args[i]); err != nil {
+			return ErrInvalidArg
+		}
+	}
+	return nil
+}
+
+/*
+maxArgs returns a Check for commands taking optional string arguments
+after the fixed ones, up to n arguments in all, n < 0 meaning no
+bound (e.g. JSON.GET key [path ...]).
+*/
+func maxArgs(n int) func(args []string) error {
+	return func(args []string) error {
+		if n >= 0 && len(args) > n {
+			return ErrInvalidCommand
+		}
+		return nil
+	}
+}
+
+/*
+firstKey is the key extractor for single-key commands.
+*/
+func firstKey(args []string) []string {
+	return args[:1]
+}
+
+/*
+secondKey is the key extractor for commands naming their key after a
+subcommand, such as XGROUP CREATE key.
+*/
+func secondKey(args []string) []string {
+	return args[1:2]
+}
+
+/*
+allButLast is the key extractor for commands listing keys before one
+last argument, such as the timeout of BLPOP.
+*/
+func allButLast(args []string) []string {
+	return args[:len(args)-1]
+}
+
+/*
+everyKey returns a key extractor for commands whose arguments repeat
+in groups of step, each group starting with a key.
+*/
+func everyKey(step int) func(args []string) []string {
+	return func(args []string) []string {
+		keys := make([]string, 0, len(args)/step)
+		for i := 0; i < len(args); i += step {
+			keys = append(keys, args[i])
+		}
+		return keys
+	}
+}
@@ -1,6 +1,9 @@
 package protocol
 
-import "testing"
+import (
+	"testing"
+	"time"
+)
 
 func TestParseLine_ValidCommands(t *testing.T) {
 	tests := []struct {
@@ -15,6 +18,78 @@ func TestParseLine_ValidCommands(t *testing.T) {
 			wantCmd:  CommandGet,
 			wantArgs: []string{"key"},
 		},
+		{
+			name:     "GET AT",
+			input:    "GET key at 1700000000000",
+			wantCmd:  CommandGet,
+			wantArgs: []string{"key", OptionAt, "1700000000000"},
+		},
+		{
+			name:     "GET VERSION",
+			input:    "GET key VERSION 42",
+			wantCmd:  CommandGet,
+			wantArgs: []string{"key", OptionVersion, "42"},
+		},
+		{
+			name:     "HISTORY command",
+			input:    "HISTORY key",
+			wantCmd:  CommandHistory,
+			wantArgs: []string{"key"},
+		},
+		{
+			name:     "TYPE command",
+			input:    "type key",
+			wantCmd:  CommandType,
+			wantArgs: []string{"key"},
+		},
+		{
+			name:     "LPUSH command",
+			input:    "lpush list a b",
+			wantCmd:  CommandLPush,
+			wantArgs: []string{"list", "a", "b"},
+		},
+		{
+			name:     "LRANGE command",
+			input:    "LRANGE list 0 -1",
+			wantCmd:  CommandLRange,
+			wantArgs: []string{"list", "0", "-1"},
+		},
+		{
+			name:     "BLPOP command",
+			input:    "BLPOP a b 0.5",
+			wantCmd:  CommandBLPop,
+			wantArgs: []string{"a", "b", "0.5"},
+		},
+		{
+			name:     "HSET command",
+			input:    "HSET user name hermes age 3",
+			wantCmd:  CommandHSet,
+			wantArgs: []string{"user", "name", "hermes", "age", "3"},
+		},
+		{
+			name:     "HSCAN command",
+			input:    "HSCAN user 0 match n* COUNT 5",
+			wantCmd:  CommandHScan,
+			wantArgs: []string{"user", "0", OptionMatch, "n*", OptionCount, "5"},
+		},
+		{
+			name:     "ZADD command",
+			input:    "ZADD board 1.5 ann -inf bob",
+			wantCmd:  CommandZAdd,
+			wantArgs: []string{"board", "1.5", "ann", "-inf", "bob"},
+		},
+		{
+			name:     "ZRANGE WITHSCORES",
+			input:    "ZRANGE board 0 -1 withscores",
+			wantCmd:  CommandZRange,
+			wantArgs: []string{"board", "0", "-1", FlagWithScores},
+		},
+		{
+			name:     "SINTER command",
+			input:    "SINTER a b c",
+			wantCmd:  CommandSInter,
+			wantArgs: []string{"a", "b", "c"},
+		},
 		{
 			name:     "SET command",
 			input:    "SET a b",
@@ -27,6 +102,60 @@ func TestParseLine_ValidCommands(t *testing.T) {
 			wantCmd:  CommandExpire,
 			wantArgs: []string{"key", "10"},
 		},
+		{
+			name:     "MGET single key",
+			input:    "MGET a",
+			wantCmd:  CommandMGet,
+			wantArgs: []string{"a"},
+		},
+		{
+			name:     "MGET many keys",
+			input:    "MGET a b c",
+			wantCmd:  CommandMGet,
+			wantArgs: []string{"a", "b", "c"},
+		},
+		{
+			name:     "MSET pairs",
+			input:    "MSET a 1 b 2",
+			wantCmd:  CommandMSet,
+			wantArgs: []string{"a", "1", "b", "2"},
+		},
+		{
+			name:     "MSETNX pair",
+			input:    "MSETNX a 1",
+			wantCmd:  CommandMSetNX,
+			wantArgs: []string{"a", "1"},
+		},
+		{
+			name:     "SCAN cursor only",
+			input:    "SCAN 0",
+			wantCmd:  CommandScan,
+			wantArgs: []string{"0"},
+		},
+		{
+			name:     "SCAN options normalized",
+			input:    "scan 17 match user:* count 10",
+			wantCmd:  CommandScan,
+			wantArgs: []string{"17", "MATCH", "user:*", "COUNT", "10"},
+		},
+		{
+			name:     "KEYS pattern",
+			input:    "KEYS *",
+			wantCmd:  CommandKeys,
+			wantArgs: []string{"*"},
+		},
+		{
+			name:     "RANGE with limit",
+			input:    "RANGE a z limit 5",
+			wantCmd:  CommandRange,
+			wantArgs: []string{"a", "z", "LIMIT", "5"},
+		},
+		{
+			name:     "PREFIX",
+			input:    "PREFIX user:",
+			wantCmd:  CommandPrefix,
+			wantArgs: []string{"user:"},
+		},
 		{
 			name:     "case insensitive command",
 			input:    "get mykey",
@@ -80,6 +209,71 @@ func TestParseLine_InvalidCommands(t *testing.T) {
 			input: "UNKNOWN a b",
 			err:   ErrInvalidCommand,
 		},
+		{
+			name:  "GET AT without time",
+			input: "GET key AT",
+			err:   ErrInvalidCommand,
+		},
+		{
+			name:  "GET VERSION not a number",
+			input: "GET key VERSION x",
+			err:   ErrInvalidArg,
+		},
+		{
+			name:  "HISTORY extra argument",
+			input: "HISTORY a b",
+			err:   ErrInvalidCommand,
+		},
+		{
+			name:  "TYPE without key",
+			input: "TYPE",
+			err:   ErrInvalidCommand,
+		},
+		{
+			name:  "LPUSH without elements",
+			input: "LPUSH list",
+			err:   ErrInvalidCommand,
+		},
+		{
+			name:  "LINDEX non-numeric index",
+			input: "LINDEX list first",
+			err:   ErrInvalidArg,
+		},
+		{
+			name:  "BLPOP without keys",
+			input: "BLPOP 0",
+			err:   ErrInvalidCommand,
+		},
+		{
+			name:  "BRPOP negative timeout",
+			input: "BRPOP a -1",
+			err:   ErrInvalidArg,
+		},
+		{
+			name:  "HSET dangling field",
+			input: "HSET user name",
+			err:   ErrInvalidCommand,
+		},
+		{
+			name:  "HINCRBY non-numeric increment",
+			input: "HINCRBY user age many",
+			err:   ErrInvalidArg,
+		},
+		{
+			name:  "ZADD NaN score",
+			input: "ZADD board nan ann",
+			err:   ErrInvalidArg,
+		},
+		{
+			name:  "ZRANGE repeated flag",
+			input: "ZRANGE board 0 -1 WITHSCORES WITHSCORES",
+			err:   ErrInvalidCommand,
+		},
+		{
+			name:  "ZRANGE unknown flag",
+			input: "ZRANGE board 0 -1 SCORES",
+			err:   ErrInvalidCommand,
+		},
 		{
 			name:  "missing arguments",
 			input: "GET",
@@ -90,11 +284,96 @@ func TestParseLine_InvalidCommands(t *testing.T) {
 			input: "GET a b",
 			err:   ErrInvalidCommand,
 		},
+		{
+			name:  "MGET without keys",
+			input: "MGET",
+			err:   ErrInvalidCommand,
+		},
+		{
+			name:  "MSET without pairs",
+			input: "MSET",
+			err:   ErrInvalidCommand,
+		},
+		{
+			name:  "MSET dangling key",
+			input: "MSET a 1 b",
+			err:   ErrInvalidCommand,
+		},
+		{
+			name:  "SCAN without cursor",
+			input: "SCAN",
+			err:   ErrInvalidCommand,
+		},
+		{
+			name:  "SCAN option without value",
+			input: "SCAN 0 MATCH",
+			err:   ErrInvalidCommand,
+		},
+		{
+			name:  "SCAN unknown option",
+			input: "SCAN 0 TYPE string",
+			err:   ErrInvalidCommand,
+		},
+		{
+			name:  "SCAN repeated option",
+			input: "SCAN 0 COUNT 1 COUNT 2",
+			err:   ErrInvalidCommand,
+		},
+		{
+			name:  "SCAN negative cursor",
+			input: "SCAN -1",
+			err:   ErrInvalidArg,
+		},
+		{
+			name:  "SCAN non-numeric count",
+			input: "SCAN 0 COUNT many",
+			err:   ErrInvalidArg,
+		},
 		{
 			name:  "invalid argument type",
 			input: "EXPIRE key notanumber",
 			err:   ErrInvalidArg,
 		},
+		{
+			name:  "XREAD without STREAMS",
+			input: "XREAD COUNT 1 s 0",
+			err:   ErrInvalidCommand,
+		},
+		{
+			name:  "XREAD keys without IDs",
+			input: "XREAD STREAMS a b 0",
+			err:   ErrInvalidCommand,
+		},
+		{
+			name:  "XREAD negative BLOCK",
+			input: "XREAD BLOCK -1 STREAMS s 0",
+			err:   ErrInvalidArg,
+		},
+		{
+			name:  "XREADGROUP without GROUP",
+			input: "XREADGROUP STREAMS s >",
+			err:   ErrInvalidCommand,
+		},
+		{
+			name:  "XGROUP DESTROY with an ID",
+			input: "XGROUP DESTROY s g 0",
+			err:   ErrInvalidCommand,
+		},
+		{
+			name:  "JSON.SET value that is not JSON",
+			input: "JSON.SET doc $ {oops",
+			err:   ErrInvalidArg,
+		},
+		{
+			name:  "JSON.DEL with two paths",
+			input: "JSON.DEL doc $.a $.b",
+			err:   ErrInvalidCommand,
+		},
+		{
+			name:  "XTRIM unknown strategy",
+			input: "XTRIM s MAXAGE 10",
+			err:   ErrInvalidCommand,
+		},
 	}
 
 	for _, tt := range tests {
@@ -106,3 +385,105 @@ func TestParseLine_InvalidCommands(t *testing.T) {
 		})
 	}
 }
+
+func TestCommand_Keys(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{input: "GET a", want: []string{"a"}},
+		{input: "SET a 1", want: []string{"a"}},
+		{input: "EXPIRE a 10", want: []string{"a"}},
+		{input: "MGET a b c", want: []string{"a", "b", "c"}},
+		{input: "MSET a 1 b 2", want: []string{"a", "b"}},
+		{input: "WATCH a b", want: []string{"a", "b"}},
+		{input: "RPUSH a x y", want: []string{"a"}},
+		{input: "BLPOP a b 0", want: []string{"a", "b"}},
+		{input: "HMGET h a b", want: []string{"h"}},
+		{input: "HSCAN h 0 COUNT 5", want: []string{"h"}},
+		{input: "SUNION a b", want: []string{"a", "b"}},
+		{input: "ZRANGE z 0 1 WITHSCORES", want: []string{"z"}},
+		{input: "XREAD COUNT 2 STREAMS a b 0 $", want: []string{"a", "b"}},
+		{input: "XREADGROUP GROUP g c NOACK STREAMS a >", want: []string{"a"}},
+		{input: "XGROUP CREATE s g $ MKSTREAM", want: []string{"s"}},
+		{input: "JSON.GET doc $.a $.b", want: []string{"doc"}},
+		{input: "SCAN 0 MATCH a*", want: nil},
+		{input: "KEYS a*", want: nil},
+		{input: "MULTI", want: nil},
+		{input: "EXEC", want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			cmd, err := ParseLine(tt.input)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			got := cmd.Keys()
+			if len(got) != len(tt.want) {
+				t.Fatalf("expected keys %v, got %v", tt.want, got)
+			}
+			for i := range tt.want {
+				if got[i] != tt.want[i] {
+					t.Fatalf("expected keys %v, got %v", tt.want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestCommand_Option(t *testing.T) {
+	cmd, err := ParseLine("SCAN 5 count 20")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if val, ok := cmd.Option(OptionCount); !ok || val != "20" {
+		t.Fatalf("expected COUNT 20, got %q (%v)", val, ok)
+	}
+	if _, ok := cmd.Option(OptionMatch); ok {
+		t.Fatalf("expected MATCH to be absent")
+	}
+}
+
+func TestCommand_Flag(t *testing.T) {
+	cmd, err := ParseLine("ZRANGEBYSCORE board -inf +inf withscores")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !cmd.Flag(FlagWithScores) {
+		t.Fatalf("expected WITHSCORES to be set")
+	}
+
+	cmd, err = ParseLine("ZRANGE board 0 -1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cmd.Flag(FlagWithScores) {
+		t.Fatalf("expected WITHSCORES to be absent")
+	}
+}
+
+func TestCommand_StreamRead(t *testing.T) {
+	cmd, err := ParseLine("xreadgroup group g alice count 10 block 250 noack streams a b > 0-1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	r := cmd.StreamRead()
+	if r.Group != "g" || r.Consumer != "alice" || r.Count != 10 || !r.NoAck {
+		t.Fatalf("unexpected group read %+v", r)
+	}
+	if !r.Blocking || r.Block != 250*time.Millisecond {
+		t.Fatalf("expected BLOCK 250ms, got %v (%v)", r.Block, r.Blocking)
+	}
+	if len(r.Keys) != 2 || r.Keys[1] != "b" || len(r.IDs) != 2 || r.IDs[0] != ">" {
+		t.Fatalf("unexpected streams %v %v", r.Keys, r.IDs)
+	}
+
+	cmd, _ = ParseLine("XREAD STREAMS s 0")
+	if r := cmd.StreamRead(); r.Blocking || r.Count != 0 || r.Group != "" {
+		t.Fatalf("unexpected plain read %+v", r)
+	}
+}
@@ -0,0 +1,203 @@
+package protocol
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+Keywords of the stream commands.
+*/
+const (
+	// OptionBlock makes XREAD and XREADGROUP wait for entries, for at
+	// most the given milliseconds, 0 meaning no limit.
+	OptionBlock = "BLOCK"
+
+	// FlagNoAck makes XREADGROUP deliver entries without leaving them
+	// pending.
+	FlagNoAck = "NOACK"
+
+	// FlagMkStream makes XGROUP CREATE create a missing stream.
+	FlagMkStream = "MKSTREAM"
+
+	KeywordStreams = "STREAMS"
+	KeywordGroup   = "GROUP"
+	KeywordMaxLen  = "MAXLEN"
+	KeywordMinID   = "MINID"
+
+	SubcommandCreate  = "CREATE"
+	SubcommandSetID   = "SETID"
+	SubcommandDestroy = "DESTROY"
+)
+
+/*
+StreamRead holds the arguments of XREAD and XREADGROUP:
+
+	XREAD [COUNT n] [BLOCK ms] STREAMS key [key ...] id [id ...]
+	XREADGROUP GROUP group consumer [COUNT n] [BLOCK ms] [NOACK] STREAMS key [key ...] id [id ...]
+
+IDs holds one ID per key, in a slice of its own.
+*/
+type StreamRead struct {
+	Group, Consumer string
+
+	// Count bounds the entries read per key, 0 meaning no bound.
+	Count int
+
+	// Blocking reports whether BLOCK was given, and Block for how
+	// long, 0 meaning no limit.
+	Blocking bool
+	Block    time.Duration
+
+	NoAck bool
+	Keys  []string
+	IDs   []string
+}
+
+/*
+StreamRead returns the arguments of an XREAD or XREADGROUP command.
+*/
+func (c Command) StreamRead() StreamRead {
+	r, _ := parseStreamRead(c.Args, c.Name == CommandXReadGroup)
+	return r
+}
+
+/*
+parseStreamRead parses the arguments of XREAD, or of XREADGROUP if
+group is set, upper-casing keywords in place.
+*/
+func parseStreamRead(args []string, group bool) (StreamRead, error) {
+	var r StreamRead
+	i := 0
+	if group {
+		if len(args) < 3 || !strings.EqualFold(args[0], KeywordGroup) {
+			return r, ErrInvalidCommand
+		}
+		args[0] = KeywordGroup
+		r.Group, r.Consumer = args[1], args[2]
+		i = 3
+	}
+
+	seen := make(map[string]bool)
+	for ; i < len(args); i++ {
+		name := strings.ToUpper(args[i])
+		if seen[name] {
+			return r, ErrInvalidCommand
+		}
+		seen[name] = true
+		args[i] = name
+
+		switch {
+		case name == OptionCount || name == OptionBlock:
+			if i+1 == len(args) {
+				return r, ErrInvalidCommand
+			}
+			i++
+			n, err := strconv.Atoi(args[i])
+			if err != nil || n < 0 {
+				return r, ErrInvalidArg
+			}
+			if name == OptionCount {
+				r.Count = n
+			} else {
+				r.Blocking, r.Block = true, time.Duration(n)*time.Millisecond
+			}
+
+		case name == FlagNoAck && group:
+			r.NoAck = true
+
+		case name == KeywordStreams:
+			rest := args[i+1:]
+			if len(rest) == 0 || len(rest)%2 != 0 {
+				return r, ErrInvalidCommand
+			}
+			r.Keys = rest[:len(rest)/2]
+			r.IDs = slices.Clone(rest[len(rest)/2:])
+			return r, nil
+
+		default:
+			return r, ErrInvalidCommand
+		}
+	}
+	return r, ErrInvalidCommand
+}
+
+/*
+checkStreamRead returns the Check of XREAD, or of XREADGROUP if group
+is set.
+*/
+func checkStreamRead(group bool) func(args []string) error {
+	return func(args []string) error {
+		_, err := parseStreamRead(args, group)
+		return err
+	}
+}
+
+/*
+streamReadKeys is the key extractor for XREAD and XREADGROUP: the
+first half of the arguments after STREAMS.
+*/
+func streamReadKeys(args []string) []string {
+	for i, arg := range args {
+		if strings.EqualFold(arg, KeywordStreams) {
+			rest := args[i+1:]
+			return rest[:len(rest)/2]
+		}
+	}
+	return nil
+}
+
+/*
+checkXTrim checks XTRIM key MAXLEN n | MINID id.
+*/
+func checkXTrim(args []string) error {
+	if len(args) != 3 {
+		return ErrInvalidCommand
+	}
+	args[1] = strings.ToUpper(args[1])
+	switch args[1] {
+	case KeywordMaxLen:
+		return argTypeUint{}.Validate(args[2])
+	case KeywordMinID:
+		return nil
+	}
+	return ErrInvalidCommand
+}
+
+/*
+checkXGroup checks the subcommands of XGROUP:
+
+	XGROUP CREATE key group id [MKSTREAM]
+	XGROUP SETID key group id
+	XGROUP DESTROY key group
+*/
+func checkXGroup(args []string) error {
+	args[0] = strings.ToUpper(args[0])
+	switch {
+	case args[0] == SubcommandCreate && len(args) == 5:
+		if args[4] = strings.ToUpper(args[4]); args[4] != FlagMkStream {
+			return ErrInvalidCommand
+		}
+		return nil
+	case args[0] == SubcommandCreate && len(args) == 4,
+		args[0] == SubcommandSetID && len(args) == 4,
+		args[0] == SubcommandDestroy && len(args) == 3:
+		return nil
+	}
+	return ErrInvalidCommand
+}
+
+/*
+checkXPending checks XPENDING key group [start end count [consumer]].
+*/
+func checkXPending(args []string) error {
+	switch len(args) {
+	case 2:
+		return nil
+	case 5, 6:
+		return argTypeUint{}.Validate(args[4])
+	}
+	return ErrInvalidCommand
+}
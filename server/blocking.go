@@ -0,0 +1,214 @@
+package server
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"hermes/protocol"
+	"hermes/store"
+)
+
+/*
+popOps maps each blocking pop to the op it runs.
+*/
+var popOps = map[string]string{
+	protocol.CommandBLPop: protocol.CommandLPop,
+	protocol.CommandBRPop: protocol.CommandRPop,
+}
+
+/*
+wakers lists the commands that may hand data to a blocked client, on
+the keys they touch.
+*/
+var wakers = map[string]bool{
+	protocol.CommandLPush: true,
+	protocol.CommandRPush: true,
+	protocol.CommandXAdd:  true,
+}
+
+/*
+executeBlockingPop serves one attempt of BLPOP or BRPOP: it pops from
+the first of the keys holding a non-empty list and replies with that
+key and the element, or nil if every list is empty. It never blocks,
+which is also how the command behaves inside a transaction.
+*/
+func executeBlockingPop(cmd protocol.Command, dataStore store.Tx, clock store.Clock) Response {
+	pop := store.Op{Name: popOps[cmd.Name], Time: store.GetUnixTimestamp(clock.Now())}
+	for _, key := range cmd.Keys() {
+		reply, err := store.Apply(dataStore, key, pop)
+		if err != nil {
+			return errorResponse(err)
+		}
+		if reply != nil {
+			return opResponse([]any{key, reply})
+		}
+	}
+	return Response{Kind: ResponseNil}
+}
+
+/*
+waitList parks clients blocked on keys until another client may have
+handed them data. It is shared by every connection of a server.
+
+A notification only says that something changed: the woken client
+tries again, and parks again if another one got there first.
+*/
+type waitList struct {
+	mu      sync.Mutex
+	waiters map[string]map[chan struct{}]struct{}
+
+	// closed is closed on server shutdown, releasing every waiter.
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newWaitList() *waitList {
+	return &waitList{
+		waiters: make(map[string]map[chan struct{}]struct{}),
+		closed:  make(chan struct{}),
+	}
+}
+
+/*
+wait registers interest in keys. The returned channel receives once
+any of them is notified; cancel unregisters it and must be called.
+Registering before looking at the keys ensures no notification in
+between is missed.
+*/
+func (w *waitList) wait(keys []string) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+
+	w.mu.Lock()
+	for _, key := range keys {
+		if w.waiters[key] == nil {
+			w.waiters[key] = make(map[chan struct{}]struct{})
+		}
+		w.waiters[key][ch] = struct{}{}
+	}
+	w.mu.Unlock()
+
+	return ch, func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+
+		for _, key := range keys {
+			delete(w.waiters[key], ch)
+			if len(w.waiters[key]) == 0 {
+				delete(w.waiters, key)
+			}
+		}
+	}
+}
+
+/*
+notify wakes every client waiting on any of keys.
+*/
+func (w *waitList) notify(keys []string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, key := range keys {
+		for ch := range w.waiters[key] {
+			select {
+			case ch <- struct{}{}:
+			default: // already woken
+			}
+		}
+	}
+}
+
+/*
+close releases every blocked client, now and from then on.
+*/
+func (w *waitList) close() {
+	w.closeOnce.Do(func() { close(w.closed) })
+}
+
+/*
+blockingPop serves BLPOP and BRPOP outside a transaction: it retries
+the pop until it gets an element or the timeout passes.
+*/
+func (s *session) blockingPop(cmd protocol.Command) Response {
+	seconds, _ := strconv.ParseFloat(cmd.Args[len(cmd.Args)-1], 64)
+	return s.block(cmd.Keys(), time.Duration(seconds*float64(time.Second)), func() Response {
+		return executeBlockingPop(cmd, s.store, s.clock)
+	})
+}
+
+/*
+blockingStreamRead serves XREAD and XREADGROUP with BLOCK outside a
+transaction: it retries the read until it gets entries or the timeout
+passes. "$" IDs are resolved by the first attempt, so that later ones
+wait for what was added since.
+*/
+func (s *session) blockingStreamRead(cmd protocol.Command, r protocol.StreamRead) Response {
+	return s.block(r.Keys, r.Block, func() Response {
+		return readStreams(&r, s.store, s.clock)
+	})
+}
+
+/*
+block runs try each time one of keys is written, until it replies
+something else than nil or the timeout passes, and then replies nil.
+A timeout of 0 waits forever, or until the server stops.
+
+It also gives up as soon as the client hangs up, and never tries once
+it has: a pop on behalf of a client that is gone would take the
+element off its list only to lose it writing to a dead socket.
+*/
+func (s *session) block(keys []string, timeout time.Duration, try func() Response) Response {
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	var gone <-chan struct{}
+	if s.hangup != nil {
+		var stop func()
+		gone, stop = s.hangup()
+		defer stop()
+	}
+
+	for {
+		ready, cancel := s.waits.wait(keys)
+		select {
+		case <-gone:
+			cancel()
+			return Response{Kind: ResponseNil}
+		default:
+		}
+
+		resp := try()
+		if resp.Kind != ResponseNil {
+			cancel()
+			return resp
+		}
+
+		select {
+		case <-ready:
+			cancel()
+		case <-deadline:
+			cancel()
+			return Response{Kind: ResponseNil}
+		case <-s.waits.closed:
+			cancel()
+			return Response{Kind: ResponseNil}
+		case <-gone:
+			cancel()
+			return Response{Kind: ResponseNil}
+		}
+	}
+}
+
+/*
+wake notifies the clients blocked on the keys of cmd, if cmd may have
+handed them data.
+*/
+func (s *session) wake(cmd protocol.Command) {
+	if wakers[cmd.Name] {
+		s.waits.notify(cmd.Keys())
+	}
+}
@@ -0,0 +1,245 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"hermes/store"
+)
+
+func TestSession_BlockingPopWakesOnPush(t *testing.T) {
+	ds := store.NewLockedStore()
+	waits := newWaitList()
+	blocked := newSession(ds, store.SystemClock(), waits)
+	pusher := newSession(ds, store.SystemClock(), waits)
+
+	done := make(chan Response)
+	go func() { done <- blocked.handle(mustParse(t, "BLPOP a b 0")) }()
+
+	select {
+	case resp := <-done:
+		t.Fatalf("expected BLPOP to block, got %+v", resp)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	pusher.handle(mustParse(t, "RPUSH b x"))
+	select {
+	case resp := <-done:
+		if resp.String() != "*2\nb\nx" {
+			t.Fatalf("expected BLPOP to pop b, got %q", resp.String())
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected RPUSH to wake BLPOP")
+	}
+	if _, ok := ds.Read("b"); ok {
+		t.Fatalf("expected the popped list to be gone")
+	}
+}
+
+func TestSession_BlockingPopTimesOut(t *testing.T) {
+	sess := newSession(store.NewLockedStore(), store.SystemClock(), newWaitList())
+
+	start := time.Now()
+	if resp := sess.handle(mustParse(t, "BRPOP a 0.05")); resp.Kind != ResponseNil {
+		t.Fatalf("expected nil on timeout, got %+v", resp)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("expected BRPOP to wait for its timeout, returned after %v", elapsed)
+	}
+}
+
+func TestSession_BlockingPopInsideMulti(t *testing.T) {
+	sess := newSession(store.NewLockedStore(), store.SystemClock(), newWaitList())
+
+	sess.handle(mustParse(t, "MULTI"))
+	sess.handle(mustParse(t, "BLPOP a 0"))
+	sess.handle(mustParse(t, "RPUSH a x"))
+	sess.handle(mustParse(t, "BLPOP a 0"))
+
+	// The first pop does not wait for the push queued after it
+	resp := sess.handle(mustParse(t, "EXEC"))
+	if resp.String() != "*3\n(nil)\n(integer) 1\n*2\na\nx" {
+		t.Fatalf("unexpected EXEC reply %q", resp.String())
+	}
+}
+
+func TestSession_ExecWakesBlockedClients(t *testing.T) {
+	ds := store.NewLockedStore()
+	waits := newWaitList()
+	blocked := newSession(ds, store.SystemClock(), waits)
+	pusher := newSession(ds, store.SystemClock(), waits)
+
+	done := make(chan Response)
+	go func() { done <- blocked.handle(mustParse(t, "BRPOP a 0")) }()
+
+	pusher.handle(mustParse(t, "MULTI"))
+	pusher.handle(mustParse(t, "LPUSH a x"))
+	pusher.handle(mustParse(t, "EXEC"))
+
+	select {
+	case resp := <-done:
+		if resp.String() != "*2\na\nx" {
+			t.Fatalf("expected BRPOP to pop a, got %q", resp.String())
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected EXEC to wake BRPOP")
+	}
+}
+
+func TestServer_StopReleasesBlockedClients(t *testing.T) {
+	s, addr := startTestServer(t)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	fmt.Fprintln(conn, "BLPOP a 0")
+	time.Sleep(50 * time.Millisecond)
+
+	stopped := make(chan struct{})
+	go func() {
+		s.Stop()
+		close(stopped)
+	}()
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || strings.TrimSpace(line) != "(nil)" {
+		t.Fatalf("expected the blocked client to get nil, got %q (%v)", line, err)
+	}
+	conn.Close()
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatalf("expected Stop to return")
+	}
+}
+
+/*
+waitForWaiters polls until n clients are blocked on key.
+*/
+func waitForWaiters(t *testing.T, w *waitList, key string, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		w.mu.Lock()
+		got := len(w.waiters[key])
+		w.mu.Unlock()
+
+		if got == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d clients blocked on %s, got %d", n, key, got)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestServer_HangupReleasesBlockedPop(t *testing.T) {
+	ds := store.NewLockedStore()
+	s, addr := startServer(t, ds)
+	defer s.Stop()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintln(conn, "BLPOP jobs 0")
+	waitForWaiters(t, s.waits, "jobs", 1)
+
+	conn.Close()
+	waitForWaiters(t, s.waits, "jobs", 0)
+
+	if got := sendCommand(t, addr, "RPUSH jobs job"); got != "(integer) 1" {
+		t.Fatalf("expected RPUSH to reply 1, got %q", got)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if got := sendCommand(t, addr, "LLEN jobs"); got != "(integer) 1" {
+		t.Fatalf("expected the job to stay queued, got LLEN %q", got)
+	}
+}
+
+func TestServer_BlockedPopKeepsPipelinedCommand(t *testing.T) {
+	s, addr := startTestServer(t)
+	defer s.Stop()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	fmt.Fprintln(conn, "BLPOP jobs 0")
+	waitForWaiters(t, s.waits, "jobs", 1)
+	fmt.Fprintln(conn, "GET missing")
+
+	if got := sendCommand(t, addr, "RPUSH jobs job"); got != "(integer) 1" {
+		t.Fatalf("expected RPUSH to reply 1, got %q", got)
+	}
+
+	reader := bufio.NewReader(conn)
+	var lines []string
+	for i := 0; i < 4; i++ {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read failed: %v", err)
+		}
+		lines = append(lines, strings.TrimSpace(line))
+	}
+	if got := strings.Join(lines, " "); got != "*2 jobs job (nil)" {
+		t.Fatalf("expected the pop and then the pipelined GET, got %q", got)
+	}
+}
+
+func TestSession_BlockingStreamReadWakesOnAdd(t *testing.T) {
+	ds := store.NewLockedStore()
+	waits := newWaitList()
+	reader := newSession(ds, store.SystemClock(), waits)
+	consumer := newSession(ds, store.SystemClock(), waits)
+	producer := newSession(ds, store.SystemClock(), waits)
+
+	producer.handle(mustParse(t, "XADD s 1-0 n old"))
+	producer.handle(mustParse(t, "XGROUP CREATE s g $"))
+
+	// "$" only waits for entries added after the read started
+	read := make(chan Response)
+	go func() { read <- reader.handle(mustParse(t, "XREAD BLOCK 0 STREAMS s $")) }()
+	group := make(chan Response)
+	go func() { group <- consumer.handle(mustParse(t, "XREADGROUP GROUP g c BLOCK 0 STREAMS s >")) }()
+
+	select {
+	case resp := <-read:
+		t.Fatalf("expected XREAD to block, got %+v", resp)
+	case resp := <-group:
+		t.Fatalf("expected XREADGROUP to block, got %+v", resp)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	producer.handle(mustParse(t, "XADD s 2-0 n new"))
+	for name, ch := range map[string]chan Response{"XREAD": read, "XREADGROUP": group} {
+		select {
+		case resp := <-ch:
+			if resp.String() != "*1\n*2\ns\n*1\n*2\n2-0\n*2\nn\nnew" {
+				t.Fatalf("expected %s to get the new entry, got %q", name, resp.String())
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected XADD to wake %s", name)
+		}
+	}
+
+	start := time.Now()
+	if resp := reader.handle(mustParse(t, "XREAD BLOCK 50 STREAMS s $")); resp.Kind != ResponseNil {
+		t.Fatalf("expected nil on timeout, got %+v", resp)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("expected XREAD to wait for its timeout, returned after %v", elapsed)
+	}
+}
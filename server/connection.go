@@ -30,12 +30,22 @@ It is responsible for:
 - IO deadlines
 - Framing (line-based reads)
 - Protocol parsing
+- Per-connection transaction state (MULTI/EXEC/WATCH)
 - Writing responses
+
+Blocking commands park the goroutine on waits, which the connections
+of one server share so that a write on one wakes clients on others.
+While a command blocks or waits in the store's queues, the session
+watches conn and gives up once the client hangs up.
 */
-func handleConnection(conn net.Conn, store store.DataStore) {
+func handleConnection(conn net.Conn, store store.DataStore, clock store.Clock, waits *waitList) {
 	defer conn.Close()
 
 	reader := bufio.NewReaderSize(conn, maxLineSize)
+	sess := newSession(store, clock, waits)
+	sess.hangup = func() (<-chan struct{}, func()) {
+		return watchHangup(conn, reader)
+	}
 
 	for {
 		conn.SetReadDeadline(time.Now().Add(readTimeout))
@@ -68,13 +78,14 @@ func handleConnection(conn net.Conn, store store.DataStore) {
 		// Parse command according to protocol rules
 		cmd, err := protocol.ParseLine(line)
 		if err != nil {
+			sess.rejectQueued()
 			conn.SetWriteDeadline(time.Now().Add(writeTimeout))
 			fmt.Fprintln(conn, "ERR", err)
 			continue
 		}
 
-		// Execute against datastore
-		resp := executeCommand(cmd, store)
+		// Execute against datastore, or queue inside MULTI
+		resp := sess.handle(cmd)
 
 		conn.SetWriteDeadline(time.Now().Add(writeTimeout))
 		if _, err := fmt.Fprintln(conn, resp.String()); err != nil {
@@ -86,3 +97,39 @@ func handleConnection(conn net.Conn, store store.DataStore) {
 		}
 	}
 }
+
+/*
+watchHangup watches conn while nothing else reads it, as while a
+command blocks or waits on the store: the returned channel is closed
+once the client hangs up. stop ends the watch and must return before
+conn is read again.
+
+The watch only peeks, so a command the client sends in the meantime
+is left for the next read. The client then counts as connected until
+the watch stops.
+*/
+func watchHangup(conn net.Conn, reader *bufio.Reader) (<-chan struct{}, func()) {
+	gone := make(chan struct{})
+	done := make(chan struct{})
+
+	// A blocked command may outlast readTimeout
+	conn.SetReadDeadline(time.Time{})
+	go func() {
+		defer close(done)
+
+		_, err := reader.Peek(1)
+		if err == nil {
+			return
+		}
+		// stop expires the deadline to end the watch
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return
+		}
+		close(gone)
+	}()
+
+	return gone, func() {
+		conn.SetReadDeadline(time.Now())
+		<-done
+	}
+}
@@ -34,7 +34,7 @@ func startNewTestServer(t *testing.T, handler func(net.Conn)) (addr string, stop
 
 // func TestHandleConnection_ReadTimeout(t *testing.T) {
 // 	addr, stop := startNewTestServer(t, func(c net.Conn) {
-// 		handleConnection(c, store.NewLockedStore())
+// 		handleConnection(c, store.NewLockedStore(), store.SystemClock(), newWaitList())
 // 	})
 // 	defer stop()
 
@@ -52,7 +52,7 @@ func startNewTestServer(t *testing.T, handler func(net.Conn)) (addr string, stop
 
 // func TestHandleConnection_WriteTimeout(t *testing.T) {
 // 	addr, stop := startNewTestServer(t, func(c net.Conn) {
-// 		handleConnection(c, store.NewLockedStore())
+// 		handleConnection(c, store.NewLockedStore(), store.SystemClock(), newWaitList())
 // 	})
 // 	defer stop()
 
@@ -74,7 +74,7 @@ func startNewTestServer(t *testing.T, handler func(net.Conn)) (addr string, stop
 
 func TestHandleConnection_WriteError(t *testing.T) {
 	addr, stop := startNewTestServer(t, func(c net.Conn) {
-		handleConnection(c, store.NewLockedStore())
+		handleConnection(c, store.NewLockedStore(), store.SystemClock(), newWaitList())
 	})
 	defer stop()
 
@@ -89,7 +89,7 @@ func TestHandleConnection_WriteError(t *testing.T) {
 
 func TestHandleConnection_ReadError(t *testing.T) {
 	addr, stop := startNewTestServer(t, func(c net.Conn) {
-		handleConnection(c, store.NewLockedStore())
+		handleConnection(c, store.NewLockedStore(), store.SystemClock(), newWaitList())
 	})
 	defer stop()
 
@@ -107,7 +107,7 @@ func TestHandleConnection_LineTooLong(t *testing.T) {
 	server, client := net.Pipe()
 	defer client.Close()
 
-	go handleConnection(server, store.NewLockedStore())
+	go handleConnection(server, store.NewLockedStore(), store.SystemClock(), newWaitList())
 
 	// Write > maxLineSize without newline
 	long := strings.Repeat("x", maxLineSize+10)
@@ -124,7 +124,7 @@ func TestHandleConnection_ParseError(t *testing.T) {
 	server, client := net.Pipe()
 	defer client.Close()
 
-	go handleConnection(server, store.NewLockedStore())
+	go handleConnection(server, store.NewLockedStore(), store.SystemClock(), newWaitList())
 
 	client.Write([]byte("INVALIDCMD\n"))
 
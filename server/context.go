@@ -0,0 +1,76 @@
+package server
+
+import (
+	"context"
+
+	"hermes/protocol"
+	"hermes/store"
+)
+
+/*
+contextTx runs the point reads and writes of a command with ctx, so
+that a command queued behind a busy store stops waiting once ctx
+ends. A cancelled Read or Expire reports the key as missing; nobody
+is left to read the reply.
+*/
+type contextTx struct {
+	store.Tx
+	cs  store.ContextStore
+	ctx context.Context
+}
+
+func (tx contextTx) Read(key string) (store.Entry, bool) {
+	val, ok, err := tx.cs.ReadContext(tx.ctx, key)
+	return val, ok && err == nil
+}
+
+func (tx contextTx) Write(key string, value store.Entry, mode store.PutMode) error {
+	return tx.cs.WriteContext(tx.ctx, key, value, mode)
+}
+
+func (tx contextTx) Expire(key string, unixTimestampMilli int64) bool {
+	ok, err := tx.cs.ExpireContext(tx.ctx, key, unixTimestampMilli)
+	return ok && err == nil
+}
+
+/*
+cancellable reports whether cmd only reads and writes single keys
+through the calls ContextStore covers.
+*/
+func cancellable(cmd protocol.Command) bool {
+	switch cmd.Name {
+	case protocol.CommandGet:
+		return !readsHistory(cmd)
+	case protocol.CommandSet, protocol.CommandExpire:
+		return true
+	}
+	return false
+}
+
+/*
+execute runs cmd outside a transaction. When the store can cancel
+what waits in its queues, GET, SET and EXPIRE run with a context that
+ends once the client hangs up, so a client stuck behind backpressure
+does not hold its place after it disconnected.
+*/
+func (s *session) execute(cmd protocol.Command) Response {
+	cs, ok := store.AsContextStore(s.store)
+	if !ok || s.hangup == nil || !cancellable(cmd) {
+		return executeCommand(cmd, s.store, s.clock)
+	}
+
+	gone, stop := s.hangup()
+	defer stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-gone:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return executeCommand(cmd, contextTx{Tx: s.store, cs: cs, ctx: ctx}, s.clock)
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"hermes/store"
+)
+
+/*
+stalledStore is a ContextStore whose reads wait until their context
+ends, like a read queued behind a loop that never gets to it.
+*/
+type stalledStore struct {
+	store.DataStore
+	waiting   chan struct{}
+	cancelled chan error
+}
+
+func (s *stalledStore) ReadContext(ctx context.Context, key string) (store.Entry, bool, error) {
+	close(s.waiting)
+	<-ctx.Done()
+	s.cancelled <- ctx.Err()
+	return store.Entry{}, false, ctx.Err()
+}
+
+func (s *stalledStore) WriteContext(ctx context.Context, key string, value store.Entry, mode store.PutMode) error {
+	return s.Write(key, value, mode)
+}
+
+func (s *stalledStore) ExpireContext(ctx context.Context, key string, unixTimestampMilli int64) (bool, error) {
+	return s.Expire(key, unixTimestampMilli), nil
+}
+
+func TestServer_HangupCancelsQueuedRead(t *testing.T) {
+	ds := &stalledStore{
+		DataStore: store.NewLockedStore(),
+		waiting:   make(chan struct{}),
+		cancelled: make(chan error, 1),
+	}
+	s, addr := startServer(t, ds)
+	defer s.Stop()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintln(conn, "GET a")
+	select {
+	case <-ds.waiting:
+	case <-time.After(time.Second):
+		t.Fatalf("expected GET to read with a context")
+	}
+	conn.Close()
+
+	select {
+	case err := <-ds.cancelled:
+		if err != context.Canceled {
+			t.Fatalf("expected the read to be cancelled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the hangup to cancel the queued read")
+	}
+}
+
+func TestServer_QueuedCommandsKeepPipelinedCommands(t *testing.T) {
+	s, addr := startServer(t, store.NewEventloopStore(16))
+	defer s.Stop()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	fmt.Fprint(conn, "SET a 1\nEXPIRE a 100\nGET a\n")
+
+	reader := bufio.NewReader(conn)
+	var lines []string
+	for i := 0; i < 3; i++ {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read failed: %v", err)
+		}
+		lines = append(lines, strings.TrimSpace(line))
+	}
+	if got := strings.Join(lines, " "); got != "OK OK 1" {
+		t.Fatalf("expected every pipelined command to be served, got %q", got)
+	}
+}
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"errors"
 	"hermes/protocol"
 	"hermes/store"
 	"strconv"
@@ -10,12 +11,23 @@ import (
 /*
 executeCommand maps a validated protocol command to datastore operations.
 Note: It contains no networking logic and no concurrency concerns.
+
+It runs against store.Tx, which every DataStore satisfies, so the same
+code serves plain commands and commands replayed inside EXEC. clock
+turns relative TTLs into expiry times; it should be the store's clock.
 */
-func executeCommand(cmd protocol.Command, dataStore store.DataStore) Response {
+func executeCommand(cmd protocol.Command, dataStore store.Tx, clock store.Clock) Response {
 	switch cmd.Name {
 	case protocol.CommandGet:
+		if readsHistory(cmd) {
+			return executeGetPast(cmd, dataStore)
+		}
+
 		key := cmd.Args[0]
-		entry, ok := dataStore.Read(key)
+		entry, ok, err := readTyped(dataStore, key, store.TypeString)
+		if err != nil {
+			return errorResponse(err)
+		}
 
 		if !ok {
 			return Response{
@@ -67,7 +79,7 @@ func executeCommand(cmd protocol.Command, dataStore store.DataStore) Response {
 			}
 		}
 
-		expirationTime := store.GetUnixTimestamp(time.Now().Add(time.Duration(ttlSec) * time.Second))
+		expirationTime := store.GetUnixTimestamp(clock.Now().Add(time.Duration(ttlSec) * time.Second))
 		ok := dataStore.Expire(key, expirationTime)
 		if !ok {
 			return Response{
@@ -78,9 +90,127 @@ func executeCommand(cmd protocol.Command, dataStore store.DataStore) Response {
 			Kind: ResponseOK,
 		}
 
+	case protocol.CommandMGet:
+		entries := dataStore.ReadBatch(cmd.Args)
+
+		items := make([]Response, len(cmd.Args))
+		for i, key := range cmd.Args {
+			entry, ok := entries[key]
+			if !ok {
+				items[i] = Response{Kind: ResponseNil}
+				continue
+			}
+			// Keys of other types read as missing, like in Redis
+			items[i] = stringValue(entry)
+		}
+		return Response{
+			Kind:  ResponseArray,
+			Items: items,
+		}
+
+	case protocol.CommandMSet:
+		err := dataStore.WriteBatch(keyValuePairs(cmd.Args), store.PutOverwrite)
+		if err != nil {
+			return Response{
+				Kind:  ResponseClientError,
+				Value: err.Error(),
+			}
+		}
+		return Response{
+			Kind: ResponseOK,
+		}
+
+	case protocol.CommandMSetNX:
+		// MSETNX reports whether the batch was applied:
+		// 1 if every key was set, 0 if none were because one already existed.
+		err := dataStore.WriteBatch(keyValuePairs(cmd.Args), store.PutIfAbsent)
+		if errors.Is(err, store.ErrKeyExists) {
+			return Response{
+				Kind:  ResponseInteger,
+				Value: "0",
+			}
+		}
+		if err != nil {
+			return Response{
+				Kind:  ResponseClientError,
+				Value: err.Error(),
+			}
+		}
+		return Response{
+			Kind:  ResponseInteger,
+			Value: "1",
+		}
+
+	case protocol.CommandScan:
+		return executeScan(cmd, dataStore)
+
+	case protocol.CommandKeys:
+		return executeKeys(cmd.Args[0], dataStore)
+
+	case protocol.CommandRange, protocol.CommandRevRange, protocol.CommandPrefix:
+		return executeOrdered(cmd, dataStore)
+
+	case protocol.CommandHistory:
+		return executeHistory(cmd.Args[0], dataStore)
+
+	case protocol.CommandType:
+		return executeType(cmd.Args[0], dataStore)
+
+	case protocol.CommandLPush, protocol.CommandRPush, protocol.CommandLPop, protocol.CommandRPop,
+		protocol.CommandLLen, protocol.CommandLRange, protocol.CommandLIndex, protocol.CommandLTrim:
+		return executeOp(cmd, dataStore, clock)
+
+	case protocol.CommandHSet, protocol.CommandHGet, protocol.CommandHMGet, protocol.CommandHDel,
+		protocol.CommandHGetAll, protocol.CommandHIncrBy, protocol.CommandHExists, protocol.CommandHLen:
+		return executeOp(cmd, dataStore, clock)
+
+	case protocol.CommandHScan:
+		return executeHScan(cmd, dataStore, clock)
+
+	case protocol.CommandSAdd, protocol.CommandSRem, protocol.CommandSIsMember, protocol.CommandSMembers,
+		protocol.CommandZAdd, protocol.CommandZIncrBy, protocol.CommandZRange, protocol.CommandZRangeByScore,
+		protocol.CommandZRank, protocol.CommandZRem, protocol.CommandZCard:
+		return executeOp(cmd, dataStore, clock)
+
+	case protocol.CommandSInter, protocol.CommandSUnion, protocol.CommandSDiff:
+		return executeSetAlgebra(cmd, dataStore, clock)
+
+	case protocol.CommandBLPop, protocol.CommandBRPop:
+		return executeBlockingPop(cmd, dataStore, clock)
+
+	case protocol.CommandXAdd, protocol.CommandXRange, protocol.CommandXRevRange, protocol.CommandXLen,
+		protocol.CommandXTrim, protocol.CommandXAck, protocol.CommandXPending, protocol.CommandXClaim:
+		return executeOp(cmd, dataStore, clock)
+
+	case protocol.CommandXGroup:
+		return executeXGroup(cmd, dataStore, clock)
+
+	case protocol.CommandXRead, protocol.CommandXReadGroup:
+		return executeStreamRead(cmd, dataStore, clock)
+
+	case protocol.CommandJSONSet:
+		return executeJSONSet(cmd, dataStore, clock)
+
+	case protocol.CommandJSONGet, protocol.CommandJSONDel, protocol.CommandJSONNumIncrBy, protocol.CommandJSONArrAppend:
+		return executeOp(cmd, dataStore, clock)
+
 	default:
 		return Response{
 			Kind: ResponseServerError,
 		}
 	}
 }
+
+/*
+keyValuePairs converts alternating key/value arguments into batch entries.
+*/
+func keyValuePairs(args []string) []store.KeyValue {
+	entries := make([]store.KeyValue, 0, len(args)/2)
+	for i := 0; i+1 < len(args); i += 2 {
+		entries = append(entries, store.KeyValue{
+			Key:   args[i],
+			Entry: store.Entry{Value: []byte(args[i+1])},
+		})
+	}
+	return entries
+}
@@ -1,9 +1,15 @@
 package server
 
 import (
+	"fmt"
 	"hermes/protocol"
 	"hermes/store"
+	"hermes/wal"
+	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestExecuteCommand_GET_MissingKey(t *testing.T) {
@@ -14,7 +20,7 @@ func TestExecuteCommand_GET_MissingKey(t *testing.T) {
 		Args: []string{"missing"},
 	}
 
-	resp := executeCommand(cmd, ds)
+	resp := executeCommand(cmd, ds, store.SystemClock())
 
 	if resp.Kind != ResponseNil {
 		t.Fatalf("expected ResponseNil, got %v", resp.Kind)
@@ -34,12 +40,12 @@ func TestExecuteCommand_SET_Then_GET(t *testing.T) {
 		Args: []string{"a"},
 	}
 
-	resp := executeCommand(setCmd, ds)
+	resp := executeCommand(setCmd, ds, store.SystemClock())
 	if resp.Kind != ResponseOK {
 		t.Fatalf("expected ResponseOK, got %v", resp.Kind)
 	}
 
-	resp = executeCommand(getCmd, ds)
+	resp = executeCommand(getCmd, ds, store.SystemClock())
 	if resp.Kind != ResponseValue || resp.Value != "1" {
 		t.Fatalf("expected value '1', got %+v", resp)
 	}
@@ -53,7 +59,7 @@ func TestExecuteCommand_EXPIRE_InvalidTTL(t *testing.T) {
 		Args: []string{"a", "notanint"},
 	}
 
-	resp := executeCommand(cmd, ds)
+	resp := executeCommand(cmd, ds, store.SystemClock())
 
 	if resp.Kind != ResponseClientError {
 		t.Fatalf("expected ResponseClientError, got %v", resp.Kind)
@@ -68,7 +74,7 @@ func TestExecuteCommand_EXPIRE_MissingKey(t *testing.T) {
 		Args: []string{"missing", "10"},
 	}
 
-	resp := executeCommand(cmd, ds)
+	resp := executeCommand(cmd, ds, store.SystemClock())
 
 	if resp.Kind != ResponseNil {
 		t.Fatalf("expected ResponseNil, got %v", resp.Kind)
@@ -83,7 +89,7 @@ func TestExecuteCommand_UnknownCommand(t *testing.T) {
 		Args: []string{},
 	}
 
-	resp := executeCommand(cmd, ds)
+	resp := executeCommand(cmd, ds, store.SystemClock())
 
 	if resp.Kind != ResponseServerError {
 		t.Fatalf("expected ResponseServerError, got %v", resp.Kind)
@@ -97,9 +103,615 @@ func TestExecuteCommand_ExpireNegativeTTL(t *testing.T) {
 			Args: []string{"k", "-5"},
 		},
 		store.NewLockedStore(),
+		store.SystemClock(),
 	)
 
 	if resp.Kind != ResponseClientError {
 		t.Fatalf("expected client error for negative ttl")
 	}
 }
+
+func TestExecuteCommand_ExpireUsesClock(t *testing.T) {
+	clock := store.NewManualClock(time.Unix(1_000_000, 0))
+	ds := store.NewLockedStore(store.WithClock(clock))
+	_ = ds.Write("k", store.Entry{Value: []byte("v")}, store.PutOverwrite)
+
+	resp := executeCommand(protocol.Command{
+		Name: protocol.CommandExpire,
+		Args: []string{"k", "10"},
+	}, ds, clock)
+	if resp.Kind != ResponseOK {
+		t.Fatalf("expected ResponseOK, got %+v", resp)
+	}
+
+	entry, _ := ds.Read("k")
+	if want := store.GetUnixTimestamp(clock.Now().Add(10 * time.Second)); entry.ExpiresAtMillis != want {
+		t.Fatalf("expected expiry %d, got %d", want, entry.ExpiresAtMillis)
+	}
+}
+
+func TestExecuteCommand_MSET_Then_MGET(t *testing.T) {
+	ds := store.NewShardedStore(4)
+
+	resp := executeCommand(protocol.Command{
+		Name: protocol.CommandMSet,
+		Args: []string{"a", "1", "b", "2"},
+	}, ds, store.SystemClock())
+	if resp.Kind != ResponseOK {
+		t.Fatalf("expected ResponseOK, got %+v", resp)
+	}
+
+	resp = executeCommand(protocol.Command{
+		Name: protocol.CommandMGet,
+		Args: []string{"a", "missing", "b"},
+	}, ds, store.SystemClock())
+	if resp.Kind != ResponseArray || len(resp.Items) != 3 {
+		t.Fatalf("expected 3-element array, got %+v", resp)
+	}
+	if resp.Items[0].Value != "1" || resp.Items[1].Kind != ResponseNil || resp.Items[2].Value != "2" {
+		t.Fatalf("unexpected MGET items: %+v", resp.Items)
+	}
+}
+
+func TestExecuteCommand_MSETNX(t *testing.T) {
+	ds := store.NewLockedStore()
+
+	resp := executeCommand(protocol.Command{
+		Name: protocol.CommandMSetNX,
+		Args: []string{"a", "1", "b", "2"},
+	}, ds, store.SystemClock())
+	if resp.Kind != ResponseInteger || resp.Value != "1" {
+		t.Fatalf("expected (integer) 1, got %+v", resp)
+	}
+
+	resp = executeCommand(protocol.Command{
+		Name: protocol.CommandMSetNX,
+		Args: []string{"b", "3", "c", "3"},
+	}, ds, store.SystemClock())
+	if resp.Kind != ResponseInteger || resp.Value != "0" {
+		t.Fatalf("expected (integer) 0, got %+v", resp)
+	}
+
+	if _, ok := ds.Read("c"); ok {
+		t.Fatalf("rejected MSETNX must not write any key")
+	}
+}
+
+/*
+A server restarted without compression must still read the values an
+earlier run stored compressed: they are replayed from the WAL with
+their encoding.
+*/
+func TestExecuteCommand_CompressedValuesReadAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	document := strings.Repeat("hermes-document;", 20)
+
+	openWal := func() store.DataStore {
+		w, err := wal.NewWAL(wal.Config{Path: filepath.Join(dir, "wal.log"), SyncPolicy: wal.SyncEveryWrite})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ds, err := store.NewWalStore(store.NewLockedStore(), w, filepath.Join(dir, "snapshot.bin"), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ds
+	}
+
+	compressed := store.NewCompressedStore(openWal(), 64)
+	for _, line := range []string{"SET doc " + document, "MSET a " + document + " b small"} {
+		if resp := executeCommand(mustParse(t, line), compressed, store.SystemClock()); resp.Kind != ResponseOK {
+			t.Fatalf("expected OK, got %+v", resp)
+		}
+	}
+	if err := compressed.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	ds := openWal()
+	defer ds.Close()
+
+	if stored, _ := ds.Read("doc"); stored.Encoding != store.EncodingFlate {
+		t.Fatalf("expected doc to be stored compressed, got encoding %d", stored.Encoding)
+	}
+
+	resp := executeCommand(mustParse(t, "GET doc"), ds, store.SystemClock())
+	if resp.Kind != ResponseValue || resp.Value != document {
+		t.Fatalf("expected GET to return the plain value, got %q", resp.Value)
+	}
+
+	resp = executeCommand(mustParse(t, "MGET a b"), ds, store.SystemClock())
+	if len(resp.Items) != 2 || resp.Items[0].Value != document || resp.Items[1].Value != "small" {
+		t.Fatalf("expected MGET to return plain values, got %+v", resp.Items)
+	}
+}
+
+func TestExecuteCommand_SCAN_FullWalk(t *testing.T) {
+	for name, ds := range map[string]store.DataStore{
+		"Locked":    store.NewLockedStore(),
+		"Sharded":   store.NewShardedStore(4),
+		"EventLoop": store.NewEventloopStore(16),
+	} {
+		t.Run(name, func(t *testing.T) {
+			defer ds.Close()
+
+			for i := 0; i < 100; i++ {
+				executeCommand(protocol.Command{
+					Name: protocol.CommandSet,
+					Args: []string{fmt.Sprintf("user:%d", i), "v"},
+				}, ds, store.SystemClock())
+				executeCommand(protocol.Command{
+					Name: protocol.CommandSet,
+					Args: []string{fmt.Sprintf("order:%d", i), "v"},
+				}, ds, store.SystemClock())
+			}
+
+			seen := make(map[string]bool)
+			cursor := "0"
+			for {
+				resp := executeCommand(protocol.Command{
+					Name: protocol.CommandScan,
+					Args: []string{cursor, protocol.OptionMatch, "user:*", protocol.OptionCount, "7"},
+				}, ds, store.SystemClock())
+				if resp.Kind != ResponseArray || len(resp.Items) != 2 {
+					t.Fatalf("unexpected SCAN reply: %+v", resp)
+				}
+
+				for _, item := range resp.Items[1].Items {
+					if !strings.HasPrefix(item.Value, "user:") {
+						t.Fatalf("MATCH let %q through", item.Value)
+					}
+					seen[item.Value] = true
+				}
+
+				cursor = resp.Items[0].Value
+				if cursor == "0" {
+					break
+				}
+			}
+
+			if len(seen) != 100 {
+				t.Fatalf("expected 100 user keys, got %d", len(seen))
+			}
+		})
+	}
+}
+
+func TestExecuteCommand_SCAN_InvalidCount(t *testing.T) {
+	ds := store.NewLockedStore()
+
+	resp := executeCommand(protocol.Command{
+		Name: protocol.CommandScan,
+		Args: []string{"0", protocol.OptionCount, "0"},
+	}, ds, store.SystemClock())
+
+	if resp.Kind != ResponseClientError {
+		t.Fatalf("expected ResponseClientError, got %+v", resp)
+	}
+}
+
+func TestExecuteCommand_KEYS(t *testing.T) {
+	ds := store.NewShardedStore(4)
+
+	for _, key := range []string{"user:1", "user:2", "order:1"} {
+		executeCommand(protocol.Command{
+			Name: protocol.CommandSet,
+			Args: []string{key, "v"},
+		}, ds, store.SystemClock())
+	}
+
+	resp := executeCommand(protocol.Command{
+		Name: protocol.CommandKeys,
+		Args: []string{"user:*"},
+	}, ds, store.SystemClock())
+
+	var keys []string
+	for _, item := range resp.Items {
+		keys = append(keys, item.Value)
+	}
+	sort.Strings(keys)
+
+	if resp.Kind != ResponseArray || strings.Join(keys, ",") != "user:1,user:2" {
+		t.Fatalf("unexpected KEYS reply: %+v", resp)
+	}
+}
+
+func TestExecuteCommand_RANGE_REVRANGE_PREFIX(t *testing.T) {
+	ds := store.NewOrderedStore()
+	defer ds.Close()
+
+	for _, key := range []string{"b", "a", "user:2", "user:1", "c"} {
+		executeCommand(protocol.Command{
+			Name: protocol.CommandSet,
+			Args: []string{key, "v" + key},
+		}, ds, store.SystemClock())
+	}
+
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{line: "RANGE - +", want: []string{"a", "va", "b", "vb", "c", "vc", "user:1", "vuser:1", "user:2", "vuser:2"}},
+		{line: "RANGE b user:1", want: []string{"b", "vb", "c", "vc"}},
+		{line: "RANGE - + LIMIT 2", want: []string{"a", "va", "b", "vb"}},
+		{line: "REVRANGE b + LIMIT 2", want: []string{"user:2", "vuser:2", "user:1", "vuser:1"}},
+		{line: "PREFIX user:", want: []string{"user:1", "vuser:1", "user:2", "vuser:2"}},
+		{line: "PREFIX nothing", want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			cmd, err := protocol.ParseLine(tt.line)
+			if err != nil {
+				t.Fatalf("parse failed: %v", err)
+			}
+
+			resp := executeCommand(cmd, ds, store.SystemClock())
+			if resp.Kind != ResponseArray {
+				t.Fatalf("expected ResponseArray, got %+v", resp)
+			}
+
+			var got []string
+			for _, item := range resp.Items {
+				got = append(got, item.Value)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExecuteCommand_RANGE_UnorderedStore(t *testing.T) {
+	resp := executeCommand(protocol.Command{
+		Name: protocol.CommandRange,
+		Args: []string{"-", "+"},
+	}, store.NewShardedStore(4), store.SystemClock())
+
+	if resp.Kind != ResponseClientError {
+		t.Fatalf("expected ResponseClientError, got %+v", resp)
+	}
+}
+
+/*
+The WAL and compressed stores forward ordered traversals: they serve
+them over an ordered store and reject them over any other.
+*/
+func TestExecuteCommand_RANGE_ThroughDecorators(t *testing.T) {
+	openWal := func(inner store.DataStore) store.DataStore {
+		dir := t.TempDir()
+		w, err := wal.NewWAL(wal.Config{Path: filepath.Join(dir, "wal.log"), SyncPolicy: wal.SyncEveryWrite})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ds, err := store.NewWalStore(inner, w, filepath.Join(dir, "snapshot.bin"), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store.NewCompressedStore(ds, 64)
+	}
+
+	for name, tc := range map[string]struct {
+		inner store.DataStore
+		want  ResponseKind
+	}{
+		"Ordered": {store.NewOrderedStore(), ResponseArray},
+		"Sharded": {store.NewShardedStore(4), ResponseClientError},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ds := openWal(tc.inner)
+			defer ds.Close()
+			executeCommand(mustParse(t, "SET k v"), ds, store.SystemClock())
+
+			for _, line := range []string{"RANGE - +", "REVRANGE - +", "PREFIX k"} {
+				resp := executeCommand(mustParse(t, line), ds, store.SystemClock())
+				if resp.Kind != tc.want {
+					t.Fatalf("%s: expected kind %v, got %+v", line, tc.want, resp)
+				}
+				if tc.want == ResponseArray && (len(resp.Items) != 2 || resp.Items[0].Value != "k") {
+					t.Fatalf("%s: expected [k v], got %+v", line, resp.Items)
+				}
+			}
+		})
+	}
+}
+
+func TestExecuteCommand_HISTORY_And_GET_Past(t *testing.T) {
+	ds := store.NewLockedStore(store.WithHistory(store.HistoryPolicy{Prefix: "user:", Keep: 3}))
+	defer ds.Close()
+
+	for i, val := range []string{"v1", "v2", "v3", "v4"} {
+		_ = ds.Write("user:1", store.Entry{
+			Value:           []byte(val),
+			WrittenAtMillis: int64(1000 * (i + 1)),
+		}, store.PutOverwrite)
+	}
+
+	resp := executeCommand(protocol.Command{Name: protocol.CommandHistory, Args: []string{"user:1"}}, ds, store.SystemClock())
+	if resp.Kind != ResponseArray || len(resp.Items) != 3 {
+		t.Fatalf("expected the last 3 revisions, got %+v", resp)
+	}
+
+	first := resp.Items[0]
+	if len(first.Items) != 4 || first.Items[1].Value != "2000" || first.Items[2].Value != "v2" {
+		t.Fatalf("unexpected oldest revision: %+v", first)
+	}
+	version := resp.Items[1].Items[0].Value
+
+	tests := []struct {
+		line string
+		want string
+	}{
+		{line: "GET user:1 AT 2500", want: "v2"},
+		{line: "GET user:1 AT 4000", want: "v4"},
+		{line: "GET user:1 AT 1500", want: ""},
+		{line: "GET user:1 VERSION " + version, want: "v3"},
+		{line: "GET user:1 VERSION 0", want: ""},
+		{line: "GET other AT 2500", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			cmd, err := protocol.ParseLine(tt.line)
+			if err != nil {
+				t.Fatalf("parse failed: %v", err)
+			}
+
+			resp := executeCommand(cmd, ds, store.SystemClock())
+			if tt.want == "" {
+				if resp.Kind != ResponseNil {
+					t.Fatalf("expected nil, got %+v", resp)
+				}
+				return
+			}
+			if resp.Kind != ResponseValue || resp.Value != tt.want {
+				t.Fatalf("expected %q, got %+v", tt.want, resp)
+			}
+		})
+	}
+}
+
+func TestExecuteCommand_GET_Past_Errors(t *testing.T) {
+	ds := store.NewLockedStore()
+	defer ds.Close()
+
+	for _, line := range []string{"GET k AT 1 VERSION 1", "GET k AT -1"} {
+		cmd, err := protocol.ParseLine(line)
+		if err != nil {
+			t.Fatalf("parse failed: %v", err)
+		}
+		if resp := executeCommand(cmd, ds, store.SystemClock()); resp.Kind != ResponseClientError {
+			t.Fatalf("%s: expected ResponseClientError, got %+v", line, resp)
+		}
+	}
+}
+
+func TestExecuteCommand_TYPE_And_WRONGTYPE(t *testing.T) {
+	ds := store.NewLockedStore(store.WithHistory(store.HistoryPolicy{Prefix: "", Keep: 2}))
+	defer ds.Close()
+
+	_ = ds.Write("list", store.Entry{Value: []byte("encoded"), Type: store.TypeList}, store.PutOverwrite)
+	executeCommand(mustParse(t, "SET str v"), ds, store.SystemClock())
+
+	for line, want := range map[string]string{
+		"TYPE list":    "list",
+		"TYPE str":     "string",
+		"TYPE missing": "none",
+	} {
+		if resp := executeCommand(mustParse(t, line), ds, store.SystemClock()); resp.Kind != ResponseValue || resp.Value != want {
+			t.Fatalf("%s: expected %q, got %+v", line, want, resp)
+		}
+	}
+
+	if resp := executeCommand(mustParse(t, "GET list"), ds, store.SystemClock()); resp.Kind != ResponseWrongType {
+		t.Fatalf("expected WRONGTYPE, got %+v", resp)
+	}
+	version, _ := ds.Read("list")
+	cmd := mustParse(t, fmt.Sprintf("GET list VERSION %d", version.Version))
+	if resp := executeCommand(cmd, ds, store.SystemClock()); resp.Kind != ResponseWrongType {
+		t.Fatalf("expected a past list to be WRONGTYPE, got %+v", resp)
+	}
+
+	resp := executeCommand(mustParse(t, "MGET str list"), ds, store.SystemClock())
+	if resp.Items[0].Value != "v" || resp.Items[1].Kind != ResponseNil {
+		t.Fatalf("expected MGET to skip the list, got %+v", resp)
+	}
+	resp = executeCommand(mustParse(t, "HISTORY list"), ds, store.SystemClock())
+	if len(resp.Items) != 1 || resp.Items[0].Items[2].Kind != ResponseNil {
+		t.Fatalf("expected HISTORY to hide the list encoding, got %+v", resp)
+	}
+
+	// SET replaces a value of any type
+	executeCommand(mustParse(t, "SET list v"), ds, store.SystemClock())
+	if resp := executeCommand(mustParse(t, "TYPE list"), ds, store.SystemClock()); resp.Value != "string" {
+		t.Fatalf("expected SET to make list a string, got %+v", resp)
+	}
+}
+
+func TestExecuteCommand_Lists(t *testing.T) {
+	ds := store.NewShardedStore(4)
+	defer ds.Close()
+
+	for _, tc := range []struct {
+		line string
+		want string
+	}{
+		{"RPUSH l b c", "(integer) 2"},
+		{"LPUSH l a", "(integer) 3"},
+		{"LRANGE l 0 -1", "*3\na\nb\nc"},
+		{"LINDEX l -1", "c"},
+		{"LLEN l", "(integer) 3"},
+		{"LTRIM l 0 1", "OK"},
+		{"RPOP l", "b"},
+		{"LPOP l", "a"},
+		{"LPOP l", "(nil)"},
+		{"TYPE l", "none"},
+		{"LRANGE missing 0 -1", "*0"},
+		{"SET s v", "OK"},
+		{"LPUSH s x", "WRONGTYPE Operation against a key holding the wrong kind of value"},
+		{"BLPOP missing s 0", "WRONGTYPE Operation against a key holding the wrong kind of value"},
+		{"RPUSH m x", "(integer) 1"},
+		{"BRPOP missing m 1", "*2\nm\nx"},
+		{"BLPOP missing m 1", "(nil)"},
+	} {
+		if got := executeCommand(mustParse(t, tc.line), ds, store.SystemClock()).String(); got != tc.want {
+			t.Fatalf("%s: expected %q, got %q", tc.line, tc.want, got)
+		}
+	}
+}
+
+func TestExecuteCommand_Hashes(t *testing.T) {
+	for name, ds := range map[string]store.DataStore{
+		"Locked":    store.NewLockedStore(),
+		"Sharded":   store.NewShardedStore(4),
+		"EventLoop": store.NewEventloopStore(16),
+	} {
+		t.Run(name, func(t *testing.T) {
+			defer ds.Close()
+
+			for _, tc := range []struct {
+				line string
+				want string
+			}{
+				{"HSET user name hermes age 3", "(integer) 2"},
+				{"HSET user age 4", "(integer) 0"},
+				{"HGET user age", "4"},
+				{"HGET user missing", "(nil)"},
+				{"HMGET user name missing", "*2\nhermes\n(nil)"},
+				{"HINCRBY user age -1", "(integer) 3"},
+				{"HINCRBY user name 1", "ERR value is not an integer or out of range"},
+				{"HGETALL user", "*4\nage\n3\nname\nhermes"},
+				{"HEXISTS user name", "(integer) 1"},
+				{"HLEN user", "(integer) 2"},
+				{"HSCAN user 0 MATCH n*", "*2\n0\n*2\nname\nhermes"},
+				{"HSCAN user 0 COUNT 0", "ERR invalid count"},
+				{"TYPE user", "hash"},
+				{"HDEL user name age missing", "(integer) 2"},
+				{"HGETALL user", "*0"},
+				{"TYPE user", "none"},
+				{"RPUSH l x", "(integer) 1"},
+				{"HGET l x", "WRONGTYPE Operation against a key holding the wrong kind of value"},
+			} {
+				if got := executeCommand(mustParse(t, tc.line), ds, store.SystemClock()).String(); got != tc.want {
+					t.Fatalf("%s: expected %q, got %q", tc.line, tc.want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestExecuteCommand_SetsAndSortedSets(t *testing.T) {
+	ds := store.NewShardedStore(4)
+	defer ds.Close()
+
+	for _, tc := range []struct {
+		line string
+		want string
+	}{
+		{"SADD a x y z", "(integer) 3"},
+		{"SADD b y z w", "(integer) 3"},
+		{"SISMEMBER a x", "(integer) 1"},
+		{"SMEMBERS a", "*3\nx\ny\nz"},
+		{"SINTER a b", "*2\ny\nz"},
+		{"SUNION a b missing", "*4\nw\nx\ny\nz"},
+		{"SDIFF a b", "*1\nx"},
+		{"SINTER a missing", "*0"},
+		{"SREM a x y z", "(integer) 3"},
+		{"TYPE a", "none"},
+		{"ZADD board 10 ann 20 bob 15 cy", "(integer) 3"},
+		{"ZINCRBY board 7.5 ann", "17.5"},
+		{"ZRANGE board 0 -1", "*3\ncy\nann\nbob"},
+		{"ZRANGE board 0 0 withscores", "*2\ncy\n15"},
+		{"ZRANGEBYSCORE board (15 +inf WITHSCORES", "*4\nann\n17.5\nbob\n20"},
+		{"ZRANGEBYSCORE board x 1", "ERR value is not a valid float"},
+		{"ZRANK board bob", "(integer) 2"},
+		{"ZRANK board nobody", "(nil)"},
+		{"ZREM board ann nobody", "(integer) 1"},
+		{"ZCARD board", "(integer) 2"},
+		{"TYPE board", "zset"},
+		{"SINTER b board", "WRONGTYPE Operation against a key holding the wrong kind of value"},
+	} {
+		if got := executeCommand(mustParse(t, tc.line), ds, store.SystemClock()).String(); got != tc.want {
+			t.Fatalf("%s: expected %q, got %q", tc.line, tc.want, got)
+		}
+	}
+}
+
+func TestExecuteCommand_Streams(t *testing.T) {
+	ds := store.NewShardedStore(4)
+	defer ds.Close()
+	clock := store.NewManualClock(time.UnixMilli(5000))
+
+	for _, tc := range []struct {
+		line string
+		want string
+	}{
+		{"XADD s 1-1 job a", "1-1"},
+		{"XADD s * job b", "5000-0"},
+		{"XADD s 10 job c", "ERR the ID specified in XADD is equal or smaller than the target stream top item"},
+		{"XLEN s", "(integer) 2"},
+		{"XRANGE s - + COUNT 1", "*1\n*2\n1-1\n*2\njob\na"},
+		{"XREVRANGE s + (1-1", "*1\n*2\n5000-0\n*2\njob\nb"},
+		{"XREAD STREAMS s missing 1-1 0", "*1\n*2\ns\n*1\n*2\n5000-0\n*2\njob\nb"},
+		{"XREAD COUNT 5 STREAMS s $", "(nil)"},
+		{"XGROUP CREATE s g 0", "OK"},
+		{"XGROUP CREATE s g 0", "ERR consumer group name already exists"},
+		{"XGROUP CREATE other g $", "ERR the stream must exist to create a group, unless MKSTREAM is given"},
+		{"XREADGROUP GROUP g alice COUNT 1 STREAMS s >", "*1\n*2\ns\n*1\n*2\n1-1\n*2\njob\na"},
+		{"XREADGROUP GROUP g bob STREAMS s >", "*1\n*2\ns\n*1\n*2\n5000-0\n*2\njob\nb"},
+		{"XREADGROUP GROUP g bob STREAMS s >", "(nil)"},
+		{"XREADGROUP GROUP g bob STREAMS s 0", "*1\n*2\ns\n*1\n*2\n5000-0\n*2\njob\nb"},
+		{"XREADGROUP GROUP nope bob STREAMS s >", "ERR no such key or consumer group"},
+		{"XPENDING s g", "*4\n(integer) 2\n1-1\n5000-0\n*2\n*2\nalice\n(integer) 1\n*2\nbob\n(integer) 1"},
+		{"XACK s g 5000-0", "(integer) 1"},
+		{"XCLAIM s g bob 0 1-1", "*1\n*2\n1-1\n*2\njob\na"},
+		{"XPENDING s g - + 10 bob", "*1\n*4\n1-1\nbob\n(integer) 0\n(integer) 2"},
+		{"XTRIM s MAXLEN 0", "(integer) 2"},
+		{"TYPE s", "stream"},
+		{"XGROUP DESTROY s g", "(integer) 1"},
+		{"RPUSH l x", "(integer) 1"},
+		{"XADD l * f v", "WRONGTYPE Operation against a key holding the wrong kind of value"},
+	} {
+		if got := executeCommand(mustParse(t, tc.line), ds, clock).String(); got != tc.want {
+			t.Fatalf("%s: expected %q, got %q", tc.line, tc.want, got)
+		}
+	}
+}
+
+func TestExecuteCommand_JSON(t *testing.T) {
+	for name, ds := range map[string]store.DataStore{
+		"Locked":    store.NewLockedStore(),
+		"Sharded":   store.NewShardedStore(4),
+		"EventLoop": store.NewEventloopStore(16),
+	} {
+		t.Run(name, func(t *testing.T) {
+			defer ds.Close()
+
+			for _, tc := range []struct {
+				line string
+				want string
+			}{
+				{"JSON.GET doc", "(nil)"},
+				{`JSON.SET doc $.a 1`, "ERR new JSON documents must be created at the root path"},
+				{`JSON.SET doc $ {"user":{"name":"hermes"},"scores":[1,2]}`, "OK"},
+				{`JSON.SET doc $.user.name "zeus" nx`, "(nil)"},
+				{`JSON.SET doc $.user.age 3 NX`, "OK"},
+				{"JSON.NUMINCRBY doc $.scores[*] 0.5", "[1.5,2.5]"},
+				{`JSON.ARRAPPEND doc $..scores 3`, "ERR invalid JSON path"},
+				{`JSON.ARRAPPEND doc $.scores 3 {"x":null}`, "*1\n(integer) 4"},
+				{"JSON.DEL doc $.scores[-1]", "(integer) 1"},
+				{"JSON.GET doc $.user.age $.scores", `{"$.scores":[[1.5,2.5,3]],"$.user.age":[3]}`},
+				{"JSON.GET doc", `{"scores":[1.5,2.5,3],"user":{"age":3,"name":"hermes"}}`},
+				{"TYPE doc", "json"},
+				{"JSON.DEL doc", "(integer) 1"},
+				{"TYPE doc", "none"},
+				{"SET s v", "OK"},
+				{"JSON.GET s", "WRONGTYPE Operation against a key holding the wrong kind of value"},
+			} {
+				if got := executeCommand(mustParse(t, tc.line), ds, store.SystemClock()).String(); got != tc.want {
+					t.Fatalf("%s: expected %q, got %q", tc.line, tc.want, got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package server
+
+/*
+matchGlob reports whether s matches a Redis-style glob pattern:
+
+- * matches any sequence of bytes, including none
+- ? matches exactly one byte
+- [abc] matches one listed byte, [a-z] a range, [^a] anything else
+- \x matches x literally
+
+Unlike path.Match, * also matches '/', since keys are not paths.
+*/
+func matchGlob(pattern, s string) bool {
+	px, sx := 0, 0
+
+	// Where to resume after the last *: pattern just past it, and the
+	// next byte of s it should swallow
+	starPx, starSx := -1, -1
+
+	for px < len(pattern) || sx < len(s) {
+		if px < len(pattern) {
+			if pattern[px] == '*' {
+				starPx, starSx = px+1, sx
+				px++
+				continue
+			}
+
+			if sx < len(s) {
+				if width, ok := matchOne(pattern[px:], s[sx]); ok {
+					px += width
+					sx++
+					continue
+				}
+			}
+		}
+
+		// Mismatch: let the last * swallow one more byte
+		if starPx >= 0 && starSx < len(s) {
+			starSx++
+			px, sx = starPx, starSx
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+/*
+matchOne matches the pattern token at the start of p against c and
+returns the token's width.
+*/
+func matchOne(p string, c byte) (int, bool) {
+	switch p[0] {
+	case '?':
+		return 1, true
+
+	case '\\':
+		if len(p) > 1 {
+			return 2, p[1] == c
+		}
+		return 1, c == '\\'
+
+	case '[':
+		if width, ok, valid := matchClass(p, c); valid {
+			return width, ok
+		}
+		// An unterminated class is a literal '['
+		return 1, c == '['
+	}
+	return 1, p[0] == c
+}
+
+/*
+matchClass matches a [...] character class. valid is false when the
+class has no closing bracket.
+*/
+func matchClass(p string, c byte) (width int, ok bool, valid bool) {
+	i := 1
+	negate := i < len(p) && p[i] == '^'
+	if negate {
+		i++
+	}
+
+	for i < len(p) && p[i] != ']' {
+		lo := p[i]
+		if lo == '\\' && i+1 < len(p) {
+			i++
+			lo = p[i]
+		}
+
+		hi := lo
+		if i+2 < len(p) && p[i+1] == '-' && p[i+2] != ']' {
+			hi = p[i+2]
+			i += 2
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+		}
+
+		if lo <= c && c <= hi {
+			ok = true
+		}
+		i++
+	}
+
+	if i >= len(p) {
+		return 0, false, false
+	}
+	return i + 1, ok != negate, true
+}
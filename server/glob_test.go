@@ -0,0 +1,41 @@
+package server
+
+import "testing"
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		s       string
+		want    bool
+	}{
+		{pattern: "*", s: "", want: true},
+		{pattern: "*", s: "anything", want: true},
+		{pattern: "user:*", s: "user:42", want: true},
+		{pattern: "user:*", s: "users", want: false},
+		{pattern: "*:session", s: "user:{42}:session", want: true},
+		{pattern: "a*b*c", s: "axxbyyc", want: true},
+		{pattern: "a*b*c", s: "axxbyy", want: false},
+		{pattern: "*/*", s: "a/b", want: true},
+		{pattern: "h?llo", s: "hello", want: true},
+		{pattern: "h?llo", s: "hllo", want: false},
+		{pattern: "h[ae]llo", s: "hallo", want: true},
+		{pattern: "h[ae]llo", s: "hillo", want: false},
+		{pattern: "h[^e]llo", s: "hallo", want: true},
+		{pattern: "h[^e]llo", s: "hello", want: false},
+		{pattern: "h[a-c]llo", s: "hbllo", want: true},
+		{pattern: "h[c-a]llo", s: "hbllo", want: true},
+		{pattern: "h[a-c]llo", s: "hdllo", want: false},
+		{pattern: `h\*llo`, s: "h*llo", want: true},
+		{pattern: `h\*llo`, s: "hello", want: false},
+		{pattern: `[\]]`, s: "]", want: true},
+		{pattern: "[abc", s: "[abc", want: true},
+		{pattern: "exact", s: "exact", want: true},
+		{pattern: "exact", s: "exactly", want: false},
+	}
+
+	for _, tt := range tests {
+		if got := matchGlob(tt.pattern, tt.s); got != tt.want {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
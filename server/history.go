@@ -0,0 +1,115 @@
+package server
+
+import (
+	"strconv"
+
+	"hermes/protocol"
+	"hermes/store"
+)
+
+/*
+readsHistory reports whether cmd reads recorded history: HISTORY, or
+GET with AT or VERSION.
+*/
+func readsHistory(cmd protocol.Command) bool {
+	if cmd.Name == protocol.CommandHistory {
+		return true
+	}
+	_, at := cmd.Option(protocol.OptionAt)
+	_, version := cmd.Option(protocol.OptionVersion)
+	return at || version
+}
+
+/*
+executeHistory serves HISTORY key.
+
+The reply lists the revisions of the key, oldest first, each as an
+array of its version, write time, value and expiry (Unix milliseconds,
+0 for none). Revisions that are not strings show a nil value. Keys
+outside every history policy show their current value only.
+
+Only stores implementing store.Historian support it.
+*/
+func executeHistory(key string, dataStore store.Tx) Response {
+	historian, ok := dataStore.(store.Historian)
+	if !ok {
+		return clientError("HISTORY is not supported by this store")
+	}
+
+	h := historian.History(key)
+	items := make([]Response, 0, len(h))
+	for _, rev := range h {
+		items = append(items, Response{
+			Kind: ResponseArray,
+			Items: []Response{
+				integer(int64(rev.Version)),
+				integer(rev.WrittenAtMillis),
+				stringValue(rev),
+				integer(rev.ExpiresAtMillis),
+			},
+		})
+	}
+
+	return Response{
+		Kind:  ResponseArray,
+		Items: items,
+	}
+}
+
+/*
+executeGetPast serves the time-travel forms of GET:
+
+	GET key AT unix-ms
+	GET key VERSION n
+
+AT returns the value the key held at that time, VERSION the value it
+held once the write with that version committed (see HISTORY). Like
+GET, it fails with WRONGTYPE on a value that is not a string.
+*/
+func executeGetPast(cmd protocol.Command, dataStore store.Tx) Response {
+	historian, ok := dataStore.(store.Historian)
+	if !ok {
+		return clientError("GET of past values is not supported by this store")
+	}
+
+	at, hasAt := cmd.Option(protocol.OptionAt)
+	version, hasVersion := cmd.Option(protocol.OptionVersion)
+	if hasAt && hasVersion {
+		return clientError("AT and VERSION can not be combined")
+	}
+
+	h := historian.History(cmd.Args[0])
+
+	var (
+		entry store.Entry
+		found bool
+	)
+	if hasAt {
+		ts, err := strconv.ParseInt(at, 10, 64)
+		if err != nil || ts < 0 {
+			return clientError("invalid timestamp")
+		}
+		entry, found = h.At(ts)
+	} else {
+		v, err := strconv.ParseUint(version, 10, 64)
+		if err != nil {
+			return clientError("invalid version")
+		}
+		entry, found = h.AtVersion(v)
+	}
+
+	if !found {
+		return Response{Kind: ResponseNil}
+	}
+	if entry.Type != store.TypeString {
+		return Response{Kind: ResponseWrongType}
+	}
+	return stringValue(entry)
+}
+
+func integer(n int64) Response {
+	return Response{
+		Kind:  ResponseInteger,
+		Value: strconv.FormatInt(n, 10),
+	}
+}
@@ -13,8 +13,13 @@ import (
 
 func startTestServer(t *testing.T) (*Server, string) {
 	t.Helper()
+	return startServer(t, store.NewLockedStore())
+}
+
+func startServer(t *testing.T, ds store.DataStore, opts ...Option) (*Server, string) {
+	t.Helper()
 
-	s := NewServer("127.0.0.1:0", store.NewLockedStore())
+	s := NewServer("127.0.0.1:0", ds, opts...)
 
 	go func() {
 		if err := s.Start(); err != nil {
@@ -70,13 +75,19 @@ func TestIntegration_SETThenGET(t *testing.T) {
 }
 
 func TestIntegration_EXPIRE(t *testing.T) {
-	s, addr := startTestServer(t)
+	clock := store.NewManualClock(time.Now())
+	s, addr := startServer(t, store.NewLockedStore(store.WithClock(clock)), WithClock(clock))
 	defer s.Stop()
 
 	sendCommand(t, addr, "SET a 1")
 	sendCommand(t, addr, "EXPIRE a 1")
 
-	time.Sleep(1100 * time.Millisecond)
+	clock.Advance(999 * time.Millisecond)
+	if resp := sendCommand(t, addr, "GET a"); resp != "1" {
+		t.Fatalf("expected a to live until its TTL, got %q", resp)
+	}
+
+	clock.Advance(time.Millisecond)
 
 	resp := sendCommand(t, addr, "GET a")
 	if resp != "(nil)" {
@@ -157,3 +168,122 @@ func TestIntegration_OversizedInput(t *testing.T) {
 		t.Fatal("expected connection to be closed")
 	}
 }
+
+func TestIntegration_MSETThenMGET(t *testing.T) {
+	s, addr := startTestServer(t)
+	defer s.Stop()
+
+	if resp := sendCommand(t, addr, "MSET a 1 b 2"); resp != "OK" {
+		t.Fatalf("unexpected MSET response: %q", resp)
+	}
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	fmt.Fprintln(conn, "MGET a missing b")
+
+	reader := bufio.NewReader(conn)
+	var lines []string
+	for i := 0; i < 4; i++ {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read failed: %v", err)
+		}
+		lines = append(lines, strings.TrimSpace(line))
+	}
+
+	want := []string{"*3", "1", "(nil)", "2"}
+	for i := range want {
+		if lines[i] != want[i] {
+			t.Fatalf("expected %q, got %q", want, lines)
+		}
+	}
+}
+
+func TestIntegration_Transaction(t *testing.T) {
+	s, addr := startTestServer(t)
+	defer s.Stop()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	send := func(cmd string, lines int) []string {
+		fmt.Fprintln(conn, cmd)
+
+		var got []string
+		for i := 0; i < lines; i++ {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatalf("read failed: %v", err)
+			}
+			got = append(got, strings.TrimSpace(line))
+		}
+		return got
+	}
+
+	if got := send("MULTI", 1); got[0] != "OK" {
+		t.Fatalf("unexpected MULTI response: %q", got)
+	}
+	if got := send("SET a 1", 1); got[0] != "QUEUED" {
+		t.Fatalf("unexpected queue response: %q", got)
+	}
+	if got := send("GET", 1); !strings.HasPrefix(got[0], "ERR") {
+		t.Fatalf("expected parse error, got %q", got)
+	}
+	if got := send("EXEC", 1); !strings.Contains(got[0], "EXECABORT") {
+		t.Fatalf("expected EXECABORT, got %q", got)
+	}
+
+	send("MULTI", 1)
+	send("SET a 1", 1)
+	send("GET a", 1)
+	got := send("EXEC", 3)
+	want := []string{"*2", "OK", "1"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("expected %q, got %q", want, got)
+		}
+	}
+}
+
+func TestIntegration_SCAN(t *testing.T) {
+	s, addr := startTestServer(t)
+	defer s.Stop()
+
+	if resp := sendCommand(t, addr, "SET only 1"); resp != "OK" {
+		t.Fatalf("unexpected SET response: %q", resp)
+	}
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	fmt.Fprintln(conn, "SCAN 0 COUNT 100")
+
+	reader := bufio.NewReader(conn)
+	var lines []string
+	for i := 0; i < 4; i++ {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read failed: %v", err)
+		}
+		lines = append(lines, strings.TrimSpace(line))
+	}
+
+	// Cursor 0 (done), then a one-element key array
+	want := []string{"*2", "0", "*1", "only"}
+	for i := range want {
+		if lines[i] != want[i] {
+			t.Fatalf("expected %q, got %q", want, lines)
+		}
+	}
+}
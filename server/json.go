@@ -0,0 +1,25 @@
+package server
+
+import (
+	"hermes/protocol"
+	"hermes/store"
+)
+
+/*
+executeJSONSet serves JSON.SET: OK once the value is set, or nil if
+the path matched nothing to set, or NX or XX ruled every match out.
+*/
+func executeJSONSet(cmd protocol.Command, dataStore store.Tx, clock store.Clock) Response {
+	reply, err := store.Apply(dataStore, cmd.Args[0], store.Op{
+		Name: cmd.Name,
+		Args: cmd.Args[1:],
+		Time: store.GetUnixTimestamp(clock.Now()),
+	})
+	if err != nil {
+		return errorResponse(err)
+	}
+	if reply == nil {
+		return Response{Kind: ResponseNil}
+	}
+	return Response{Kind: ResponseOK}
+}
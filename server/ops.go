@@ -0,0 +1,84 @@
+package server
+
+import (
+	"strconv"
+
+	"hermes/protocol"
+	"hermes/store"
+)
+
+/*
+okReplies lists the ops that reply OK instead of their result.
+*/
+var okReplies = map[string]bool{
+	protocol.CommandLTrim: true,
+}
+
+/*
+executeOp serves a command on a typed value: its first argument is the
+key, and the store runs the rest as the op named after the command.
+*/
+func executeOp(cmd protocol.Command, dataStore store.Tx, clock store.Clock) Response {
+	reply, err := store.Apply(dataStore, cmd.Args[0], store.Op{
+		Name: cmd.Name,
+		Args: cmd.Args[1:],
+		Time: store.GetUnixTimestamp(clock.Now()),
+	})
+	if err != nil {
+		return errorResponse(err)
+	}
+	if okReplies[cmd.Name] {
+		return Response{Kind: ResponseOK}
+	}
+	return opResponse(reply)
+}
+
+/*
+opResponse converts the result of an op into a reply.
+*/
+func opResponse(reply any) Response {
+	switch v := reply.(type) {
+	case nil:
+		return Response{Kind: ResponseNil}
+	case int64:
+		return Response{Kind: ResponseInteger, Value: strconv.FormatInt(v, 10)}
+	case string:
+		return Response{Kind: ResponseValue, Value: v}
+	case []string:
+		items := make([]Response, len(v))
+		for i, s := range v {
+			items[i] = Response{Kind: ResponseValue, Value: s}
+		}
+		return Response{Kind: ResponseArray, Items: items}
+	case []any:
+		items := make([]Response, len(v))
+		for i, r := range v {
+			items[i] = opResponse(r)
+		}
+		return Response{Kind: ResponseArray, Items: items}
+	default:
+		return Response{Kind: ResponseServerError}
+	}
+}
+
+/*
+atomically runs fn with keys held, so that ops on several keys see
+one instant: under Atomic on a whole DataStore, directly on a Tx,
+which already holds them.
+*/
+func atomically(dataStore store.Tx, keys []string, fn func(tx store.Tx) Response) Response {
+	ds, ok := dataStore.(store.DataStore)
+	if !ok {
+		return fn(dataStore)
+	}
+
+	var resp Response
+	err := ds.Atomic(keys, func(tx store.Tx) error {
+		resp = fn(tx)
+		return nil
+	})
+	if err != nil {
+		return Response{Kind: ResponseServerError}
+	}
+	return resp
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"strconv"
+
+	"hermes/protocol"
+	"hermes/store"
+)
+
+// Open range bounds, as in Redis ZRANGEBYLEX.
+const (
+	rangeMin = "-"
+	rangeMax = "+"
+)
+
+/*
+executeOrdered serves RANGE, REVRANGE and PREFIX:
+
+	RANGE start end [LIMIT n]
+	REVRANGE start end [LIMIT n]
+	PREFIX prefix [LIMIT n]
+
+Ranges are half-open, [start, end); "-" and "+" stand for the lowest
+and highest possible key. REVRANGE returns the same keys as RANGE in
+descending order. The reply is a flat array of alternating keys and
+values; values that are not strings are listed as nil.
+
+Only stores implementing store.Ordered support these commands, as
+reported by store.AsOrdered.
+*/
+func executeOrdered(cmd protocol.Command, dataStore store.Tx) Response {
+	ordered, ok := store.AsOrdered(dataStore)
+	if !ok {
+		return clientError(cmd.Name + " is not supported by this store")
+	}
+
+	limit := -1
+	if val, ok := cmd.Option(protocol.OptionLimit); ok {
+		n, err := strconv.Atoi(val)
+		if err != nil || n < 0 {
+			return clientError("invalid limit")
+		}
+		limit = n
+	}
+
+	items := []Response{}
+	collect := func(key string, value store.Entry) bool {
+		if limit == 0 {
+			return false
+		}
+		items = append(items,
+			Response{Kind: ResponseValue, Value: key},
+			stringValue(value),
+		)
+		limit--
+		return true
+	}
+
+	switch cmd.Name {
+	case protocol.CommandRange:
+		ordered.Range(rangeBound(cmd.Args[0], rangeMin), rangeBound(cmd.Args[1], rangeMax), collect)
+	case protocol.CommandRevRange:
+		ordered.ReverseRange(rangeBound(cmd.Args[0], rangeMin), rangeBound(cmd.Args[1], rangeMax), collect)
+	case protocol.CommandPrefix:
+		ordered.Prefix(cmd.Args[0], collect)
+	}
+
+	return Response{
+		Kind:  ResponseArray,
+		Items: items,
+	}
+}
+
+/*
+rangeBound maps the open bound marker to the empty string, which the
+store reads as "no bound".
+*/
+func rangeBound(arg, open string) string {
+	if arg == open {
+		return ""
+	}
+	return arg
+}
@@ -1,5 +1,10 @@
 package server
 
+import (
+	"strconv"
+	"strings"
+)
+
 /*
 ResponseKind represents the category of a server response.
 
@@ -23,14 +28,29 @@ const (
 
 	// Server encountered an internal error.
 	ResponseServerError
+
+	// Operation succeeded and returned an integer (e.g. a count or flag).
+	ResponseInteger
+
+	// Operation succeeded and returned an ordered list of responses.
+	ResponseArray
+
+	// Command was queued inside a transaction.
+	ResponseQueued
+
+	// Command was run against a key holding another type of value.
+	ResponseWrongType
 )
 
 /*
 Response represents the result of executing a command.
+
+Items is only used by ResponseArray.
 */
 type Response struct {
 	Kind  ResponseKind
 	Value string
+	Items []Response
 }
 
 /*
@@ -57,6 +77,25 @@ func (r Response) String() string {
 	case ResponseServerError:
 		return "ERR internal error"
 
+	case ResponseInteger:
+		return "(integer) " + r.Value
+
+	case ResponseArray:
+		// Arrays are framed by a count header followed by one
+		// element per line, so clients know how many lines to read.
+		lines := make([]string, 0, len(r.Items)+1)
+		lines = append(lines, "*"+strconv.Itoa(len(r.Items)))
+		for _, item := range r.Items {
+			lines = append(lines, item.String())
+		}
+		return strings.Join(lines, "\n")
+
+	case ResponseQueued:
+		return "QUEUED"
+
+	case ResponseWrongType:
+		return "WRONGTYPE Operation against a key holding the wrong kind of value"
+
 	default:
 		// should never happen.
 		return "ERR unknown response"
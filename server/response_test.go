@@ -33,6 +33,29 @@ func TestResponseString(t *testing.T) {
 			resp: Response{Kind: ResponseServerError},
 			want: "ERR internal error",
 		},
+		{
+			name: "Integer",
+			resp: Response{Kind: ResponseInteger, Value: "1"},
+			want: "(integer) 1",
+		},
+		{
+			name: "Array",
+			resp: Response{Kind: ResponseArray, Items: []Response{
+				{Kind: ResponseValue, Value: "a"},
+				{Kind: ResponseNil},
+			}},
+			want: "*2\na\n(nil)",
+		},
+		{
+			name: "WrongType",
+			resp: Response{Kind: ResponseWrongType},
+			want: "WRONGTYPE Operation against a key holding the wrong kind of value",
+		},
+		{
+			name: "EmptyArray",
+			resp: Response{Kind: ResponseArray},
+			want: "*0",
+		},
 	}
 
 	for _, tt := range tests {
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"errors"
+	"strconv"
+
+	"hermes/protocol"
+	"hermes/store"
+)
+
+// defaultScanCount is the SCAN step size when COUNT is not given.
+const defaultScanCount = 10
+
+// keysScanCount is the step size KEYS uses to walk the keyspace.
+const keysScanCount = 1000
+
+// errInvalidCount rejects a COUNT that is not a positive integer.
+var errInvalidCount = errors.New("invalid count")
+
+/*
+executeScan runs one SCAN step: SCAN cursor [MATCH pattern] [COUNT n].
+
+The reply is a two-element array: the cursor to pass to the next call
+(0 once the scan is complete) and the keys found in this step. MATCH
+is applied after the step, so a step may return no keys while the
+scan is still going, like in Redis.
+
+Nothing is held between calls; each step locks only what it reads.
+*/
+func executeScan(cmd protocol.Command, dataStore store.Tx) Response {
+	sc, ok := store.AsScanner(dataStore)
+	if !ok {
+		return clientError("SCAN is not supported by this store")
+	}
+
+	cursor, err := strconv.ParseUint(cmd.Args[0], 10, 64)
+	if err != nil {
+		return clientError("invalid cursor")
+	}
+
+	count, err := scanCount(cmd)
+	if err != nil {
+		return clientError(err.Error())
+	}
+
+	pattern, hasPattern := cmd.Option(protocol.OptionMatch)
+
+	entries, next := sc.ScanEntries(cursor, count)
+	keys := make([]Response, 0, len(entries))
+	for _, kv := range entries {
+		if hasPattern && !matchGlob(pattern, kv.Key) {
+			continue
+		}
+		keys = append(keys, Response{
+			Kind:  ResponseValue,
+			Value: kv.Key,
+		})
+	}
+
+	return Response{
+		Kind: ResponseArray,
+		Items: []Response{
+			{Kind: ResponseValue, Value: strconv.FormatUint(next, 10)},
+			{Kind: ResponseArray, Items: keys},
+		},
+	}
+}
+
+/*
+scanCount returns the step size of a scan: its COUNT option, or
+defaultScanCount.
+*/
+func scanCount(cmd protocol.Command) (int, error) {
+	val, ok := cmd.Option(protocol.OptionCount)
+	if !ok {
+		return defaultScanCount, nil
+	}
+
+	count, err := strconv.Atoi(val)
+	if err != nil || count <= 0 {
+		return 0, errInvalidCount
+	}
+	return count, nil
+}
+
+/*
+executeHScan runs one HSCAN step: HSCAN key cursor [MATCH pattern]
+[COUNT n]. It walks the fields of a hash like SCAN walks the keyspace,
+and replies with the next cursor and the fields of this step, each
+followed by its value. MATCH is applied to the fields after the step.
+*/
+func executeHScan(cmd protocol.Command, dataStore store.Tx, clock store.Clock) Response {
+	count, err := scanCount(cmd)
+	if err != nil {
+		return clientError(err.Error())
+	}
+
+	reply, err := store.Apply(dataStore, cmd.Args[0], store.Op{
+		Name: cmd.Name,
+		Args: []string{cmd.Args[1], strconv.Itoa(count)},
+		Time: store.GetUnixTimestamp(clock.Now()),
+	})
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	step := reply.([]any)
+	pairs := step[1].([]string)
+	pattern, hasPattern := cmd.Option(protocol.OptionMatch)
+
+	fields := make([]Response, 0, len(pairs))
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if hasPattern && !matchGlob(pattern, pairs[i]) {
+			continue
+		}
+		fields = append(fields,
+			Response{Kind: ResponseValue, Value: pairs[i]},
+			Response{Kind: ResponseValue, Value: pairs[i+1]},
+		)
+	}
+
+	return Response{
+		Kind: ResponseArray,
+		Items: []Response{
+			{Kind: ResponseValue, Value: step[0].(string)},
+			{Kind: ResponseArray, Items: fields},
+		},
+	}
+}
+
+/*
+executeKeys returns every key matching pattern.
+
+It is a full scan run server-side, step by step, so other clients are
+served between steps. Keys changed during the walk follow the SCAN
+guarantees; keys are reported once even if the scan returns them twice.
+*/
+func executeKeys(pattern string, dataStore store.Tx) Response {
+	sc, ok := store.AsScanner(dataStore)
+	if !ok {
+		return clientError("KEYS is not supported by this store")
+	}
+
+	seen := make(map[string]struct{})
+	keys := []Response{}
+
+	var cursor uint64
+	for {
+		entries, next := sc.ScanEntries(cursor, keysScanCount)
+		for _, kv := range entries {
+			if _, dup := seen[kv.Key]; dup || !matchGlob(pattern, kv.Key) {
+				continue
+			}
+			seen[kv.Key] = struct{}{}
+			keys = append(keys, Response{
+				Kind:  ResponseValue,
+				Value: kv.Key,
+			})
+		}
+
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+
+	return Response{
+		Kind:  ResponseArray,
+		Items: keys,
+	}
+}
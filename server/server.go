@@ -14,6 +14,10 @@ Server manages listener lifecycle and client connection goroutines.
 type Server struct {
 	addr  string
 	store store.DataStore
+	clock store.Clock
+
+	// waits parks the connections blocked on keys.
+	waits *waitList
 
 	ln           net.Listener
 	wg           sync.WaitGroup
@@ -23,12 +27,34 @@ type Server struct {
 
 }
 
-func NewServer(addr string, store store.DataStore) *Server {
-	return &Server{
-		addr:         addr,
-		store:        store,
-		ready:        make(chan struct{}),
+/*
+Option configures optional behavior of a Server.
+*/
+type Option func(*Server)
+
+/*
+WithClock sets the clock used to turn TTLs into expiry times. It
+should be the clock the store was built with; the default is
+store.SystemClock.
+*/
+func WithClock(clock store.Clock) Option {
+	return func(s *Server) {
+		s.clock = clock
+	}
+}
+
+func NewServer(addr string, dataStore store.DataStore, opts ...Option) *Server {
+	s := &Server{
+		addr:  addr,
+		store: dataStore,
+		clock: store.SystemClock(),
+		waits: newWaitList(),
+		ready: make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
+	return s
 }
 
 /*
@@ -60,12 +86,13 @@ func (s *Server) Start() error {
 }
 
 func (s *Server) handleConnection(conn net.Conn) {
-	handleConnection(conn, s.store)
+	handleConnection(conn, s.store, s.clock, s.waits)
 }
 
 /*
 Stop initiates graceful shutdown:
 - stops accepting new connections
+- releases clients blocked on keys
 - waits for active handlers to exit
 */
 func (s *Server) Stop() {
@@ -73,5 +100,6 @@ func (s *Server) Stop() {
 	if s.ln != nil {
 		s.ln.Close()
 	}
+	s.waits.close()
 	s.wg.Wait()
 }
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"slices"
+
+	"hermes/protocol"
+	"hermes/store"
+)
+
+/*
+executeSetAlgebra serves SINTER, SUNION and SDIFF: it combines the
+members of every set named, a missing key counting as an empty set,
+and replies with the resulting members in order. The sets are read
+at one instant, with every key held.
+*/
+func executeSetAlgebra(cmd protocol.Command, dataStore store.Tx, clock store.Clock) Response {
+	return atomically(dataStore, cmd.Args, func(tx store.Tx) Response {
+		var result map[string]struct{}
+		for i, key := range cmd.Args {
+			reply, err := store.Apply(tx, key, store.Op{
+				Name: protocol.CommandSMembers,
+				Time: store.GetUnixTimestamp(clock.Now()),
+			})
+			if err != nil {
+				return errorResponse(err)
+			}
+			members := reply.([]string)
+
+			if i == 0 {
+				result = make(map[string]struct{}, len(members))
+				for _, member := range members {
+					result[member] = struct{}{}
+				}
+				continue
+			}
+			combine(cmd.Name, result, members)
+		}
+
+		sorted := make([]string, 0, len(result))
+		for member := range result {
+			sorted = append(sorted, member)
+		}
+		slices.Sort(sorted)
+		return opResponse(sorted)
+	})
+}
+
+/*
+combine applies one set of members to result, as the command cmd
+does with each set after the first.
+*/
+func combine(cmd string, result map[string]struct{}, members []string) {
+	switch cmd {
+	case protocol.CommandSInter:
+		other := make(map[string]struct{}, len(members))
+		for _, member := range members {
+			other[member] = struct{}{}
+		}
+		for member := range result {
+			if _, ok := other[member]; !ok {
+				delete(result, member)
+			}
+		}
+
+	case protocol.CommandSUnion:
+		for _, member := range members {
+			result[member] = struct{}{}
+		}
+
+	case protocol.CommandSDiff:
+		for _, member := range members {
+			delete(result, member)
+		}
+	}
+}
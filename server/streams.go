@@ -0,0 +1,85 @@
+package server
+
+import (
+	"strconv"
+
+	"hermes/protocol"
+	"hermes/store"
+)
+
+/*
+executeXGroup serves XGROUP: its key follows the subcommand, which
+the store runs as the first argument of the XGROUP op. CREATE and
+SETID reply OK.
+*/
+func executeXGroup(cmd protocol.Command, dataStore store.Tx, clock store.Clock) Response {
+	reply, err := store.Apply(dataStore, cmd.Args[1], store.Op{
+		Name: cmd.Name,
+		Args: append([]string{cmd.Args[0]}, cmd.Args[2:]...),
+		Time: store.GetUnixTimestamp(clock.Now()),
+	})
+	if err != nil {
+		return errorResponse(err)
+	}
+	if reply == nil {
+		return Response{Kind: ResponseOK}
+	}
+	return opResponse(reply)
+}
+
+/*
+executeStreamRead serves one attempt of XREAD or XREADGROUP. It never
+blocks, which is also how both behave inside a transaction.
+*/
+func executeStreamRead(cmd protocol.Command, dataStore store.Tx, clock store.Clock) Response {
+	r := cmd.StreamRead()
+	return readStreams(&r, dataStore, clock)
+}
+
+/*
+readStreams reads every stream of r at one instant, and replies with
+[key, entries] for each key that has entries, or nil if none has.
+XREADGROUP also replies for keys read by ID, which list the
+consumer's pending entries, even when there are none.
+
+XREAD IDs given as "$" are resolved in r to the last ID of their
+stream, so that a blocked read retried later waits for the entries
+added after the first attempt.
+*/
+func readStreams(r *protocol.StreamRead, dataStore store.Tx, clock store.Clock) Response {
+	count := strconv.Itoa(r.Count)
+	return atomically(dataStore, r.Keys, func(tx store.Tx) Response {
+		var items []Response
+		for i, key := range r.Keys {
+			op := store.Op{Name: protocol.CommandXRead, Args: []string{r.IDs[i], count}}
+			if r.Group != "" {
+				op = store.Op{Name: protocol.CommandXReadGroup, Args: []string{r.Group, r.Consumer, r.IDs[i], count}}
+				if r.NoAck {
+					op.Args = append(op.Args, protocol.FlagNoAck)
+				}
+			}
+			op.Time = store.GetUnixTimestamp(clock.Now())
+
+			reply, err := store.Apply(tx, key, op)
+			if err != nil {
+				return errorResponse(err)
+			}
+
+			var entries []any
+			if r.Group == "" {
+				resolved := reply.([]any)
+				r.IDs[i], entries = resolved[0].(string), resolved[1].([]any)
+			} else {
+				entries = reply.([]any)
+			}
+			if len(entries) > 0 || (r.Group != "" && r.IDs[i] != ">") {
+				items = append(items, opResponse([]any{key, entries}))
+			}
+		}
+
+		if len(items) == 0 {
+			return Response{Kind: ResponseNil}
+		}
+		return Response{Kind: ResponseArray, Items: items}
+	})
+}
@@ -0,0 +1,237 @@
+package server
+
+import (
+	"errors"
+
+	"hermes/protocol"
+	"hermes/store"
+)
+
+// errWatchedKeyChanged aborts EXEC when a watched key was modified.
+var errWatchedKeyChanged = errors.New("watched key changed")
+
+/*
+session holds the transaction state of a single client connection.
+
+It is owned by the connection goroutine and never shared, so it
+needs no synchronization. Atomicity comes from store.Atomic at EXEC
+time; nothing is held while commands are being queued.
+*/
+type session struct {
+	store store.DataStore
+	clock store.Clock
+
+	// waits is where blocking commands park, shared with the other
+	// connections of the server.
+	waits *waitList
+
+	// hangup, when set, watches the client's connection while a
+	// command blocks or waits on the store: the channel it returns is closed once the client
+	// hangs up, and stop ends the watch.
+	hangup func() (gone <-chan struct{}, stop func())
+
+	// inMulti is set between MULTI and EXEC/DISCARD.
+	inMulti bool
+
+	// aborted is set when a command fails to queue; EXEC then refuses
+	// to run a transaction the client did not fully describe.
+	aborted bool
+
+	queued []protocol.Command
+
+	// watched maps each WATCHed key to the version observed at WATCH
+	// time. Version 0 means the key did not exist.
+	watched map[string]uint64
+}
+
+func newSession(dataStore store.DataStore, clock store.Clock, waits *waitList) *session {
+	return &session{
+		store: dataStore,
+		clock: clock,
+		waits: waits,
+	}
+}
+
+/*
+handle executes a command, or queues it while a transaction is open.
+Transaction control commands are interpreted here and never reach
+executeCommand.
+*/
+func (s *session) handle(cmd protocol.Command) Response {
+	switch cmd.Name {
+	case protocol.CommandMulti:
+		if s.inMulti {
+			return clientError("MULTI calls can not be nested")
+		}
+		s.inMulti = true
+		return Response{Kind: ResponseOK}
+
+	case protocol.CommandExec:
+		return s.exec()
+
+	case protocol.CommandDiscard:
+		if !s.inMulti {
+			return clientError("DISCARD without MULTI")
+		}
+		s.reset()
+		return Response{Kind: ResponseOK}
+
+	case protocol.CommandWatch:
+		if s.inMulti {
+			return clientError("WATCH inside MULTI is not allowed")
+		}
+		s.watch(cmd.Keys())
+		return Response{Kind: ResponseOK}
+
+	case protocol.CommandScan, protocol.CommandKeys,
+		protocol.CommandRange, protocol.CommandRevRange, protocol.CommandPrefix:
+		// Scans and ordered traversals work on the whole keyspace,
+		// not on declared keys, so they cannot join a transaction
+		if s.inMulti {
+			return clientError(cmd.Name + " inside MULTI is not allowed")
+		}
+
+	case protocol.CommandHistory, protocol.CommandGet:
+		// History is kept by the store, not by the transaction
+		if s.inMulti && readsHistory(cmd) {
+			return clientError(cmd.Name + " of past values inside MULTI is not allowed")
+		}
+
+	case protocol.CommandUnwatch:
+		if !s.inMulti {
+			s.watched = nil
+			return Response{Kind: ResponseOK}
+		}
+
+	case protocol.CommandBLPop, protocol.CommandBRPop:
+		// Inside MULTI the pop runs once at EXEC, without blocking
+		if !s.inMulti {
+			return s.blockingPop(cmd)
+		}
+
+	case protocol.CommandXRead, protocol.CommandXReadGroup:
+		if r := cmd.StreamRead(); r.Blocking && !s.inMulti {
+			return s.blockingStreamRead(cmd, r)
+		}
+	}
+
+	if s.inMulti {
+		s.queued = append(s.queued, cmd)
+		return Response{Kind: ResponseQueued}
+	}
+
+	resp := s.execute(cmd)
+	s.wake(cmd)
+	return resp
+}
+
+/*
+rejectQueued records that a command sent inside MULTI was invalid.
+*/
+func (s *session) rejectQueued() {
+	if s.inMulti {
+		s.aborted = true
+	}
+}
+
+/*
+watch records the current version of each key.
+
+Watching a key twice keeps the first observed version, so a change
+between the two WATCH calls still aborts the transaction.
+*/
+func (s *session) watch(keys []string) {
+	if s.watched == nil {
+		s.watched = make(map[string]uint64, len(keys))
+	}
+
+	current := s.store.ReadBatch(keys)
+	for _, key := range keys {
+		if _, ok := s.watched[key]; ok {
+			continue
+		}
+		s.watched[key] = current[key].Version
+	}
+}
+
+/*
+exec runs the queued commands atomically.
+
+All keys touched by queued commands, plus the watched keys, are held
+through store.Atomic. Watched versions are re-checked while holding
+them: any difference aborts the transaction without running anything
+and EXEC replies nil, like Redis.
+*/
+func (s *session) exec() Response {
+	if !s.inMulti {
+		return clientError("EXEC without MULTI")
+	}
+	defer s.reset()
+
+	if s.aborted {
+		return clientError("EXECABORT Transaction discarded because of previous errors")
+	}
+
+	watchedKeys := make([]string, 0, len(s.watched))
+	for key := range s.watched {
+		watchedKeys = append(watchedKeys, key)
+	}
+
+	keys := append([]string(nil), watchedKeys...)
+	for _, cmd := range s.queued {
+		keys = append(keys, cmd.Keys()...)
+	}
+
+	results := make([]Response, len(s.queued))
+	err := s.store.Atomic(keys, func(tx store.Tx) error {
+		current := tx.ReadBatch(watchedKeys)
+		for key, version := range s.watched {
+			if current[key].Version != version {
+				return errWatchedKeyChanged
+			}
+		}
+
+		for i, cmd := range s.queued {
+			// UNWATCH is meaningless once EXEC has started
+			if cmd.Name == protocol.CommandUnwatch {
+				results[i] = Response{Kind: ResponseOK}
+				continue
+			}
+			results[i] = executeCommand(cmd, tx, s.clock)
+		}
+		return nil
+	})
+
+	if errors.Is(err, errWatchedKeyChanged) {
+		return Response{Kind: ResponseNil}
+	}
+	if err != nil {
+		return Response{Kind: ResponseServerError}
+	}
+
+	for _, cmd := range s.queued {
+		s.wake(cmd)
+	}
+	return Response{
+		Kind:  ResponseArray,
+		Items: results,
+	}
+}
+
+/*
+reset leaves the transaction and drops every watch, as EXEC and
+DISCARD both do.
+*/
+func (s *session) reset() {
+	s.inMulti = false
+	s.aborted = false
+	s.queued = nil
+	s.watched = nil
+}
+
+func clientError(msg string) Response {
+	return Response{
+		Kind:  ResponseClientError,
+		Value: msg,
+	}
+}
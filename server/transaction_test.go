@@ -0,0 +1,167 @@
+package server
+
+import (
+	"hermes/protocol"
+	"hermes/store"
+	"testing"
+)
+
+func mustParse(t *testing.T, line string) protocol.Command {
+	t.Helper()
+
+	cmd, err := protocol.ParseLine(line)
+	if err != nil {
+		t.Fatalf("parse %q failed: %v", line, err)
+	}
+	return cmd
+}
+
+func TestSession_MultiExec(t *testing.T) {
+	sess := newSession(store.NewShardedStore(8), store.SystemClock(), newWaitList())
+
+	if resp := sess.handle(mustParse(t, "MULTI")); resp.Kind != ResponseOK {
+		t.Fatalf("expected OK, got %+v", resp)
+	}
+	for _, line := range []string{"SET a 1", "MSET b 2 c 3", "MGET a b c"} {
+		if resp := sess.handle(mustParse(t, line)); resp.Kind != ResponseQueued {
+			t.Fatalf("expected QUEUED for %q, got %+v", line, resp)
+		}
+	}
+
+	// Nothing runs before EXEC
+	if _, ok := sess.store.Read("a"); ok {
+		t.Fatalf("queued command executed before EXEC")
+	}
+
+	resp := sess.handle(mustParse(t, "EXEC"))
+	if resp.Kind != ResponseArray || len(resp.Items) != 3 {
+		t.Fatalf("expected 3 results, got %+v", resp)
+	}
+
+	mget := resp.Items[2]
+	if mget.Kind != ResponseArray || mget.Items[0].Value != "1" || mget.Items[2].Value != "3" {
+		t.Fatalf("transaction did not observe its own writes: %+v", mget)
+	}
+}
+
+func TestSession_Discard(t *testing.T) {
+	sess := newSession(store.NewLockedStore(), store.SystemClock(), newWaitList())
+
+	sess.handle(mustParse(t, "MULTI"))
+	sess.handle(mustParse(t, "SET a 1"))
+
+	if resp := sess.handle(mustParse(t, "DISCARD")); resp.Kind != ResponseOK {
+		t.Fatalf("expected OK, got %+v", resp)
+	}
+	if _, ok := sess.store.Read("a"); ok {
+		t.Fatalf("discarded command was executed")
+	}
+
+	if resp := sess.handle(mustParse(t, "EXEC")); resp.Kind != ResponseClientError {
+		t.Fatalf("expected EXEC without MULTI error, got %+v", resp)
+	}
+}
+
+func TestSession_ControlErrors(t *testing.T) {
+	sess := newSession(store.NewLockedStore(), store.SystemClock(), newWaitList())
+
+	if resp := sess.handle(mustParse(t, "DISCARD")); resp.Kind != ResponseClientError {
+		t.Fatalf("expected DISCARD without MULTI error, got %+v", resp)
+	}
+
+	sess.handle(mustParse(t, "MULTI"))
+	if resp := sess.handle(mustParse(t, "MULTI")); resp.Kind != ResponseClientError {
+		t.Fatalf("expected nested MULTI error, got %+v", resp)
+	}
+	if resp := sess.handle(mustParse(t, "WATCH a")); resp.Kind != ResponseClientError {
+		t.Fatalf("expected WATCH inside MULTI error, got %+v", resp)
+	}
+	if resp := sess.handle(mustParse(t, "SCAN 0")); resp.Kind != ResponseClientError {
+		t.Fatalf("expected SCAN inside MULTI error, got %+v", resp)
+	}
+}
+
+func TestSession_WatchConflictAbortsExec(t *testing.T) {
+	ds := store.NewEventloopStore(16)
+	sess := newSession(ds, store.SystemClock(), newWaitList())
+	other := newSession(ds, store.SystemClock(), newWaitList())
+
+	sess.handle(mustParse(t, "WATCH a"))
+
+	// Another client modifies the watched key
+	other.handle(mustParse(t, "SET a changed"))
+
+	sess.handle(mustParse(t, "MULTI"))
+	sess.handle(mustParse(t, "SET a mine"))
+
+	if resp := sess.handle(mustParse(t, "EXEC")); resp.Kind != ResponseNil {
+		t.Fatalf("expected nil EXEC on conflict, got %+v", resp)
+	}
+
+	val, _ := ds.Read("a")
+	if string(val.Value) != "changed" {
+		t.Fatalf("aborted transaction was applied")
+	}
+}
+
+func TestSession_WatchWithoutConflict(t *testing.T) {
+	sess := newSession(store.NewLockedStore(), store.SystemClock(), newWaitList())
+
+	sess.handle(mustParse(t, "SET a 1"))
+	sess.handle(mustParse(t, "WATCH a missing"))
+	sess.handle(mustParse(t, "MULTI"))
+	sess.handle(mustParse(t, "SET a 2"))
+
+	resp := sess.handle(mustParse(t, "EXEC"))
+	if resp.Kind != ResponseArray || len(resp.Items) != 1 || resp.Items[0].Kind != ResponseOK {
+		t.Fatalf("expected successful EXEC, got %+v", resp)
+	}
+}
+
+func TestSession_WatchDetectsCreationAndExpire(t *testing.T) {
+	ds := store.NewLockedStore()
+	ds.Write("ttl", store.Entry{Value: []byte("v")}, store.PutOverwrite)
+
+	for _, change := range []string{"SET missing now", "EXPIRE ttl 100"} {
+		sess := newSession(ds, store.SystemClock(), newWaitList())
+		sess.handle(mustParse(t, "WATCH missing ttl"))
+
+		executeCommand(mustParse(t, change), ds, store.SystemClock())
+
+		sess.handle(mustParse(t, "MULTI"))
+		sess.handle(mustParse(t, "GET ttl"))
+		if resp := sess.handle(mustParse(t, "EXEC")); resp.Kind != ResponseNil {
+			t.Fatalf("%q did not abort watching transaction: %+v", change, resp)
+		}
+	}
+}
+
+func TestSession_UnwatchClearsWatches(t *testing.T) {
+	ds := store.NewLockedStore()
+	sess := newSession(ds, store.SystemClock(), newWaitList())
+
+	sess.handle(mustParse(t, "WATCH a"))
+	sess.handle(mustParse(t, "UNWATCH"))
+	executeCommand(mustParse(t, "SET a 1"), ds, store.SystemClock())
+
+	sess.handle(mustParse(t, "MULTI"))
+	sess.handle(mustParse(t, "GET a"))
+	if resp := sess.handle(mustParse(t, "EXEC")); resp.Kind != ResponseArray {
+		t.Fatalf("expected EXEC to run after UNWATCH, got %+v", resp)
+	}
+}
+
+func TestSession_RejectedCommandAbortsExec(t *testing.T) {
+	sess := newSession(store.NewLockedStore(), store.SystemClock(), newWaitList())
+
+	sess.handle(mustParse(t, "MULTI"))
+	sess.handle(mustParse(t, "SET a 1"))
+	sess.rejectQueued()
+
+	if resp := sess.handle(mustParse(t, "EXEC")); resp.Kind != ResponseClientError {
+		t.Fatalf("expected EXECABORT, got %+v", resp)
+	}
+	if _, ok := sess.store.Read("a"); ok {
+		t.Fatalf("aborted transaction was applied")
+	}
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"errors"
+
+	"hermes/store"
+)
+
+/*
+readTyped reads key for a command that works on values of type t. A
+key holding any other type fails with store.ErrWrongType; a missing
+key is reported absent, as every type treats it as empty. The value is
+returned decoded, whether or not a compressed store is in front.
+*/
+func readTyped(dataStore store.Tx, key string, t store.ValueType) (store.Entry, bool, error) {
+	entry, ok := dataStore.Read(key)
+	if !ok {
+		return store.Entry{}, false, nil
+	}
+	if entry.Type != t {
+		return store.Entry{}, false, store.ErrWrongType
+	}
+	if entry, ok = store.DecodeEntry(entry); !ok {
+		return store.Entry{}, false, store.ErrUnknownEncoding
+	}
+	return entry, true, nil
+}
+
+/*
+errorResponse turns an error returned by the store into a reply. A type
+mismatch gets the WRONGTYPE reply whichever command ran into it, like
+in Redis.
+*/
+func errorResponse(err error) Response {
+	if errors.Is(err, store.ErrWrongType) {
+		return Response{Kind: ResponseWrongType}
+	}
+	return clientError(err.Error())
+}
+
+/*
+executeType serves TYPE key: the name of the type of the key's value,
+or "none" if the key does not exist.
+*/
+func executeType(key string, dataStore store.Tx) Response {
+	entry, ok := dataStore.Read(key)
+	if !ok {
+		return Response{Kind: ResponseValue, Value: "none"}
+	}
+	return Response{Kind: ResponseValue, Value: entry.Type.String()}
+}
+
+/*
+stringValue is the reply element for a value listed alongside others,
+by MGET, traversals or HISTORY: the value itself for a string, nil
+for any other type, whose encoding is not meant for clients. A value
+that cannot be decoded is listed as nil too.
+*/
+func stringValue(entry store.Entry) Response {
+	if entry.Type != store.TypeString {
+		return Response{Kind: ResponseNil}
+	}
+	entry, ok := store.DecodeEntry(entry)
+	if !ok {
+		return Response{Kind: ResponseNil}
+	}
+	return Response{Kind: ResponseValue, Value: string(entry.Value)}
+}
@@ -25,11 +25,29 @@ Item is a minimal DTO representing a single persisted entry.
 Design choice:
 - This struct intentionally does NOT depend on store.Entry
 - Acts as a stable persistence boundary even if store internals evolve
+
+WrittenAt is when the value was written (Unix milliseconds), 0 if
+unknown. History, when set, is the key's full recorded history, oldest
+first, ending with the item's own value: loading replaces the key's
+history with it. Its items carry no Key or History of their own.
+
+Encoding tells how Value is encoded, 0 for a plain value, and Type
+the kind of value it holds, 0 for a string. Like the value itself,
+both are stored and handed back without interpretation.
+
+An item with a Checkpoint has nothing else: it identifies the point
+of the write-ahead log the snapshot was taken at, also opaque here.
 */
 type Item struct {
 	Key       string
 	Value     []byte
 	ExpiresAt int64
+	WrittenAt int64
+	Encoding  uint8
+	Type      uint8
+	History   []Item
+
+	Checkpoint uint64
 }
 
 /*
@@ -42,14 +60,62 @@ Why push-based (instead of pull / iterator object):
 */
 type Streamer func(yield func(Item) bool)
 
+/*
+Item layouts. An item starts with the key length; a negative value
+instead tags a newer layout, so snapshots written before write times
+and histories existed still load.
+
+	legacy:   [KeyLen:int32][Key][ValLen:int32][Value][Expire:int64]
+	stamped:  [-1][KeyLen:int32][Key][Revision]
+	history:  [-2][KeyLen:int32][Key][Count:int32][Revision]...
+	encoded:  [-3][KeyLen:int32][Key][Encoding:uint8][Revision]
+	encodedH: [-4][KeyLen:int32][Key][Count:int32]([Encoding:uint8][Revision])...
+	typed:    [-5][KeyLen:int32][Key][Encoding:uint8][Type:uint8][Revision]
+	typedH:   [-6][KeyLen:int32][Key][Count:int32]([Encoding:uint8][Type:uint8][Revision])...
+	mark:     [-7][Checkpoint:uint64]
+
+	Revision: [ValLen:int32][Value][Expire:int64][WrittenAt:int64]
+
+An item is written in the oldest layout that holds it: the encoded
+layouts only for items with an encoded value, the typed ones only for
+items holding something other than a string. Snapshots of plain
+strings keep the older layouts.
+*/
+const (
+	itemStamped        int32 = -1
+	itemHistory        int32 = -2
+	itemEncoded        int32 = -3
+	itemEncodedHistory int32 = -4
+	itemTyped          int32 = -5
+	itemTypedHistory   int32 = -6
+	itemCheckpoint     int32 = -7
+)
+
+/*
+Metadata carried by each revision of an item, by layout.
+*/
+const (
+	metaNone     = iota // no metadata
+	metaEncoding        // the encoding
+	metaType            // the encoding and the type
+)
+
+// Layout tags by metadata, for single items and for histories.
+var (
+	itemTags    = [...]int32{itemStamped, itemEncoded, itemTyped}
+	historyTags = [...]int32{itemHistory, itemEncodedHistory, itemTypedHistory}
+)
+
 /*
 Write serializes a stream of items into a compact binary snapshot.
 
-Binary Format (Little Endian): [KeyLen:int32][KeyBytes][ValLen:int32][ValueBytes][Expire:int64]
+Binary Format (Little Endian): one item after another, with no header
+(see the item layouts above).
 
 - Binary over JSON → smaller, faster, deterministic
 - Length-prefixed fields → safe parsing without delimiters
 - One-pass streaming → no need to buffer entire dataset in memory
+- No header → snapshots written in several calls concatenate
 */
 func Write(w io.Writer, stream Streamer) error {
 	var writeErr error
@@ -62,19 +128,51 @@ func Write(w io.Writer, stream Streamer) error {
 		writeErr = binary.Write(w, binary.LittleEndian, v)
 	}
 
+	writeBytes := func(b []byte) {
+		write(int32(len(b)))
+		if writeErr == nil {
+			_, writeErr = w.Write(b)
+		}
+	}
+
+	writeRevision := func(item Item, meta int) {
+		if meta >= metaEncoding {
+			write(item.Encoding)
+		}
+		if meta >= metaType {
+			write(item.Type)
+		}
+		writeBytes(item.Value)
+		write(int64(item.ExpiresAt))
+		write(int64(item.WrittenAt))
+	}
+
 	// Stream items one-by-one to avoid memory amplification
 	stream(func(item Item) bool {
-		write(int32(len(item.Key)))
-		if writeErr == nil {
-			_, writeErr = w.Write([]byte(item.Key))
+		if item.Checkpoint != 0 {
+			write(itemCheckpoint)
+			write(item.Checkpoint)
+			return writeErr == nil
+		}
+		if len(item.History) == 0 {
+			meta := metaOf(item)
+			write(itemTags[meta])
+			writeBytes([]byte(item.Key))
+			writeRevision(item, meta)
+			return writeErr == nil
 		}
 
-		write(int32(len(item.Value)))
-		if writeErr == nil {
-			_, writeErr = w.Write(item.Value)
+		meta := metaNone
+		for _, rev := range item.History {
+			meta = max(meta, metaOf(rev))
 		}
 
-		write(int64(item.ExpiresAt))
+		write(historyTags[meta])
+		writeBytes([]byte(item.Key))
+		write(int32(len(item.History)))
+		for _, rev := range item.History {
+			writeRevision(rev, meta)
+		}
 
 		// Stop streaming on first failure
 		return writeErr == nil
@@ -94,45 +192,169 @@ This strictness prevents silently loading inconsistent state.
 */
 func Load(r io.Reader, set func(Item)) error {
 	for {
-		var keyLen int32
-		if err := binary.Read(r, binary.LittleEndian, &keyLen); err != nil {
+		var tag int32
+		if err := binary.Read(r, binary.LittleEndian, &tag); err != nil {
 			if err == io.EOF {
 				return nil // End of file, success
 			}
 			return err
 		}
-		if keyLen < 0 {
+
+		item, err := loadItem(r, tag)
+		if err == io.EOF {
+			// The file ended inside an item
 			return io.ErrUnexpectedEOF
 		}
-
-		keyBytes := make([]byte, keyLen)
-		if _, err := io.ReadFull(r, keyBytes); err != nil {
+		if err != nil {
 			return err
 		}
 
-		var valLen int32
-		if err := binary.Read(r, binary.LittleEndian, &valLen); err != nil {
-			return err
-		}
-		if valLen < 0 {
-			return io.ErrUnexpectedEOF
-		}
+		// Delegate application logic to caller
+		set(item)
+	}
+}
 
-		valBytes := make([]byte, valLen)
-		if _, err := io.ReadFull(r, valBytes); err != nil {
-			return err
+/*
+loadItem reads the rest of an item whose first field was tag.
+*/
+func loadItem(r io.Reader, tag int32) (Item, error) {
+	if tag >= 0 {
+		key, err := readBytesOfLen(r, tag)
+		if err != nil {
+			return Item{}, err
+		}
+		value, err := readBytes(r)
+		if err != nil {
+			return Item{}, err
 		}
 
 		var expire int64
 		if err := binary.Read(r, binary.LittleEndian, &expire); err != nil {
-			return err
+			return Item{}, err
 		}
+		return Item{Key: string(key), Value: value, ExpiresAt: expire}, nil
+	}
+	if tag == itemCheckpoint {
+		var id uint64
+		if err := binary.Read(r, binary.LittleEndian, &id); err != nil {
+			return Item{}, err
+		}
+		return Item{Checkpoint: id}, nil
+	}
 
-		// Delegate application logic to caller
-		set(Item{
-			Key:       string(keyBytes),
-			Value:     valBytes,
-			ExpiresAt: expire,
-		})
+	key, err := readBytes(r)
+	if err != nil {
+		return Item{}, err
+	}
+
+	switch tag {
+	case itemStamped, itemEncoded, itemTyped:
+		item, err := readRevision(r, tagMeta(tag))
+		item.Key = string(key)
+		return item, err
+
+	case itemHistory, itemEncodedHistory, itemTypedHistory:
+		var count int32
+		if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+			return Item{}, err
+		}
+		if count <= 0 {
+			return Item{}, io.ErrUnexpectedEOF
+		}
+
+		history := make([]Item, count)
+		for i := range history {
+			if history[i], err = readRevision(r, tagMeta(tag)); err != nil {
+				return Item{}, err
+			}
+		}
+
+		item := history[count-1]
+		item.Key = string(key)
+		item.History = history
+		return item, nil
+
+	default:
+		return Item{}, io.ErrUnexpectedEOF
+	}
+}
+
+/*
+readRevision reads a revision, preceded by the metadata its layout
+carries.
+*/
+func readRevision(r io.Reader, meta int) (Item, error) {
+	var encoding, typ uint8
+	if meta >= metaEncoding {
+		if err := binary.Read(r, binary.LittleEndian, &encoding); err != nil {
+			return Item{}, err
+		}
+	}
+	if meta >= metaType {
+		if err := binary.Read(r, binary.LittleEndian, &typ); err != nil {
+			return Item{}, err
+		}
+	}
+
+	value, err := readBytes(r)
+	if err != nil {
+		return Item{}, err
+	}
+
+	var times [2]int64
+	if err := binary.Read(r, binary.LittleEndian, &times); err != nil {
+		return Item{}, err
+	}
+	return Item{Value: value, ExpiresAt: times[0], WrittenAt: times[1], Encoding: encoding, Type: typ}, nil
+}
+
+/*
+metaOf returns the metadata a revision needs written.
+*/
+func metaOf(item Item) int {
+	switch {
+	case item.Type != 0:
+		return metaType
+	case item.Encoding != 0:
+		return metaEncoding
+	default:
+		return metaNone
+	}
+}
+
+/*
+tagMeta returns the metadata carried by the revisions of a layout.
+*/
+func tagMeta(tag int32) int {
+	switch tag {
+	case itemTyped, itemTypedHistory:
+		return metaType
+	case itemEncoded, itemEncodedHistory:
+		return metaEncoding
+	default:
+		return metaNone
+	}
+}
+
+/*
+readBytes reads a length-prefixed byte string.
+*/
+func readBytes(r io.Reader) ([]byte, error) {
+	var n int32
+	if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return nil, err
+	}
+	return readBytesOfLen(r, n)
+}
+
+func readBytesOfLen(r io.Reader, n int32) ([]byte, error) {
+	if n < 0 {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
 	}
+	return b, nil
 }
@@ -239,3 +239,191 @@ func TestSnapshot_LoadValueLenReadError(t *testing.T) {
 		t.Fatal("expected error while reading valLen, got nil")
 	}
 }
+
+func TestSnapshot_RoundTripHistory(t *testing.T) {
+	var buf bytes.Buffer
+
+	history := []Item{
+		{Value: []byte("v1"), WrittenAt: 10},
+		{Value: []byte("v2"), ExpiresAt: 99, WrittenAt: 20},
+	}
+	items := []Item{
+		{Key: "plain", Value: []byte("p"), WrittenAt: 5},
+		{Key: "tracked", History: history},
+	}
+
+	err := Write(&buf, func(yield func(Item) bool) {
+		for _, it := range items {
+			if !yield(it) {
+				return
+			}
+		}
+	})
+	if err != nil {
+		t.Fatalf("snapshot write failed: %v", err)
+	}
+
+	var loaded []Item
+	if err := Load(&buf, func(it Item) { loaded = append(loaded, it) }); err != nil {
+		t.Fatalf("snapshot load failed: %v", err)
+	}
+	if len(loaded) != 2 {
+		t.Fatalf("expected 2 items, got %d", len(loaded))
+	}
+
+	if loaded[0].WrittenAt != 5 || loaded[0].History != nil {
+		t.Fatalf("plain item mismatch: %+v", loaded[0])
+	}
+
+	tracked := loaded[1]
+	if tracked.Key != "tracked" || string(tracked.Value) != "v2" ||
+		tracked.ExpiresAt != 99 || tracked.WrittenAt != 20 {
+		t.Fatalf("history item must take its value from the last revision: %+v", tracked)
+	}
+	if len(tracked.History) != 2 || string(tracked.History[0].Value) != "v1" ||
+		tracked.History[0].WrittenAt != 10 {
+		t.Fatalf("history mismatch: %+v", tracked.History)
+	}
+}
+
+func TestSnapshot_RoundTripEncoding(t *testing.T) {
+	var buf bytes.Buffer
+
+	items := []Item{
+		{Key: "plain", Value: []byte("p"), WrittenAt: 5},
+		{Key: "packed", Value: []byte("z"), WrittenAt: 6, Encoding: 1},
+		{Key: "mixed", History: []Item{
+			{Value: []byte("v1"), WrittenAt: 10},
+			{Value: []byte("v2"), WrittenAt: 20, Encoding: 1},
+		}},
+	}
+
+	err := Write(&buf, func(yield func(Item) bool) {
+		for _, it := range items {
+			if !yield(it) {
+				return
+			}
+		}
+	})
+	if err != nil {
+		t.Fatalf("snapshot write failed: %v", err)
+	}
+
+	var loaded []Item
+	if err := Load(&buf, func(it Item) { loaded = append(loaded, it) }); err != nil {
+		t.Fatalf("snapshot load failed: %v", err)
+	}
+	if len(loaded) != 3 {
+		t.Fatalf("expected 3 items, got %d", len(loaded))
+	}
+
+	if loaded[0].Encoding != 0 || loaded[1].Encoding != 1 || string(loaded[1].Value) != "z" {
+		t.Fatalf("item encodings mismatch: %+v", loaded[:2])
+	}
+	mixed := loaded[2]
+	if mixed.Encoding != 1 || len(mixed.History) != 2 ||
+		mixed.History[0].Encoding != 0 || mixed.History[1].Encoding != 1 {
+		t.Fatalf("history encodings mismatch: %+v", mixed)
+	}
+}
+
+func TestSnapshot_RoundTripType(t *testing.T) {
+	var buf bytes.Buffer
+
+	items := []Item{
+		{Key: "list", Value: []byte("l"), WrittenAt: 5, Type: 1},
+		{Key: "packed", Value: []byte("h"), WrittenAt: 6, Encoding: 1, Type: 2},
+		{Key: "retyped", History: []Item{
+			{Value: []byte("v1"), WrittenAt: 10, Encoding: 1},
+			{Value: []byte("v2"), WrittenAt: 20, Type: 3},
+		}},
+	}
+
+	err := Write(&buf, func(yield func(Item) bool) {
+		for _, it := range items {
+			if !yield(it) {
+				return
+			}
+		}
+	})
+	if err != nil {
+		t.Fatalf("snapshot write failed: %v", err)
+	}
+
+	var loaded []Item
+	if err := Load(&buf, func(it Item) { loaded = append(loaded, it) }); err != nil {
+		t.Fatalf("snapshot load failed: %v", err)
+	}
+	if len(loaded) != 3 {
+		t.Fatalf("expected 3 items, got %d", len(loaded))
+	}
+
+	if loaded[0].Type != 1 || loaded[0].Encoding != 0 || string(loaded[0].Value) != "l" {
+		t.Fatalf("item type mismatch: %+v", loaded[0])
+	}
+	if loaded[1].Type != 2 || loaded[1].Encoding != 1 {
+		t.Fatalf("item type and encoding mismatch: %+v", loaded[1])
+	}
+	retyped := loaded[2]
+	if retyped.Type != 3 || len(retyped.History) != 2 ||
+		retyped.History[0].Type != 0 || retyped.History[0].Encoding != 1 ||
+		retyped.History[1].Type != 3 {
+		t.Fatalf("history types mismatch: %+v", retyped)
+	}
+}
+
+func TestSnapshot_RoundTripCheckpoint(t *testing.T) {
+	var buf bytes.Buffer
+
+	items := []Item{
+		{Key: "k", Value: []byte("v"), WrittenAt: 5},
+		{Checkpoint: 1<<63 + 7},
+	}
+	err := Write(&buf, func(yield func(Item) bool) {
+		for _, it := range items {
+			if !yield(it) {
+				return
+			}
+		}
+	})
+	if err != nil {
+		t.Fatalf("snapshot write failed: %v", err)
+	}
+
+	var loaded []Item
+	if err := Load(&buf, func(it Item) { loaded = append(loaded, it) }); err != nil {
+		t.Fatalf("snapshot load failed: %v", err)
+	}
+	if len(loaded) != 2 || loaded[0].Key != "k" || loaded[1].Checkpoint != 1<<63+7 || loaded[1].Key != "" {
+		t.Fatalf("checkpoint mismatch: %+v", loaded)
+	}
+}
+
+func TestSnapshot_LoadLegacyItem(t *testing.T) {
+	var buf bytes.Buffer
+	_ = binary.Write(&buf, binary.LittleEndian, int32(1))
+	buf.Write([]byte("k"))
+	_ = binary.Write(&buf, binary.LittleEndian, int32(1))
+	buf.Write([]byte("v"))
+	_ = binary.Write(&buf, binary.LittleEndian, int64(42))
+
+	var loaded []Item
+	if err := Load(&buf, func(it Item) { loaded = append(loaded, it) }); err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	if len(loaded) != 1 || loaded[0].Key != "k" || string(loaded[0].Value) != "v" ||
+		loaded[0].ExpiresAt != 42 || loaded[0].WrittenAt != 0 {
+		t.Fatalf("legacy item mismatch: %+v", loaded)
+	}
+}
+
+func TestSnapshot_LoadUnknownTag(t *testing.T) {
+	var buf bytes.Buffer
+	_ = binary.Write(&buf, binary.LittleEndian, int32(-8))
+	_ = binary.Write(&buf, binary.LittleEndian, int32(1))
+	buf.Write([]byte("k"))
+
+	if err := Load(&buf, func(Item) {}); err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected ErrUnexpectedEOF, got %v", err)
+	}
+}
@@ -0,0 +1,939 @@
+package store
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"hermes/wal"
+)
+
+// defaultMaxFileSize is the size at which the active data file is sealed.
+const defaultMaxFileSize = 64 << 20
+
+// defaultMergeInterval is how often sealed files are checked for merging.
+const defaultMergeInterval = time.Minute
+
+/*
+mergeFragmentation is the share of stale bytes in the sealed files
+above which an automatic merge runs.
+*/
+const mergeFragmentation = 0.5
+
+/*
+Merger is implemented by stores whose on-disk files can be compacted
+on demand.
+*/
+type Merger interface {
+	Merge() error
+}
+
+/*
+keydirEntry locates the latest record of a key: its data file, offset
+and encoded size. The TTL and write time are kept next to it, so only
+reads that return the value touch the disk.
+*/
+type keydirEntry struct {
+	file      uint32
+	offset    int64
+	size      uint32
+	expiresAt int64
+	writtenAt int64
+	version   uint64
+}
+
+func (e keydirEntry) expired(now int64) bool {
+	return e.expiresAt != 0 && now >= e.expiresAt
+}
+
+/*
+bitcaskStore is a disk-backed store in the style of Bitcask: memory
+holds only the keydir, one small entry per key, and values live in
+append-only data files.
+
+Every mutation is appended to the active data file before it is
+applied to the keydir, so the files are the store's only log and it
+needs no WAL. Superseded and expired records are reclaimed by Merge.
+
+The keydir is split over the same hash slots as a keyspace, which
+gives ScanEntries the cursor contract of the in-memory stores.
+A single lock guards the keydir and the file set, like lockedStore;
+value reads take it shared.
+*/
+type bitcaskStore struct {
+	mu  sync.RWMutex
+	dir string
+
+	clock       Clock
+	syncPolicy  wal.SyncPolicy
+	maxFileSize int64
+
+	keydir    [scanSlots]map[string]keydirEntry
+	sweepSlot int
+
+	active *dataFile
+	files  map[uint32]*dataFile
+
+	// stale counts the bytes of each file's records that are no longer
+	// needed, which is what a merge reclaims.
+	stale map[uint32]int64
+
+	// views counts open views; files retired by a merge stay open
+	// until the last view reading them is closed.
+	views   int
+	retired []*dataFile
+	closed  bool
+
+	// mergeMu lets one merge run at a time.
+	mergeMu sync.Mutex
+
+	sweeper  *sweeper
+	done     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+/*
+NewBitcaskStore opens the Bitcask store in dir, creating it if needed.
+
+The keydir is rebuilt from the data files: merged files are loaded
+from their hint files, the others are scanned. A record torn at the
+tail of the newest file is cut off; a damaged record anywhere else
+fails with ErrCorruptDataFile. Writes then go to a new active file.
+
+Durability follows WithSyncPolicy, files are sealed at WithMaxFileSize
+and merged in the background per WithMergeInterval. Expired keydir
+entries are removed by a sweeper (WithSweepInterval). History
+policies are not supported.
+*/
+func NewBitcaskStore(dir string, opts ...Option) (DataStore, error) {
+	o := newOptions(opts)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	s := &bitcaskStore{
+		dir:         dir,
+		clock:       o.clock,
+		syncPolicy:  o.syncPolicy,
+		maxFileSize: o.maxFileSize,
+		files:       make(map[uint32]*dataFile),
+		stale:       make(map[uint32]int64),
+		done:        make(chan struct{}),
+	}
+
+	last, err := s.load()
+	if err != nil {
+		s.closeFiles()
+		return nil, err
+	}
+	if err := s.rotate(last + 1); err != nil {
+		s.closeFiles()
+		return nil, err
+	}
+
+	s.sweeper = startSweeper(o.sweepInterval, s.sweepTick)
+	s.wg.Add(1)
+	go s.background(time.Duration(o.syncPolicy), o.mergeInterval)
+	return s, nil
+}
+
+/*
+load opens every data file and rebuilds the keydir. It returns the
+id of the newest file, 0 if there is none.
+*/
+func (s *bitcaskStore) load() (uint32, error) {
+	ids, err := listDataFiles(s.dir)
+	if err != nil {
+		return 0, err
+	}
+
+	// Files older than the newest merged one were its inputs
+	for i := len(ids) - 1; i > 0; i-- {
+		merged, err := isMerged(dataPath(s.dir, ids[i]))
+		if err != nil {
+			return 0, err
+		}
+		if !merged {
+			continue
+		}
+		for _, id := range ids[:i] {
+			if err := removeFile(s.dir, id); err != nil {
+				return 0, err
+			}
+		}
+		ids = ids[i:]
+		break
+	}
+
+	for i, id := range ids {
+		f, err := os.OpenFile(dataPath(s.dir, id), os.O_RDWR, 0o644)
+		if err != nil {
+			return 0, err
+		}
+		df := &dataFile{id: id, f: f}
+		s.files[id] = df
+
+		if hints, err := readHints(hintPath(s.dir, id)); err == nil {
+			s.loadHints(df, hints)
+			continue
+		}
+		if err := s.scan(df, i == len(ids)-1); err != nil {
+			return 0, err
+		}
+	}
+
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	// An empty newest file, left by a restart without writes, is
+	// reused rather than kept forever
+	last := ids[len(ids)-1]
+	if df := s.files[last]; df.size == 0 {
+		df.f.Close()
+		delete(s.files, last)
+		if err := removeFile(s.dir, last); err != nil {
+			return 0, err
+		}
+		return last - 1, nil
+	}
+	return last, nil
+}
+
+func (s *bitcaskStore) loadHints(df *dataFile, hints []hint) {
+	info, err := df.f.Stat()
+	if err == nil {
+		df.size = info.Size()
+	}
+
+	for _, h := range hints {
+		s.put(h.key, keydirEntry{
+			file:      df.id,
+			offset:    h.offset,
+			size:      h.size,
+			expiresAt: h.expiresAt,
+			writtenAt: h.writtenAt,
+			version:   nextVersion(),
+		})
+	}
+}
+
+/*
+scan replays the records of a data file into the keydir. In the newest
+file, a torn or damaged tail is truncated away, together with any
+group left without its commit.
+*/
+func (s *bitcaskStore) scan(df *dataFile, newest bool) error {
+	info, err := df.f.Stat()
+	if err != nil {
+		return err
+	}
+	r := bufio.NewReader(df.f)
+
+	type pending struct {
+		rec    record
+		offset int64
+		size   int
+	}
+	var group []pending
+	var offset, committed int64
+
+	for {
+		rec, size, err := readRecord(r, info.Size()-offset)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if !newest {
+				return err
+			}
+			break
+		}
+
+		if rec.kind&recordGrouped != 0 {
+			rec.kind &^= recordGrouped
+			group = append(group, pending{rec: rec, offset: offset, size: size})
+		} else {
+			seq := nextVersion()
+			switch rec.kind {
+			case recordCommit:
+				for _, p := range group {
+					s.replay(df.id, p.rec, p.offset, p.size, seq)
+				}
+				group = nil
+				s.stale[df.id] += int64(size)
+			case recordMerged:
+				s.stale[df.id] += int64(size)
+			default:
+				s.replay(df.id, rec, offset, size, seq)
+			}
+		}
+
+		offset += int64(size)
+		if group == nil {
+			committed = offset
+		}
+	}
+
+	if committed < offset || newest {
+		if err := df.f.Truncate(committed); err != nil {
+			return err
+		}
+	}
+	df.size = committed
+	return nil
+}
+
+/*
+replay applies one loaded record at sequence seq.
+*/
+func (s *bitcaskStore) replay(file uint32, rec record, offset int64, size int, seq uint64) {
+	switch rec.kind {
+	case recordPut:
+		s.put(rec.key, keydirEntry{
+			file:      file,
+			offset:    offset,
+			size:      uint32(size),
+			expiresAt: rec.expiresAt,
+			writtenAt: rec.writtenAt,
+			version:   seq,
+		})
+
+	case recordExpire:
+		s.stale[file] += int64(size)
+		if e, ok := s.lookup(rec.key); ok {
+			e.expiresAt, e.version = rec.expiresAt, seq
+			s.keydir[slotOf(rec.key)][rec.key] = e
+		}
+	}
+}
+
+/*
+lookup returns the keydir entry of key, expired or not.
+*/
+func (s *bitcaskStore) lookup(key string) (keydirEntry, bool) {
+	e, ok := s.keydir[slotOf(key)][key]
+	return e, ok
+}
+
+/*
+live returns the keydir entry of key if it has not expired.
+*/
+func (s *bitcaskStore) live(key string) (keydirEntry, bool) {
+	e, ok := s.lookup(key)
+	if !ok || e.expired(s.now()) {
+		return keydirEntry{}, false
+	}
+	return e, true
+}
+
+/*
+put points key at a new record; the record it replaces becomes stale.
+*/
+func (s *bitcaskStore) put(key string, e keydirEntry) {
+	i := slotOf(key)
+	if s.keydir[i] == nil {
+		s.keydir[i] = make(map[string]keydirEntry)
+	}
+	if old, ok := s.keydir[i][key]; ok {
+		s.stale[old.file] += int64(old.size)
+	}
+	s.keydir[i][key] = e
+}
+
+/*
+drop removes key from the keydir; its record becomes stale.
+*/
+func (s *bitcaskStore) drop(key string) {
+	i := slotOf(key)
+	if old, ok := s.keydir[i][key]; ok {
+		s.stale[old.file] += int64(old.size)
+		delete(s.keydir[i], key)
+	}
+}
+
+func (s *bitcaskStore) now() int64 {
+	return unixNow(s.clock)
+}
+
+/*
+entry reads the value e points at. A value that cannot be read is
+reported absent.
+*/
+func (s *bitcaskStore) entry(e keydirEntry) (Entry, bool) {
+	df := s.file(e.file)
+	if df == nil {
+		return Entry{}, false
+	}
+	rec, err := df.readRecordAt(e.offset, e.size)
+	if err != nil {
+		return Entry{}, false
+	}
+
+	return Entry{
+		Value:           rec.value,
+		ExpiresAtMillis: e.expiresAt,
+		Version:         e.version,
+		WrittenAtMillis: e.writtenAt,
+		Encoding:        rec.encoding,
+		Type:            rec.valueType,
+	}, true
+}
+
+/*
+file returns the data file with the given id, including files a merge
+replaced that open views still read.
+*/
+func (s *bitcaskStore) file(id uint32) *dataFile {
+	if df, ok := s.files[id]; ok {
+		return df
+	}
+	for _, df := range s.retired {
+		if df.id == id {
+			return df
+		}
+	}
+	return nil
+}
+
+/*
+appendRecords writes records to the active file in one write, and
+returns where each one starts. More than one record is written as a
+group that loads all-or-nothing. The caller holds the lock.
+*/
+func (s *bitcaskStore) appendRecords(recs []record) ([]int64, error) {
+	if s.closed {
+		return nil, ErrStoreClosed
+	}
+
+	grouped := len(recs) > 1
+	var buf []byte
+	offsets := make([]int64, len(recs))
+	for i, rec := range recs {
+		if grouped {
+			rec.kind |= recordGrouped
+		}
+		offsets[i] = int64(len(buf))
+		buf = rec.appendTo(buf)
+	}
+	if grouped {
+		buf = record{kind: recordCommit}.appendTo(buf)
+		s.stale[s.active.id] += recordHeaderSize
+	}
+
+	start, err := s.active.append(buf)
+	if err != nil {
+		return nil, err
+	}
+	if s.syncPolicy == wal.SyncEveryWrite {
+		if err := s.active.f.Sync(); err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range offsets {
+		offsets[i] += start
+	}
+	return offsets, nil
+}
+
+/*
+commit appends the records of one mutation and applies them to the
+keydir at one sequence. The active file is sealed once full.
+*/
+func (s *bitcaskStore) commit(recs []record) error {
+	offsets, err := s.appendRecords(recs)
+	if err != nil {
+		return err
+	}
+
+	seq := nextVersion()
+	for i, rec := range recs {
+		s.replay(s.active.id, rec, offsets[i], rec.size(), seq)
+	}
+
+	if s.active.size >= s.maxFileSize {
+		return s.rotate(s.active.id + 1)
+	}
+	return nil
+}
+
+/*
+rotate seals the active file and starts a new one with the given id.
+*/
+func (s *bitcaskStore) rotate(id uint32) error {
+	f, err := os.OpenFile(dataPath(s.dir, id), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	if s.active != nil {
+		if err := s.active.f.Sync(); err != nil {
+			f.Close()
+			return err
+		}
+	}
+
+	s.active = &dataFile{id: id, f: f}
+	s.files[id] = s.active
+	return syncDir(s.dir)
+}
+
+/*
+putRecord builds the record of a write. Like the in-memory store, a
+write keeps the TTL and write time it is given, and is stamped with
+the current time when it has none.
+*/
+func (s *bitcaskStore) putRecord(key string, value Entry, now int64) record {
+	if value.WrittenAtMillis == 0 {
+		value.WrittenAtMillis = now
+	}
+	return record{
+		kind:      recordPut,
+		writtenAt: value.WrittenAtMillis,
+		expiresAt: value.ExpiresAtMillis,
+		key:       key,
+		value:     value.Value,
+		encoding:  value.Encoding,
+		valueType: value.Type,
+	}
+}
+
+/*
+Read returns the live value of key, read from its data file under the
+shared lock.
+*/
+func (s *bitcaskStore) Read(key string) (Entry, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	e, ok := s.live(key)
+	if !ok {
+		return Entry{}, false
+	}
+	return s.entry(e)
+}
+
+/*
+Write appends the value and then points the keydir at it, applying
+the put mode against the live keydir.
+*/
+func (s *bitcaskStore) Write(key string, value Entry, mode PutMode) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := checkPutMode(mode, s.exists(key)); err != nil {
+		return err
+	}
+	return s.commit([]record{s.putRecord(key, value, s.now())})
+}
+
+func (s *bitcaskStore) exists(key string) bool {
+	_, ok := s.live(key)
+	return ok
+}
+
+/*
+checkPutMode validates a write against whether its key exists.
+*/
+func checkPutMode(mode PutMode, exists bool) error {
+	if _, ok := putFactories[mode]; !ok {
+		return ErrInvalidPutMode
+	}
+
+	switch mode {
+	case PutIfAbsent:
+		if exists {
+			return ErrKeyExists
+		}
+	case PutUpdate:
+		if !exists {
+			return ErrKeyNotFound
+		}
+	}
+	return nil
+}
+
+/*
+Expire appends an expire record for a live key. An expired leftover is
+dropped from the keydir instead, with nothing written: its records
+already expire it on load.
+*/
+func (s *bitcaskStore) Expire(key string, unixTimestampMilli int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.expire(key, unixTimestampMilli)
+}
+
+func (s *bitcaskStore) expire(key string, unixTimestampMilli int64) bool {
+	if !s.exists(key) {
+		s.drop(key)
+		return false
+	}
+
+	err := s.commit([]record{{kind: recordExpire, expiresAt: unixTimestampMilli, key: key}})
+	return err == nil
+}
+
+/*
+ReadBatch holds the shared lock across all keys so the result
+reflects a single point in time.
+*/
+func (s *bitcaskStore) ReadBatch(keys []string) map[string]Entry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make(map[string]Entry, len(keys))
+	for _, key := range keys {
+		e, ok := s.live(key)
+		if !ok {
+			continue
+		}
+		if val, ok := s.entry(e); ok {
+			result[key] = val
+		}
+	}
+	return result
+}
+
+/*
+WriteBatch validates every key, then appends the batch as one group.
+*/
+func (s *bitcaskStore) WriteBatch(entries []KeyValue, mode PutMode) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, kv := range entries {
+		if err := checkPutMode(mode, s.exists(kv.Key)); err != nil {
+			return err
+		}
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+
+	now := s.now()
+	recs := make([]record, len(entries))
+	for i, kv := range entries {
+		recs[i] = s.putRecord(kv.Key, kv.Entry, now)
+	}
+	return s.commit(recs)
+}
+
+/*
+SweepExpired removes expired keys from the keydir in steps, taking
+the lock exclusively for one step at a time. Nothing is written: the
+records of a swept key already expire it on load.
+*/
+func (s *bitcaskStore) SweepExpired() int {
+	return s.sweep(scanSlots)
+}
+
+/*
+sweepTick is one run of the background sweeper: a bounded part of
+SweepExpired.
+*/
+func (s *bitcaskStore) sweepTick() int {
+	return s.sweep(sweepTickSlots)
+}
+
+/*
+sweep sweeps the next slots slots of the keydir like sweepLocked.
+*/
+func (s *bitcaskStore) sweep(slots int) int {
+	total := 0
+	for slots > 0 {
+		s.mu.Lock()
+		n, swept := s.sweepExpired(min(slots, sweepStepSlots), sweepBatchSize)
+		s.mu.Unlock()
+
+		total += n
+		slots -= swept
+	}
+	return total
+}
+
+/*
+sweepExpired visits up to slots slots of the keydir, like
+store.sweepExpired.
+*/
+func (s *bitcaskStore) sweepExpired(slots, limit int) (removed, swept int) {
+	now := s.now()
+
+	for ; swept < slots; swept++ {
+		for key, e := range s.keydir[s.sweepSlot] {
+			if !e.expired(now) {
+				continue
+			}
+			s.drop(key)
+			removed++
+
+			// Resume from this slot: it may hold more expired keys
+			if removed == limit {
+				return removed, swept
+			}
+		}
+		s.sweepSlot = (s.sweepSlot + 1) % scanSlots
+	}
+	return removed, swept
+}
+
+/*
+Iterate visits every live entry under the shared lock, like
+lockedStore: fn must not call back into the store.
+*/
+func (s *bitcaskStore) Iterate(fn func(key string, value Entry) bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := s.now()
+	for _, slot := range s.keydir {
+		for key, e := range slot {
+			if e.expired(now) {
+				continue
+			}
+			val, ok := s.entry(e)
+			if ok && !fn(key, val) {
+				return
+			}
+		}
+	}
+}
+
+/*
+ScanEntries reads the live entries of whole slots starting at cursor,
+under the shared lock. See keyspace.scan for the cursor contract.
+*/
+func (s *bitcaskStore) ScanEntries(cursor uint64, count int) ([]KeyValue, uint64) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := s.now()
+	var entries []KeyValue
+	for slot := cursor; slot < scanSlots; slot++ {
+		for key, e := range s.keydir[slot] {
+			if e.expired(now) {
+				continue
+			}
+			if val, ok := s.entry(e); ok {
+				entries = append(entries, KeyValue{Key: key, Entry: val})
+			}
+		}
+		if len(entries) >= count && slot+1 < scanSlots {
+			return entries, slot + 1
+		}
+	}
+	return entries, 0
+}
+
+/*
+Close stops the background work, fsyncs the active file and closes
+every file. Later writes fail with ErrStoreClosed.
+*/
+func (s *bitcaskStore) Close() error {
+	s.stopOnce.Do(func() { close(s.done) })
+	s.wg.Wait()
+	s.sweeper.stop()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+
+	err := s.active.f.Sync()
+	if cerr := s.closeFiles(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (s *bitcaskStore) closeFiles() error {
+	var err error
+	for _, df := range s.files {
+		if cerr := df.f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	for _, df := range s.retired {
+		df.f.Close()
+	}
+	return err
+}
+
+/*
+background runs the periodic work until Close: fsyncing the active
+file under an interval sync policy, and merging once stale records
+make up enough of the sealed files.
+*/
+func (s *bitcaskStore) background(syncEvery, mergeEvery time.Duration) {
+	defer s.wg.Done()
+
+	syncC, stopSync := tick(syncEvery)
+	defer stopSync()
+	mergeC, stopMerge := tick(mergeEvery)
+	defer stopMerge()
+
+	for {
+		select {
+		case <-syncC:
+			s.mu.Lock()
+			_ = s.active.f.Sync()
+			s.mu.Unlock()
+		case <-mergeC:
+			if s.fragmented() {
+				_ = s.Merge()
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+/*
+tick returns the channel of a ticker, or a nil channel that never
+fires when interval is not positive.
+*/
+func tick(interval time.Duration) (<-chan time.Time, func()) {
+	if interval <= 0 {
+		return nil, func() {}
+	}
+	t := time.NewTicker(interval)
+	return t.C, t.Stop
+}
+
+/*
+fragmented reports whether stale records make up more than
+mergeFragmentation of the sealed files.
+*/
+func (s *bitcaskStore) fragmented() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var total, stale int64
+	for id, df := range s.files {
+		if id != s.active.id {
+			total += df.size
+			stale += s.stale[id]
+		}
+	}
+	return total > 0 && float64(stale) > mergeFragmentation*float64(total)
+}
+
+/*
+Snapshot copies the keydir, a few words per key, under the lock. Values are read from the data files while the view is open, so
+a merge keeps the files it replaces until every view is closed.
+*/
+func (s *bitcaskStore) Snapshot() View {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v := &bitcaskView{
+		s:      s,
+		keydir: make(map[string]keydirEntry),
+		rp:     readPoint{seq: versionCounter.Load(), now: s.now()},
+	}
+	for _, slot := range s.keydir {
+		for key, e := range slot {
+			if !e.expired(v.rp.now) {
+				v.keydir[key] = e
+			}
+		}
+	}
+	s.views++
+	return v
+}
+
+/*
+bitcaskView is the View of the Bitcask store: a private copy of the
+keydir, whose records stay on disk for as long as the view is open.
+*/
+type bitcaskView struct {
+	s      *bitcaskStore
+	keydir map[string]keydirEntry
+	rp     readPoint
+	closed atomic.Bool
+}
+
+func (v *bitcaskView) Read(key string) (Entry, bool) {
+	e, ok := v.keydir[key]
+	if !ok || v.closed.Load() {
+		return Entry{}, false
+	}
+
+	v.s.mu.RLock()
+	defer v.s.mu.RUnlock()
+	return v.s.entry(e)
+}
+
+func (v *bitcaskView) ReadBatch(keys []string) map[string]Entry {
+	result := make(map[string]Entry, len(keys))
+	for _, key := range keys {
+		if val, ok := v.Read(key); ok {
+			result[key] = val
+		}
+	}
+	return result
+}
+
+/*
+Iterate reads values a chunk at a time, so the store's lock is not
+held while fn runs.
+*/
+func (v *bitcaskView) Iterate(fn func(key string, value Entry) bool) {
+	chunk := make([]KeyValue, 0, iterateChunk)
+	flush := func() bool {
+		for _, kv := range chunk {
+			if !fn(kv.Key, kv.Entry) {
+				return false
+			}
+		}
+		chunk = chunk[:0]
+		return !v.closed.Load()
+	}
+
+	v.s.mu.RLock()
+	for key, e := range v.keydir {
+		if v.closed.Load() {
+			break
+		}
+		if val, ok := v.s.entry(e); ok {
+			chunk = append(chunk, KeyValue{Key: key, Entry: val})
+		}
+		if len(chunk) < iterateChunk {
+			continue
+		}
+
+		v.s.mu.RUnlock()
+		if !flush() {
+			return
+		}
+		v.s.mu.RLock()
+	}
+	v.s.mu.RUnlock()
+	flush()
+}
+
+func (v *bitcaskView) Sequence() uint64 {
+	return v.rp.seq
+}
+
+func (v *bitcaskView) Close() {
+	if !v.closed.CompareAndSwap(false, true) {
+		return
+	}
+
+	v.s.mu.Lock()
+	defer v.s.mu.Unlock()
+
+	v.s.views--
+	if v.s.views == 0 {
+		v.s.releaseRetired()
+	}
+}
@@ -0,0 +1,335 @@
+package store
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ErrCorruptDataFile is returned when a Bitcask data file fails its
+// checksums anywhere but at the tail of the newest file.
+var ErrCorruptDataFile = errors.New("corrupt bitcask data file")
+
+/*
+Record kinds of a data file.
+
+A batch or transaction is written as grouped records followed by a
+commit record, all in one write. Loading applies grouped records only
+once their commit is read, so a group torn by a crash is dropped
+whole, like a WAL BEGIN ... COMMIT group.
+
+A merged file starts with a merged record: every file older than the
+newest merged file is left over from a merge that did not finish
+cleaning up, and is deleted on open.
+
+A put whose value is compressed has recordFlate set, and a put of
+anything but a string keeps its value type in the bits of
+recordTypeMask. Both are folded into the record when decoding, so
+kinds never carry them.
+*/
+const (
+	recordPut    byte = 1
+	recordExpire byte = 2
+	recordCommit byte = 3
+	recordMerged byte = 4
+
+	recordGrouped  byte = 0x80
+	recordFlate    byte = 0x40
+	recordTypeMask byte = 0x38
+	recordTypeBits      = 3 // shift of the value type into recordTypeMask
+)
+
+/*
+Record layout (Little Endian). The checksum covers everything after
+it; an expire record has no value and carries the new deadline.
+
+	[CRC:uint32][Kind:uint8][WrittenAt:int64][ExpiresAt:int64][KeyLen:uint32][ValLen:uint32][Key][Value]
+*/
+const recordHeaderSize = 4 + 1 + 8 + 8 + 4 + 4
+
+type record struct {
+	kind      byte
+	writtenAt int64
+	expiresAt int64
+	key       string
+	value     []byte
+	encoding  Encoding
+	valueType ValueType
+}
+
+func (r record) size() int {
+	return recordHeaderSize + len(r.key) + len(r.value)
+}
+
+func (r record) appendTo(buf []byte) []byte {
+	kind := r.kind
+	if r.encoding == EncodingFlate {
+		kind |= recordFlate
+	}
+	kind |= byte(r.valueType) << recordTypeBits & recordTypeMask
+
+	start := len(buf)
+	buf = append(buf, 0, 0, 0, 0, kind)
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(r.writtenAt))
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(r.expiresAt))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(r.key)))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(r.value)))
+	buf = append(buf, r.key...)
+	buf = append(buf, r.value...)
+
+	binary.LittleEndian.PutUint32(buf[start:], crc32.ChecksumIEEE(buf[start+4:]))
+	return buf
+}
+
+/*
+decodeRecord decodes one whole record, verifying its checksum.
+*/
+func decodeRecord(b []byte) (record, error) {
+	if len(b) < recordHeaderSize {
+		return record{}, ErrCorruptDataFile
+	}
+
+	keyLen := int(binary.LittleEndian.Uint32(b[21:]))
+	valLen := int(binary.LittleEndian.Uint32(b[25:]))
+	if len(b) != recordHeaderSize+keyLen+valLen ||
+		crc32.ChecksumIEEE(b[4:]) != binary.LittleEndian.Uint32(b) {
+		return record{}, ErrCorruptDataFile
+	}
+
+	kind, encoding := b[4], EncodingRaw
+	if kind&recordFlate != 0 {
+		kind, encoding = kind&^recordFlate, EncodingFlate
+	}
+	valueType := ValueType(kind & recordTypeMask >> recordTypeBits)
+	kind &^= recordTypeMask
+
+	key := b[recordHeaderSize : recordHeaderSize+keyLen]
+	return record{
+		kind:      kind,
+		encoding:  encoding,
+		valueType: valueType,
+		writtenAt: int64(binary.LittleEndian.Uint64(b[5:])),
+		expiresAt: int64(binary.LittleEndian.Uint64(b[13:])),
+		key:       string(key),
+		value:     b[recordHeaderSize+keyLen:],
+	}, nil
+}
+
+/*
+readRecord reads the record at the reader's position and returns it
+with its encoded size. remaining is the number of bytes left from
+there to the end of the file. A reader ending exactly before a record
+returns io.EOF; one ending inside it, or a record failing its
+checksum, returns ErrCorruptDataFile.
+
+The lengths in the header are checked against remaining before the
+record is read: a torn or damaged header could claim any size, and is
+reported corrupt rather than allocated for.
+*/
+func readRecord(r *bufio.Reader, remaining int64) (record, int, error) {
+	header, err := r.Peek(recordHeaderSize)
+	if err == io.EOF && len(header) == 0 {
+		return record{}, 0, io.EOF
+	}
+	if err != nil {
+		return record{}, 0, ErrCorruptDataFile
+	}
+
+	size := int64(recordHeaderSize) +
+		int64(binary.LittleEndian.Uint32(header[21:])) +
+		int64(binary.LittleEndian.Uint32(header[25:]))
+	if size > remaining {
+		return record{}, 0, ErrCorruptDataFile
+	}
+
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return record{}, 0, ErrCorruptDataFile
+	}
+
+	rec, err := decodeRecord(buf)
+	return rec, int(size), err
+}
+
+/*
+Hint layout: one entry per record of a merged data file, enough to
+rebuild the keydir without reading values.
+
+	[CRC:uint32][WrittenAt:int64][ExpiresAt:int64][Offset:int64][Size:uint32][KeyLen:uint32][Key]
+*/
+const hintHeaderSize = 4 + 8 + 8 + 8 + 4 + 4
+
+type hint struct {
+	key       string
+	writtenAt int64
+	expiresAt int64
+	offset    int64
+	size      uint32
+}
+
+func (h hint) appendTo(buf []byte) []byte {
+	start := len(buf)
+	buf = append(buf, 0, 0, 0, 0)
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(h.writtenAt))
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(h.expiresAt))
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(h.offset))
+	buf = binary.LittleEndian.AppendUint32(buf, h.size)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(h.key)))
+	buf = append(buf, h.key...)
+
+	binary.LittleEndian.PutUint32(buf[start:], crc32.ChecksumIEEE(buf[start+4:]))
+	return buf
+}
+
+/*
+readHints reads a whole hint file. Hints are an optimization: any
+damage fails the read, and the caller scans the data file instead.
+*/
+func readHints(path string) ([]hint, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var hints []hint
+	for len(b) > 0 {
+		if len(b) < hintHeaderSize {
+			return nil, ErrCorruptDataFile
+		}
+		size := hintHeaderSize + int(binary.LittleEndian.Uint32(b[32:]))
+		if len(b) < size || crc32.ChecksumIEEE(b[4:size]) != binary.LittleEndian.Uint32(b) {
+			return nil, ErrCorruptDataFile
+		}
+
+		hints = append(hints, hint{
+			writtenAt: int64(binary.LittleEndian.Uint64(b[4:])),
+			expiresAt: int64(binary.LittleEndian.Uint64(b[12:])),
+			offset:    int64(binary.LittleEndian.Uint64(b[20:])),
+			size:      binary.LittleEndian.Uint32(b[28:]),
+			key:       string(b[hintHeaderSize:size]),
+		})
+		b = b[size:]
+	}
+	return hints, nil
+}
+
+/*
+dataFile is one append-only data file. Only the active file is
+written; sealed files are read concurrently through ReadAt.
+*/
+type dataFile struct {
+	id   uint32
+	f    *os.File
+	size int64
+}
+
+func dataPath(dir string, id uint32) string {
+	return filepath.Join(dir, fmt.Sprintf("%010d.data", id))
+}
+
+func hintPath(dir string, id uint32) string {
+	return filepath.Join(dir, fmt.Sprintf("%010d.hint", id))
+}
+
+/*
+readRecordAt reads the record of size bytes at offset.
+*/
+func (df *dataFile) readRecordAt(offset int64, size uint32) (record, error) {
+	buf := make([]byte, size)
+	if _, err := df.f.ReadAt(buf, offset); err != nil {
+		return record{}, err
+	}
+	return decodeRecord(buf)
+}
+
+/*
+append writes buf at the end of the file in one call. A failed write
+is cut off again, so a retry never lands after a partial record.
+*/
+func (df *dataFile) append(buf []byte) (int64, error) {
+	offset := df.size
+	if _, err := df.f.WriteAt(buf, offset); err != nil {
+		_ = df.f.Truncate(offset)
+		return 0, err
+	}
+	df.size += int64(len(buf))
+	return offset, nil
+}
+
+/*
+listDataFiles returns the ids of the data files in dir, oldest first,
+after removing the temporary files of an interrupted merge.
+*/
+func listDataFiles(dir string) ([]uint32, error) {
+	names, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []uint32
+	for _, de := range names {
+		name := de.Name()
+		if strings.HasSuffix(name, ".tmp") {
+			if err := os.Remove(filepath.Join(dir, name)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		base, ok := strings.CutSuffix(name, ".data")
+		if !ok {
+			continue
+		}
+		id, err := strconv.ParseUint(base, 10, 32)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, uint32(id))
+	}
+	slices.Sort(ids)
+	return ids, nil
+}
+
+/*
+isMerged reports whether the data file at path starts with a merged
+record.
+*/
+func isMerged(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	rec, _, err := readRecord(bufio.NewReader(f), info.Size())
+	if err == io.EOF || err == ErrCorruptDataFile {
+		return false, nil
+	}
+	return rec.kind == recordMerged, err
+}
+
+/*
+syncDir fsyncs a directory so that renames and removals in it are
+durable.
+*/
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,243 @@
+package store
+
+import (
+	"bufio"
+	"errors"
+	"io/fs"
+	"os"
+)
+
+/*
+mergeItem is a keydir entry captured for a merge.
+*/
+type mergeItem struct {
+	key   string
+	entry keydirEntry
+}
+
+/*
+Merge compacts every sealed data file into one merged file holding
+only the latest live record of each key, with its current TTL, and
+writes its hint file. Writers are blocked only at the start, to seal
+the active file, and at the end, to repoint the keydir:
+
+ 1. The active file is sealed. The merged file takes the id between it
+    and the new active file, so it loads after its inputs and before
+    anything written since.
+ 2. The live records are copied without holding the lock: sealed files
+    never change.
+ 3. Keys not written again meanwhile are pointed at the copies, and the
+    inputs are removed, once no open view reads them any more.
+
+A crash at any point loses nothing: the merged file is only renamed
+into place once complete and synced, and from then on its inputs are
+garbage that the next open deletes.
+*/
+func (s *bitcaskStore) Merge() error {
+	s.mergeMu.Lock()
+	defer s.mergeMu.Unlock()
+
+	out, inputs, live, expired, err := s.startMerge()
+	if err != nil || inputs == nil {
+		return err
+	}
+
+	merged, hints, err := s.writeMerged(out, inputs, live)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.files[out] = merged
+	for i, item := range live {
+		cur, ok := s.lookup(item.key)
+		if !ok || cur.file != item.entry.file || cur.offset != item.entry.offset {
+			s.stale[out] += int64(hints[i].size)
+			continue
+		}
+		cur.file, cur.offset, cur.size = out, hints[i].offset, hints[i].size
+		s.keydir[slotOf(item.key)][item.key] = cur
+	}
+
+	// Keys that had expired are not copied, and leave with their files
+	for _, item := range expired {
+		if cur, ok := s.lookup(item.key); ok && cur.file == item.entry.file && cur.offset == item.entry.offset {
+			delete(s.keydir[slotOf(item.key)], item.key)
+		}
+	}
+
+	for id, df := range inputs {
+		delete(s.files, id)
+		delete(s.stale, id)
+		s.retired = append(s.retired, df)
+	}
+	if s.views == 0 {
+		s.releaseRetired()
+	}
+	return nil
+}
+
+/*
+startMerge seals the active file and captures the keydir entries of
+the sealed files, split into live and expired. inputs is nil when
+there is nothing to merge.
+*/
+func (s *bitcaskStore) startMerge() (out uint32, inputs map[uint32]*dataFile, live, expired []mergeItem, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return 0, nil, nil, nil, ErrStoreClosed
+	}
+	if len(s.files) == 1 && s.active.size == 0 {
+		return 0, nil, nil, nil, nil
+	}
+
+	out = s.active.id + 1
+	if err := s.rotate(out + 1); err != nil {
+		return 0, nil, nil, nil, err
+	}
+
+	inputs = make(map[uint32]*dataFile, len(s.files)-1)
+	for id, df := range s.files {
+		if id < out {
+			inputs[id] = df
+		}
+	}
+
+	now := s.now()
+	for _, slot := range s.keydir {
+		for key, e := range slot {
+			if e.file >= out {
+				continue
+			}
+			if e.expired(now) {
+				expired = append(expired, mergeItem{key: key, entry: e})
+			} else {
+				live = append(live, mergeItem{key: key, entry: e})
+			}
+		}
+	}
+	return out, inputs, live, expired, nil
+}
+
+/*
+writeMerged writes the merged data file with id out and its hint file,
+one hint per item of live, and renames both into place.
+*/
+func (s *bitcaskStore) writeMerged(out uint32, inputs map[uint32]*dataFile, live []mergeItem) (*dataFile, []hint, error) {
+	dataTmp, hintTmp := dataPath(s.dir, out)+".tmp", hintPath(s.dir, out)+".tmp"
+
+	f, err := os.OpenFile(dataTmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return nil, nil, err
+	}
+	fail := func(err error) (*dataFile, []hint, error) {
+		f.Close()
+		os.Remove(dataTmp)
+		os.Remove(hintTmp)
+		return nil, nil, err
+	}
+
+	w := bufio.NewWriter(f)
+	buf := record{kind: recordMerged}.appendTo(nil)
+	if _, err := w.Write(buf); err != nil {
+		return fail(err)
+	}
+	offset := int64(len(buf))
+
+	hints := make([]hint, len(live))
+	var hintBuf []byte
+	for i, item := range live {
+		rec, err := inputs[item.entry.file].readRecordAt(item.entry.offset, item.entry.size)
+		if err != nil {
+			return fail(err)
+		}
+		rec.kind, rec.expiresAt = recordPut, item.entry.expiresAt
+
+		buf = rec.appendTo(buf[:0])
+		if _, err := w.Write(buf); err != nil {
+			return fail(err)
+		}
+
+		hints[i] = hint{
+			key:       item.key,
+			writtenAt: rec.writtenAt,
+			expiresAt: rec.expiresAt,
+			offset:    offset,
+			size:      uint32(len(buf)),
+		}
+		hintBuf = hints[i].appendTo(hintBuf)
+		offset += int64(len(buf))
+	}
+
+	if err := w.Flush(); err != nil {
+		return fail(err)
+	}
+	if err := f.Sync(); err != nil {
+		return fail(err)
+	}
+	if err := writeSynced(hintTmp, hintBuf); err != nil {
+		return fail(err)
+	}
+
+	// The data file goes first: a merged file without its hint file
+	// is scanned instead
+	if err := os.Rename(dataTmp, dataPath(s.dir, out)); err != nil {
+		return fail(err)
+	}
+	if err := os.Rename(hintTmp, hintPath(s.dir, out)); err != nil {
+		os.Remove(hintTmp)
+	}
+	if err := syncDir(s.dir); err != nil {
+		return nil, nil, err
+	}
+	return &dataFile{id: out, f: f, size: offset}, hints, nil
+}
+
+/*
+writeSynced writes b to a new file at path and fsyncs it.
+*/
+func writeSynced(path string, b []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+/*
+releaseRetired closes and removes the files replaced by merges. The
+caller holds the lock and no view is open.
+*/
+func (s *bitcaskStore) releaseRetired() {
+	for _, df := range s.retired {
+		df.f.Close()
+		_ = removeFile(s.dir, df.id)
+	}
+	s.retired = nil
+	_ = syncDir(s.dir)
+}
+
+/*
+removeFile removes a data file and its hint file, if any.
+*/
+func removeFile(dir string, id uint32) error {
+	if err := os.Remove(dataPath(dir, id)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err := os.Remove(hintPath(dir, id)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
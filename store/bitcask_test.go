@@ -0,0 +1,395 @@
+package store
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+)
+
+/*
+openBitcask opens the Bitcask store in dir on clock, with small files
+so that tests span several of them.
+*/
+func openBitcask(t *testing.T, dir string, clock Clock) *bitcaskStore {
+	t.Helper()
+
+	ds, err := NewBitcaskStore(dir,
+		WithClock(clock),
+		WithMaxFileSize(1<<10),
+		WithMergeInterval(0),
+		WithSweepInterval(0),
+	)
+	if err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	return ds.(*bitcaskStore)
+}
+
+func dataFiles(t *testing.T, dir string) []uint32 {
+	t.Helper()
+
+	ids, err := listDataFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ids
+}
+
+func mustHold(t *testing.T, ds DataStore, key, want string) {
+	t.Helper()
+
+	val, ok := ds.Read(key)
+	if !ok || string(val.Value) != want {
+		t.Fatalf("expected %s=%q, got %q (found=%v)", key, want, val.Value, ok)
+	}
+}
+
+func TestBitcask_Recovery(t *testing.T) {
+	dir := t.TempDir()
+	clock := NewManualClock(time.Unix(1_700_000_000, 0))
+
+	s := openBitcask(t, dir, clock)
+	for i := 0; i < 100; i++ {
+		_ = s.Write("key:"+strconv.Itoa(i%10), Entry{Value: []byte(strconv.Itoa(i))}, PutOverwrite)
+	}
+	_ = s.WriteBatch([]KeyValue{
+		{Key: "a", Entry: Entry{Value: []byte("1")}},
+		{Key: "b", Entry: Entry{Value: []byte("2")}},
+	}, PutOverwrite)
+	_ = s.Atomic([]string{"a", "tx"}, func(tx Tx) error {
+		_ = tx.Write("tx", Entry{Value: []byte("t")}, PutIfAbsent)
+		tx.Expire("a", unixNow(clock)+1000)
+		return nil
+	})
+	s.Expire("b", unixNow(clock)-1)
+	written, _ := s.Read("key:3")
+
+	if len(dataFiles(t, dir)) < 2 {
+		t.Fatalf("expected writes to span several data files")
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r := openBitcask(t, dir, clock)
+	defer r.Close()
+
+	for i := 90; i < 100; i++ {
+		mustHold(t, r, "key:"+strconv.Itoa(i%10), strconv.Itoa(i))
+	}
+	mustHold(t, r, "tx", "t")
+	if _, ok := r.Read("b"); ok {
+		t.Fatalf("expired key revived by recovery")
+	}
+
+	val, _ := r.Read("a")
+	if val.ExpiresAtMillis != unixNow(clock)+1000 {
+		t.Fatalf("TTL not recovered: %d", val.ExpiresAtMillis)
+	}
+	clock.Advance(time.Second)
+	if _, ok := r.Read("a"); ok {
+		t.Fatalf("recovered TTL did not expire the key")
+	}
+
+	if got, _ := r.Read("key:3"); got.WrittenAtMillis != written.WrittenAtMillis {
+		t.Fatalf("write time not recovered: %d, want %d", got.WrittenAtMillis, written.WrittenAtMillis)
+	}
+}
+
+func TestBitcask_TornTailIsCutOff(t *testing.T) {
+	dir := t.TempDir()
+	clock := NewManualClock(time.Unix(1_700_000_000, 0))
+
+	s := openBitcask(t, dir, clock)
+	_ = s.Write("kept", Entry{Value: []byte("v")}, PutOverwrite)
+	active := s.active.id
+	_ = s.Close()
+
+	// Simulate a crash halfway through appending a batch
+	var batch []byte
+	for _, key := range []string{"x", "y"} {
+		batch = record{kind: recordPut | recordGrouped, key: key, value: []byte("1")}.appendTo(batch)
+	}
+	batch = record{kind: recordCommit}.appendTo(batch)
+
+	f, err := os.OpenFile(dataPath(dir, active), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(batch[:len(batch)-5])
+	f.Close()
+
+	r := openBitcask(t, dir, clock)
+	mustHold(t, r, "kept", "v")
+	if got := r.ReadBatch([]string{"x", "y"}); len(got) != 0 {
+		t.Fatalf("torn batch partially applied: %v", got)
+	}
+
+	// The tail is gone, so later writes are readable after a reopen
+	_ = r.Write("after", Entry{Value: []byte("v")}, PutOverwrite)
+	_ = r.Close()
+
+	r = openBitcask(t, dir, clock)
+	defer r.Close()
+	mustHold(t, r, "after", "v")
+}
+
+/*
+A torn header can claim any length: opening must treat it as a torn
+tail instead of allocating what it claims.
+*/
+func TestBitcask_TornHeaderIsNotAllocated(t *testing.T) {
+	dir := t.TempDir()
+	clock := NewManualClock(time.Unix(1_700_000_000, 0))
+
+	s := openBitcask(t, dir, clock)
+	_ = s.Write("kept", Entry{Value: []byte("v")}, PutOverwrite)
+	active := s.active.id
+	_ = s.Close()
+
+	intact, err := os.Stat(dataPath(dir, active))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header := record{kind: recordPut, key: "x", value: []byte("1")}.appendTo(nil)[:recordHeaderSize]
+	binary.LittleEndian.PutUint32(header[21:], math.MaxUint32)
+	binary.LittleEndian.PutUint32(header[25:], math.MaxUint32)
+	f, err := os.OpenFile(dataPath(dir, active), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(header)
+	f.Close()
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	r := openBitcask(t, dir, clock)
+	runtime.ReadMemStats(&after)
+	defer r.Close()
+
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<30 {
+		t.Fatalf("expected the torn header not to be allocated for, allocated %d bytes", allocated)
+	}
+	mustHold(t, r, "kept", "v")
+	if info, _ := os.Stat(dataPath(dir, active)); info.Size() != intact.Size() {
+		t.Fatalf("expected the torn header to be cut off, file holds %d bytes", info.Size())
+	}
+}
+
+func TestBitcask_CorruptSealedFileFailsOpen(t *testing.T) {
+	dir := t.TempDir()
+	clock := NewManualClock(time.Unix(1_700_000_000, 0))
+
+	s := openBitcask(t, dir, clock)
+	for i := 0; i < 50; i++ {
+		_ = s.Write("key:"+strconv.Itoa(i), Entry{Value: []byte("value")}, PutOverwrite)
+	}
+	_ = s.Close()
+
+	path := dataPath(dir, dataFiles(t, dir)[0])
+	b, _ := os.ReadFile(path)
+	b[recordHeaderSize] ^= 0xff
+	os.WriteFile(path, b, 0o644)
+
+	if _, err := NewBitcaskStore(dir); !errors.Is(err, ErrCorruptDataFile) {
+		t.Fatalf("expected ErrCorruptDataFile, got %v", err)
+	}
+}
+
+func TestBitcask_MergeReclaimsStaleRecords(t *testing.T) {
+	dir := t.TempDir()
+	clock := NewManualClock(time.Unix(1_700_000_000, 0))
+
+	s := openBitcask(t, dir, clock)
+	for i := 0; i < 500; i++ {
+		_ = s.Write("key:"+strconv.Itoa(i%5), Entry{Value: []byte(strconv.Itoa(i))}, PutOverwrite)
+	}
+	_ = s.Write("gone", Entry{Value: []byte("v")}, PutOverwrite)
+	s.Expire("gone", unixNow(clock)+10)
+	clock.Advance(time.Second)
+
+	before := len(dataFiles(t, dir))
+	if !s.fragmented() {
+		t.Fatalf("expected overwritten files to be fragmented")
+	}
+	if err := s.Merge(); err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+
+	ids := dataFiles(t, dir)
+	if len(ids) >= before || len(ids) != 2 {
+		t.Fatalf("expected the merged file and the active file, got %v (was %d files)", ids, before)
+	}
+	if _, err := os.Stat(hintPath(dir, ids[0])); err != nil {
+		t.Fatalf("merged file has no hint file: %v", err)
+	}
+	if s.fragmented() {
+		t.Fatalf("merged store still fragmented")
+	}
+
+	for i := 495; i < 500; i++ {
+		mustHold(t, s, "key:"+strconv.Itoa(i%5), strconv.Itoa(i))
+	}
+	_ = s.Write("key:0", Entry{Value: []byte("new")}, PutOverwrite)
+	_ = s.Close()
+
+	r := openBitcask(t, dir, clock)
+	defer r.Close()
+
+	mustHold(t, r, "key:0", "new")
+	mustHold(t, r, "key:4", "499")
+	if _, ok := r.lookup("gone"); ok {
+		t.Fatalf("expired key survived the merge")
+	}
+}
+
+func TestBitcask_MergeKeepsValueTypes(t *testing.T) {
+	dir := t.TempDir()
+	clock := NewManualClock(time.Unix(1_700_000_000, 0))
+
+	s := openBitcask(t, dir, clock)
+	_ = s.Write("list", Entry{Value: []byte("l"), Type: TypeList}, PutOverwrite)
+	_ = s.Write("json", Entry{Value: []byte("j"), Type: TypeJSON, Encoding: EncodingFlate}, PutOverwrite)
+	_ = s.Write("str", Entry{Value: []byte("s")}, PutOverwrite)
+	if err := s.Merge(); err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	_ = s.Close()
+
+	r := openBitcask(t, dir, clock)
+	defer r.Close()
+	for key, want := range map[string]ValueType{"list": TypeList, "json": TypeJSON, "str": TypeString} {
+		val, ok := r.Read(key)
+		if !ok || val.Type != want {
+			t.Fatalf("expected %s to keep its type %s, got %s", key, want, val.Type)
+		}
+	}
+	if val, _ := r.Read("json"); val.Encoding != EncodingFlate {
+		t.Fatalf("expected the type not to disturb the encoding")
+	}
+}
+
+func TestBitcask_MergeKeepsFilesForOpenViews(t *testing.T) {
+	dir := t.TempDir()
+	clock := NewManualClock(time.Unix(1_700_000_000, 0))
+
+	s := openBitcask(t, dir, clock)
+	defer s.Close()
+
+	for i := 0; i < 100; i++ {
+		_ = s.Write("key", Entry{Value: []byte(strconv.Itoa(i))}, PutOverwrite)
+	}
+	view := s.Snapshot()
+
+	_ = s.Write("key", Entry{Value: []byte("new")}, PutOverwrite)
+	if err := s.Merge(); err != nil {
+		t.Fatal(err)
+	}
+
+	if val, ok := view.Read("key"); !ok || string(val.Value) != "99" {
+		t.Fatalf("view lost its value after a merge: %q %v", val.Value, ok)
+	}
+	if len(dataFiles(t, dir)) <= 2 {
+		t.Fatalf("merge inputs removed while a view reads them")
+	}
+
+	view.Close()
+	if ids := dataFiles(t, dir); len(ids) != 2 {
+		t.Fatalf("merge inputs not removed once the view closed: %v", ids)
+	}
+	mustHold(t, s, "key", "new")
+}
+
+func TestBitcask_InterruptedMergeIsCleanedUp(t *testing.T) {
+	dir := t.TempDir()
+	clock := NewManualClock(time.Unix(1_700_000_000, 0))
+
+	s := openBitcask(t, dir, clock)
+	for i := 0; i < 100; i++ {
+		_ = s.Write("key", Entry{Value: []byte(strconv.Itoa(i))}, PutOverwrite)
+	}
+	_ = s.Write("gone", Entry{Value: []byte("v")}, PutOverwrite)
+	s.Expire("gone", unixNow(clock)-1)
+
+	// An open view keeps the inputs on disk, like a crash right after
+	// the merged file was renamed into place
+	view := s.Snapshot()
+	if err := s.Merge(); err != nil {
+		t.Fatal(err)
+	}
+	_ = s.Close()
+	view.Close()
+
+	os.WriteFile(filepath.Join(dir, "0000000099.data.tmp"), []byte("partial"), 0o644)
+
+	r := openBitcask(t, dir, clock)
+	defer r.Close()
+
+	if ids := dataFiles(t, dir); len(ids) != 2 {
+		t.Fatalf("merge leftovers not removed: %v", ids)
+	}
+	mustHold(t, r, "key", "99")
+	if _, ok := r.lookup("gone"); ok {
+		t.Fatalf("key dropped by the merge revived from its inputs")
+	}
+}
+
+func TestBitcask_MergeWithoutHintsScansMergedFile(t *testing.T) {
+	dir := t.TempDir()
+	clock := NewManualClock(time.Unix(1_700_000_000, 0))
+
+	s := openBitcask(t, dir, clock)
+	for i := 0; i < 100; i++ {
+		_ = s.Write("key:"+strconv.Itoa(i%3), Entry{Value: []byte(strconv.Itoa(i))}, PutOverwrite)
+	}
+	if err := s.Merge(); err != nil {
+		t.Fatal(err)
+	}
+	_ = s.Close()
+
+	// A crash between the two renames leaves no hint file
+	ids := dataFiles(t, dir)
+	if err := os.Remove(hintPath(dir, ids[0])); err != nil {
+		t.Fatal(err)
+	}
+
+	r := openBitcask(t, dir, clock)
+	defer r.Close()
+
+	mustHold(t, r, "key:0", "99")
+	mustHold(t, r, "key:2", "98")
+}
+
+func TestBitcask_ConcurrentMerge(t *testing.T) {
+	dir := t.TempDir()
+	clock := NewManualClock(time.Unix(1_700_000_000, 0))
+
+	s := openBitcask(t, dir, clock)
+	defer s.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 5; i++ {
+			if err := s.Merge(); err != nil {
+				t.Errorf("merge failed: %v", err)
+			}
+		}
+	}()
+
+	for i := 0; i < 2000; i++ {
+		_ = s.Write("key:"+strconv.Itoa(i%20), Entry{Value: []byte(strconv.Itoa(i))}, PutOverwrite)
+	}
+	<-done
+
+	for i := 1980; i < 2000; i++ {
+		mustHold(t, s, "key:"+strconv.Itoa(i%20), strconv.Itoa(i))
+	}
+}
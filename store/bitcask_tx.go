@@ -0,0 +1,135 @@
+package store
+
+/*
+Atomic runs fn under the exclusive lock, buffering its mutations in a
+private overlay. They are then appended as one group and applied to
+the keydir at one sequence, so a reload and every reader see the
+transaction whole or not at all.
+
+If fn returns an error, mutations made before it are still committed,
+like walStore.Atomic.
+*/
+func (s *bitcaskStore) Atomic(keys []string, fn func(tx Tx) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx := &bitcaskTx{
+		s:        s,
+		declared: make(map[string]struct{}, len(keys)),
+		overlay:  make(map[string]Entry),
+	}
+	for _, key := range keys {
+		tx.declared[key] = struct{}{}
+	}
+
+	fnErr := fn(tx)
+	if len(tx.records) == 0 {
+		return fnErr
+	}
+	if err := s.commit(tx.records); err != nil {
+		return err
+	}
+	return fnErr
+}
+
+/*
+bitcaskTx records the records of a transaction without touching the
+keydir. Reads see the transaction's own writes through overlay.
+*/
+type bitcaskTx struct {
+	s *bitcaskStore
+
+	declared map[string]struct{}
+	overlay  map[string]Entry
+	records  []record
+}
+
+func (tx *bitcaskTx) Read(key string) (Entry, bool) {
+	if _, ok := tx.declared[key]; !ok {
+		return Entry{}, false
+	}
+
+	if val, ok := tx.overlay[key]; ok {
+		if val.expired(tx.s.now()) {
+			return Entry{}, false
+		}
+		return val, true
+	}
+
+	e, ok := tx.s.live(key)
+	if !ok {
+		return Entry{}, false
+	}
+	return tx.s.entry(e)
+}
+
+func (tx *bitcaskTx) Write(key string, value Entry, mode PutMode) error {
+	if _, ok := tx.declared[key]; !ok {
+		return ErrKeyNotDeclared
+	}
+	_, exists := tx.Read(key)
+	if err := checkPutMode(mode, exists); err != nil {
+		return err
+	}
+
+	tx.stage(tx.s.putRecord(key, value, tx.s.now()))
+	return nil
+}
+
+func (tx *bitcaskTx) Expire(key string, unixTimestampMilli int64) bool {
+	if _, ok := tx.Read(key); !ok {
+		return false
+	}
+
+	tx.stage(record{kind: recordExpire, expiresAt: unixTimestampMilli, key: key})
+	return true
+}
+
+func (tx *bitcaskTx) ReadBatch(keys []string) map[string]Entry {
+	result := make(map[string]Entry, len(keys))
+	for _, key := range keys {
+		if val, ok := tx.Read(key); ok {
+			result[key] = val
+		}
+	}
+	return result
+}
+
+func (tx *bitcaskTx) WriteBatch(entries []KeyValue, mode PutMode) error {
+	for _, kv := range entries {
+		if _, ok := tx.declared[kv.Key]; !ok {
+			return ErrKeyNotDeclared
+		}
+		_, exists := tx.Read(kv.Key)
+		if err := checkPutMode(mode, exists); err != nil {
+			return err
+		}
+	}
+
+	now := tx.s.now()
+	for _, kv := range entries {
+		tx.stage(tx.s.putRecord(kv.Key, kv.Entry, now))
+	}
+	return nil
+}
+
+/*
+stage buffers a validated record and applies it to the overlay.
+*/
+func (tx *bitcaskTx) stage(rec record) {
+	tx.records = append(tx.records, rec)
+
+	if rec.kind == recordExpire {
+		val, _ := tx.Read(rec.key)
+		val.ExpiresAtMillis = rec.expiresAt
+		tx.overlay[rec.key] = val
+		return
+	}
+	tx.overlay[rec.key] = Entry{
+		Value:           rec.value,
+		ExpiresAtMillis: rec.expiresAt,
+		WrittenAtMillis: rec.writtenAt,
+		Encoding:        rec.encoding,
+		Type:            rec.valueType,
+	}
+}
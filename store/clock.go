@@ -0,0 +1,94 @@
+package store
+
+import (
+	"sync"
+	"time"
+)
+
+/*
+Clock is the source of time for expiration, write times and views.
+
+Stores never call time.Now directly: passing a ManualClock with
+WithClock makes TTL behavior deterministic in tests, with no sleeping.
+*/
+type Clock interface {
+	Now() time.Time
+}
+
+/*
+monotonicClock reports wall time derived from the monotonic clock.
+
+It reads the wall clock once, at start, and then only adds the
+monotonic time elapsed since. Stepping the system clock (NTP, manual
+changes) therefore does not move it: a TTL of ten seconds lasts ten
+seconds, instead of ending early or late after a jump.
+*/
+type monotonicClock struct {
+	start time.Time
+}
+
+func (c monotonicClock) Now() time.Time {
+	return c.start.Add(time.Since(c.start))
+}
+
+// systemClock is the default of every store and of the server, so
+// that all of them agree on the time.
+var systemClock Clock = monotonicClock{start: time.Now()}
+
+/*
+SystemClock returns the real clock used by default. Its wall time is
+fixed at process start and advanced by the monotonic clock.
+*/
+func SystemClock() Clock {
+	return systemClock
+}
+
+/*
+ManualClock is a Clock that only moves when told to. It is safe for
+concurrent use.
+*/
+type ManualClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+/*
+NewManualClock creates a clock stopped at now.
+*/
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{now: now}
+}
+
+func (c *ManualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+/*
+Advance moves the clock forward by d.
+*/
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+}
+
+/*
+Set moves the clock to now, which may be in the past.
+*/
+func (c *ManualClock) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = now
+}
+
+/*
+unixNow returns the current time of c in Unix milliseconds.
+*/
+func unixNow(c Clock) int64 {
+	return GetUnixTimestamp(c.Now())
+}
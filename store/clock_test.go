@@ -0,0 +1,121 @@
+package store
+
+import (
+	"hermes/wal"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestManualClock_DrivesExpiry(t *testing.T) {
+	for _, oc := range optionCases {
+		t.Run(oc.name, func(t *testing.T) {
+			clock := NewManualClock(time.Unix(1_000_000, 0))
+			s := oc.new(WithClock(clock), WithSweepInterval(0))
+			defer s.Close()
+
+			_ = s.Write("a", Entry{Value: []byte("1")}, PutOverwrite)
+			if !s.Expire("a", GetUnixTimestamp(clock.Now().Add(10*time.Second))) {
+				t.Fatalf("expire failed")
+			}
+
+			clock.Advance(9 * time.Second)
+			if _, ok := s.Read("a"); !ok {
+				t.Fatalf("key expired before its TTL")
+			}
+			view := s.Snapshot()
+			defer view.Close()
+
+			clock.Advance(time.Second)
+			if _, ok := s.Read("a"); ok {
+				t.Fatalf("key must expire once the clock reaches its TTL")
+			}
+			if _, ok := view.Read("a"); !ok {
+				t.Fatalf("a view must evaluate TTLs at the time it was taken")
+			}
+
+			if n := s.(Sweeper).SweepExpired(); n != 1 {
+				t.Fatalf("expected the sweeper to remove 1 key, got %d", n)
+			}
+		})
+	}
+}
+
+func TestManualClock_Set(t *testing.T) {
+	start := time.Unix(1_000_000, 0)
+	clock := NewManualClock(start)
+
+	clock.Advance(time.Minute)
+	if got := clock.Now(); !got.Equal(start.Add(time.Minute)) {
+		t.Fatalf("expected %v, got %v", start.Add(time.Minute), got)
+	}
+
+	clock.Set(start)
+	if got := clock.Now(); !got.Equal(start) {
+		t.Fatalf("expected the clock to go back to %v, got %v", start, got)
+	}
+}
+
+func TestSystemClock_FollowsWallTime(t *testing.T) {
+	clock := SystemClock()
+
+	prev := clock.Now()
+	for i := 0; i < 1000; i++ {
+		now := clock.Now()
+		if now.Before(prev) {
+			t.Fatalf("clock went back from %v to %v", prev, now)
+		}
+		prev = now
+	}
+
+	if drift := time.Since(prev).Abs(); drift > time.Second {
+		t.Fatalf("clock drifted %v from wall time", drift)
+	}
+}
+
+func TestWalStore_ReplayUsesClock(t *testing.T) {
+	dir := t.TempDir()
+	walPath := filepath.Join(dir, "wal.log")
+	snapPath := filepath.Join(dir, "snapshot.bin")
+
+	clock := NewManualClock(time.Unix(1_000_000, 0))
+	open := func() DataStore {
+		w, err := wal.NewWAL(wal.Config{Path: walPath, SyncPolicy: wal.SyncEveryWrite})
+		if err != nil {
+			t.Fatal(err)
+		}
+		opts := []Option{WithClock(clock), WithHistory(HistoryPolicy{Keep: 5})}
+		ds, err := NewWalStore(NewLockedStore(opts...), w, snapPath, 0, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ds
+	}
+
+	ds := open()
+	written := GetUnixTimestamp(clock.Now())
+	_ = ds.Write("a", Entry{Value: []byte("1")}, PutOverwrite)
+	_ = ds.Expire("a", written+10_000)
+
+	// Keep the WAL only, so recovery replays it
+	_ = os.Remove(snapPath)
+	recovered := open()
+	defer recovered.Close()
+	defer ds.Close()
+
+	// The expiry lies long in the past of the wall clock, but not of
+	// the store's clock
+	got, ok := recovered.Read("a")
+	if !ok {
+		t.Fatalf("expected a to be live at the clock's time")
+	}
+	if got.WrittenAtMillis != written {
+		t.Fatalf("expected write time %d from the clock, got %d", written, got.WrittenAtMillis)
+	}
+
+	clock.Advance(10 * time.Second)
+	if _, ok := recovered.Read("a"); ok {
+		t.Fatalf("expected a to expire with the clock")
+	}
+}
@@ -3,48 +3,71 @@ package store
 import (
 	"errors"
 	"hermes/snapshot"
+	"hermes/wal"
+	"math/rand/v2"
 	"os"
 	"path/filepath"
 	"time"
 )
 
 /*
-Compact performs snapshot-based compaction.
-
-High-level algorithm (Stop-the-World):
-1. Block all writes using a global lock
-2. Stream all live entries into a temporary snapshot
-3. fsync snapshot to guarantee durability
-4. Rotate WAL to establish a new clean baseline
-5. Atomically promote snapshot
-
-Design trade-offs:
-- Writes are paused during compaction
-- Simpler correctness model
-- Snapshot frequency should be low
-*/
-func (s *walStore) Compact() error {
-	// Capability check: store must support iteration
-	iterStore, ok := s.store.(Iterable)
-	if !ok {
-		return errors.New("underlying store does not support iteration")
-	}
+Compact performs snapshot-based compaction without stopping writes
+while the snapshot is streamed.
+
+High-level algorithm:
+1. Briefly block writes, pin a view of the store and start recording
+   the keys written from then on
+2. Stream the view into a temporary snapshot while writes continue,
+   followed by the recorded key histories
+3. Block writes again and append the current state of every key
+   written during step 2. The snapshot now matches memory exactly
+4. Mark the end of the snapshot with a checkpoint, in the snapshot
+   and durably in the WAL, then fsync the snapshot, promote it
+   atomically and rotate the WAL, still under the lock, so the new
+   WAL starts exactly where the snapshot ends
+
+Writers are paused for steps 1, 3 and 4 only: the pause is
+proportional to the keys written during the stream, not to the size
+of the store.
 
+Keys that no longer exist in step 3 are written as tombstones: items
+that expired at the epoch. Loading applies items in order, so a
+tombstone hides the key's older item and is then never visible.
+Histories are written whole and replace each other the same way.
+
+Ops are not idempotent, so no record the snapshot holds may be
+replayed on top of it. Should the WAL not be rotated after the
+snapshot is promoted, by a crash or a failed Rotate, it still holds
+the snapshot's checkpoint, and recovery skips every record up to it.
+*/
+func (s *walStore) Compact() (err error) {
 	// Capability check: WAL must support rotation
-	rotator, ok := s.wal.(interface{ Rotate() error })
+	rotator, ok := s.wal.(checkpointer)
 	if !ok {
 		return errors.New("wal does not support rotation")
 	}
 
-	// Stop-the-world: block all writers
+	s.compactMu.Lock()
+	defer s.compactMu.Unlock()
+
+	// Phase 1: pin a view and start tracking changes
 	s.mu.Lock()
-	defer s.mu.Unlock()
+	view := s.store.Snapshot()
+	if view == nil {
+		s.mu.Unlock()
+		return errors.New("underlying store does not support snapshots")
+	}
+	s.setDirty(make(map[string]struct{}))
+	s.mu.Unlock()
+
+	defer view.Close()
+	defer s.setDirty(nil)
 
 	// Ensure snapshot directory exists
 	dir := filepath.Dir(s.snapshotPath)
 	if err := os.MkdirAll(dir, 0755); err != nil {
-        	return err
-    	}
+		return err
+	}
 
 	// Write snapshot to a temporary file
 	tempSnap, err := os.CreateTemp(dir, "snapshot-*.bin")
@@ -61,19 +84,33 @@ func (s *walStore) Compact() error {
 		}
 	}()
 
-	// Adapter bridges store.Iterate to snapshot.Streamer
-	adaptor := func(yield func(snapshot.Item) bool) {
-		iterStore.Iterate(func(key string, value Entry) bool {
-			return yield(snapshot.Item{
-				Key:       key,
-				Value:     value.Value,
-				ExpiresAt: value.ExpiresAtMillis,
-			})
-		})
+	// Phase 2: stream the view; writers keep running
+	if err = snapshot.Write(tempSnap, streamEntries(view.Iterate)); err != nil {
+		return err
+	}
+	if hs, ok := s.store.(historyStore); ok {
+		if err = snapshot.Write(tempSnap, streamHistories(hs)); err != nil {
+			return err
+		}
+	}
+
+	// Phase 3: stop writers and catch up with what they changed
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dirty := s.setDirty(nil)
+	if err = snapshot.Write(tempSnap, s.streamDirty(dirty)); err != nil {
+		return err
 	}
 
-	// Persist snapshot
-	if err = snapshot.Write(tempSnap, adaptor); err != nil {
+	// Mark the end of the snapshot in both files, the WAL's durably
+	checkpoint := newCheckpoint()
+	if err = snapshot.Write(tempSnap, func(yield func(snapshot.Item) bool) {
+		yield(snapshot.Item{Checkpoint: checkpoint})
+	}); err != nil {
+		return err
+	}
+	if err = rotator.Checkpoint(checkpoint); err != nil {
 		return err
 	}
 
@@ -95,6 +132,138 @@ func (s *walStore) Compact() error {
 	return nil
 }
 
+/*
+checkpointer is a WAL that compaction can cut: it marks where a
+snapshot ends, and starts a new file from there.
+*/
+type checkpointer interface {
+	Checkpoint(id uint64) error
+	Rotate() error
+}
+
+/*
+newCheckpoint returns a random checkpoint id. Ids only need to differ
+from those of the earlier snapshots whose checkpoints a WAL may hold.
+*/
+func newCheckpoint() uint64 {
+	for {
+		if id := rand.Uint64(); id != 0 {
+			return id
+		}
+	}
+}
+
+/*
+holdsCheckpoint reports whether w still holds the checkpoint id,
+which it does when the snapshot that recorded it was promoted but w
+was not rotated after it.
+*/
+func holdsCheckpoint(w wal.WAL, id uint64) (bool, error) {
+	err := w.Replay(func(r wal.WALRecord) error {
+		if r.Type == wal.RecordCheckpoint && r.Checkpoint == id {
+			return errCheckpointFound
+		}
+		return nil
+	})
+	if errors.Is(err, errCheckpointFound) {
+		return true, nil
+	}
+	return false, err
+}
+
+// errCheckpointFound stops the scan of holdsCheckpoint.
+var errCheckpointFound = errors.New("checkpoint found")
+
+/*
+setDirty replaces the set of keys written during compaction and
+returns the previous one.
+*/
+func (s *walStore) setDirty(dirty map[string]struct{}) map[string]struct{} {
+	s.dirtyMu.Lock()
+	defer s.dirtyMu.Unlock()
+
+	prev := s.dirty
+	s.dirty = dirty
+	return prev
+}
+
+/*
+streamEntries adapts an Iterate function to snapshot.Streamer.
+*/
+func streamEntries(iterate func(fn func(key string, value Entry) bool)) snapshot.Streamer {
+	return func(yield func(snapshot.Item) bool) {
+		iterate(func(key string, value Entry) bool {
+			return yield(entryItem(key, value))
+		})
+	}
+}
+
+/*
+streamHistories adapts the recorded histories of a store to
+snapshot.Streamer.
+*/
+func streamHistories(hs historyStore) snapshot.Streamer {
+	return func(yield func(snapshot.Item) bool) {
+		hs.histories(func(key string, h History) bool {
+			return yield(historyItem(key, h))
+		})
+	}
+}
+
+func entryItem(key string, value Entry) snapshot.Item {
+	return snapshot.Item{
+		Key:       key,
+		Value:     value.Value,
+		ExpiresAt: value.ExpiresAtMillis,
+		WrittenAt: value.WrittenAtMillis,
+		Encoding:  uint8(value.Encoding),
+		Type:      uint8(value.Type),
+	}
+}
+
+/*
+historyItem converts a history into an item that restores it whole.
+*/
+func historyItem(key string, h History) snapshot.Item {
+	revisions := make([]snapshot.Item, len(h))
+	for i, rev := range h {
+		revisions[i] = entryItem("", rev)
+	}
+
+	item := entryItem(key, h[len(h)-1])
+	item.History = revisions
+	return item
+}
+
+// tombstoneExpiry marks a snapshot item for a key that no longer exists.
+const tombstoneExpiry = 1
+
+/*
+streamDirty yields the current state of every key in dirty. The caller
+holds mu, so no write is in flight. Stores with history are asked for
+it, which also returns the entry of keys without one.
+*/
+func (s *walStore) streamDirty(dirty map[string]struct{}) snapshot.Streamer {
+	historian, _ := s.store.(Historian)
+
+	return func(yield func(snapshot.Item) bool) {
+		for key := range dirty {
+			item := snapshot.Item{Key: key, ExpiresAt: tombstoneExpiry}
+			if historian != nil {
+				if h := historian.History(key); len(h) > 0 {
+					item = historyItem(key, h)
+				}
+			} else if val, ok := s.store.Read(key); ok {
+				item = entryItem(key, val)
+			}
+
+			if !yield(item) {
+				return
+			}
+		}
+	}
+}
+
 /*
 startSnapshotSupervisor periodically triggers compaction.
 
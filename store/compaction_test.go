@@ -1,21 +1,30 @@
 package store
 
 import (
+	"errors"
 	"hermes/wal"
 	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
 	"testing"
+	"time"
 )
 
 /*
-Fake store that does NOT implement Iterable.
+Fake store that cannot take snapshots.
 Used to validate capability guards.
 */
 type nonIterableStore struct{}
 
-func (n *nonIterableStore) Write(string, Entry, PutMode) error { return nil }
-func (n *nonIterableStore) Read(string) (Entry, bool)          { return Entry{}, false }
-func (n *nonIterableStore) Expire(string, int64) bool          { return false }
-func (n *nonIterableStore) Close() error                       { return nil }
+func (n *nonIterableStore) Write(string, Entry, PutMode) error    { return nil }
+func (n *nonIterableStore) Read(string) (Entry, bool)             { return Entry{}, false }
+func (n *nonIterableStore) Expire(string, int64) bool             { return false }
+func (n *nonIterableStore) ReadBatch([]string) map[string]Entry   { return nil }
+func (n *nonIterableStore) WriteBatch([]KeyValue, PutMode) error  { return nil }
+func (n *nonIterableStore) Atomic([]string, func(Tx) error) error { return nil }
+func (n *nonIterableStore) Snapshot() View                        { return nil }
+func (n *nonIterableStore) Close() error                          { return nil }
 
 /*
 Fake WAL that does NOT implement Rotate().
@@ -23,10 +32,11 @@ Fake WAL that does NOT implement Rotate().
 type walWithoutRotate struct{}
 
 func (w *walWithoutRotate) Append(wal.WALRecord) error             { return nil }
+func (w *walWithoutRotate) AppendAtomic([]wal.WALRecord) error     { return nil }
 func (w *walWithoutRotate) Replay(func(wal.WALRecord) error) error { return nil }
 func (w *walWithoutRotate) Close() error                           { return nil }
 
-func TestCompact_FailsWithoutIterable(t *testing.T) {
+func TestCompact_FailsWithoutSnapshot(t *testing.T) {
 	ws := &walStore{
 		store:        &nonIterableStore{},
 		wal:          &walWithoutRotate{},
@@ -35,7 +45,7 @@ func TestCompact_FailsWithoutIterable(t *testing.T) {
 
 	err := ws.Compact()
 	if err == nil {
-		t.Fatalf("expected error for non-iterable store")
+		t.Fatalf("expected error for store without snapshots")
 	}
 }
 
@@ -54,3 +64,155 @@ func TestCompact_FailsWithoutRotate(t *testing.T) {
 		t.Fatalf("expected error for wal without Rotate")
 	}
 }
+
+/*
+Writers keep running while Compact streams the snapshot; whatever they
+did must survive a restart from the snapshot and the rotated WAL.
+*/
+func TestCompact_ConcurrentWritesSurviveRecovery(t *testing.T) {
+	factory := setupFactory(t, func() DataStore { return NewLockedStore() })
+	ds, walPath, snapPath, closeFn, cleanup := factory()
+	defer cleanup()
+
+	const keys = 500
+	for i := 0; i < keys; i++ {
+		_ = ds.Write("key:"+strconv.Itoa(i), Entry{Value: []byte("0")}, PutOverwrite)
+	}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		past := GetUnixTimestamp(time.Now().Add(-time.Millisecond))
+		for round := 1; ; round++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+
+			key := "key:" + strconv.Itoa(round%keys)
+			_ = ds.Write(key, Entry{Value: []byte(strconv.Itoa(round))}, PutOverwrite)
+			if round%5 == 0 {
+				_ = ds.Expire(key, past)
+			}
+		}
+	}()
+
+	for i := 0; i < 5; i++ {
+		if err := ds.(*walStore).Compact(); err != nil {
+			t.Fatalf("compact failed: %v", err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+
+	want := make(map[string]string)
+	for i := 0; i < keys; i++ {
+		key := "key:" + strconv.Itoa(i)
+		if val, ok := ds.Read(key); ok {
+			want[key] = string(val.Value)
+		}
+	}
+	closeFn()
+
+	w, err := wal.NewWAL(wal.Config{Path: walPath, SyncPolicy: wal.SyncEveryWrite})
+	if err != nil {
+		t.Fatal(err)
+	}
+	recovered, err := NewWalStore(NewLockedStore(), w, snapPath, 0)
+	if err != nil {
+		t.Fatalf("recovery failed: %v", err)
+	}
+	defer recovered.Close()
+
+	for i := 0; i < keys; i++ {
+		key := "key:" + strconv.Itoa(i)
+		val, ok := recovered.Read(key)
+		if want, live := want[key]; ok != live || string(val.Value) != want {
+			t.Fatalf("%s recovered as %q (%v), want %q (%v)", key, val.Value, ok, want, live)
+		}
+	}
+}
+
+/*
+walFailingRotate is a WAL whose rotations fail, leaving the log as it
+is after a crash between promoting a snapshot and rotating.
+*/
+type walFailingRotate struct {
+	wal.WAL
+}
+
+func (w walFailingRotate) Checkpoint(id uint64) error {
+	return w.WAL.(checkpointer).Checkpoint(id)
+}
+
+func (w walFailingRotate) Rotate() error {
+	return errors.New("rotate failed")
+}
+
+func TestCompact_UnrotatedWALIsNotReplayedTwice(t *testing.T) {
+	dir := t.TempDir()
+	open := func(failRotate bool) (DataStore, wal.WAL) {
+		t.Helper()
+		w, err := wal.NewWAL(wal.Config{Path: filepath.Join(dir, "wal.log")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if failRotate {
+			w = walFailingRotate{w}
+		}
+		ws, err := NewWalStore(NewLockedStore(), w, filepath.Join(dir, "snapshot.bin"), 0)
+		if err != nil {
+			t.Fatalf("recovery failed: %v", err)
+		}
+		return ws, w
+	}
+	mustLen := func(s DataStore, op, key string, want int64) {
+		t.Helper()
+		if n, err := Apply(s, key, Op{Name: op}); err != nil || n != want {
+			t.Fatalf("expected %s %s to be %d, got %v (%v)", op, key, want, n, err)
+		}
+	}
+
+	s, w := open(true)
+	_, _ = Apply(s, "q", Op{Name: "RPUSH", Args: []string{"a"}})
+	_, _ = Apply(s, "s", Op{Name: "XADD", Args: []string{"5-0", "f", "v"}})
+	if err := s.(*walStore).Compact(); err == nil {
+		t.Fatalf("expected the compaction to fail rotating")
+	}
+
+	// Writes after the promoted snapshot still replay, once
+	_, _ = Apply(s, "q", Op{Name: "RPUSH", Args: []string{"b"}})
+	w.Close()
+
+	s, w = open(true)
+	mustLen(s, "LLEN", "q", 2)
+	mustLen(s, "XLEN", "s", 1)
+
+	// A second failed compaction leaves two checkpoints in the WAL:
+	// replay resumes after the one of the promoted snapshot
+	_, _ = Apply(s, "q", Op{Name: "RPUSH", Args: []string{"c"}})
+	if err := s.(*walStore).Compact(); err == nil {
+		t.Fatalf("expected the compaction to fail rotating")
+	}
+	_, _ = Apply(s, "q", Op{Name: "RPUSH", Args: []string{"d"}})
+	w.Close()
+
+	// Once a compaction rotates, the new WAL holds no checkpoint and
+	// replays whole
+	s, _ = open(false)
+	mustLen(s, "LLEN", "q", 4)
+	_, _ = Apply(s, "q", Op{Name: "RPUSH", Args: []string{"e"}})
+	if err := s.(*walStore).Compact(); err != nil {
+		t.Fatal(err)
+	}
+	_, _ = Apply(s, "q", Op{Name: "RPUSH", Args: []string{"f"}})
+	s.(*walStore).wal.Close()
+
+	s, _ = open(false)
+	defer s.Close()
+	mustLen(s, "LLEN", "q", 6)
+	mustLen(s, "XLEN", "s", 1)
+}
@@ -0,0 +1,413 @@
+package store
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+/*
+Compressor is implemented by stores that compress values, to report
+how much they save.
+*/
+type Compressor interface {
+	CompressionStats() CompressionStats
+}
+
+/*
+CompressionStats describes the values written through a compressed
+store since it was created, overwritten ones included.
+*/
+type CompressionStats struct {
+	// Values counts the values written; Compressed counts those
+	// stored compressed.
+	Values     uint64
+	Compressed uint64
+
+	// RawBytes and StoredBytes are the sizes of those values as
+	// written and as stored.
+	RawBytes    uint64
+	StoredBytes uint64
+
+	// Ratio is RawBytes divided by StoredBytes: 2.0 means values take
+	// half the space they would raw. It is 0 before any write.
+	Ratio float64
+}
+
+/*
+compressedStore is a decorator that compresses values of at least
+threshold bytes with DEFLATE before handing them to the wrapped store,
+and decompresses them on the way out.
+
+Compressed values are stored with EncodingFlate, a flag every store
+keeps and persists, so the compressed bytes are what memory holds and
+what the WAL, snapshots and Bitcask files write. Replay hands them
+back still compressed, with no work to redo. A value that would not
+shrink is stored raw.
+
+To compress the log too, wrap the walStore in the compressed store,
+not the reverse: the WAL then logs the compressed values.
+*/
+type compressedStore struct {
+	store     DataStore
+	threshold int
+
+	values      atomic.Uint64
+	compressed  atomic.Uint64
+	rawBytes    atomic.Uint64
+	storedBytes atomic.Uint64
+}
+
+/*
+NewCompressedStore wraps st, compressing every value of at least
+threshold bytes.
+
+Typed values written by ops are compressed only when st stores them as
+bytes, as Bitcask and the tiered store do. The in-memory stores keep
+them decoded instead, and the WAL logs the op rather than the value,
+so through those the values of ops are neither compressed nor counted
+in CompressionStats.
+*/
+func NewCompressedStore(st DataStore, threshold int) DataStore {
+	return &compressedStore{store: st, threshold: threshold}
+}
+
+/*
+CompressionStats returns the counters of the values written so far.
+*/
+func (s *compressedStore) CompressionStats() CompressionStats {
+	stats := CompressionStats{
+		Values:      s.values.Load(),
+		Compressed:  s.compressed.Load(),
+		RawBytes:    s.rawBytes.Load(),
+		StoredBytes: s.storedBytes.Load(),
+	}
+	if stats.StoredBytes > 0 {
+		stats.Ratio = float64(stats.RawBytes) / float64(stats.StoredBytes)
+	}
+	return stats
+}
+
+/*
+encode returns value as it is stored. Values that are already encoded
+are stored as they are.
+*/
+func (s *compressedStore) encode(value Entry) Entry {
+	if value.Encoding != EncodingRaw {
+		return value
+	}
+
+	raw := len(value.Value)
+	if raw >= s.threshold {
+		if packed, ok := compress(value.Value); ok {
+			value.Value, value.Encoding = packed, EncodingFlate
+			s.compressed.Add(1)
+		}
+	}
+
+	s.values.Add(1)
+	s.rawBytes.Add(uint64(raw))
+	s.storedBytes.Add(uint64(len(value.Value)))
+	return value
+}
+
+func (s *compressedStore) encodeAll(entries []KeyValue) []KeyValue {
+	encoded := make([]KeyValue, len(entries))
+	for i, kv := range entries {
+		encoded[i] = KeyValue{Key: kv.Key, Entry: s.encode(kv.Entry)}
+	}
+	return encoded
+}
+
+/*
+DecodeEntry returns a stored entry with its plain value. An entry that
+cannot be decoded is reported absent.
+
+Entries keep their encoding wherever they are stored, so a value
+written through a compressed store is still compressed when the same
+data is later opened without one. Readers that hand values to clients
+decode them with DecodeEntry rather than rely on a compressed store
+being in front.
+*/
+func DecodeEntry(val Entry) (Entry, bool) {
+	if val.Encoding == EncodingRaw {
+		return val, true
+	}
+
+	value, err := decode(val.Value, val.Encoding)
+	if err != nil {
+		return Entry{}, false
+	}
+	val.Value, val.Encoding = value, EncodingRaw
+	return val, true
+}
+
+/*
+decodeBatch decodes every entry of batch in place.
+*/
+func decodeBatch(batch map[string]Entry) map[string]Entry {
+	for key, val := range batch {
+		if val, ok := DecodeEntry(val); ok {
+			batch[key] = val
+		} else {
+			delete(batch, key)
+		}
+	}
+	return batch
+}
+
+/*
+decodeEach wraps fn to receive plain values, skipping the entries that
+cannot be decoded.
+*/
+func decodeEach(fn func(key string, value Entry) bool) func(key string, value Entry) bool {
+	return func(key string, value Entry) bool {
+		val, ok := DecodeEntry(value)
+		return !ok || fn(key, val)
+	}
+}
+
+func (s *compressedStore) Read(key string) (Entry, bool) {
+	val, ok := s.store.Read(key)
+	if !ok {
+		return Entry{}, false
+	}
+	return DecodeEntry(val)
+}
+
+func (s *compressedStore) Write(key string, value Entry, mode PutMode) error {
+	return s.store.Write(key, s.encode(value), mode)
+}
+
+/*
+Expire only changes the key's TTL, so the value is not touched.
+*/
+func (s *compressedStore) Expire(key string, unixTimestampMilli int64) bool {
+	return s.store.Expire(key, unixTimestampMilli)
+}
+
+/*
+ReadContext, WriteContext and ExpireContext give up when ctx ends if
+the wrapped store is a ContextStore, and are the plain calls otherwise.
+*/
+func (s *compressedStore) ReadContext(ctx context.Context, key string) (Entry, bool, error) {
+	cs, ok := s.store.(ContextStore)
+	if !ok {
+		val, ok := s.Read(key)
+		return val, ok, nil
+	}
+
+	val, ok, err := cs.ReadContext(ctx, key)
+	if !ok || err != nil {
+		return Entry{}, false, err
+	}
+	val, ok = DecodeEntry(val)
+	return val, ok, nil
+}
+
+func (s *compressedStore) WriteContext(ctx context.Context, key string, value Entry, mode PutMode) error {
+	if cs, ok := s.store.(ContextStore); ok {
+		return cs.WriteContext(ctx, key, s.encode(value), mode)
+	}
+	return s.Write(key, value, mode)
+}
+
+func (s *compressedStore) ExpireContext(ctx context.Context, key string, unixTimestampMilli int64) (bool, error) {
+	if cs, ok := s.store.(ContextStore); ok {
+		return cs.ExpireContext(ctx, key, unixTimestampMilli)
+	}
+	return s.Expire(key, unixTimestampMilli), nil
+}
+
+func (s *compressedStore) ReadBatch(keys []string) map[string]Entry {
+	return decodeBatch(s.store.ReadBatch(keys))
+}
+
+func (s *compressedStore) WriteBatch(entries []KeyValue, mode PutMode) error {
+	return s.store.WriteBatch(s.encodeAll(entries), mode)
+}
+
+func (s *compressedStore) Atomic(keys []string, fn func(tx Tx) error) error {
+	return s.store.Atomic(keys, func(inner Tx) error {
+		return fn(&compressedTx{s: s, inner: inner})
+	})
+}
+
+/*
+Apply forwards op to a wrapped store that runs ops itself, so a store
+that logs ops keeps doing so and one that keeps values decoded keeps
+them so. Other stores run it through Atomic, which compresses and
+counts the value it writes like any other.
+*/
+func (s *compressedStore) Apply(key string, op Op) (any, error) {
+	if _, ok := s.store.(Applier); ok {
+		return Apply(s.store, key, op)
+	}
+	return applyAtomic(s, key, op)
+}
+
+/*
+Iterate visits the wrapped store's entries with plain values. Stores
+that cannot iterate are traversed through a view.
+*/
+func (s *compressedStore) Iterate(fn func(key string, value Entry) bool) {
+	it, ok := s.store.(Iterable)
+	if !ok {
+		v := s.Snapshot()
+		defer v.Close()
+		v.Iterate(fn)
+		return
+	}
+	it.Iterate(decodeEach(fn))
+}
+
+/*
+ScanEntries decodes one step of the wrapped store's scan. Over a store
+that cannot scan, which AsScanner reports, it returns an empty,
+finished scan.
+*/
+func (s *compressedStore) ScanEntries(cursor uint64, count int) ([]KeyValue, uint64) {
+	sc, ok := s.store.(Scanner)
+	if !ok {
+		return nil, 0
+	}
+
+	entries, next := sc.ScanEntries(cursor, count)
+	decoded := entries[:0]
+	for _, kv := range entries {
+		if val, ok := DecodeEntry(kv.Entry); ok {
+			decoded = append(decoded, KeyValue{Key: kv.Key, Entry: val})
+		}
+	}
+	return decoded, next
+}
+
+/*
+History decodes the wrapped store's history. Stores without history
+return nothing.
+*/
+func (s *compressedStore) History(key string) History {
+	h, ok := s.store.(Historian)
+	if !ok {
+		return nil
+	}
+
+	recorded := h.History(key)
+	decoded := make(History, 0, len(recorded))
+	for _, rev := range recorded {
+		if rev, ok := DecodeEntry(rev); ok {
+			decoded = append(decoded, rev)
+		}
+	}
+	return decoded
+}
+
+/*
+Range, ReverseRange and Prefix decode the wrapped store's traversals.
+They visit nothing when the wrapped store is not Ordered, which
+AsOrdered reports.
+*/
+func (s *compressedStore) Range(start, end string, fn func(key string, value Entry) bool) {
+	if o, ok := s.store.(Ordered); ok {
+		o.Range(start, end, decodeEach(fn))
+	}
+}
+
+func (s *compressedStore) ReverseRange(start, end string, fn func(key string, value Entry) bool) {
+	if o, ok := s.store.(Ordered); ok {
+		o.ReverseRange(start, end, decodeEach(fn))
+	}
+}
+
+func (s *compressedStore) Prefix(prefix string, fn func(key string, value Entry) bool) {
+	if o, ok := s.store.(Ordered); ok {
+		o.Prefix(prefix, decodeEach(fn))
+	}
+}
+
+func (s *compressedStore) Snapshot() View {
+	return &compressedView{view: s.store.Snapshot()}
+}
+
+func (s *compressedStore) Close() error {
+	return s.store.Close()
+}
+
+/*
+compressedTx is the Tx handed to an Atomic callback.
+*/
+type compressedTx struct {
+	s     *compressedStore
+	inner Tx
+}
+
+func (tx *compressedTx) Read(key string) (Entry, bool) {
+	val, ok := tx.inner.Read(key)
+	if !ok {
+		return Entry{}, false
+	}
+	return DecodeEntry(val)
+}
+
+func (tx *compressedTx) Write(key string, value Entry, mode PutMode) error {
+	return tx.inner.Write(key, tx.s.encode(value), mode)
+}
+
+func (tx *compressedTx) Expire(key string, unixTimestampMilli int64) bool {
+	return tx.inner.Expire(key, unixTimestampMilli)
+}
+
+func (tx *compressedTx) ReadBatch(keys []string) map[string]Entry {
+	return decodeBatch(tx.inner.ReadBatch(keys))
+}
+
+func (tx *compressedTx) WriteBatch(entries []KeyValue, mode PutMode) error {
+	return tx.inner.WriteBatch(tx.s.encodeAll(entries), mode)
+}
+
+/*
+Apply forwards op like compressedStore.Apply. Otherwise the op reads
+the value decompressed and writes it back through tx, compressed.
+*/
+func (tx *compressedTx) Apply(key string, op Op) (any, error) {
+	switch tx.inner.(type) {
+	case Applier, liveTx:
+		return Apply(tx.inner, key, op)
+	}
+
+	res, err := runOp(tx, key, op)
+	if err != nil {
+		return nil, err
+	}
+	return res.reply, res.commit(tx, key)
+}
+
+/*
+compressedView decodes what its wrapped view sees.
+*/
+type compressedView struct {
+	view View
+}
+
+func (v *compressedView) Read(key string) (Entry, bool) {
+	val, ok := v.view.Read(key)
+	if !ok {
+		return Entry{}, false
+	}
+	return DecodeEntry(val)
+}
+
+func (v *compressedView) ReadBatch(keys []string) map[string]Entry {
+	return decodeBatch(v.view.ReadBatch(keys))
+}
+
+func (v *compressedView) Iterate(fn func(key string, value Entry) bool) {
+	v.view.Iterate(decodeEach(fn))
+}
+
+func (v *compressedView) Sequence() uint64 {
+	return v.view.Sequence()
+}
+
+func (v *compressedView) Close() {
+	v.view.Close()
+}
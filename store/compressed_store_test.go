@@ -0,0 +1,220 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"hermes/wal"
+)
+
+// document is a value that compresses well, like a JSON blob.
+var document = strings.Repeat(`{"user":"hermes","roles":["reader","writer"]},`, 50)
+
+/*
+openCompressedWal opens a compressed store over a walStore over a
+locked store, all on files in dir.
+*/
+func openCompressedWal(t *testing.T, dir string, clock Clock) DataStore {
+	t.Helper()
+
+	w, err := wal.NewWAL(wal.Config{Path: filepath.Join(dir, "wal.log"), SyncPolicy: wal.SyncEveryWrite})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws, err := NewWalStore(
+		NewLockedStore(WithClock(clock)),
+		w,
+		filepath.Join(dir, "snapshot.bin"),
+		0,
+		WithClock(clock),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewCompressedStore(ws, 64)
+}
+
+func TestCompressed_StoresCompressedValues(t *testing.T) {
+	inner := NewLockedStore()
+	s := NewCompressedStore(inner, 64)
+
+	_ = s.Write("doc", Entry{Value: []byte(document)}, PutOverwrite)
+	_ = s.Write("small", Entry{Value: []byte("tiny")}, PutOverwrite)
+
+	stored, _ := inner.Read("doc")
+	if stored.Encoding != EncodingFlate || len(stored.Value) >= len(document) {
+		t.Fatalf("expected doc to be stored compressed, got %d bytes with encoding %d", len(stored.Value), stored.Encoding)
+	}
+	if stored, _ := inner.Read("small"); stored.Encoding != EncodingRaw {
+		t.Fatalf("expected a value under the threshold to be stored raw")
+	}
+
+	val, ok := s.Read("doc")
+	if !ok || string(val.Value) != document || val.Encoding != EncodingRaw {
+		t.Fatalf("expected doc to read back plain")
+	}
+	mustHold(t, s, "small", "tiny")
+
+	stats := s.(Compressor).CompressionStats()
+	if stats.Values != 2 || stats.Compressed != 1 {
+		t.Fatalf("expected 2 values with 1 compressed, got %+v", stats)
+	}
+	if stats.RawBytes != uint64(len(document)+4) || stats.Ratio <= 1 {
+		t.Fatalf("expected a compression ratio above 1, got %+v", stats)
+	}
+}
+
+func TestCompressed_IncompressibleValueStaysRaw(t *testing.T) {
+	inner := NewLockedStore()
+	s := NewCompressedStore(inner, 0)
+
+	_ = s.Write("k", Entry{Value: []byte("abc")}, PutOverwrite)
+	if stored, _ := inner.Read("k"); stored.Encoding != EncodingRaw || string(stored.Value) != "abc" {
+		t.Fatalf("expected a value that does not shrink to be stored raw")
+	}
+}
+
+func TestCompressed_BatchesTransactionsAndViews(t *testing.T) {
+	s := NewCompressedStore(NewShardedStore(4), 64)
+
+	_ = s.WriteBatch([]KeyValue{
+		{Key: "a", Entry: Entry{Value: []byte(document + "a")}},
+		{Key: "b", Entry: Entry{Value: []byte(document + "b")}},
+	}, PutOverwrite)
+	_ = s.Atomic([]string{"a", "c"}, func(tx Tx) error {
+		val, _ := tx.Read("a")
+		return tx.Write("c", Entry{Value: append(val.Value, 'c')}, PutIfAbsent)
+	})
+
+	view := s.Snapshot()
+	defer view.Close()
+	_ = s.Write("a", Entry{Value: []byte("new")}, PutOverwrite)
+
+	batch := s.ReadBatch([]string{"a", "b", "c"})
+	if string(batch["a"].Value) != "new" || string(batch["b"].Value) != document+"b" ||
+		string(batch["c"].Value) != document+"ac" {
+		t.Fatalf("unexpected batch %v", batch)
+	}
+	if val, _ := view.Read("a"); string(val.Value) != document+"a" {
+		t.Fatalf("expected the view to read the compressed value plain")
+	}
+
+	seen := 0
+	s.(Iterable).Iterate(func(key string, value Entry) bool {
+		if value.Encoding != EncodingRaw {
+			t.Fatalf("expected %s to iterate plain", key)
+		}
+		seen++
+		return true
+	})
+	if seen != 3 {
+		t.Fatalf("expected 3 keys, got %d", seen)
+	}
+}
+
+func TestCompressed_SurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	clock := NewManualClock(time.Unix(1_700_000_000, 0))
+
+	s := openCompressedWal(t, dir, clock)
+	for i := 0; i < 10; i++ {
+		_ = s.Write("doc:"+strconv.Itoa(i), Entry{Value: []byte(document)}, PutOverwrite)
+	}
+
+	info, err := os.Stat(filepath.Join(dir, "wal.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() >= int64(len(document)) {
+		t.Fatalf("expected the WAL to log compressed values, it holds %d bytes", info.Size())
+	}
+
+	// Close snapshots the compressed values and rotates the WAL
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	info, err = os.Stat(filepath.Join(dir, "snapshot.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() >= int64(len(document)) {
+		t.Fatalf("expected the snapshot to hold compressed values, it holds %d bytes", info.Size())
+	}
+
+	r := openCompressedWal(t, dir, clock)
+	_ = r.Write("doc:10", Entry{Value: []byte(document)}, PutOverwrite)
+	r.(*compressedStore).store.(*walStore).wal.Close()
+
+	// Replay both the snapshot and the WAL
+	r = openCompressedWal(t, dir, clock)
+	defer r.Close()
+	for i := 0; i <= 10; i++ {
+		mustHold(t, r, "doc:"+strconv.Itoa(i), document)
+	}
+}
+
+func TestCompressed_Bitcask(t *testing.T) {
+	dir := t.TempDir()
+	clock := NewManualClock(time.Unix(1_700_000_000, 0))
+
+	s := NewCompressedStore(openBitcask(t, dir, clock), 64)
+	_ = s.Write("doc", Entry{Value: []byte(document)}, PutOverwrite)
+	_ = s.Write("small", Entry{Value: []byte("tiny")}, PutOverwrite)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	inner := openBitcask(t, dir, clock)
+	if err := inner.Merge(); err != nil {
+		t.Fatal(err)
+	}
+	if stored, _ := inner.Read("doc"); stored.Encoding != EncodingFlate {
+		t.Fatalf("expected the data files to keep the encoding")
+	}
+
+	r := NewCompressedStore(inner, 64)
+	defer r.Close()
+	mustHold(t, r, "doc", document)
+	mustHold(t, r, "small", "tiny")
+}
+
+func TestCompressed_OpsOnBitcask(t *testing.T) {
+	dir := t.TempDir()
+	clock := NewManualClock(time.Unix(1_700_000_000, 0))
+
+	inner := openBitcask(t, dir, clock)
+	s := NewCompressedStore(inner, 64)
+	defer s.Close()
+
+	items := strings.Fields(strings.Repeat("reader writer ", 50))
+	if _, err := Apply(s, "l", Op{Name: "RPUSH", Args: items}); err != nil {
+		t.Fatal(err)
+	}
+	if stored, _ := inner.Read("l"); stored.Encoding != EncodingFlate || stored.Type != TypeList {
+		t.Fatalf("expected the list written by RPUSH to be stored compressed, got encoding %d", stored.Encoding)
+	}
+	if stats := s.(Compressor).CompressionStats(); stats.Values != 1 || stats.Compressed != 1 {
+		t.Fatalf("expected the op's value to be counted, got %+v", stats)
+	}
+
+	// Ops read the compressed value back, also within a transaction
+	err := s.Atomic([]string{"l"}, func(tx Tx) error {
+		_, err := Apply(tx, "l", Op{Name: "RPUSH", Args: []string{"admin"}})
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Apply(s, "l", Op{Name: "LRANGE", Args: []string{"-2", "-1"}})
+	if err != nil || !reflect.DeepEqual(got, []string{"writer", "admin"}) {
+		t.Fatalf("expected [writer admin], got %v (%v)", got, err)
+	}
+	if stats := s.(Compressor).CompressionStats(); stats.Values != 2 || stats.Compressed != 2 {
+		t.Fatalf("expected both ops' values to be counted, got %+v", stats)
+	}
+}
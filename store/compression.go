@@ -0,0 +1,78 @@
+package store
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"io"
+	"sync"
+)
+
+/*
+Encoding identifies how an entry's value is encoded.
+
+Its values are persisted by the WAL, snapshots and Bitcask data files,
+so existing ones must never be renumbered.
+*/
+type Encoding uint8
+
+const (
+	EncodingRaw   Encoding = iota // the value as written
+	EncodingFlate                 // the value compressed with DEFLATE
+)
+
+// ErrUnknownEncoding is returned when a value carries an encoding this
+// build cannot decode.
+var ErrUnknownEncoding = errors.New("unknown value encoding")
+
+/*
+flateWriters recycles compressors: each one allocates several hundred
+KiB of tables, too much to create per write.
+*/
+var flateWriters = sync.Pool{
+	New: func() any {
+		w, _ := flate.NewWriter(nil, flate.BestSpeed)
+		return w
+	},
+}
+
+/*
+compress returns value compressed with DEFLATE, or false when that
+would not make it smaller.
+*/
+func compress(value []byte) ([]byte, bool) {
+	var buf bytes.Buffer
+	buf.Grow(len(value) / 2)
+
+	w := flateWriters.Get().(*flate.Writer)
+	defer flateWriters.Put(w)
+
+	w.Reset(&buf)
+	if _, err := w.Write(value); err != nil {
+		return nil, false
+	}
+	if err := w.Close(); err != nil {
+		return nil, false
+	}
+
+	if buf.Len() >= len(value) {
+		return nil, false
+	}
+	return buf.Bytes(), true
+}
+
+/*
+decode returns the plain value of an entry encoded with encoding.
+*/
+func decode(value []byte, encoding Encoding) ([]byte, error) {
+	switch encoding {
+	case EncodingRaw:
+		return value, nil
+	case EncodingFlate:
+		r := flate.NewReader(bytes.NewReader(value))
+		defer r.Close()
+		return io.ReadAll(r)
+	default:
+		return nil, ErrUnknownEncoding
+	}
+}
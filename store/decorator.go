@@ -0,0 +1,17 @@
+package store
+
+/*
+decorator is implemented by stores that wrap another store, such as
+the WAL, compressed and tiered stores. They implement the optional
+interfaces they forward, like Ordered, Scanner or ContextStore, whatever
+they wrap, so a type assertion on them alone cannot tell whether the
+wrapped store supports them: AsOrdered, AsScanner and AsContextStore
+follow unwrap to check.
+*/
+type decorator interface {
+	unwrap() DataStore
+}
+
+func (s *walStore) unwrap() DataStore        { return s.store }
+func (s *compressedStore) unwrap() DataStore { return s.store }
+func (t *tieredStore) unwrap() DataStore     { return t.store }
@@ -1,5 +1,15 @@
 package store
 
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrStoreClosed is returned by event loop stores once Close has been called.
+var ErrStoreClosed = errors.New("store closed")
+
 /*
 operation represents the type of request sent to the event loop.
 Each operation corresponds to one DataStore method.
@@ -10,8 +20,17 @@ const (
 	opRead operation = iota
 	opWrite
 	opExpire
-	opClose
-	opIterate
+	opScan
+	opReadBatch
+	opWriteBatch
+	opAtomic
+	opSweep
+	opPark
+	opReadAt
+	opScanAt
+	opHistory
+	opHistories
+	opRestoreHistory
 )
 
 /*
@@ -27,8 +46,31 @@ type request struct {
 	value     Entry
 	mode      PutMode
 	expiresAt int64
-	
-	iterFn    func(key string, value Entry) bool
+
+	// keys and entries carry the payload of batch operations,
+	// which the loop executes as a single request.
+	keys    []string
+	entries []KeyValue
+
+	// cursor and count describe one step of a scan.
+	cursor uint64
+	count  int
+
+	// at is the read point of view reads (opReadAt, opScanAt).
+	at readPoint
+
+	// history is the payload of opRestoreHistory.
+	history History
+
+	// atomicFn is run on the loop goroutine as a single request.
+	atomicFn func(tx Tx) error
+
+	// release ends an opPark: the loop stays idle until it is closed.
+	release chan struct{}
+
+	// ctx lets a caller abandon a request while it is still queued;
+	// the loop drops requests whose context is already done.
+	ctx context.Context
 
 	// reply is a per-request response channel used to return
 	// results back to the caller synchronously.
@@ -41,11 +83,57 @@ A single response type is used for all operations:
 - Read uses value + ok
 - Write uses err
 - Expire uses ok
+- ReadBatch uses values
+- SweepExpired uses count
+- ScanEntries uses entries + cursor
+- History uses history, and histories uses all
 */
 type response struct {
-	value Entry
-	ok    bool
-	err   error
+	value   Entry
+	values  map[string]Entry
+	entries []KeyValue
+	cursor  uint64
+	count   int
+	ok      bool
+	err     error
+	history History
+	all     []keyHistory
+}
+
+/*
+ContextStore is implemented by stores whose operations can wait in a
+queue. A caller that no longer needs the result (for example because
+its client disconnected) cancels ctx to stop waiting.
+
+A canceled call returns ctx.Err(). A request still queued when ctx
+ends is dropped by the store; one already picked up may complete.
+
+walStore and compressedStore implement it by forwarding to the store
+they wrap, and fall back to the plain calls when it is not a
+ContextStore.
+*/
+type ContextStore interface {
+	ReadContext(ctx context.Context, key string) (Entry, bool, error)
+	WriteContext(ctx context.Context, key string, value Entry, mode PutMode) error
+	ExpireContext(ctx context.Context, key string, unixTimestampMilli int64) (bool, error)
+}
+
+/*
+AsContextStore returns st as a ContextStore if its operations can
+actually be cancelled: decorators implement the interface whatever
+they wrap (see decorator), so the wrapped store must be one too.
+*/
+func AsContextStore(st any) (ContextStore, bool) {
+	cs, ok := st.(ContextStore)
+	if !ok {
+		return nil, false
+	}
+	if d, ok := st.(decorator); ok {
+		if _, ok := AsContextStore(d.unwrap()); !ok {
+			return nil, false
+		}
+	}
+	return cs, true
 }
 
 /*
@@ -57,7 +145,7 @@ all requests sequentially. This eliminates the need for locks
 and guarantees linearizable behavior across all operations.
 */
 type eventLoopStore struct {
-	requests chan request
+	loop *eventLoop
 }
 
 /*
@@ -66,66 +154,316 @@ request channel. The buffer size controls backpressure:
 callers block when the channel is full.
 
 The underlying store is owned exclusively by the event loop
-goroutine and is never accessed directly by callers.
+goroutine and is never accessed directly by callers. The loop also
+runs active expiry between requests, configured with WithSweepInterval.
 */
-func NewEventloopStore(buffer int) DataStore {
-	reqCh := make(chan request, buffer)
-	s := &store{
-		data: make(map[string]Entry),
+func NewEventloopStore(buffer int, opts ...Option) DataStore {
+	o := newOptions(opts)
+
+	return &eventLoopStore{
+		loop: newEventLoop(buffer, o),
 	}
+}
+
+/*
+eventLoop is a goroutine that exclusively owns a store and serves
+requests for it. It backs both eventLoopStore (one loop) and
+multiLoopStore (one loop per partition).
+
+store is touched by other goroutines only while the loop is parked
+(see multiLoopStore.park).
+
+Lifecycle:
+  - done is closed by close: new requests are refused with ErrStoreClosed
+  - the loop then drains requests already queued, closes the store and
+    closes stopped before exiting
+*/
+type eventLoop struct {
+	requests chan request
+	store    *store
+
+	done      chan struct{}
+	stopped   chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+}
 
-	eLS := &eventLoopStore{
-		requests: reqCh,
+/*
+newEventLoop creates a loop over an empty store and starts its goroutine.
+*/
+func newEventLoop(buffer int, o options) *eventLoop {
+	l := &eventLoop{
+		requests: make(chan request, buffer),
+		store:    newStore(o),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
 	}
 
 	// Start the event loop goroutine which owns the store.
-	go eLS.loop(s)
+	go l.run(o.sweepInterval)
 
-	return eLS
+	return l
 }
 
 /*
-loop runs in a dedicated goroutine and serially processes
+maxDrainBatch bounds how many queued requests a loop takes per wake-up.
+A bound keeps one busy loop from starving its sweeper tick.
+*/
+const maxDrainBatch = 64
+
+/*
+replyPool recycles reply channels across calls.
+
+A reply channel is returned to the pool only after its single response
+has been received, so a recycled channel is always empty. Channels of
+abandoned calls are never recycled: a late response may still arrive.
+*/
+var replyPool = sync.Pool{
+	New: func() any {
+		return make(chan response, 1)
+	},
+}
+
+/*
+run runs in a dedicated goroutine and serially processes
 all incoming requests.
 
 This goroutine is the sole owner of the underlying store,
-which guarantees safety without locks.
+which guarantees safety without locks. Between requests it runs one
+bounded sweep step on every tick (see sweepStepSlots), so active
+expiry never delays a queued request for long.
+
+Every wake-up drains up to maxDrainBatch queued requests before
+blocking again, so a loop under load pays one scheduler handoff per
+batch rather than per request.
 */
-func (s *eventLoopStore) loop(store *store) {
-	for req := range s.requests {
-		switch req.op {
+func (l *eventLoop) run(sweepInterval time.Duration) {
+	var tick <-chan time.Time
+	if sweepInterval > 0 {
+		ticker := time.NewTicker(sweepInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
 
-		case opRead:
-			entry, ok := store.Read(req.key)
-			req.reply <- response{
-				value: entry,
-				ok:    ok,
+	batch := make([]request, 0, maxDrainBatch)
+	for {
+		select {
+		case req := <-l.requests:
+			batch = l.drain(append(batch, req))
+			for _, queued := range batch {
+				handleRequest(l.store, queued)
 			}
 
-		case opWrite:
-			err := store.Write(req.key, req.value, req.mode)
-			req.reply <- response{
-				err: err,
-			}
+			// Drop references so finished payloads can be collected
+			clear(batch)
+			batch = batch[:0]
 
-		case opExpire:
-			ok := store.Expire(req.key, req.expiresAt)
-			req.reply <- response{
-				ok: ok,
-			}
+		case <-tick:
+			l.store.sweepExpired(sweepStepSlots, sweepBatchSize)
 
-		case opClose:
-			err := store.Close()
-			req.reply <- response{
-				err: err,
-			}
+		case <-l.done:
+			l.shutdown()
+			return
+		}
+	}
+}
 
-		case opIterate:
-			store.Iterate(req.iterFn)
-			req.reply <- response{
-				ok: true,
-			}
+/*
+drain appends already-queued requests to batch without blocking.
+*/
+func (l *eventLoop) drain(batch []request) []request {
+	for len(batch) < maxDrainBatch {
+		select {
+		case req := <-l.requests:
+			batch = append(batch, req)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+/*
+shutdown completes every request accepted before close, then closes
+the store. Callers racing with close that are not served here observe
+stopped and fail with ErrStoreClosed.
+*/
+func (l *eventLoop) shutdown() {
+	for {
+		select {
+		case req := <-l.requests:
+			handleRequest(l.store, req)
+			continue
+		default:
 		}
+		break
+	}
+
+	l.closeErr = l.store.Close()
+	close(l.stopped)
+}
+
+/*
+close stops the loop and waits for it to exit. It is idempotent; every
+call returns the result of closing the underlying store.
+*/
+func (l *eventLoop) close() error {
+	l.closeOnce.Do(func() {
+		close(l.done)
+	})
+	<-l.stopped
+	return l.closeErr
+}
+
+/*
+call sends req to the loop and waits for its response, using a pooled
+reply channel.
+
+It fails with ErrStoreClosed once the loop is closed, and with
+ctx.Err() if ctx ends first, whether the request is still waiting for
+buffer space or already queued.
+*/
+func (l *eventLoop) call(ctx context.Context, req request) (response, error) {
+	select {
+	case <-l.done:
+		return response{}, ErrStoreClosed
+	default:
+	}
+
+	reply := replyPool.Get().(chan response)
+	req.reply = reply
+	req.ctx = ctx
+
+	select {
+	case l.requests <- req:
+	case <-l.done:
+		replyPool.Put(reply)
+		return response{}, ErrStoreClosed
+	case <-ctx.Done():
+		replyPool.Put(reply)
+		return response{}, ctx.Err()
+	}
+
+	select {
+	case resp := <-reply:
+		replyPool.Put(reply)
+		return resp, nil
+
+	case <-l.stopped:
+		// The loop replies before it stops, so no reply now means the
+		// request arrived after the final drain
+		return lateReply(reply, ErrStoreClosed)
+
+	case <-ctx.Done():
+		return lateReply(reply, ctx.Err())
+	}
+}
+
+/*
+lateReply prefers a response that raced with the caller giving up.
+*/
+func lateReply(reply chan response, err error) (response, error) {
+	select {
+	case resp := <-reply:
+		replyPool.Put(reply)
+		return resp, nil
+	default:
+		return response{}, err
+	}
+}
+
+/*
+handleRequest executes a single request against the store and replies.
+It must only run on the goroutine owning store.
+*/
+func handleRequest(store *store, req request) {
+	if req.ctx != nil && req.ctx.Err() != nil {
+		req.reply <- response{err: req.ctx.Err()}
+		return
+	}
+
+	switch req.op {
+
+	case opRead:
+		entry, ok := store.Read(req.key)
+		req.reply <- response{
+			value: entry,
+			ok:    ok,
+		}
+
+	case opWrite:
+		err := store.Write(req.key, req.value, req.mode)
+		req.reply <- response{
+			err: err,
+		}
+
+	case opExpire:
+		ok := store.Expire(req.key, req.expiresAt)
+		req.reply <- response{
+			ok: ok,
+		}
+
+	case opScan:
+		entries, next := store.ScanEntries(req.cursor, req.count)
+		req.reply <- response{
+			entries: entries,
+			cursor:  next,
+		}
+
+	case opReadBatch:
+		req.reply <- response{
+			values: store.ReadBatch(req.keys),
+		}
+
+	case opWriteBatch:
+		err := store.WriteBatch(req.entries, req.mode)
+		req.reply <- response{
+			err: err,
+		}
+
+	case opAtomic:
+		err := store.Atomic(req.keys, req.atomicFn)
+		req.reply <- response{
+			err: err,
+		}
+
+	case opSweep:
+		req.reply <- response{
+			count: store.SweepExpired(),
+		}
+
+	case opPark:
+		// Hand the store to the caller and stay idle until released
+		req.reply <- response{ok: true}
+		<-req.release
+
+	case opReadAt:
+		entry, ok := store.readAt(req.key, req.at)
+		req.reply <- response{
+			value: entry,
+			ok:    ok,
+		}
+
+	case opScanAt:
+		entries, next := store.scanAt(req.cursor, req.count, req.at)
+		req.reply <- response{
+			entries: entries,
+			cursor:  next,
+		}
+
+	case opHistory:
+		req.reply <- response{
+			history: store.History(req.key),
+		}
+
+	case opHistories:
+		req.reply <- response{
+			all: store.collectHistories(),
+		}
+
+	case opRestoreHistory:
+		store.restoreHistory(req.key, req.history)
+		req.reply <- response{}
 	}
 }
 
@@ -135,19 +473,25 @@ until the operation is completed.
 
 From the caller's perspective, this behaves like a
 synchronous method call, even though the implementation
-is message-based.
+is message-based. A closed store reports every key as absent.
 */
 func (s *eventLoopStore) Read(key string) (Entry, bool) {
-	reply := make(chan response, 1)
+	val, ok, _ := s.ReadContext(context.Background(), key)
+	return val, ok
+}
 
-	s.requests <- request{
-		op:    opRead,
-		key:   key,
-		reply: reply,
+/*
+ReadContext is Read that gives up when ctx ends.
+*/
+func (s *eventLoopStore) ReadContext(ctx context.Context, key string) (Entry, bool, error) {
+	resp, err := s.loop.call(ctx, request{
+		op:  opRead,
+		key: key,
+	})
+	if err == nil {
+		err = resp.err
 	}
-
-	resp := <-reply
-	return resp.value, resp.ok
+	return resp.value, resp.ok, err
 }
 
 /*
@@ -155,17 +499,22 @@ Write sends a write request to the event loop and blocks
 until the operation completes.
 */
 func (s *eventLoopStore) Write(key string, value Entry, mode PutMode) error {
-	reply := make(chan response, 1)
+	return s.WriteContext(context.Background(), key, value, mode)
+}
 
-	s.requests <- request{
+/*
+WriteContext is Write that gives up when ctx ends.
+*/
+func (s *eventLoopStore) WriteContext(ctx context.Context, key string, value Entry, mode PutMode) error {
+	resp, err := s.loop.call(ctx, request{
 		op:    opWrite,
 		key:   key,
 		value: value,
 		mode:  mode,
-		reply: reply,
+	})
+	if err != nil {
+		return err
 	}
-
-	resp := <-reply
 	return resp.err
 }
 
@@ -173,42 +522,212 @@ func (s *eventLoopStore) Write(key string, value Entry, mode PutMode) error {
 Expire sends an expiry request to the event loop and blocks
 until the TTL metadata is updated.
 
-Expired keys are removed lazily during reads, but all expiry
-decisions are serialized through the event loop.
+Expired keys are skipped by reads and removed by the loop's
+sweeper, but all expiry decisions are serialized through the event loop.
 */
 func (s *eventLoopStore) Expire(key string, unixTimestampMilli int64) bool {
-	reply := make(chan response, 1)
+	ok, _ := s.ExpireContext(context.Background(), key, unixTimestampMilli)
+	return ok
+}
 
-	s.requests <- request{
+/*
+ExpireContext is Expire that gives up when ctx ends.
+*/
+func (s *eventLoopStore) ExpireContext(ctx context.Context, key string, unixTimestampMilli int64) (bool, error) {
+	resp, err := s.loop.call(ctx, request{
 		op:        opExpire,
 		key:       key,
 		expiresAt: unixTimestampMilli,
-		reply:     reply,
+	})
+	if err == nil {
+		err = resp.err
 	}
-
-	resp := <-reply
-	return resp.ok
+	return resp.ok, err
 }
 
-func (s *eventLoopStore) Close() error {
-	reply := make(chan response, 1)
+/*
+ReadBatch sends all keys as one request, so the loop observes
+them without interleaving any other operation.
+*/
+func (s *eventLoopStore) ReadBatch(keys []string) map[string]Entry {
+	resp, err := s.loop.call(context.Background(), request{
+		op:   opReadBatch,
+		keys: keys,
+	})
+	if err != nil {
+		return map[string]Entry{}
+	}
+	return resp.values
+}
 
-	s.requests <- request{
-		op: opClose,
-		reply: reply,
+/*
+WriteBatch sends the whole batch as one request. Because the loop
+processes requests one at a time, the batch is applied atomically.
+*/
+func (s *eventLoopStore) WriteBatch(entries []KeyValue, mode PutMode) error {
+	resp, err := s.loop.call(context.Background(), request{
+		op:      opWriteBatch,
+		entries: entries,
+		mode:    mode,
+	})
+	if err != nil {
+		return err
 	}
+	return resp.err
+}
+
+/*
+Atomic runs fn on the loop goroutine. No other request is processed
+until fn returns, so every key is effectively held for its duration.
 
-	resp := <-reply
+fn must not call back into the store: the loop would wait on itself.
+*/
+func (s *eventLoopStore) Atomic(keys []string, fn func(tx Tx) error) error {
+	resp, err := s.loop.call(context.Background(), request{
+		op:       opAtomic,
+		keys:     keys,
+		atomicFn: fn,
+	})
+	if err != nil {
+		return err
+	}
 	return resp.err
 }
 
+/*
+Apply runs every op, queries included, as one Atomic on the loop,
+which keeps typed values decoded.
+*/
+func (s *eventLoopStore) Apply(key string, op Op) (any, error) {
+	return applyAtomic(s, key, op)
+}
+
+/*
+SweepExpired asks the loop to remove every expired entry at once.
+*/
+func (s *eventLoopStore) SweepExpired() int {
+	resp, _ := s.loop.call(context.Background(), request{
+		op: opSweep,
+	})
+	return resp.count
+}
+
+/*
+Close refuses new requests, completes the ones already queued and
+stops the loop goroutine. Later calls fail with ErrStoreClosed;
+closing twice is harmless.
+*/
+func (s *eventLoopStore) Close() error {
+	return s.loop.close()
+}
+
+/*
+ScanEntries returns one step of a scan, taken in a single loop turn.
+Entries are copied out, so the caller processes them on its own
+goroutine while the loop keeps serving other clients.
+*/
+func (s *eventLoopStore) ScanEntries(cursor uint64, count int) ([]KeyValue, uint64) {
+	resp, err := s.loop.call(context.Background(), request{
+		op:     opScan,
+		cursor: cursor,
+		count:  count,
+	})
+	if err != nil {
+		return nil, 0
+	}
+	return resp.entries, resp.cursor
+}
+
+/*
+Iterate walks the store in chunks through ScanEntries.
+
+fn runs on the caller goroutine between loop turns, so it may use the
+store, and a slow consumer (such as a snapshot writer) never blocks
+other clients for more than one chunk. The view is not a single point
+in time; see Scanner for the guarantees.
+*/
 func (s *eventLoopStore) Iterate(fn func(key string, value Entry) bool) {
-	reply := make(chan response, 1)
+	iterateByScan(s, fn)
+}
 
-	s.requests <- request{
-		op:     opIterate,
-		iterFn: fn,
-		reply:  reply,
+/*
+Snapshot returns a view of the store. Its reads are ordinary messages
+to the loop, answered from the versions kept for the view.
+*/
+func (s *eventLoopStore) Snapshot() View {
+	return newView(s, s.loop.store.views, s.loop.store.clock)
+}
+
+func (s *eventLoopStore) readAt(key string, rp readPoint) (Entry, bool) {
+	resp, err := s.loop.call(context.Background(), request{
+		op:  opReadAt,
+		key: key,
+		at:  rp,
+	})
+	if err != nil {
+		return Entry{}, false
+	}
+	return resp.value, resp.ok
+}
+
+func (s *eventLoopStore) scanAt(cursor uint64, count int, rp readPoint) ([]KeyValue, uint64) {
+	resp, err := s.loop.call(context.Background(), request{
+		op:     opScanAt,
+		cursor: cursor,
+		count:  count,
+		at:     rp,
+	})
+	if err != nil {
+		return nil, 0
 	}
-	<-reply
+	return resp.entries, resp.cursor
+}
+
+/*
+History asks the loop for the history of key. Histories are never
+modified in place, so the reply shares them safely.
+*/
+func (s *eventLoopStore) History(key string) History {
+	return s.loop.history(key)
+}
+
+/*
+histories copies every history in one loop turn and calls fn on the
+caller goroutine.
+*/
+func (s *eventLoopStore) histories(fn func(key string, h History) bool) {
+	s.loop.histories(fn)
+}
+
+func (s *eventLoopStore) restoreHistory(key string, h History) {
+	s.loop.restoreHistory(key, h)
+}
+
+func (l *eventLoop) history(key string) History {
+	resp, err := l.call(context.Background(), request{
+		op:  opHistory,
+		key: key,
+	})
+	if err != nil {
+		return nil
+	}
+	return resp.history
+}
+
+func (l *eventLoop) histories(fn func(key string, h History) bool) bool {
+	resp, err := l.call(context.Background(), request{
+		op: opHistories,
+	})
+	if err != nil {
+		return true
+	}
+	return yieldHistories(resp.all, fn)
+}
+
+func (l *eventLoop) restoreHistory(key string, h History) {
+	_, _ = l.call(context.Background(), request{
+		op:      opRestoreHistory,
+		key:     key,
+		history: h,
+	})
 }
@@ -0,0 +1,249 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+var loopStores = map[string]func(buffer int) DataStore{
+	"EventLoop": func(buffer int) DataStore { return NewEventloopStore(buffer) },
+	"MultiLoop": func(buffer int) DataStore { return NewMultiLoopStore(1, buffer) },
+}
+
+/*
+blockLoop occupies the loop of an eventLoopStore until the returned
+function is called, so later requests stay queued.
+*/
+func blockLoop(t *testing.T, s DataStore, key string) (release func()) {
+	t.Helper()
+
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+	go func() {
+		_ = s.Atomic([]string{key}, func(Tx) error {
+			close(started)
+			<-unblock
+			return nil
+		})
+	}()
+	<-started
+
+	return func() { close(unblock) }
+}
+
+func TestEventLoopStores_CallsAfterCloseFail(t *testing.T) {
+	for name, newStore := range loopStores {
+		t.Run(name, func(t *testing.T) {
+			s := newStore(16)
+			_ = s.Write("a", Entry{Value: []byte("1")}, PutOverwrite)
+
+			if err := s.Close(); err != nil {
+				t.Fatalf("close failed: %v", err)
+			}
+			if err := s.Close(); err != nil {
+				t.Fatalf("second close failed: %v", err)
+			}
+
+			if err := s.Write("a", Entry{Value: []byte("2")}, PutOverwrite); !errors.Is(err, ErrStoreClosed) {
+				t.Fatalf("expected ErrStoreClosed, got %v", err)
+			}
+			if _, ok := s.Read("a"); ok {
+				t.Fatalf("closed store must not serve reads")
+			}
+			if _, _, err := s.(ContextStore).ReadContext(context.Background(), "a"); !errors.Is(err, ErrStoreClosed) {
+				t.Fatalf("expected ErrStoreClosed, got %v", err)
+			}
+			if err := s.WriteBatch([]KeyValue{{Key: "b"}}, PutOverwrite); !errors.Is(err, ErrStoreClosed) {
+				t.Fatalf("expected ErrStoreClosed, got %v", err)
+			}
+			if err := s.Atomic([]string{"a"}, func(Tx) error { return nil }); !errors.Is(err, ErrStoreClosed) {
+				t.Fatalf("expected ErrStoreClosed, got %v", err)
+			}
+		})
+	}
+}
+
+func TestEventLoopStores_CloseStopsGoroutines(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	for i := 0; i < 20; i++ {
+		for _, newStore := range loopStores {
+			s := newStore(4)
+			_ = s.Write("a", Entry{Value: []byte("1")}, PutOverwrite)
+			_ = s.Close()
+		}
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("loop goroutines leaked: %d before, %d after", before, runtime.NumGoroutine())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestEventLoopStore_CloseDrainsQueuedRequests(t *testing.T) {
+	s := NewEventloopStore(16).(*eventLoopStore)
+	release := blockLoop(t, s, "block")
+
+	const writers = 8
+	errs := make(chan error, writers)
+	for i := 0; i < writers; i++ {
+		go func() {
+			errs <- s.Write("k", Entry{Value: []byte("v")}, PutOverwrite)
+		}()
+	}
+	for len(s.loop.requests) < writers {
+		time.Sleep(time.Millisecond)
+	}
+
+	closed := make(chan error)
+	go func() { closed <- s.Close() }()
+
+	// Close is refusing new work but must still finish the queue
+	<-s.loop.done
+	release()
+
+	for i := 0; i < writers; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("queued write failed during close: %v", err)
+		}
+	}
+	if err := <-closed; err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+}
+
+func TestEventLoopStore_ContextCancelsBlockedSend(t *testing.T) {
+	// Unbuffered: the write waits for the busy loop to receive it
+	s := NewEventloopStore(0)
+	defer s.Close()
+	release := blockLoop(t, s, "block")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	err := s.(ContextStore).WriteContext(ctx, "k", Entry{Value: []byte("v")}, PutOverwrite)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+
+	release()
+	if _, ok := s.Read("k"); ok {
+		t.Fatalf("abandoned write was applied")
+	}
+}
+
+func TestEventLoopStore_ContextDropsQueuedRequest(t *testing.T) {
+	s := NewEventloopStore(4)
+	defer s.Close()
+	release := blockLoop(t, s, "block")
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	var err error
+	go func() {
+		defer wg.Done()
+		err = s.(ContextStore).WriteContext(ctx, "k", Entry{Value: []byte("v")}, PutOverwrite)
+	}()
+
+	for len(s.(*eventLoopStore).loop.requests) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	wg.Wait()
+
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context canceled, got %v", err)
+	}
+
+	release()
+	if _, ok := s.Read("k"); ok {
+		t.Fatalf("request queued with a canceled context was applied")
+	}
+}
+
+func TestEventLoopStores_IterateCallbackCanUseStore(t *testing.T) {
+	for name, newStore := range loopStores {
+		t.Run(name, func(t *testing.T) {
+			s := newStore(16)
+			defer s.Close()
+
+			for i := 0; i < 1000; i++ {
+				_ = s.Write("k"+strconv.Itoa(i), Entry{Value: []byte("v")}, PutOverwrite)
+			}
+
+			done := make(chan int)
+			go func() {
+				seen := 0
+				s.(Iterable).Iterate(func(key string, _ Entry) bool {
+					// Used to deadlock: the callback ran on the loop itself
+					if _, ok := s.Read(key); !ok {
+						t.Errorf("key %q vanished", key)
+					}
+					seen++
+					return true
+				})
+				done <- seen
+			}()
+
+			select {
+			case seen := <-done:
+				if seen != 1000 {
+					t.Fatalf("expected 1000 keys, saw %d", seen)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("iteration callback deadlocked")
+			}
+		})
+	}
+}
+
+func TestEventLoopStores_ScanReturnsStableKeys(t *testing.T) {
+	for name, newStore := range loopStores {
+		t.Run(name, func(t *testing.T) {
+			s := newStore(16)
+			defer s.Close()
+
+			const stable = 3000
+			for i := 0; i < stable; i++ {
+				_ = s.Write("stable:"+strconv.Itoa(i), Entry{Value: []byte("v")}, PutOverwrite)
+				_ = s.Write("volatile:"+strconv.Itoa(i), Entry{Value: []byte("v")}, PutOverwrite)
+			}
+
+			past := GetUnixTimestamp(time.Now().Add(-time.Millisecond))
+			seen := make(map[string]int)
+
+			var cursor uint64
+			for step := 0; ; step++ {
+				entries, next := s.(Scanner).ScanEntries(cursor, 100)
+				for _, kv := range entries {
+					seen[kv.Key]++
+				}
+
+				// Mutate between steps: drop old keys, add new ones
+				_ = s.Expire("volatile:"+strconv.Itoa(step), past)
+				_ = s.Write("added:"+strconv.Itoa(step), Entry{Value: []byte("v")}, PutOverwrite)
+
+				if next == 0 {
+					break
+				}
+				cursor = next
+			}
+
+			for i := 0; i < stable; i++ {
+				if seen["stable:"+strconv.Itoa(i)] == 0 {
+					t.Fatalf("stable key %d was never returned", i)
+				}
+			}
+		})
+	}
+}
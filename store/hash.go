@@ -0,0 +1,231 @@
+package store
+
+import (
+	"cmp"
+	"slices"
+	"strconv"
+)
+
+/*
+hashValue is the decoded form of a TypeHash value: a map of fields to
+values, stored as the number of fields followed by each field and its
+value, in field order so that equal hashes encode alike.
+*/
+type hashValue struct {
+	fields map[string]string
+}
+
+func decodeHash(data []byte) (typedValue, error) {
+	r := valueReader{data: data}
+	h := &hashValue{}
+	if len(data) == 0 {
+		h.fields = make(map[string]string)
+		return h, nil
+	}
+
+	n := r.count()
+	h.fields = make(map[string]string, n)
+	for i := 0; i < n; i++ {
+		field := r.string()
+		h.fields[field] = r.string()
+	}
+	return h, r.finish()
+}
+
+func (h *hashValue) encode() []byte {
+	size := 1
+	for field, value := range h.fields {
+		size += len(field) + len(value) + 4
+	}
+
+	buf := appendUvarint(make([]byte, 0, size), uint64(len(h.fields)))
+	for _, field := range h.sortedFields() {
+		buf = appendString(buf, field)
+		buf = appendString(buf, h.fields[field])
+	}
+	return buf
+}
+
+func (h *hashValue) empty() bool {
+	return len(h.fields) == 0
+}
+
+func (h *hashValue) sortedFields() []string {
+	fields := make([]string, 0, len(h.fields))
+	for field := range h.fields {
+		fields = append(fields, field)
+	}
+	slices.Sort(fields)
+	return fields
+}
+
+/*
+scan returns up to count fields, with their values, starting at
+cursor, and the cursor to resume from, 0 once every field was
+returned.
+
+Fields are visited in the order of their hashes and the cursor is the
+hash to resume from, so, like SCAN on the keyspace, a full iteration
+returns every field present from its start to its end, however the
+hash changes in between. Fields sharing a hash are returned together.
+*/
+func (h *hashValue) scan(cursor uint64, count int) ([]string, uint64) {
+	type hashedField struct {
+		hash  uint64
+		field string
+	}
+
+	pending := make([]hashedField, 0, len(h.fields))
+	for field := range h.fields {
+		if hf := (hashedField{hashOf(field), field}); hf.hash >= cursor {
+			pending = append(pending, hf)
+		}
+	}
+	slices.SortFunc(pending, func(a, b hashedField) int {
+		if c := cmp.Compare(a.hash, b.hash); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.field, b.field)
+	})
+
+	var pairs []string
+	for i, hf := range pending {
+		if i >= count && hf.hash != pending[i-1].hash {
+			return pairs, hf.hash
+		}
+		pairs = append(pairs, hf.field, h.fields[hf.field])
+	}
+	return pairs, 0
+}
+
+/*
+hashOp adapts an op on a hash to an opSpec.
+*/
+func hashOp(query bool, minArgs, maxArgs int, fn func(h *hashValue, args []string) (any, func(), error)) opSpec {
+	return typedOp(TypeHash, query, minArgs, maxArgs, fn)
+}
+
+/*
+hashOps are the ops on hashes. Each field of HSET and HDEL is logged,
+never the rest of the hash.
+*/
+var hashOps = map[string]opSpec{
+	"HSET": hashOp(false, 2, -1, func(h *hashValue, args []string) (any, func(), error) {
+		if len(args)%2 != 0 {
+			return nil, nil, ErrOpArgs
+		}
+
+		// A field set twice is added once, and the last value wins
+		set := make(map[string]string, len(args)/2)
+		for i := 0; i < len(args); i += 2 {
+			set[args[i]] = args[i+1]
+		}
+
+		added, changed := int64(0), false
+		for field, value := range set {
+			old, ok := h.fields[field]
+			if !ok {
+				added++
+			}
+			changed = changed || !ok || old != value
+		}
+		if !changed {
+			return added, nil, nil
+		}
+		return added, func() {
+			for field, value := range set {
+				h.fields[field] = value
+			}
+		}, nil
+	}),
+
+	"HGET": hashOp(true, 1, 1, queryOp(func(h *hashValue, args []string) (any, error) {
+		if value, ok := h.fields[args[0]]; ok {
+			return value, nil
+		}
+		return nil, nil
+	})),
+
+	"HMGET": hashOp(true, 1, -1, queryOp(func(h *hashValue, args []string) (any, error) {
+		values := make([]any, len(args))
+		for i, field := range args {
+			if value, ok := h.fields[field]; ok {
+				values[i] = value
+			}
+		}
+		return values, nil
+	})),
+
+	"HDEL": hashOp(false, 1, -1, func(h *hashValue, args []string) (any, func(), error) {
+		removed := make(map[string]struct{}, len(args))
+		for _, field := range args {
+			if _, ok := h.fields[field]; ok {
+				removed[field] = struct{}{}
+			}
+		}
+		if len(removed) == 0 {
+			return int64(0), nil, nil
+		}
+		return int64(len(removed)), func() {
+			for field := range removed {
+				delete(h.fields, field)
+			}
+		}, nil
+	}),
+
+	"HGETALL": hashOp(true, 0, 0, queryOp(func(h *hashValue, _ []string) (any, error) {
+		pairs := make([]string, 0, 2*len(h.fields))
+		for _, field := range h.sortedFields() {
+			pairs = append(pairs, field, h.fields[field])
+		}
+		return pairs, nil
+	})),
+
+	"HINCRBY": hashOp(false, 2, 2, func(h *hashValue, args []string) (any, func(), error) {
+		by, err := int64Arg(args[1])
+		if err != nil {
+			return nil, nil, err
+		}
+
+		cur := int64(0)
+		if value, ok := h.fields[args[0]]; ok {
+			if cur, err = int64Arg(value); err != nil {
+				return nil, nil, err
+			}
+		}
+		n, err := addInt64(cur, by)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return n, func() {
+			h.fields[args[0]] = strconv.FormatInt(n, 10)
+		}, nil
+	}),
+
+	"HEXISTS": hashOp(true, 1, 1, queryOp(func(h *hashValue, args []string) (any, error) {
+		if _, ok := h.fields[args[0]]; ok {
+			return int64(1), nil
+		}
+		return int64(0), nil
+	})),
+
+	"HLEN": hashOp(true, 0, 0, queryOp(func(h *hashValue, _ []string) (any, error) {
+		return int64(len(h.fields)), nil
+	})),
+
+	// HSCAN cursor count replies [next cursor, [field, value, ...]]
+	"HSCAN": hashOp(true, 2, 2, queryOp(func(h *hashValue, args []string) (any, error) {
+		cursor, err := strconv.ParseUint(args[0], 10, 64)
+		if err != nil {
+			return nil, ErrNotInteger
+		}
+		count, err := intArg(args[1])
+		if err != nil || count <= 0 {
+			return nil, ErrNotInteger
+		}
+
+		pairs, next := h.scan(cursor, count)
+		return []any{strconv.FormatUint(next, 10), pairs}, nil
+	})),
+}
@@ -0,0 +1,119 @@
+package store
+
+import (
+	"errors"
+	"math"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"hermes/wal"
+)
+
+func TestHash_Increment(t *testing.T) {
+	s := NewLockedStore()
+
+	for _, tc := range []struct {
+		by   string
+		want int64
+	}{{"5", 5}, {"-3", 2}} {
+		if got, err := Apply(s, "h", Op{Name: "HINCRBY", Args: []string{"n", tc.by}}); err != nil || got != tc.want {
+			t.Fatalf("HINCRBY n %s: expected %d, got %v (%v)", tc.by, tc.want, got, err)
+		}
+	}
+
+	_, _ = Apply(s, "h", Op{Name: "HSET", Args: []string{"name", "hermes", "max", strconv.FormatInt(math.MaxInt64, 10)}})
+	if _, err := Apply(s, "h", Op{Name: "HINCRBY", Args: []string{"name", "1"}}); !errors.Is(err, ErrNotInteger) {
+		t.Fatalf("expected ErrNotInteger, got %v", err)
+	}
+	if _, err := Apply(s, "h", Op{Name: "HINCRBY", Args: []string{"max", "1"}}); !errors.Is(err, ErrOverflow) {
+		t.Fatalf("expected ErrOverflow, got %v", err)
+	}
+	if got, _ := Apply(s, "h", Op{Name: "HGET", Args: []string{"max"}}); got != strconv.FormatInt(math.MaxInt64, 10) {
+		t.Fatalf("expected a failed increment to leave the field, got %v", got)
+	}
+}
+
+func TestHash_ScanSurvivesChanges(t *testing.T) {
+	s := NewLockedStore()
+
+	const fields = 100
+	for i := 0; i < fields; i++ {
+		_, _ = Apply(s, "h", Op{Name: "HSET", Args: []string{"f" + strconv.Itoa(i), "v"}})
+	}
+
+	seen := make(map[string]int)
+	cursor := "0"
+	for step := 0; ; step++ {
+		got, err := Apply(s, "h", Op{Name: "HSCAN", Args: []string{cursor, "7"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		reply := got.([]any)
+		pairs := reply[1].([]string)
+		for i := 0; i < len(pairs); i += 2 {
+			seen[pairs[i]]++
+		}
+
+		// Fields come and go between steps; the odd ones stay put
+		_, _ = Apply(s, "h", Op{Name: "HDEL", Args: []string{"f" + strconv.Itoa(2*step)}})
+		_, _ = Apply(s, "h", Op{Name: "HSET", Args: []string{"new" + strconv.Itoa(step), "v"}})
+
+		if cursor = reply[0].(string); cursor == "0" {
+			break
+		}
+	}
+
+	for i := 1; i < fields; i += 2 {
+		if field := "f" + strconv.Itoa(i); seen[field] != 1 {
+			t.Fatalf("expected %s to be returned once, got %d", field, seen[field])
+		}
+	}
+}
+
+func TestWalStore_HashOpsAreLogged(t *testing.T) {
+	dir := t.TempDir()
+	clock := NewManualClock(time.Unix(1_700_000_000, 0))
+
+	s := openCompressedWal(t, dir, clock)
+	_, _ = Apply(s, "user", Op{Name: "HSET", Args: []string{"bio", document, "name", "hermes"}, Time: unixNow(clock)})
+	_, _ = Apply(s, "user", Op{Name: "HINCRBY", Args: []string{"visits", "3"}, Time: unixNow(clock)})
+	_, _ = Apply(s, "user", Op{Name: "HSET", Args: []string{"name", "zeus"}, Time: unixNow(clock)})
+	_, _ = Apply(s, "user", Op{Name: "HDEL", Args: []string{"bio"}, Time: unixNow(clock)})
+	s.(*compressedStore).store.(*walStore).wal.Close()
+
+	// Later updates log their fields alone, never the large bio again
+	raw, err := wal.NewWAL(wal.Config{Path: filepath.Join(dir, "wal.log"), SyncPolicy: wal.SyncEveryWrite})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ops []string
+	_ = raw.Replay(func(r wal.WALRecord) error {
+		ops = append(ops, r.Op)
+		if len(ops) > 1 {
+			for _, arg := range r.Args {
+				if arg == document {
+					t.Fatalf("expected %s to log only its fields", r.Op)
+				}
+			}
+		}
+		return nil
+	})
+	raw.Close()
+	if !slices.Equal(ops, []string{"HSET", "HINCRBY", "HSET", "HDEL"}) {
+		t.Fatalf("expected one op record per mutation, got %v", ops)
+	}
+
+	r := openCompressedWal(t, dir, clock)
+	defer r.Close()
+	got, err := Apply(r, "user", Op{Name: "HGETALL"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"name", "zeus", "visits", "3"}
+	if !slices.Equal(got.([]string), want) {
+		t.Fatalf("expected the hash to recover as %v, got %v", want, got)
+	}
+}
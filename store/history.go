@@ -0,0 +1,252 @@
+package store
+
+import (
+	"slices"
+	"strings"
+	"time"
+)
+
+/*
+History is the recorded sequence of values of a key, oldest first.
+
+Each revision is the Entry as written, with its version and write
+time. A TTL change does not add a revision: it updates the expiry of
+the latest one, so a revision's TTL is the last one the value had.
+*/
+type History []Entry
+
+/*
+At returns the value the key held at unixTimestampMilli: the latest
+revision written at or before it, if not expired at that time.
+*/
+func (h History) At(unixTimestampMilli int64) (Entry, bool) {
+	for i := len(h) - 1; i >= 0; i-- {
+		if h[i].WrittenAtMillis <= unixTimestampMilli {
+			if h[i].expired(unixTimestampMilli) {
+				return Entry{}, false
+			}
+			return h[i], true
+		}
+	}
+	return Entry{}, false
+}
+
+/*
+AtVersion returns the value the key held once the write with the given
+version was committed: the latest revision whose version is not
+greater. Expiry is not considered, since versions carry no time.
+*/
+func (h History) AtVersion(version uint64) (Entry, bool) {
+	for i := len(h) - 1; i >= 0; i-- {
+		if h[i].Version <= version {
+			return h[i], true
+		}
+	}
+	return Entry{}, false
+}
+
+/*
+HistoryPolicy opts the keys starting with Prefix into history
+recording.
+
+Keep bounds the number of revisions kept per key, the current one
+included; MaxAge keeps whatever is needed to answer reads at any time
+within that long ago. A zero bound does not limit; at least one should
+be set. When policies overlap, the longest prefix wins.
+*/
+type HistoryPolicy struct {
+	Prefix string
+	Keep   int
+	MaxAge time.Duration
+}
+
+/*
+Historian is implemented by stores that can return the history of a
+key.
+
+For a key under a history policy, History returns its recorded
+revisions, even after the key itself is gone. For any other key it
+returns the current entry alone, if there is one.
+*/
+type Historian interface {
+	History(key string) History
+}
+
+/*
+historyStore is implemented by the in-memory stores. It lets a
+durable wrapper persist and reload histories.
+
+histories visits every recorded history, each copied as of one
+instant; no lock is held while fn runs. restoreHistory replaces the
+history of key and makes its last revision the current entry.
+*/
+type historyStore interface {
+	histories(fn func(key string, h History) bool)
+	restoreHistory(key string, h History)
+}
+
+/*
+historyPolicies holds the policies of a store, longest prefix first.
+*/
+type historyPolicies []HistoryPolicy
+
+func newHistoryPolicies(policies []HistoryPolicy) historyPolicies {
+	sorted := slices.Clone(policies)
+	slices.SortStableFunc(sorted, func(a, b HistoryPolicy) int {
+		return len(b.Prefix) - len(a.Prefix)
+	})
+	return sorted
+}
+
+/*
+match returns the policy covering key, if any.
+*/
+func (p historyPolicies) match(key string) (HistoryPolicy, bool) {
+	for _, policy := range p {
+		if strings.HasPrefix(key, policy.Prefix) {
+			return policy, true
+		}
+	}
+	return HistoryPolicy{}, false
+}
+
+/*
+trim drops the revisions the policy no longer retains at now.
+
+With MaxAge, a revision is dropped once its successor was written
+before the cutoff: no read within the window can return it. The whole
+history goes once its last revision expired before the cutoff too.
+*/
+func (policy HistoryPolicy) trim(h History, now int64) History {
+	if policy.Keep > 0 && len(h) > policy.Keep {
+		h = h[len(h)-policy.Keep:]
+	}
+
+	if policy.MaxAge > 0 {
+		cutoff := now - policy.MaxAge.Milliseconds()
+		for len(h) > 1 && h[1].WrittenAtMillis <= cutoff {
+			h = h[1:]
+		}
+
+		last := h[len(h)-1]
+		if last.ExpiresAtMillis != 0 && last.ExpiresAtMillis <= cutoff {
+			return nil
+		}
+	}
+	return h
+}
+
+/*
+recordRevision appends value to the history of key if a policy covers
+it. A value already expired when written was never visible and is not
+recorded. The history is copied on write, so copies handed out stay
+intact.
+*/
+func (s *store) recordRevision(key string, value Entry, now int64) {
+	policy, ok := s.policies.match(key)
+	if !ok || value.expired(now) {
+		return
+	}
+
+	h := append(slices.Clip(s.data.history(key)), value.materialized())
+	s.data.setHistory(key, policy.trim(h, now))
+}
+
+/*
+retimeRevision applies a TTL change to the latest revision of key.
+*/
+func (s *store) retimeRevision(key string, unixTimestampMilli int64) {
+	h := s.data.history(key)
+	if len(h) == 0 {
+		return
+	}
+
+	h = slices.Clone(h)
+	h[len(h)-1].ExpiresAtMillis = unixTimestampMilli
+	s.data.setHistory(key, h)
+}
+
+/*
+trimHistories applies the policies of one slot's histories at now.
+*/
+func (s *store) trimHistories(slot int, now int64) {
+	for key, h := range s.data.revisions[slot] {
+		if policy, ok := s.policies.match(key); ok {
+			s.data.setHistory(key, policy.trim(h, now))
+		}
+	}
+}
+
+/*
+History returns the history of key. The returned slice is never
+modified by the store.
+*/
+func (s *store) History(key string) History {
+	if h := s.data.history(key); h != nil {
+		return slices.Clip(h)
+	}
+	if val, ok := s.data.get(key); ok {
+		return History{val.materialized()}
+	}
+	return nil
+}
+
+/*
+keyHistory is a history copied out of a store.
+*/
+type keyHistory struct {
+	key     string
+	history History
+}
+
+/*
+collectHistories copies out every recorded history. Histories are
+never modified in place, so the copy shares their revisions.
+*/
+func (s *store) collectHistories() []keyHistory {
+	var all []keyHistory
+	for _, slot := range s.data.revisions {
+		for key, h := range slot {
+			all = append(all, keyHistory{key: key, history: slices.Clip(h)})
+		}
+	}
+	return all
+}
+
+func (s *store) histories(fn func(key string, h History) bool) {
+	yieldHistories(s.collectHistories(), fn)
+}
+
+func yieldHistories(all []keyHistory, fn func(key string, h History) bool) bool {
+	for _, kh := range all {
+		if !fn(kh.key, kh.history) {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+restoreHistory gives every revision a new version, in order, since
+versions do not survive a restart. Only keys covered by a policy keep
+their history; others just get the last revision as their value.
+*/
+func (s *store) restoreHistory(key string, h History) {
+	if len(h) == 0 {
+		return
+	}
+
+	restored := make(History, len(h))
+	for i, rev := range h {
+		rev.Version = nextVersion()
+		restored[i] = rev
+	}
+
+	last := restored[len(restored)-1]
+	s.putAt(key, last, last.Version)
+
+	if policy, ok := s.policies.match(key); ok {
+		now := s.now()
+		s.data.setHistory(key, policy.trim(restored, now))
+	}
+}
@@ -0,0 +1,224 @@
+package store
+
+import (
+	"hermes/wal"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func historyValues(h History) []string {
+	values := make([]string, len(h))
+	for i, rev := range h {
+		values[i] = string(rev.Value)
+	}
+	return values
+}
+
+func TestHistory_KeepsRevisionsPerPrefix(t *testing.T) {
+	for _, hc := range optionCases {
+		t.Run(hc.name, func(t *testing.T) {
+			s := hc.new(WithHistory(
+				HistoryPolicy{Prefix: "user:", Keep: 3},
+				HistoryPolicy{Prefix: "user:admin:", Keep: 1},
+			))
+			defer s.Close()
+
+			for i := 1; i <= 5; i++ {
+				val := Entry{Value: []byte("v" + strconv.Itoa(i))}
+				_ = s.Write("user:1", val, PutOverwrite)
+				_ = s.Write("user:admin:1", val, PutOverwrite)
+				_ = s.Write("other", val, PutOverwrite)
+			}
+
+			historian := s.(Historian)
+			if got := historyValues(historian.History("user:1")); len(got) != 3 || got[0] != "v3" || got[2] != "v5" {
+				t.Fatalf("expected [v3 v4 v5], got %v", got)
+			}
+			if got := historyValues(historian.History("user:admin:1")); len(got) != 1 || got[0] != "v5" {
+				t.Fatalf("longest prefix must win, got %v", got)
+			}
+			if got := historyValues(historian.History("other")); len(got) != 1 || got[0] != "v5" {
+				t.Fatalf("untracked key must return its current value, got %v", got)
+			}
+			if h := historian.History("missing"); h != nil {
+				t.Fatalf("expected no history for a missing key, got %v", h)
+			}
+
+			h := historian.History("user:1")
+			for i := 1; i < len(h); i++ {
+				if h[i].Version <= h[i-1].Version {
+					t.Fatalf("revision versions must increase: %v", h)
+				}
+			}
+		})
+	}
+}
+
+func TestHistory_OutlivesKey(t *testing.T) {
+	for _, hc := range optionCases {
+		t.Run(hc.name, func(t *testing.T) {
+			s := hc.new(WithHistory(HistoryPolicy{Prefix: "", Keep: 10}))
+			defer s.Close()
+
+			_ = s.Write("a", Entry{Value: []byte("v1")}, PutOverwrite)
+			_ = s.Expire("a", GetUnixTimestamp(time.Now().Add(-time.Second)))
+
+			if _, ok := s.Read("a"); ok {
+				t.Fatalf("expected a to be expired")
+			}
+			h := s.(Historian).History("a")
+			if len(h) != 1 || string(h[0].Value) != "v1" || h[0].ExpiresAtMillis == 0 {
+				t.Fatalf("expected the expired revision to remain, got %+v", h)
+			}
+		})
+	}
+}
+
+func TestHistory_At(t *testing.T) {
+	s := NewLockedStore(WithSweepInterval(0), WithHistory(HistoryPolicy{Keep: 10}))
+	defer s.Close()
+
+	_ = s.Write("a", Entry{Value: []byte("v1"), WrittenAtMillis: 1000}, PutOverwrite)
+	_ = s.Write("a", Entry{Value: []byte("v2"), WrittenAtMillis: 2000}, PutOverwrite)
+	_ = s.Expire("a", 3000)
+
+	h := s.(Historian).History("a")
+	if len(h) != 2 {
+		t.Fatalf("an expire must not add a revision, got %d", len(h))
+	}
+
+	tests := []struct {
+		at   int64
+		want string
+	}{
+		{at: 999, want: ""},
+		{at: 1000, want: "v1"},
+		{at: 1999, want: "v1"},
+		{at: 2000, want: "v2"},
+		{at: 2999, want: "v2"},
+		{at: 3000, want: ""},
+	}
+	for _, tt := range tests {
+		got, ok := h.At(tt.at)
+		if tt.want == "" {
+			if ok {
+				t.Fatalf("At(%d): expected nothing, got %q", tt.at, got.Value)
+			}
+			continue
+		}
+		if !ok || string(got.Value) != tt.want {
+			t.Fatalf("At(%d): expected %q, got %q", tt.at, tt.want, got.Value)
+		}
+	}
+
+	if got, ok := h.AtVersion(h[0].Version); !ok || string(got.Value) != "v1" {
+		t.Fatalf("AtVersion: expected v1, got %q %v", got.Value, ok)
+	}
+	if _, ok := h.AtVersion(h[0].Version - 1); ok {
+		t.Fatalf("AtVersion before the first revision must find nothing")
+	}
+}
+
+func TestHistory_MaxAge(t *testing.T) {
+	s := NewLockedStore(WithSweepInterval(0), WithHistory(HistoryPolicy{MaxAge: time.Hour}))
+	defer s.Close()
+
+	now := GetUnixTimestamp(time.Now())
+	old := now - 3*time.Hour.Milliseconds()
+
+	_ = s.Write("a", Entry{Value: []byte("v1"), WrittenAtMillis: old}, PutOverwrite)
+	_ = s.Write("a", Entry{Value: []byte("v2"), WrittenAtMillis: old + 1}, PutOverwrite)
+
+	// Still needed: v2 is the value at any time within the last hour
+	if got := historyValues(s.(Historian).History("a")); len(got) != 1 || got[0] != "v2" {
+		t.Fatalf("expected [v2], got %v", got)
+	}
+
+	_ = s.Write("a", Entry{Value: []byte("v3"), WrittenAtMillis: now}, PutOverwrite)
+	if got := historyValues(s.(Historian).History("a")); len(got) != 2 {
+		t.Fatalf("expected [v2 v3], got %v", got)
+	}
+}
+
+func TestHistory_SurvivesReshard(t *testing.T) {
+	s := NewShardedStore(2, WithHistory(HistoryPolicy{Keep: 5})).(*shardedStore)
+	defer s.Close()
+
+	for i := 0; i < 100; i++ {
+		key := "key:" + strconv.Itoa(i)
+		_ = s.Write(key, Entry{Value: []byte("v1")}, PutOverwrite)
+		_ = s.Write(key, Entry{Value: []byte("v2")}, PutOverwrite)
+	}
+	_ = s.Expire("key:0", GetUnixTimestamp(time.Now().Add(-time.Second)))
+
+	if err := s.Reshard(7); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 100; i++ {
+		key := "key:" + strconv.Itoa(i)
+		if got := historyValues(s.History(key)); len(got) != 2 || got[1] != "v2" {
+			t.Fatalf("%s: expected [v1 v2] after reshard, got %v", key, got)
+		}
+	}
+}
+
+func TestWalStore_HistoryRecovery(t *testing.T) {
+	newStore := func() DataStore {
+		return NewLockedStore(WithHistory(HistoryPolicy{Keep: 10}))
+	}
+
+	for _, compact := range []bool{false, true} {
+		t.Run("Compact="+strconv.FormatBool(compact), func(t *testing.T) {
+			factory := setupFactory(t, newStore)
+			ds, walPath, snapPath, closeFn, cleanup := factory()
+			defer cleanup()
+
+			_ = ds.Write("a", Entry{Value: []byte("v1"), WrittenAtMillis: 1000}, PutOverwrite)
+			_ = ds.WriteBatch([]KeyValue{
+				{Key: "a", Entry: Entry{Value: []byte("v2")}},
+				{Key: "b", Entry: Entry{Value: []byte("v1")}},
+			}, PutOverwrite)
+			_ = ds.Write("dead", Entry{Value: []byte("v1")}, PutOverwrite)
+			_ = ds.Expire("dead", GetUnixTimestamp(time.Now().Add(-time.Second)))
+			want := ds.(Historian).History("a")
+
+			if compact {
+				closeFn()
+			} else {
+				defer closeFn()
+			}
+
+			w, err := wal.NewWAL(wal.Config{Path: walPath, SyncPolicy: wal.SyncEveryWrite})
+			if err != nil {
+				t.Fatal(err)
+			}
+			recovered, err := NewWalStore(newStore(), w, snapPath, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer recovered.Close()
+
+			got := recovered.(Historian).History("a")
+			if len(got) != 2 {
+				t.Fatalf("expected 2 revisions of a, got %+v", got)
+			}
+			for i := range got {
+				if string(got[i].Value) != string(want[i].Value) || got[i].WrittenAtMillis != want[i].WrittenAtMillis {
+					t.Fatalf("revision %d: expected %+v, got %+v", i, want[i], got[i])
+				}
+			}
+			if got[0].WrittenAtMillis != 1000 {
+				t.Fatalf("expected the original write time, got %d", got[0].WrittenAtMillis)
+			}
+
+			if h := recovered.(Historian).History("dead"); len(h) != 1 || string(h[0].Value) != "v1" {
+				t.Fatalf("expected the history of an expired key to survive, got %+v", h)
+			}
+			if _, ok := recovered.Read("dead"); ok {
+				t.Fatalf("expired key must stay expired")
+			}
+		})
+	}
+}
@@ -0,0 +1,557 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+/*
+jsonValue is the decoded form of a TypeJSON value: a document parsed
+by encoding/json, numbers kept as json.Number so that integers and
+their digits survive a round trip. ok is false for a missing document,
+unlike a document that is JSON null.
+
+It is stored as its JSON text, with object members in key order.
+*/
+type jsonValue struct {
+	doc any
+	ok  bool
+}
+
+func decodeJSON(data []byte) (typedValue, error) {
+	if len(data) == 0 {
+		return &jsonValue{}, nil
+	}
+	doc, err := parseJSON(string(data))
+	if err != nil {
+		return nil, errCorruptValue
+	}
+	return &jsonValue{doc: doc, ok: true}, nil
+}
+
+func (v *jsonValue) encode() []byte {
+	return []byte(marshalJSON(v.doc))
+}
+
+func (v *jsonValue) empty() bool {
+	return !v.ok
+}
+
+/*
+parseJSON parses one JSON value, such as an op argument.
+*/
+func parseJSON(text string) (any, error) {
+	dec := json.NewDecoder(strings.NewReader(text))
+	dec.UseNumber()
+
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return nil, ErrInvalidJSON
+	}
+	if _, err := dec.Token(); err == nil {
+		return nil, ErrInvalidJSON // trailing data
+	}
+	return doc, nil
+}
+
+/*
+marshalJSON returns the JSON text of a parsed value, without escaping
+HTML characters.
+*/
+func marshalJSON(v any) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(v) // parsed values always encode
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+/*
+jsonStep is one step of a path: a member name, an array index,
+negative ones counting from the end, or a wildcard matching every
+member or element.
+*/
+type jsonStep struct {
+	name     string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+/*
+parseJSONPath parses the JSONPath subset used by the JSON ops: a root,
+"$" (or "." alone), followed by steps of the forms .name, ["name"] or
+['name'], [index], and .* or [*]. A path starting with "." instead of
+"$." is read as if it did.
+*/
+func parseJSONPath(path string) ([]jsonStep, error) {
+	switch {
+	case path == "." || path == "$":
+		return nil, nil
+	case strings.HasPrefix(path, "$"):
+		path = path[1:]
+	case !strings.HasPrefix(path, "."):
+		return nil, ErrInvalidPath
+	}
+
+	var steps []jsonStep
+	for path != "" {
+		switch path[0] {
+		case '.':
+			path = path[1:]
+			end := strings.IndexAny(path, ".[")
+			if end < 0 {
+				end = len(path)
+			}
+			name := path[:end]
+			if name == "" {
+				return nil, ErrInvalidPath
+			}
+			steps = append(steps, jsonStep{name: name, wildcard: name == "*"})
+			path = path[end:]
+
+		case '[':
+			end := strings.IndexByte(path, ']')
+			if end < 0 {
+				return nil, ErrInvalidPath
+			}
+			step, err := parseBracket(path[1:end])
+			if err != nil {
+				return nil, err
+			}
+			steps = append(steps, step)
+			path = path[end+1:]
+
+		default:
+			return nil, ErrInvalidPath
+		}
+	}
+	return steps, nil
+}
+
+/*
+parseBracket parses the inside of a [...] step.
+*/
+func parseBracket(inner string) (jsonStep, error) {
+	if inner == "*" {
+		return jsonStep{wildcard: true}, nil
+	}
+	if len(inner) >= 2 && (inner[0] == '"' || inner[0] == '\'') && inner[len(inner)-1] == inner[0] {
+		return jsonStep{name: inner[1 : len(inner)-1]}, nil
+	}
+	index, err := strconv.Atoi(inner)
+	if err != nil {
+		return jsonStep{}, ErrInvalidPath
+	}
+	return jsonStep{index: index, isIndex: true}, nil
+}
+
+/*
+matchPath returns the values that steps match in node, object members
+in key order.
+*/
+func matchPath(node any, steps []jsonStep) []any {
+	if len(steps) == 0 {
+		return []any{node}
+	}
+
+	var out []any
+	step, rest := steps[0], steps[1:]
+	switch n := node.(type) {
+	case map[string]any:
+		if !step.wildcard {
+			if child, ok := n[step.name]; ok && !step.isIndex {
+				out = append(out, matchPath(child, rest)...)
+			}
+			return out
+		}
+		for _, name := range sortedMembers(n) {
+			out = append(out, matchPath(n[name], rest)...)
+		}
+
+	case []any:
+		if !step.wildcard {
+			if i, ok := arrayIndex(n, step); ok {
+				out = append(out, matchPath(n[i], rest)...)
+			}
+			return out
+		}
+		for _, child := range n {
+			out = append(out, matchPath(child, rest)...)
+		}
+	}
+	return out
+}
+
+/*
+jsonUpdate is called on each value a path matches, found reporting
+whether it exists: only the member named by the last step may not,
+when the path creates it. It returns the value to put in its place,
+and false to remove it instead.
+*/
+type jsonUpdate func(cur any, found bool) (next any, keep bool)
+
+/*
+updatePath runs fn on every value that steps match below node, and
+returns node updated. If create is set, a last step naming a missing
+member of an object calls fn to add it.
+*/
+func updatePath(node any, steps []jsonStep, create bool, fn jsonUpdate) any {
+	step, rest := steps[0], steps[1:]
+	last := len(rest) == 0
+
+	switch n := node.(type) {
+	case map[string]any:
+		names := []string{step.name}
+		if step.wildcard {
+			names = sortedMembers(n)
+		} else if step.isIndex {
+			return n
+		}
+		for _, name := range names {
+			child, ok := n[name]
+			switch {
+			case !last && ok:
+				n[name] = updatePath(child, rest, create, fn)
+			case last && (ok || create):
+				if next, keep := fn(child, ok); keep {
+					n[name] = next
+				} else {
+					delete(n, name)
+				}
+			}
+		}
+		return n
+
+	case []any:
+		indexes := make([]int, 0, len(n))
+		if step.wildcard {
+			for i := range n {
+				indexes = append(indexes, i)
+			}
+		} else if i, ok := arrayIndex(n, step); ok {
+			indexes = append(indexes, i)
+		}
+
+		removed := make(map[int]bool)
+		for _, i := range indexes {
+			if !last {
+				n[i] = updatePath(n[i], rest, create, fn)
+				continue
+			}
+			if next, keep := fn(n[i], true); keep {
+				n[i] = next
+			} else {
+				removed[i] = true
+			}
+		}
+		if len(removed) == 0 {
+			return n
+		}
+		kept := make([]any, 0, len(n)-len(removed))
+		for i, child := range n {
+			if !removed[i] {
+				kept = append(kept, child)
+			}
+		}
+		return kept
+	}
+	return node
+}
+
+/*
+arrayIndex resolves an index step into arr.
+*/
+func arrayIndex(arr []any, step jsonStep) (int, bool) {
+	if !step.isIndex {
+		return 0, false
+	}
+	i := step.index
+	if i < 0 {
+		i += len(arr)
+	}
+	return i, i >= 0 && i < len(arr)
+}
+
+func sortedMembers(m map[string]any) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+/*
+update runs fn on every value path matches in the document, the root
+included, and reports whether fn ran at all.
+*/
+func (v *jsonValue) update(steps []jsonStep, create bool, fn jsonUpdate) bool {
+	ran := false
+	counted := func(cur any, found bool) (any, bool) {
+		ran = true
+		return fn(cur, found)
+	}
+
+	if len(steps) == 0 {
+		if v.ok || create {
+			v.doc, v.ok = counted(v.doc, v.ok)
+		}
+		return ran
+	}
+	if v.ok {
+		v.doc = updatePath(v.doc, steps, create, counted)
+	}
+	return ran
+}
+
+/*
+plan runs fn on every value path matches, like update, but leaves the
+document as it is: it returns the change that puts the values fn
+returned in their place. fn runs once, when planning.
+*/
+func (v *jsonValue) plan(steps []jsonStep, create bool, fn jsonUpdate) func() {
+	type update struct {
+		next any
+		keep bool
+	}
+	var updates []update
+	v.update(steps, create, func(cur any, found bool) (any, bool) {
+		next, keep := fn(cur, found)
+		updates = append(updates, update{next, keep})
+		return cur, found
+	})
+
+	// The document is unchanged until then, so the path matches the
+	// same values in the same order
+	return func() {
+		i := 0
+		v.update(steps, create, func(any, bool) (any, bool) {
+			u := updates[i]
+			i++
+			return u.next, u.keep
+		})
+	}
+}
+
+/*
+addJSONNumbers adds two JSON numbers, as integers if both are, else as
+floats.
+*/
+func addJSONNumbers(a, b json.Number) (json.Number, error) {
+	x, errX := a.Int64()
+	y, errY := b.Int64()
+	if errX == nil && errY == nil {
+		sum, err := addInt64(x, y)
+		if err != nil {
+			return "", err
+		}
+		return json.Number(strconv.FormatInt(sum, 10)), nil
+	}
+
+	f, errF := a.Float64()
+	g, errG := b.Float64()
+	if errF != nil || errG != nil {
+		return "", ErrNotFloat
+	}
+	sum := f + g
+	if math.IsInf(sum, 0) || math.IsNaN(sum) {
+		return "", ErrOverflow
+	}
+	return json.Number(strconv.FormatFloat(sum, 'g', -1, 64)), nil
+}
+
+/*
+jsonOp adapts an op on a JSON document to an opSpec.
+*/
+func jsonOp(query bool, minArgs, maxArgs int, fn func(v *jsonValue, args []string) (any, func(), error)) opSpec {
+	return typedOp(TypeJSON, query, minArgs, maxArgs, fn)
+}
+
+/*
+jsonOps are the ops on JSON documents. Paths may match several values,
+so ops reply per match; each mutation is logged as the op, with its
+path and the values it writes, not as the whole document.
+*/
+var jsonOps = map[string]opSpec{
+	// JSON.SET path value [NX|XX] sets every value path matches, adding
+	// a missing object member named by its last step, and replies nil
+	// if nothing was set; NX only adds, XX only replaces. A new
+	// document must be set at the root.
+	"JSON.SET": jsonOp(false, 2, 3, func(v *jsonValue, args []string) (any, func(), error) {
+		steps, err := parseJSONPath(args[0])
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, err := parseJSON(args[1]); err != nil {
+			return nil, nil, err
+		}
+		var nx, xx bool
+		if len(args) == 3 {
+			nx, xx = strings.EqualFold(args[2], "NX"), strings.EqualFold(args[2], "XX")
+			if !nx && !xx {
+				return nil, nil, ErrSyntax
+			}
+		}
+		if !v.ok && len(steps) > 0 {
+			return nil, nil, ErrJSONNewRoot
+		}
+
+		set := int64(0)
+		change := v.plan(steps, !xx, func(cur any, found bool) (any, bool) {
+			if (nx && found) || (xx && !found) {
+				return cur, found
+			}
+			set++
+			// Each match gets its own copy, so that later updates of
+			// one leave the others alone
+			value, _ := parseJSON(args[1])
+			return value, true
+		})
+		if set == 0 {
+			return nil, nil, nil
+		}
+		return set, change, nil
+	}),
+
+	// JSON.GET [path ...] replies with the document's JSON text, or
+	// for one path the JSON array of its matches, or for several an
+	// object mapping each path to its matches
+	"JSON.GET": jsonOp(true, 0, -1, queryOp(func(v *jsonValue, args []string) (any, error) {
+		if !v.ok {
+			return nil, nil
+		}
+		if len(args) == 0 {
+			return marshalJSON(v.doc), nil
+		}
+
+		byPath := make(map[string]any, len(args))
+		for _, path := range args {
+			steps, err := parseJSONPath(path)
+			if err != nil {
+				return nil, err
+			}
+			matches := matchPath(v.doc, steps)
+			if matches == nil {
+				matches = []any{}
+			}
+			byPath[path] = matches
+		}
+		if len(args) == 1 {
+			return marshalJSON(byPath[args[0]]), nil
+		}
+		return marshalJSON(byPath), nil
+	})),
+
+	// JSON.DEL [path] removes every value path matches, the whole
+	// document by default, and replies with how many it removed
+	"JSON.DEL": jsonOp(false, 0, 1, func(v *jsonValue, args []string) (any, func(), error) {
+		path := "$"
+		if len(args) == 1 {
+			path = args[0]
+		}
+		steps, err := parseJSONPath(path)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		removed := int64(0)
+		change := v.plan(steps, false, func(any, bool) (any, bool) {
+			removed++
+			return nil, false
+		})
+		if removed == 0 {
+			return removed, nil, nil
+		}
+		return removed, change, nil
+	}),
+
+	// JSON.NUMINCRBY path increment adds increment to every number
+	// path matches, and replies with the JSON array of the new values,
+	// null for matches that are not numbers
+	"JSON.NUMINCRBY": jsonOp(false, 2, 2, func(v *jsonValue, args []string) (any, func(), error) {
+		steps, err := parseJSONPath(args[0])
+		if err != nil {
+			return nil, nil, err
+		}
+		incr, ok := jsonNumber(args[1])
+		if !ok {
+			return nil, nil, ErrNotFloat
+		}
+
+		results := []any{}
+		changed := false
+		var failed error
+		change := v.plan(steps, false, func(cur any, found bool) (any, bool) {
+			n, isNumber := cur.(json.Number)
+			if !isNumber || failed != nil {
+				results = append(results, nil)
+				return cur, found
+			}
+			sum, err := addJSONNumbers(n, incr)
+			if err != nil {
+				failed = err
+				return cur, found
+			}
+			results = append(results, sum)
+			changed = changed || sum != n
+			return sum, true
+		})
+		if failed != nil {
+			return nil, nil, failed
+		}
+		if !changed {
+			return marshalJSON(results), nil, nil
+		}
+		return marshalJSON(results), change, nil
+	}),
+
+	// JSON.ARRAPPEND path value [value ...] appends the values to every
+	// array path matches, and replies with the new length of each, nil
+	// for matches that are not arrays
+	"JSON.ARRAPPEND": jsonOp(false, 2, -1, func(v *jsonValue, args []string) (any, func(), error) {
+		steps, err := parseJSONPath(args[0])
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, arg := range args[1:] {
+			if _, err := parseJSON(arg); err != nil {
+				return nil, nil, err
+			}
+		}
+
+		lengths := []any{}
+		appended := false
+		change := v.plan(steps, false, func(cur any, found bool) (any, bool) {
+			arr, ok := cur.([]any)
+			if !ok {
+				lengths = append(lengths, nil)
+				return cur, found
+			}
+			for _, arg := range args[1:] {
+				value, _ := parseJSON(arg)
+				arr = append(arr, value)
+			}
+			lengths = append(lengths, int64(len(arr)))
+			appended = true
+			return arr, true
+		})
+		if !appended {
+			return lengths, nil, nil
+		}
+		return lengths, change, nil
+	}),
+}
+
+/*
+jsonNumber parses a JSON number argument.
+*/
+func jsonNumber(arg string) (json.Number, bool) {
+	v, err := parseJSON(arg)
+	n, ok := v.(json.Number)
+	return n, err == nil && ok
+}
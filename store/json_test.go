@@ -0,0 +1,145 @@
+package store
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"hermes/wal"
+)
+
+func TestJSON_ParsePath(t *testing.T) {
+	for path, want := range map[string][]jsonStep{
+		"$":              nil,
+		".":              nil,
+		"$.a.b":          {{name: "a"}, {name: "b"}},
+		".a":             {{name: "a"}},
+		`$["x y"]['z']`:  {{name: "x y"}, {name: "z"}},
+		"$.items[-1].id": {{name: "items"}, {index: -1, isIndex: true}, {name: "id"}},
+		"$.*[*]":         {{name: "*", wildcard: true}, {wildcard: true}},
+	} {
+		got, err := parseJSONPath(path)
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s: expected %+v, got %+v (%v)", path, want, got, err)
+		}
+	}
+
+	for _, path := range []string{"", "a", "$a", "$.", "$[", "$[x]", "$.a..b"} {
+		if _, err := parseJSONPath(path); !errors.Is(err, ErrInvalidPath) {
+			t.Fatalf("%q: expected ErrInvalidPath, got %v", path, err)
+		}
+	}
+}
+
+func TestJSON_Ops(t *testing.T) {
+	s := NewLockedStore()
+	run := func(name string, args ...string) any {
+		t.Helper()
+		got, err := Apply(s, "doc", Op{Name: name, Args: args})
+		if err != nil {
+			t.Fatalf("%s %v failed: %v", name, args, err)
+		}
+		return got
+	}
+
+	if _, err := Apply(s, "doc", Op{Name: "JSON.SET", Args: []string{"$.a", "1"}}); !errors.Is(err, ErrJSONNewRoot) {
+		t.Fatalf("expected ErrJSONNewRoot, got %v", err)
+	}
+	run("JSON.SET", "$", `{"name":"hermes","tags":["a"],"items":[{"n":1},{"n":2.5},{"n":"x"}]}`)
+
+	for _, step := range []struct {
+		name string
+		args []string
+		want any
+	}{
+		{"JSON.SET", []string{"$.name", `"zeus"`, "NX"}, nil},
+		{"JSON.SET", []string{"$.age", "3", "XX"}, nil},
+		{"JSON.SET", []string{"$.missing.deep", "1"}, nil},
+		{"JSON.SET", []string{"$.age", "3", "NX"}, int64(1)},
+		{"JSON.SET", []string{"$.items[*].seen", "true"}, int64(3)},
+		{"JSON.NUMINCRBY", []string{"$.items[*].n", "2"}, "[3,4.5,null]"},
+		{"JSON.NUMINCRBY", []string{"$.age", "-1"}, "[2]"},
+		{"JSON.ARRAPPEND", []string{"$.tags", `"b"`, `{"c":[1]}`}, []any{int64(3)}},
+		{"JSON.ARRAPPEND", []string{"$.*", "0"}, []any{nil, int64(4), nil, int64(4)}},
+		{"JSON.DEL", []string{"$.items[0]"}, int64(1)},
+		{"JSON.DEL", []string{"$.items[*].seen"}, int64(2)},
+		{"JSON.DEL", []string{"$.nope"}, int64(0)},
+		{"JSON.GET", []string{"$.tags[-1]"}, "[0]"},
+		{"JSON.GET", []string{"$.age", "$.items[*].n"}, `{"$.age":[2],"$.items[*].n":[4.5,"x"]}`},
+		{"JSON.GET", nil, `{"age":2,"items":[{"n":4.5},{"n":"x"},0],"name":"hermes","tags":["a","b",{"c":[1]},0]}`},
+	} {
+		if got := run(step.name, step.args...); !reflect.DeepEqual(got, step.want) {
+			t.Fatalf("%s %v: expected %#v, got %#v", step.name, step.args, step.want, got)
+		}
+	}
+
+	if _, err := Apply(s, "doc", Op{Name: "JSON.NUMINCRBY", Args: []string{"$.age", "9223372036854775807"}}); !errors.Is(err, ErrOverflow) {
+		t.Fatalf("expected ErrOverflow, got %v", err)
+	}
+	if _, err := Apply(s, "doc", Op{Name: "JSON.SET", Args: []string{"$.a", "{oops"}}); !errors.Is(err, ErrInvalidJSON) {
+		t.Fatalf("expected ErrInvalidJSON, got %v", err)
+	}
+
+	// Deleting the root removes the key
+	if n := run("JSON.DEL"); n != int64(1) {
+		t.Fatalf("expected the root to be removed, got %v", n)
+	}
+	if _, ok := s.Read("doc"); ok {
+		t.Fatalf("expected an emptied document to remove its key")
+	}
+	if got := run("JSON.GET"); got != nil {
+		t.Fatalf("expected a missing document to read as nil, got %v", got)
+	}
+}
+
+func TestWalStore_JSONOpsAreLogged(t *testing.T) {
+	dir := t.TempDir()
+	clock := NewManualClock(time.Unix(1_700_000_000, 0))
+	large := `{"bio":"` + strings.Repeat("x", 4096) + `","visits":0,"log":[]}`
+
+	s := openCompressedWal(t, dir, clock)
+	for _, op := range []Op{
+		{Name: "JSON.SET", Args: []string{"$", large}},
+		{Name: "JSON.NUMINCRBY", Args: []string{"$.visits", "1"}},
+		{Name: "JSON.ARRAPPEND", Args: []string{"$.log", `"login"`}},
+		{Name: "JSON.SET", Args: []string{"$.name", `"hermes"`}},
+		{Name: "JSON.DEL", Args: []string{"$.bio"}},
+	} {
+		op.Time = unixNow(clock)
+		if _, err := Apply(s, "user", op); err != nil {
+			t.Fatalf("%s failed: %v", op.Name, err)
+		}
+	}
+	s.(*compressedStore).store.(*walStore).wal.Close()
+
+	// Only the first record carries the document, later ones a path
+	raw, err := wal.NewWAL(wal.Config{Path: filepath.Join(dir, "wal.log"), SyncPolicy: wal.SyncEveryWrite})
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := 0
+	_ = raw.Replay(func(r wal.WALRecord) error {
+		records++
+		if size := len(strings.Join(r.Args, "")); records > 1 && size > 64 {
+			t.Fatalf("expected %s to log its path alone, got %d bytes", r.Op, size)
+		}
+		return nil
+	})
+	raw.Close()
+	if records != 5 {
+		t.Fatalf("expected one record per mutation, got %d", records)
+	}
+
+	r := openCompressedWal(t, dir, clock)
+	defer r.Close()
+	got, err := Apply(r, "user", Op{Name: "JSON.GET"})
+	if want := `{"log":["login"],"name":"hermes","visits":1}`; err != nil || got != want {
+		t.Fatalf("expected the document to recover as %s, got %v (%v)", want, got, err)
+	}
+	if val, _ := r.Read("user"); val.Type != TypeJSON {
+		t.Fatalf("expected the key to keep its type, got %v", val.Type)
+	}
+}
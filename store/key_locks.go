@@ -0,0 +1,52 @@
+package store
+
+import (
+	"slices"
+	"sync"
+)
+
+// keyLockCount is the number of mutexes keys are spread over.
+const keyLockCount = 256
+
+/*
+keyLocks serializes the writers of each key without the wrapped
+store's locks: every key maps to one of a fixed set of mutexes, and
+only writers whose keys share a mutex wait for each other. Readers
+never take them.
+
+walStore holds a key's mutex from the check of a write to its apply,
+append included, so a key's records reach the log in the order its
+writes are applied, while the wrapped store is only held to apply.
+*/
+type keyLocks struct {
+	mu [keyLockCount]sync.Mutex
+}
+
+/*
+lock locks the mutexes of keys in index order, so that writers of
+overlapping keys cannot deadlock, and returns the function unlocking
+them.
+*/
+func (l *keyLocks) lock(keys ...string) func() {
+	if len(keys) == 1 {
+		m := &l.mu[hashOf(keys[0])%keyLockCount]
+		m.Lock()
+		return m.Unlock
+	}
+
+	held := make([]uint64, len(keys))
+	for i, key := range keys {
+		held[i] = hashOf(key) % keyLockCount
+	}
+	slices.Sort(held)
+	held = slices.Compact(held)
+
+	for _, i := range held {
+		l.mu[i].Lock()
+	}
+	return func() {
+		for _, i := range held {
+			l.mu[i].Unlock()
+		}
+	}
+}
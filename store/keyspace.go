@@ -0,0 +1,324 @@
+package store
+
+import "hash/maphash"
+
+/*
+scanSlots is the number of hash slots a keyspace is split into.
+It must be a power of two.
+*/
+const scanSlots = 1024
+
+// slotSeed is fixed for the process so a key's slot never changes.
+var slotSeed = maphash.MakeSeed()
+
+/*
+keyspace holds a store's entries, spread over fixed hash slots.
+
+A key always lives in the same slot, so a slot index is a stable
+position: walking slots in order visits every key that stays in the
+keyspace exactly once, no matter what is inserted or deleted between
+steps. This is what lets scans resume from a plain cursor without any
+per-scan state, like Redis SCAN.
+
+Slot maps are allocated on first write, so small stores stay cheap.
+
+An ordered keyspace also maintains index, a skip list of its keys, for
+range and prefix traversals. It is nil for hash-only stores.
+
+superseded holds, by slot as well, the old versions of keys that
+open views may still read (see View). It stays empty while no view is
+open.
+
+revisions holds the recorded history of keys under a history policy
+(see HistoryPolicy), also by slot. A key's history may outlive it.
+
+slab, when set, holds the current entries in place of slots, packed
+into pointer-free slabs (see slabTable). Slots are then left unused.
+
+expiring counts, by slot, the entries that have a TTL, so the sweeper
+skips the slots that cannot hold an expired entry without visiting
+them.
+*/
+type keyspace struct {
+	slots      [scanSlots]map[string]Entry
+	slab       *slabTable
+	size       int
+	expiring   [scanSlots]int
+	index      *skipList
+	superseded [scanSlots]map[string][]oldVersion
+	revisions  [scanSlots]map[string]History
+}
+
+/*
+hashOf returns the hash of key. Its low bits are the key's slot.
+*/
+func hashOf(key string) uint64 {
+	return maphash.String(slotSeed, key)
+}
+
+func slotOf(key string) int {
+	return int(hashOf(key) & (scanSlots - 1))
+}
+
+func (ks *keyspace) get(key string) (Entry, bool) {
+	if ks.slab != nil {
+		return ks.slab.get(hashOf(key), key)
+	}
+	val, ok := ks.slots[slotOf(key)][key]
+	return val, ok
+}
+
+/*
+has reports whether key has a current entry, expired or not.
+*/
+func (ks *keyspace) has(key string) bool {
+	if ks.slab != nil {
+		return ks.slab.find(hashOf(key), key) != 0
+	}
+	_, ok := ks.slots[slotOf(key)][key]
+	return ok
+}
+
+func (ks *keyspace) put(key string, value Entry) {
+	if ks.slab != nil {
+		h := hashOf(key)
+		i := int(h & (scanSlots - 1))
+		if id := ks.slab.find(h, key); id != 0 && ks.slab.records[id].expiresAt != 0 {
+			ks.expiring[i]--
+		}
+		if ks.slab.put(h, key, value) {
+			ks.inserted(key)
+		}
+		ks.countTTL(i, value)
+		return
+	}
+
+	i := slotOf(key)
+	slot := ks.slots[i]
+	if slot == nil {
+		slot = make(map[string]Entry)
+		ks.slots[i] = slot
+	}
+
+	if old, ok := slot[key]; !ok {
+		ks.inserted(key)
+	} else if old.ExpiresAtMillis != 0 {
+		ks.expiring[i]--
+	}
+	slot[key] = value
+	ks.countTTL(i, value)
+}
+
+/*
+countTTL counts value in the TTLs of slot i if it has one.
+*/
+func (ks *keyspace) countTTL(i int, value Entry) {
+	if value.ExpiresAtMillis != 0 {
+		ks.expiring[i]++
+	}
+}
+
+/*
+hasTTLs reports whether any entry of slot i has a TTL.
+*/
+func (ks *keyspace) hasTTLs(i int) bool {
+	return ks.expiring[i] > 0
+}
+
+func (ks *keyspace) inserted(key string) {
+	ks.size++
+	if ks.index != nil {
+		ks.index.insert(key)
+	}
+}
+
+func (ks *keyspace) del(key string) {
+	if ks.slab != nil {
+		h := hashOf(key)
+		id := ks.slab.find(h, key)
+		if id == 0 {
+			return
+		}
+		if ks.slab.records[id].expiresAt != 0 {
+			ks.expiring[h&(scanSlots-1)]--
+		}
+		ks.slab.del(h, key)
+		ks.deleted(key)
+		return
+	}
+
+	i := slotOf(key)
+	if old, ok := ks.slots[i][key]; ok {
+		if old.ExpiresAtMillis != 0 {
+			ks.expiring[i]--
+		}
+		delete(ks.slots[i], key)
+		ks.deleted(key)
+	}
+}
+
+func (ks *keyspace) deleted(key string) {
+	ks.size--
+	if ks.index != nil {
+		ks.index.delete(key)
+	}
+}
+
+/*
+defragment repacks the slabs once they are mostly holes. Map-backed
+keyspaces have nothing to do.
+*/
+func (ks *keyspace) defragment() {
+	if ks.slab != nil && ks.slab.fragmented() {
+		ks.slab.defragment()
+	}
+}
+
+/*
+versions returns the superseded versions of key, newest first.
+*/
+func (ks *keyspace) versions(key string) []oldVersion {
+	return ks.superseded[slotOf(key)][key]
+}
+
+/*
+setVersions replaces the superseded versions of key; an empty chain
+forgets the key's old versions.
+*/
+func (ks *keyspace) setVersions(key string, chain []oldVersion) {
+	i := slotOf(key)
+	if len(chain) == 0 {
+		delete(ks.superseded[i], key)
+		return
+	}
+
+	if ks.superseded[i] == nil {
+		ks.superseded[i] = make(map[string][]oldVersion)
+	}
+	ks.superseded[i][key] = chain
+}
+
+/*
+history returns the recorded revisions of key, oldest first.
+*/
+func (ks *keyspace) history(key string) History {
+	return ks.revisions[slotOf(key)][key]
+}
+
+/*
+setHistory replaces the recorded revisions of key; an empty history
+forgets the key.
+*/
+func (ks *keyspace) setHistory(key string, h History) {
+	i := slotOf(key)
+	if len(h) == 0 {
+		delete(ks.revisions[i], key)
+		return
+	}
+
+	if ks.revisions[i] == nil {
+		ks.revisions[i] = make(map[string]History)
+	}
+	ks.revisions[i][key] = h
+}
+
+/*
+holds reports whether the keyspace has anything for key: a current
+entry, versions kept for views or a recorded history.
+*/
+func (ks *keyspace) holds(key string) bool {
+	i := slotOf(key)
+	if ks.has(key) {
+		return true
+	}
+	if _, ok := ks.superseded[i][key]; ok {
+		return true
+	}
+	_, ok := ks.revisions[i][key]
+	return ok
+}
+
+/*
+detached calls fn for every key that has no current entry but still
+has versions kept for views or a recorded history, until fn returns
+false.
+*/
+func (ks *keyspace) detached(fn func(key string) bool) {
+	for i := range ks.slots {
+		for key := range ks.superseded[i] {
+			if !ks.has(key) && !fn(key) {
+				return
+			}
+		}
+		for key := range ks.revisions[i] {
+			if ks.has(key) {
+				continue
+			}
+			if _, kept := ks.superseded[i][key]; !kept && !fn(key) {
+				return
+			}
+		}
+	}
+}
+
+// len returns the number of stored entries, expired ones included.
+func (ks *keyspace) len() int {
+	return ks.size
+}
+
+/*
+each calls fn for every stored entry, expired ones included, in slot
+order. fn may delete the entry it is given. Returning false stops.
+*/
+func (ks *keyspace) each(fn func(key string, value Entry) bool) bool {
+	for i := range ks.slots {
+		if !ks.eachIn(i, fn) {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+eachIn calls fn for every stored entry of one slot, expired ones
+included. fn may delete the entry it is given. Returning false stops.
+*/
+func (ks *keyspace) eachIn(slot int, fn func(key string, value Entry) bool) bool {
+	if ks.slab != nil {
+		return ks.slab.each(slot, fn)
+	}
+	for key, val := range ks.slots[slot] {
+		if !fn(key, val) {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+scan visits whole slots starting at cursor until at least count entries
+were passed to fn or the last slot is done. It returns the cursor to
+resume from, or 0 once every slot has been visited.
+
+Like Redis SCAN, count is a hint: a slot is never split, so a call may
+visit more entries.
+*/
+func (ks *keyspace) scan(cursor uint64, count int, fn func(key string, value Entry)) uint64 {
+	if count <= 0 {
+		count = 1
+	}
+
+	seen := 0
+	for i := cursor; i < scanSlots; i++ {
+		ks.eachIn(int(i), func(key string, val Entry) bool {
+			fn(key, val)
+			seen++
+			return true
+		})
+
+		if seen >= count && i+1 < scanSlots {
+			return i + 1
+		}
+	}
+	return 0
+}
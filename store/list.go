@@ -0,0 +1,181 @@
+package store
+
+/*
+listValue is the decoded form of a TypeList value, stored as its length
+followed by its elements, head first.
+
+It is a deque: front holds the elements pushed at the head, in reverse,
+and back the rest, so pushes and pops at either end take constant time
+on a list kept decoded between ops.
+*/
+type listValue struct {
+	front []string
+	back  []string
+}
+
+func decodeList(data []byte) (typedValue, error) {
+	r := valueReader{data: data}
+	l := &listValue{}
+	if len(data) == 0 {
+		return l, nil
+	}
+
+	n := r.count()
+	l.back = make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		l.back = append(l.back, r.string())
+	}
+	return l, r.finish()
+}
+
+func (l *listValue) encode() []byte {
+	size := 1
+	for i := 0; i < l.len(); i++ {
+		size += len(l.at(i)) + 2
+	}
+
+	buf := appendUvarint(make([]byte, 0, size), uint64(l.len()))
+	for i := 0; i < l.len(); i++ {
+		buf = appendString(buf, l.at(i))
+	}
+	return buf
+}
+
+func (l *listValue) empty() bool {
+	return l.len() == 0
+}
+
+func (l *listValue) len() int {
+	return len(l.front) + len(l.back)
+}
+
+/*
+at returns the element at index i, counting from the head.
+*/
+func (l *listValue) at(i int) string {
+	if i < len(l.front) {
+		return l.front[len(l.front)-1-i]
+	}
+	return l.back[i-len(l.front)]
+}
+
+/*
+slice returns the elements from index from up to to, as a new slice.
+*/
+func (l *listValue) slice(from, to int) []string {
+	out := make([]string, 0, to-from)
+	for i := from; i < to; i++ {
+		out = append(out, l.at(i))
+	}
+	return out
+}
+
+func (l *listValue) popHead() {
+	if n := len(l.front); n > 0 {
+		l.front[n-1] = ""
+		l.front = l.front[:n-1]
+		return
+	}
+	l.back[0] = ""
+	l.back = l.back[1:]
+}
+
+func (l *listValue) popTail() {
+	if n := len(l.back); n > 0 {
+		l.back[n-1] = ""
+		l.back = l.back[:n-1]
+		return
+	}
+	l.front[0] = ""
+	l.front = l.front[1:]
+}
+
+/*
+listOp adapts an op on a list to an opSpec.
+*/
+func listOp(query bool, minArgs, maxArgs int, fn func(l *listValue, args []string) (any, func(), error)) opSpec {
+	return typedOp(TypeList, query, minArgs, maxArgs, fn)
+}
+
+/*
+listOps are the ops on lists. Pushes reply with the new length, pops
+with the removed element or nil.
+*/
+var listOps = map[string]opSpec{
+	"LPUSH": listOp(false, 1, -1, func(l *listValue, args []string) (any, func(), error) {
+		return int64(l.len() + len(args)), func() {
+			l.front = append(l.front, args...)
+		}, nil
+	}),
+
+	"RPUSH": listOp(false, 1, -1, func(l *listValue, args []string) (any, func(), error) {
+		return int64(l.len() + len(args)), func() {
+			l.back = append(l.back, args...)
+		}, nil
+	}),
+
+	"LPOP": listOp(false, 0, 0, func(l *listValue, _ []string) (any, func(), error) {
+		if l.empty() {
+			return nil, nil, nil
+		}
+		return l.at(0), l.popHead, nil
+	}),
+
+	"RPOP": listOp(false, 0, 0, func(l *listValue, _ []string) (any, func(), error) {
+		if l.empty() {
+			return nil, nil, nil
+		}
+		return l.at(l.len() - 1), l.popTail, nil
+	}),
+
+	"LLEN": listOp(true, 0, 0, queryOp(func(l *listValue, _ []string) (any, error) {
+		return int64(l.len()), nil
+	})),
+
+	"LRANGE": listOp(true, 2, 2, queryOp(func(l *listValue, args []string) (any, error) {
+		start, err := intArg(args[0])
+		if err != nil {
+			return nil, err
+		}
+		stop, err := intArg(args[1])
+		if err != nil {
+			return nil, err
+		}
+
+		from, to := indexRange(l.len(), start, stop)
+		return l.slice(from, to), nil
+	})),
+
+	"LINDEX": listOp(true, 1, 1, queryOp(func(l *listValue, args []string) (any, error) {
+		i, err := intArg(args[0])
+		if err != nil {
+			return nil, err
+		}
+		if i < 0 {
+			i += l.len()
+		}
+		if i < 0 || i >= l.len() {
+			return nil, nil
+		}
+		return l.at(i), nil
+	})),
+
+	"LTRIM": listOp(false, 2, 2, func(l *listValue, args []string) (any, func(), error) {
+		start, err := intArg(args[0])
+		if err != nil {
+			return nil, nil, err
+		}
+		stop, err := intArg(args[1])
+		if err != nil {
+			return nil, nil, err
+		}
+
+		from, to := indexRange(l.len(), start, stop)
+		if from == 0 && to == l.len() {
+			return nil, nil, nil
+		}
+		return nil, func() {
+			l.front, l.back = nil, l.slice(from, to)
+		}, nil
+	}),
+}
@@ -0,0 +1,148 @@
+package store
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"hermes/wal"
+)
+
+/*
+listOf returns the elements of the list at key.
+*/
+func listOf(t *testing.T, s Tx, key string) []string {
+	t.Helper()
+
+	got, err := Apply(s, key, Op{Name: "LRANGE", Args: []string{"0", "-1"}})
+	if err != nil {
+		t.Fatalf("LRANGE %s failed: %v", key, err)
+	}
+	return got.([]string)
+}
+
+func TestList_Ranges(t *testing.T) {
+	s := NewLockedStore()
+	_, _ = Apply(s, "l", Op{Name: "RPUSH", Args: []string{"a", "b", "c", "d", "e"}})
+
+	for _, tc := range []struct {
+		start, stop string
+		want        []string
+	}{
+		{"0", "-1", []string{"a", "b", "c", "d", "e"}},
+		{"1", "2", []string{"b", "c"}},
+		{"-2", "100", []string{"d", "e"}},
+		{"-100", "0", []string{"a"}},
+		{"3", "1", []string{}},
+		{"5", "10", []string{}},
+	} {
+		got, err := Apply(s, "l", Op{Name: "LRANGE", Args: []string{tc.start, tc.stop}})
+		if err != nil || !slices.Equal(got.([]string), tc.want) {
+			t.Fatalf("LRANGE %s %s: expected %v, got %v (%v)", tc.start, tc.stop, tc.want, got, err)
+		}
+	}
+
+	for index, want := range map[string]any{"0": "a", "-1": "e", "4": "e", "5": nil, "-6": nil} {
+		if got, _ := Apply(s, "l", Op{Name: "LINDEX", Args: []string{index}}); got != want {
+			t.Fatalf("LINDEX %s: expected %v, got %v", index, want, got)
+		}
+	}
+
+	// Trimming to nothing removes the key
+	_, _ = Apply(s, "l", Op{Name: "LTRIM", Args: []string{"2", "1"}})
+	if _, ok := s.Read("l"); ok {
+		t.Fatalf("expected an empty trim to remove the key")
+	}
+}
+
+func TestList_BadArguments(t *testing.T) {
+	s := NewLockedStore()
+
+	for _, op := range []Op{
+		{Name: "RPUSH"},
+		{Name: "LPOP", Args: []string{"1"}},
+		{Name: "LRANGE", Args: []string{"0"}},
+	} {
+		if _, err := Apply(s, "l", op); !errors.Is(err, ErrOpArgs) {
+			t.Fatalf("%s %v: expected ErrOpArgs, got %v", op.Name, op.Args, err)
+		}
+	}
+	if _, err := Apply(s, "l", Op{Name: "LINDEX", Args: []string{"x"}}); !errors.Is(err, ErrNotInteger) {
+		t.Fatalf("expected ErrNotInteger, got %v", err)
+	}
+
+	_ = s.Write("l", Entry{Value: []byte{5, 1}, Type: TypeList}, PutOverwrite)
+	if _, err := Apply(s, "l", Op{Name: "LLEN"}); err == nil {
+		t.Fatalf("expected a corrupt list to fail to decode")
+	}
+}
+
+func TestWalStore_ListOpsAreLogged(t *testing.T) {
+	dir := t.TempDir()
+	clock := NewManualClock(time.Unix(1_700_000_000, 0))
+	exp := unixNow(clock) + time.Hour.Milliseconds()
+
+	s := openCompressedWal(t, dir, clock)
+	for i := 0; i < 100; i++ {
+		_, _ = Apply(s, "l", Op{Name: "RPUSH", Args: []string{strconv.Itoa(i)}, Time: unixNow(clock)})
+	}
+	s.Expire("l", exp)
+	_, _ = Apply(s, "l", Op{Name: "LPOP", Time: unixNow(clock)})
+
+	// Each push logs its own element, never the whole list
+	raw, err := wal.NewWAL(wal.Config{Path: filepath.Join(dir, "wal.log"), SyncPolicy: wal.SyncEveryWrite})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops := 0
+	_ = raw.Replay(func(r wal.WALRecord) error {
+		if r.Type == wal.RecordOp {
+			ops++
+			if len(r.Args) > 1 {
+				t.Fatalf("expected an op to log its arguments only, got %v", r.Args)
+			}
+		}
+		return nil
+	})
+	raw.Close()
+	if ops != 101 {
+		t.Fatalf("expected 101 op records, got %d", ops)
+	}
+
+	// Close snapshots the list, then the WAL logs a transaction
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r := openCompressedWal(t, dir, clock)
+	_ = r.Atomic([]string{"l", "m"}, func(tx Tx) error {
+		v, _ := Apply(tx, "l", Op{Name: "RPOP", Time: unixNow(clock)})
+		_, err := Apply(tx, "m", Op{Name: "LPUSH", Args: []string{v.(string)}, Time: unixNow(clock)})
+		return err
+	})
+	_, _ = Apply(r, "gone", Op{Name: "RPUSH", Args: []string{"x"}, Time: unixNow(clock)})
+	_, _ = Apply(r, "gone", Op{Name: "RPOP", Time: unixNow(clock)})
+	r.(*compressedStore).store.(*walStore).wal.Close()
+
+	r = openCompressedWal(t, dir, clock)
+	defer r.Close()
+
+	want := make([]string, 0, 98)
+	for i := 1; i < 99; i++ {
+		want = append(want, strconv.Itoa(i))
+	}
+	if got := listOf(t, r, "l"); !slices.Equal(got, want) {
+		t.Fatalf("expected the list to recover as %v, got %v", want, got)
+	}
+	if val, _ := r.Read("l"); val.ExpiresAtMillis != exp || val.Type != TypeList {
+		t.Fatalf("expected the list to keep its type and TTL, got %+v", val)
+	}
+	if got := listOf(t, r, "m"); !slices.Equal(got, []string{"99"}) {
+		t.Fatalf("expected the transaction to replay, got %v", got)
+	}
+	if _, ok := r.Read("gone"); ok {
+		t.Fatalf("expected an emptied list to stay removed")
+	}
+}
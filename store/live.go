@@ -0,0 +1,133 @@
+package store
+
+/*
+liveTx is implemented by the Tx of the in-memory stores, which keep
+typed values decoded between ops instead of encoding them: an op costs
+what it does to the value, not the value's size. The value is encoded
+only when its entry is handed out whole, by Read, iteration, a view or
+a history (see Entry.materialized), and before a view's old version is
+changed in place.
+
+A value written encoded, by Write or a snapshot load, is decoded by
+the first op holding its key exclusively and kept decoded from then
+on.
+*/
+type liveTx interface {
+	// readLive returns the entry of key, the value of type typ it
+	// holds, decoded, and whether the key exists. The value must not
+	// be changed but through commitLive.
+	readLive(key string, typ ValueType) (Entry, typedValue, bool, error)
+
+	// commitLive applies the change of res to the value readLive
+	// returned, and stores it.
+	commitLive(key string, res opResult) error
+}
+
+/*
+readLive returns the entry of key and its value of type typ, decoded.
+A missing key holds an empty value. A value stored encoded is decoded,
+and kept decoded if keep is set, which needs exclusive access.
+*/
+func (s *store) readLive(key string, typ ValueType, keep bool) (Entry, typedValue, bool, error) {
+	cur, ok := s.get(key)
+	if !ok {
+		v, err := valueDecoders[typ](nil)
+		return Entry{}, v, false, err
+	}
+	if cur.Type != typ {
+		return Entry{}, nil, false, ErrWrongType
+	}
+	if cur.live != nil {
+		return cur, cur.live, true, nil
+	}
+
+	v, err := decodeTyped(cur, true, typ)
+	if err != nil {
+		return Entry{}, nil, false, err
+	}
+	if keep {
+		// Same value and version: only its form changes
+		cur.Value, cur.Encoding, cur.live = nil, EncodingRaw, v
+		s.data.put(key, cur)
+	}
+	return cur, v, true, nil
+}
+
+/*
+queryLive runs the query op against the value of key if it needs no
+decoding to be kept: it is already decoded, missing, or of another
+type. Otherwise it reports false and leaves the op to a caller holding
+the key exclusively. It only reads, so a shared lock is enough.
+*/
+func (s *store) queryLive(key string, op Op) (reply any, done bool, err error) {
+	spec := opSpecs[op.Name]
+	if cur, ok := s.get(key); ok && cur.Type == spec.typ && cur.live == nil {
+		return nil, false, nil
+	}
+
+	cur, v, found, err := s.readLive(key, spec.typ, false)
+	if err != nil {
+		return nil, true, err
+	}
+	res, err := spec.plan(v, op, cur, found)
+	return res.reply, true, err
+}
+
+/*
+commitLive applies the change of res to the value of key, committed at
+sequence seq. The current version is retired first, encoded, so open
+views keep seeing it unchanged. An emptied value removes the key like
+opResult.commit does, by expiring it at once.
+*/
+func (s *store) commitLive(key string, res opResult, seq uint64) {
+	cur, _ := s.get(key)
+	s.retire(key, seq)
+	res.change()
+
+	now := s.now()
+	switch {
+	case !res.value.empty():
+		val := Entry{
+			Type:            res.typ,
+			ExpiresAtMillis: res.expiresAt,
+			WrittenAtMillis: res.time,
+			Version:         seq,
+			live:            res.value,
+		}
+		if val.WrittenAtMillis == 0 {
+			val.WrittenAtMillis = now
+		}
+		s.data.put(key, val)
+		s.recordRevision(key, val, now)
+
+	case res.found:
+		cur.ExpiresAtMillis, cur.Version = tombstoneExpiry, seq
+		s.data.put(key, cur)
+		s.retimeRevision(key, tombstoneExpiry)
+	}
+}
+
+func (tx *routedTx) readLive(key string, typ ValueType) (Entry, typedValue, bool, error) {
+	st := tx.route(key)
+	if st == nil {
+		v, err := valueDecoders[typ](nil)
+		return Entry{}, v, false, err
+	}
+	return st.readLive(key, typ, true)
+}
+
+func (tx *routedTx) commitLive(key string, res opResult) error {
+	st := tx.route(key)
+	if st == nil {
+		return ErrKeyNotDeclared
+	}
+	st.commitLive(key, res, tx.commitSeq())
+	return nil
+}
+
+/*
+Apply runs op through Atomic, whose Tx keeps the value decoded.
+*/
+func (s *store) Apply(key string, op Op) (any, error) {
+	return applyAtomic(s, key, op)
+}
@@ -0,0 +1,115 @@
+package store
+
+import (
+	"slices"
+	"strconv"
+	"testing"
+)
+
+/*
+liveOf returns the decoded value kept for key by a locked store.
+*/
+func liveOf(s DataStore, key string) typedValue {
+	ls := s.(*lockedStore)
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+
+	val, _ := ls.store.data.get(key)
+	return val.live
+}
+
+func TestLive_OpsKeepTheValueDecoded(t *testing.T) {
+	for name, opts := range map[string][]Option{
+		"Maps": nil,
+		"Slab": {WithSlabStorage()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			s := NewLockedStore(opts...)
+			defer s.Close()
+
+			_, _ = Apply(s, "l", Op{Name: "RPUSH", Args: []string{"a", "b"}})
+			live := liveOf(s, "l")
+			if live == nil {
+				t.Fatalf("expected the list to be kept decoded")
+			}
+
+			_, _ = Apply(s, "l", Op{Name: "LPUSH", Args: []string{"0"}})
+			_, _ = Apply(s, "l", Op{Name: "RPOP"})
+			if liveOf(s, "l") != live {
+				t.Fatalf("expected ops to change the decoded list in place")
+			}
+
+			// Reads hand out the value encoded
+			val, ok := s.Read("l")
+			if !ok || val.Type != TypeList || val.live != nil {
+				t.Fatalf("expected Read to return an encoded list, got %+v", val)
+			}
+			decoded, err := decodeList(val.Value)
+			if err != nil || !slices.Equal(decoded.(*listValue).slice(0, 2), []string{"0", "a"}) {
+				t.Fatalf("expected Read to encode the current list, got %v (%v)", decoded, err)
+			}
+		})
+	}
+}
+
+func TestLive_WrittenValueIsDecodedOnce(t *testing.T) {
+	s := NewLockedStore()
+	defer s.Close()
+
+	h := &hashValue{fields: map[string]string{"f": "1"}}
+	_ = s.Write("h", Entry{Value: h.encode(), Type: TypeHash}, PutOverwrite)
+
+	if got, _ := Apply(s, "h", Op{Name: "HGET", Args: []string{"f"}}); got != "1" {
+		t.Fatalf("expected HGET to read the written hash, got %v", got)
+	}
+	live := liveOf(s, "h")
+	if live == nil {
+		t.Fatalf("expected the first op to keep the written hash decoded")
+	}
+
+	if got, _ := Apply(s, "h", Op{Name: "HLEN"}); got != int64(1) || liveOf(s, "h") != live {
+		t.Fatalf("expected later ops to use the decoded hash, got %v", got)
+	}
+}
+
+func TestLive_HistoryKeepsEachRevision(t *testing.T) {
+	s := NewLockedStore(WithHistory(HistoryPolicy{Keep: 5}))
+	defer s.Close()
+
+	for i := 0; i < 3; i++ {
+		_, _ = Apply(s, "l", Op{Name: "RPUSH", Args: []string{strconv.Itoa(i)}})
+	}
+
+	h := s.(Historian).History("l")
+	if len(h) != 3 {
+		t.Fatalf("expected 3 revisions, got %d", len(h))
+	}
+	for i, rev := range h {
+		l, err := decodeList(rev.Value)
+		if err != nil || l.(*listValue).len() != i+1 {
+			t.Fatalf("expected revision %d to hold %d elements, got %v (%v)", i, i+1, l, err)
+		}
+	}
+}
+
+/*
+BenchmarkApply_PushOntoLongList pushes onto and pops from a list of a
+million elements: with the list kept decoded, an op does not cost its
+length.
+*/
+func BenchmarkApply_PushOntoLongList(b *testing.B) {
+	s := NewLockedStore()
+	defer s.Close()
+
+	items := make([]string, 1<<20)
+	for i := range items {
+		items[i] = strconv.Itoa(i)
+	}
+	_, _ = Apply(s, "l", Op{Name: "RPUSH", Args: items})
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = Apply(s, "l", Op{Name: "RPUSH", Args: []string{"x"}})
+		_, _ = Apply(s, "l", Op{Name: "LPOP"})
+	}
+}
@@ -14,29 +14,45 @@ concurrent access.
 type lockedStore struct {
 	mu    sync.RWMutex
 	store *store
+
+	sweeper *sweeper
 }
 
 /*
 NewLockedStore creates a store protected by a global lock.
 This serves as a simple and safe baseline concurrency model.
+
+Expired entries are removed by a background sweeper, configured
+with WithSweepInterval.
 */
-func NewLockedStore() DataStore {
-	return &lockedStore{
-		store: &store{
-			data: make(map[string]Entry),
-		},
+func NewLockedStore(opts ...Option) DataStore {
+	return newLockedStore(&store{}, newOptions(opts))
+}
+
+func newLockedStore(st *store, o options) *lockedStore {
+	st.policies = o.history
+	st.clock = o.clock
+	st.views = o.views
+	if o.slab {
+		st.data.slab = newSlabTable()
+	}
+	s := &lockedStore{
+		store: st,
 	}
+	s.sweeper = startSweeper(o.sweepInterval, s.sweepTick)
+	return s
 }
 
 /*
-Read acquires the global lock before delegating to the store.
+Read acquires the global lock in shared mode before delegating
+to the store.
 
-Reads take an exclusive lock because lazy expiration may
-delete expired keys.
+Reads never delete expired keys, so concurrent readers do not
+serialize; removal is left to writes and the sweeper.
 */
 func (s *lockedStore) Read(key string) (Entry, bool) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	return s.store.Read(key)
 }
 
@@ -59,7 +75,69 @@ func (s *lockedStore) Expire(key string, unixTimestampMilli int64) bool {
 	return s.store.Expire(key, unixTimestampMilli)
 }
 
+/*
+ReadBatch holds the global lock across all keys so the result
+reflects a single point in time.
+*/
+func (s *lockedStore) ReadBatch(keys []string) map[string]Entry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.store.ReadBatch(keys)
+}
+
+/*
+WriteBatch holds the global lock so the batch is applied atomically.
+*/
+func (s *lockedStore) WriteBatch(entries []KeyValue, mode PutMode) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.store.WriteBatch(entries, mode)
+}
+
+/*
+Atomic holds the global lock for the duration of fn.
+*/
+func (s *lockedStore) Atomic(keys []string, fn func(tx Tx) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.store.Atomic(keys, fn)
+}
+
+/*
+Apply runs a query under the shared lock when the value is already
+kept decoded, so concurrent queries do not serialize. Mutations, and
+queries on a value still encoded, hold the global lock.
+*/
+func (s *lockedStore) Apply(key string, op Op) (any, error) {
+	if isQuery(op) {
+		s.mu.RLock()
+		reply, done, err := s.store.queryLive(key, op)
+		s.mu.RUnlock()
+		if done {
+			return reply, err
+		}
+	}
+	return applyAtomic(s, key, op)
+}
+
+/*
+SweepExpired removes expired entries in steps, holding the
+exclusive lock only for one step at a time.
+*/
+func (s *lockedStore) SweepExpired() int {
+	return sweepLocked(&s.mu, s.store, scanSlots)
+}
+
+/*
+sweepTick is one run of the background sweeper: a bounded part of
+SweepExpired.
+*/
+func (s *lockedStore) sweepTick() int {
+	return sweepLocked(&s.mu, s.store, sweepTickSlots)
+}
+
 func (s *lockedStore) Close() error {
+	s.sweeper.stop()
 	return s.store.Close()
 }
 
@@ -68,4 +146,66 @@ func (s *lockedStore) Iterate(fn func(key string, value Entry) bool) {
 	defer s.mu.RUnlock()
 
 	s.store.Iterate(fn)
-}
\ No newline at end of file
+}
+/*
+ScanEntries returns one step of a scan under the read lock. The lock
+is released between steps, so a long scan never blocks writers for
+more than a step.
+*/
+func (s *lockedStore) ScanEntries(cursor uint64, count int) ([]KeyValue, uint64) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.store.ScanEntries(cursor, count)
+}
+
+/*
+History reads the history of key under the read lock.
+*/
+func (s *lockedStore) History(key string) History {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.store.History(key)
+}
+
+/*
+histories copies every history under the read lock and calls fn
+after releasing it.
+*/
+func (s *lockedStore) histories(fn func(key string, h History) bool) {
+	s.mu.RLock()
+	all := s.store.collectHistories()
+	s.mu.RUnlock()
+
+	yieldHistories(all, fn)
+}
+
+func (s *lockedStore) restoreHistory(key string, h History) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.store.restoreHistory(key, h)
+}
+
+/*
+Snapshot returns a view of the store. Each read through it takes the
+read lock only for that read.
+*/
+func (s *lockedStore) Snapshot() View {
+	return newView(s, s.store.views, s.store.clock)
+}
+
+func (s *lockedStore) readAt(key string, rp readPoint) (Entry, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.store.readAt(key, rp)
+}
+
+func (s *lockedStore) scanAt(cursor uint64, count int, rp readPoint) ([]KeyValue, uint64) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.store.scanAt(cursor, count, rp)
+}
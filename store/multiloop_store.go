@@ -0,0 +1,414 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"slices"
+)
+
+/*
+multiLoopStore implements DataStore with several event loops.
+
+Keys are partitioned across loops by hash tag, like shards in
+shardedStore, and each loop owns its partition exclusively. Requests
+for different partitions run in parallel, so throughput is no longer
+capped by a single goroutine.
+
+Single-key operations are plain messages to the owning loop.
+Multi-key operations that span loops park every involved loop, in
+ascending loop order, and then run on the caller goroutine. Parking
+in a fixed order is the loop equivalent of ordered shard locking and
+rules out deadlocks between concurrent batches.
+*/
+type multiLoopStore struct {
+	loops []*eventLoop
+	hash  HashFunc
+	clock Clock
+}
+
+/*
+NewMultiLoopStore creates a store served by numLoops event loops,
+each with a request buffer of the given size. A non-positive numLoops
+starts one loop per available CPU.
+
+Keys sharing a hash tag are served by the same loop, which keeps
+multi-key operations on them a single message. The hash function and
+sweep interval are configured with WithHashFunc and WithSweepInterval.
+*/
+func NewMultiLoopStore(numLoops int, buffer int, opts ...Option) DataStore {
+	o := newOptions(opts)
+
+	if numLoops <= 0 {
+		numLoops = runtime.GOMAXPROCS(0)
+	}
+
+	s := &multiLoopStore{
+		loops: make([]*eventLoop, numLoops),
+		hash:  o.hash,
+		clock: o.clock,
+	}
+
+	for i := range s.loops {
+		s.loops[i] = newEventLoop(buffer, o)
+	}
+	return s
+}
+
+/*
+loopIndex returns the loop owning key. Only the key's hash tag takes
+part in the hash.
+*/
+func (s *multiLoopStore) loopIndex(key string) int {
+	return int(s.hash(hashTag(key)) % uint32(len(s.loops)))
+}
+
+func (s *multiLoopStore) loopFor(key string) *eventLoop {
+	return s.loops[s.loopIndex(key)]
+}
+
+/*
+loopIndexes returns the sorted, unique loops owning keys.
+*/
+func (s *multiLoopStore) loopIndexes(keys []string) []int {
+	indexes := make([]int, 0, len(keys))
+	for _, key := range keys {
+		indexes = append(indexes, s.loopIndex(key))
+	}
+	slices.Sort(indexes)
+	return slices.Compact(indexes)
+}
+
+/*
+park stops the given loops in ascending order and returns a function
+that resumes them all.
+
+While parked, a loop is blocked inside opPark and does not touch its
+store, so the caller may use the stores directly. The park replies
+order the loops' earlier work before the caller's accesses.
+
+If a loop is closed, the loops parked so far are resumed and
+ErrStoreClosed is returned.
+*/
+func (s *multiLoopStore) park(indexes []int) (release func(), err error) {
+	done := make(chan struct{})
+	release = func() {
+		close(done)
+	}
+
+	for _, i := range indexes {
+		_, err := s.loops[i].call(context.Background(), request{
+			op:      opPark,
+			release: done,
+		})
+		if err != nil {
+			release()
+			return nil, err
+		}
+	}
+	return release, nil
+}
+
+/*
+Read sends a read request to the loop owning the key.
+A closed store reports every key as absent.
+*/
+func (s *multiLoopStore) Read(key string) (Entry, bool) {
+	val, ok, _ := s.ReadContext(context.Background(), key)
+	return val, ok
+}
+
+/*
+ReadContext is Read that gives up when ctx ends.
+*/
+func (s *multiLoopStore) ReadContext(ctx context.Context, key string) (Entry, bool, error) {
+	resp, err := s.loopFor(key).call(ctx, request{
+		op:  opRead,
+		key: key,
+	})
+	if err == nil {
+		err = resp.err
+	}
+	return resp.value, resp.ok, err
+}
+
+/*
+Write sends a write request to the loop owning the key.
+*/
+func (s *multiLoopStore) Write(key string, value Entry, mode PutMode) error {
+	return s.WriteContext(context.Background(), key, value, mode)
+}
+
+/*
+WriteContext is Write that gives up when ctx ends.
+*/
+func (s *multiLoopStore) WriteContext(ctx context.Context, key string, value Entry, mode PutMode) error {
+	resp, err := s.loopFor(key).call(ctx, request{
+		op:    opWrite,
+		key:   key,
+		value: value,
+		mode:  mode,
+	})
+	if err != nil {
+		return err
+	}
+	return resp.err
+}
+
+/*
+Expire sends an expiry request to the loop owning the key.
+*/
+func (s *multiLoopStore) Expire(key string, unixTimestampMilli int64) bool {
+	ok, _ := s.ExpireContext(context.Background(), key, unixTimestampMilli)
+	return ok
+}
+
+/*
+ExpireContext is Expire that gives up when ctx ends.
+*/
+func (s *multiLoopStore) ExpireContext(ctx context.Context, key string, unixTimestampMilli int64) (bool, error) {
+	resp, err := s.loopFor(key).call(ctx, request{
+		op:        opExpire,
+		key:       key,
+		expiresAt: unixTimestampMilli,
+	})
+	if err == nil {
+		err = resp.err
+	}
+	return resp.ok, err
+}
+
+/*
+ReadBatch is a single message when every key lives on one loop.
+Otherwise the involved loops are parked so the result is a consistent
+view across partitions.
+*/
+func (s *multiLoopStore) ReadBatch(keys []string) map[string]Entry {
+	indexes := s.loopIndexes(keys)
+	if len(indexes) == 1 {
+		resp, err := s.loops[indexes[0]].call(context.Background(), request{
+			op:   opReadBatch,
+			keys: keys,
+		})
+		if err != nil {
+			return map[string]Entry{}
+		}
+		return resp.values
+	}
+
+	result := make(map[string]Entry, len(keys))
+
+	release, err := s.park(indexes)
+	if err != nil {
+		return result
+	}
+	defer release()
+
+	for _, key := range keys {
+		if val, ok := s.loopFor(key).store.Read(key); ok {
+			result[key] = val
+		}
+	}
+	return result
+}
+
+/*
+WriteBatch applies the batch atomically: as one message on a single
+loop, or with every involved loop parked.
+*/
+func (s *multiLoopStore) WriteBatch(entries []KeyValue, mode PutMode) error {
+	keys := make([]string, len(entries))
+	for i, kv := range entries {
+		keys[i] = kv.Key
+	}
+
+	indexes := s.loopIndexes(keys)
+	if len(indexes) == 1 {
+		resp, err := s.loops[indexes[0]].call(context.Background(), request{
+			op:      opWriteBatch,
+			entries: entries,
+			mode:    mode,
+		})
+		if err != nil {
+			return err
+		}
+		return resp.err
+	}
+
+	release, err := s.park(indexes)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return writeBatch(func(key string) *store {
+		return s.loopFor(key).store
+	}, entries, mode, nextVersion())
+}
+
+/*
+Atomic parks every loop owning a declared key and runs fn on the
+caller goroutine. Undeclared keys are rejected like in shardedStore.
+
+fn must not call back into the store: parked loops would never answer.
+*/
+func (s *multiLoopStore) Atomic(keys []string, fn func(tx Tx) error) error {
+	declared := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		declared[key] = struct{}{}
+	}
+
+	release, err := s.park(s.loopIndexes(keys))
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return fn(&routedTx{
+		route: func(key string) *store {
+			if _, ok := declared[key]; !ok {
+				return nil
+			}
+			return s.loopFor(key).store
+		},
+	})
+}
+
+/*
+Apply runs every op as one Atomic; see eventLoopStore.Apply.
+*/
+func (s *multiLoopStore) Apply(key string, op Op) (any, error) {
+	return applyAtomic(s, key, op)
+}
+
+/*
+ScanEntries steps through the loops one after another. The cursor
+holds the loop index in its upper 32 bits and the slot position within
+that loop in the lower 32 bits.
+*/
+func (s *multiLoopStore) ScanEntries(cursor uint64, count int) ([]KeyValue, uint64) {
+	i := cursor >> 32
+	if i >= uint64(len(s.loops)) {
+		return nil, 0
+	}
+
+	resp, err := s.loops[i].call(context.Background(), request{
+		op:     opScan,
+		cursor: cursor & 0xffffffff,
+		count:  count,
+	})
+	if err != nil {
+		return nil, 0
+	}
+
+	switch {
+	case resp.cursor != 0:
+		return resp.entries, i<<32 | resp.cursor
+	case i+1 < uint64(len(s.loops)):
+		return resp.entries, (i + 1) << 32
+	}
+	return resp.entries, 0
+}
+
+/*
+Snapshot returns a view of the store; see eventLoopStore.Snapshot.
+*/
+func (s *multiLoopStore) Snapshot() View {
+	// Every loop was built from the same options, so they share one
+	// registry
+	return newView(s, s.loops[0].store.views, s.clock)
+}
+
+func (s *multiLoopStore) readAt(key string, rp readPoint) (Entry, bool) {
+	resp, err := s.loopFor(key).call(context.Background(), request{
+		op:  opReadAt,
+		key: key,
+		at:  rp,
+	})
+	if err != nil {
+		return Entry{}, false
+	}
+	return resp.value, resp.ok
+}
+
+/*
+scanAt steps through the loops like ScanEntries.
+*/
+func (s *multiLoopStore) scanAt(cursor uint64, count int, rp readPoint) ([]KeyValue, uint64) {
+	i := cursor >> 32
+	if i >= uint64(len(s.loops)) {
+		return nil, 0
+	}
+
+	resp, err := s.loops[i].call(context.Background(), request{
+		op:     opScanAt,
+		cursor: cursor & 0xffffffff,
+		count:  count,
+		at:     rp,
+	})
+	if err != nil {
+		return nil, 0
+	}
+
+	switch {
+	case resp.cursor != 0:
+		return resp.entries, i<<32 | resp.cursor
+	case i+1 < uint64(len(s.loops)):
+		return resp.entries, (i + 1) << 32
+	}
+	return resp.entries, 0
+}
+
+/*
+History asks the loop owning key, like eventLoopStore.History.
+*/
+func (s *multiLoopStore) History(key string) History {
+	return s.loopFor(key).history(key)
+}
+
+/*
+histories visits the loops one after another, one loop turn each.
+*/
+func (s *multiLoopStore) histories(fn func(key string, h History) bool) {
+	for _, l := range s.loops {
+		if !l.histories(fn) {
+			return
+		}
+	}
+}
+
+func (s *multiLoopStore) restoreHistory(key string, h History) {
+	s.loopFor(key).restoreHistory(key, h)
+}
+
+/*
+Iterate walks every loop in chunks on the caller goroutine, like
+eventLoopStore.Iterate.
+*/
+func (s *multiLoopStore) Iterate(fn func(key string, value Entry) bool) {
+	iterateByScan(s, fn)
+}
+
+/*
+SweepExpired asks every loop to remove its expired entries.
+*/
+func (s *multiLoopStore) SweepExpired() int {
+	total := 0
+	for _, l := range s.loops {
+		resp, _ := l.call(context.Background(), request{op: opSweep})
+		total += resp.count
+	}
+	return total
+}
+
+/*
+Close shuts every loop down like eventLoopStore.Close. A multi-key
+operation that already parked some loops finishes before they stop.
+*/
+func (s *multiLoopStore) Close() error {
+	var errs []error
+	for _, l := range s.loops {
+		errs = append(errs, l.close())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,81 @@
+package store
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestMultiLoopStore_HashTagsShareLoop(t *testing.T) {
+	s := NewMultiLoopStore(8, 16).(*multiLoopStore)
+
+	indexes := s.loopIndexes([]string{"user:{42}:profile", "user:{42}:session", "{42}"})
+	if len(indexes) != 1 {
+		t.Fatalf("expected tagged keys on one loop, got %v", indexes)
+	}
+}
+
+func TestMultiLoopStore_BatchAcrossLoops(t *testing.T) {
+	s := NewMultiLoopStore(4, 16)
+
+	entries := make([]KeyValue, 64)
+	keys := make([]string, len(entries))
+	for i := range entries {
+		keys[i] = "key:" + strconv.Itoa(i)
+		entries[i] = KeyValue{Key: keys[i], Entry: Entry{Value: []byte(strconv.Itoa(i))}}
+	}
+
+	if err := s.WriteBatch(entries, PutIfAbsent); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// A single existing key rejects the whole batch on every loop
+	entries = append(entries, KeyValue{Key: "fresh", Entry: Entry{Value: []byte("x")}})
+	if err := s.WriteBatch(entries, PutIfAbsent); err != ErrKeyExists {
+		t.Fatalf("expected ErrKeyExists, got %v", err)
+	}
+	if _, ok := s.Read("fresh"); ok {
+		t.Fatalf("rejected batch left a partial write")
+	}
+
+	got := s.ReadBatch(keys)
+	for i, key := range keys {
+		if string(got[key].Value) != strconv.Itoa(i) {
+			t.Fatalf("unexpected value for %s: %q", key, got[key].Value)
+		}
+	}
+}
+
+func TestMultiLoopStore_AtomicRejectsUndeclaredKeys(t *testing.T) {
+	s := NewMultiLoopStore(4, 16)
+
+	err := s.Atomic([]string{"a"}, func(tx Tx) error {
+		if err := tx.Write("a", Entry{Value: []byte("1")}, PutOverwrite); err != nil {
+			return err
+		}
+		return tx.Write("b", Entry{Value: []byte("1")}, PutOverwrite)
+	})
+	if err != ErrKeyNotDeclared {
+		t.Fatalf("expected ErrKeyNotDeclared, got %v", err)
+	}
+
+	// Loops resume once the transaction is over
+	if val, ok := s.Read("a"); !ok || string(val.Value) != "1" {
+		t.Fatalf("expected committed write, got %+v", val)
+	}
+}
+
+func TestMultiLoopStore_IterateStopsEarly(t *testing.T) {
+	s := NewMultiLoopStore(4, 16)
+	for i := 0; i < 100; i++ {
+		_ = s.Write(strconv.Itoa(i), Entry{Value: []byte("v")}, PutOverwrite)
+	}
+
+	seen := 0
+	s.(Iterable).Iterate(func(string, Entry) bool {
+		seen++
+		return seen < 10
+	})
+	if seen != 10 {
+		t.Fatalf("expected iteration to stop after 10 keys, saw %d", seen)
+	}
+}
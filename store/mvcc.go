@@ -0,0 +1,303 @@
+package store
+
+import (
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+/*
+View is a read-only image of a store as of one commit sequence.
+
+Every write is stamped with a sequence from a global counter, and a
+batch or transaction stamps all of its keys with the same one. A view
+sees exactly the writes with a sequence up to its own, plus the TTLs
+as they stood at the instant it was taken, so reads across many keys
+and shards agree with each other without stopping writers.
+
+Superseded versions are kept only while some open view can still see
+them. A view must be closed once done; an open view pins the versions
+it needs in memory. Reads on a closed view report every key absent.
+*/
+type View interface {
+	Read(key string) (Entry, bool)
+	ReadBatch(keys []string) map[string]Entry
+
+	// Iterate visits every entry visible to the view exactly once,
+	// in chunks: the store is not locked while fn runs.
+	Iterate(fn func(key string, value Entry) bool)
+
+	// Sequence returns the commit sequence the view is pinned to.
+	Sequence() uint64
+
+	Close()
+}
+
+/*
+readPoint is the position a view reads at: a commit sequence and the
+instant (Unix milliseconds) used to evaluate TTLs.
+*/
+type readPoint struct {
+	seq uint64
+	now int64
+}
+
+/*
+oldVersion is a superseded entry and the sequence that superseded it
+(by overwrite or removal). It is visible to views in
+[entry.Version, end).
+*/
+type oldVersion struct {
+	entry Entry
+	end   uint64
+}
+
+/*
+viewRegistry tracks the sequences pinned by the open views of one
+store.
+
+Writers consult it to decide whether the version they replace must be
+kept. While no view is open the check is a single atomic load, so
+stores pay nothing for MVCC until it is used. Each store has its own,
+so a view left open on one store never makes another keep versions.
+*/
+type viewRegistry struct {
+	mu   sync.RWMutex
+	open atomic.Int64
+	seqs []uint64 // sorted, one element per open view
+}
+
+/*
+pin registers a view at the latest commit sequence.
+
+The view count is raised before the sequence is read: a writer that
+still sees no open views must have drawn its sequence earlier, so the
+version it replaces is older than anything the new view reads.
+*/
+func (r *viewRegistry) pin(now int64) readPoint {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.open.Add(1)
+	seq := versionCounter.Load()
+	i, _ := slices.BinarySearch(r.seqs, seq)
+	r.seqs = slices.Insert(r.seqs, i, seq)
+
+	return readPoint{seq: seq, now: now}
+}
+
+func (r *viewRegistry) unpin(seq uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if i, ok := slices.BinarySearch(r.seqs, seq); ok {
+		r.seqs = slices.Delete(r.seqs, i, i+1)
+		r.open.Add(-1)
+	}
+}
+
+func (r *viewRegistry) active() bool {
+	return r.open.Load() > 0
+}
+
+/*
+needed reports whether an open view reads at a sequence in [from, end),
+that is whether it can see a version living in that range.
+*/
+func (r *viewRegistry) needed(from, end uint64) bool {
+	if !r.active() {
+		return false
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	i, _ := slices.BinarySearch(r.seqs, from)
+	return i < len(r.seqs) && r.seqs[i] < end
+}
+
+/*
+prune drops the versions of a chain no open view can see.
+*/
+func (r *viewRegistry) prune(chain []oldVersion) []oldVersion {
+	if !r.active() {
+		return nil
+	}
+	return slices.DeleteFunc(chain, func(v oldVersion) bool {
+		return !r.needed(v.entry.Version, v.end)
+	})
+}
+
+/*
+viewSource is implemented by every store that serves views.
+
+readAt and scanAt mirror Read and ScanEntries at a read point. Since
+a view's contents never change, a scan at a read point returns each
+visible key exactly once.
+*/
+type viewSource interface {
+	readAt(key string, rp readPoint) (Entry, bool)
+	scanAt(cursor uint64, count int, rp readPoint) ([]KeyValue, uint64)
+}
+
+/*
+view is the View of every store; the store-specific part is the
+viewSource it reads from.
+*/
+type view struct {
+	src    viewSource
+	views  *viewRegistry
+	rp     readPoint
+	closed atomic.Bool
+}
+
+/*
+newView opens a view of src, pinned in the store's registry views.
+*/
+func newView(src viewSource, views *viewRegistry, clock Clock) *view {
+	return &view{
+		src:   src,
+		views: views,
+		rp:    views.pin(unixNow(clock)),
+	}
+}
+
+func (v *view) Read(key string) (Entry, bool) {
+	if v.closed.Load() {
+		return Entry{}, false
+	}
+	return v.src.readAt(key, v.rp)
+}
+
+/*
+ReadBatch reads key by key: every read is at the same point, so the
+result is consistent without holding the keys together.
+*/
+func (v *view) ReadBatch(keys []string) map[string]Entry {
+	result := make(map[string]Entry, len(keys))
+	for _, key := range keys {
+		if val, ok := v.Read(key); ok {
+			result[key] = val
+		}
+	}
+	return result
+}
+
+func (v *view) Iterate(fn func(key string, value Entry) bool) {
+	var cursor uint64
+	for !v.closed.Load() {
+		entries, next := v.src.scanAt(cursor, iterateChunk, v.rp)
+		for _, kv := range entries {
+			if !fn(kv.Key, kv.Entry) {
+				return
+			}
+		}
+
+		if next == 0 {
+			return
+		}
+		cursor = next
+	}
+}
+
+func (v *view) Sequence() uint64 {
+	return v.rp.seq
+}
+
+func (v *view) Close() {
+	if v.closed.CompareAndSwap(false, true) {
+		v.views.unpin(v.rp.seq)
+	}
+}
+
+/*
+retire is called before key is overwritten or removed at sequence end.
+It keeps the current version if an open view can still see it and
+drops older versions no view needs any more.
+*/
+func (s *store) retire(key string, end uint64) {
+	chain := s.data.versions(key)
+	if !s.views.active() && chain == nil {
+		return
+	}
+
+	if old, ok := s.data.get(key); ok && s.views.needed(old.Version, end) {
+		// Encoded, since a live value changes in place
+		chain = append([]oldVersion{{entry: old.materialized(), end: end}}, chain...)
+	}
+	s.data.setVersions(key, s.views.prune(chain))
+}
+
+/*
+readAt returns the version of key visible at rp.
+*/
+func (s *store) readAt(key string, rp readPoint) (Entry, bool) {
+	val, ok := s.data.get(key)
+	if !ok || val.Version > rp.seq {
+		val, ok = Entry{}, false
+		for _, old := range s.data.versions(key) {
+			if old.entry.Version <= rp.seq {
+				val, ok = old.entry, rp.seq < old.end
+				break
+			}
+		}
+	}
+
+	if !ok || val.expired(rp.now) {
+		return Entry{}, false
+	}
+	return val.materialized(), true
+}
+
+/*
+appendSlotAt appends the entries of one slot visible at rp, including
+keys that have since been removed.
+*/
+func (s *store) appendSlotAt(entries []KeyValue, slot int, rp readPoint) []KeyValue {
+	s.data.eachIn(slot, func(key string, _ Entry) bool {
+		if val, ok := s.readAt(key, rp); ok {
+			entries = append(entries, KeyValue{Key: key, Entry: val})
+		}
+		return true
+	})
+	for key := range s.data.superseded[slot] {
+		if s.data.has(key) {
+			continue
+		}
+		if val, ok := s.readAt(key, rp); ok {
+			entries = append(entries, KeyValue{Key: key, Entry: val})
+		}
+	}
+	return entries
+}
+
+/*
+scanAt visits whole slots from cursor like ScanEntries, at rp.
+*/
+func (s *store) scanAt(cursor uint64, count int, rp readPoint) ([]KeyValue, uint64) {
+	var entries []KeyValue
+	for slot := cursor; slot < scanSlots; slot++ {
+		entries = s.appendSlotAt(entries, int(slot), rp)
+		if len(entries) >= count && slot+1 < scanSlots {
+			return entries, slot + 1
+		}
+	}
+	return entries, 0
+}
+
+/*
+collectVersions prunes the version chains of one slot.
+*/
+func (s *store) collectVersions(slot int) {
+	for key, chain := range s.data.superseded[slot] {
+		s.data.setVersions(key, s.views.prune(chain))
+	}
+}
+
+/*
+Snapshot returns a view of the store. Like every other method of the
+plain store it must not be used concurrently with writes.
+*/
+func (s *store) Snapshot() View {
+	return newView(s, s.views, s.clock)
+}
@@ -0,0 +1,224 @@
+package store
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSnapshot_StableUnderWrites(t *testing.T) {
+	for _, sc := range storeCases {
+		t.Run(sc.name, func(t *testing.T) {
+			s := sc.new()
+			defer s.Close()
+
+			_ = s.Write("a", Entry{Value: []byte("a1")}, PutOverwrite)
+			_ = s.Write("b", Entry{Value: []byte("b1")}, PutOverwrite)
+
+			view := s.Snapshot()
+			defer view.Close()
+
+			_ = s.Write("a", Entry{Value: []byte("a2")}, PutOverwrite)
+			_ = s.Write("c", Entry{Value: []byte("c1")}, PutOverwrite)
+			_ = s.Expire("b", GetUnixTimestamp(time.Now().Add(-time.Millisecond)))
+
+			if got, ok := view.Read("a"); !ok || string(got.Value) != "a1" {
+				t.Fatalf("view must keep a=a1, got %q %v", got.Value, ok)
+			}
+			if got, ok := view.Read("b"); !ok || string(got.Value) != "b1" {
+				t.Fatalf("view must keep expired b, got %q %v", got.Value, ok)
+			}
+			if _, ok := view.Read("c"); ok {
+				t.Fatalf("view must not see c written after it")
+			}
+
+			seen := make(map[string]string)
+			view.Iterate(func(key string, value Entry) bool {
+				seen[key] = string(value.Value)
+				return true
+			})
+			if len(seen) != 2 || seen["a"] != "a1" || seen["b"] != "b1" {
+				t.Fatalf("unexpected view contents: %v", seen)
+			}
+
+			if got, ok := s.Read("a"); !ok || string(got.Value) != "a2" {
+				t.Fatalf("store must read a=a2, got %q %v", got.Value, ok)
+			}
+			if _, ok := s.Read("b"); ok {
+				t.Fatalf("store must not read expired b")
+			}
+
+			view.Close()
+			if _, ok := view.Read("a"); ok {
+				t.Fatalf("closed view must report keys absent")
+			}
+		})
+	}
+}
+
+func TestSnapshot_SeesBatchesWhole(t *testing.T) {
+	const keys = 16
+
+	for _, sc := range storeCases {
+		t.Run(sc.name, func(t *testing.T) {
+			s := sc.new()
+			defer s.Close()
+
+			names := make([]string, keys)
+			for i := range names {
+				names[i] = fmt.Sprintf("k%d", i)
+			}
+
+			stop := make(chan struct{})
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for round := 0; ; round++ {
+					select {
+					case <-stop:
+						return
+					default:
+					}
+
+					value := []byte(strconv.Itoa(round))
+					entries := make([]KeyValue, keys)
+					for i, key := range names {
+						entries[i] = KeyValue{Key: key, Entry: Entry{Value: value}}
+					}
+					_ = s.WriteBatch(entries, PutOverwrite)
+				}
+			}()
+
+			for i := 0; i < 200; i++ {
+				view := s.Snapshot()
+
+				values := make(map[string]bool)
+				for _, val := range view.ReadBatch(names) {
+					values[string(val.Value)] = true
+				}
+				view.Iterate(func(key string, value Entry) bool {
+					values[string(value.Value)] = true
+					return true
+				})
+				view.Close()
+
+				if len(values) > 1 {
+					close(stop)
+					wg.Wait()
+					t.Fatalf("view saw a torn batch: %v", values)
+				}
+			}
+
+			close(stop)
+			wg.Wait()
+		})
+	}
+}
+
+func TestSnapshot_VersionsCollectedAfterClose(t *testing.T) {
+	s := NewLockedStore(WithSweepInterval(0)).(*lockedStore)
+	defer s.Close()
+
+	_ = s.Write("a", Entry{Value: []byte("v1")}, PutOverwrite)
+	view := s.Snapshot()
+
+	for i := 2; i <= 4; i++ {
+		_ = s.Write("a", Entry{Value: []byte("v" + strconv.Itoa(i))}, PutOverwrite)
+	}
+
+	// Only the version the view reads is kept
+	if n := len(s.store.data.versions("a")); n != 1 {
+		t.Fatalf("expected 1 old version, got %d", n)
+	}
+
+	view.Close()
+	s.SweepExpired()
+
+	if n := len(s.store.data.versions("a")); n != 0 {
+		t.Fatalf("expected old versions to be collected, got %d", n)
+	}
+}
+
+func TestSnapshot_KeepsNothingWithoutViews(t *testing.T) {
+	s := NewLockedStore(WithSweepInterval(0)).(*lockedStore)
+	defer s.Close()
+
+	for i := 0; i < 10; i++ {
+		_ = s.Write("a", Entry{Value: []byte(strconv.Itoa(i))}, PutOverwrite)
+	}
+
+	if n := len(s.store.data.versions("a")); n != 0 {
+		t.Fatalf("expected no old versions without views, got %d", n)
+	}
+}
+
+func TestSnapshot_ViewsPinOnlyTheirStore(t *testing.T) {
+	other := NewShardedStore(2, WithSweepInterval(0))
+	defer other.Close()
+	view := other.Snapshot()
+	defer view.Close()
+
+	s := NewLockedStore(WithSweepInterval(0)).(*lockedStore)
+	defer s.Close()
+
+	for i := 0; i < 10; i++ {
+		_ = s.Write("a", Entry{Value: []byte(strconv.Itoa(i))}, PutOverwrite)
+	}
+
+	if n := len(s.store.data.versions("a")); n != 0 {
+		t.Fatalf("expected a view of another store to keep nothing, got %d old versions", n)
+	}
+}
+
+func TestSnapshot_IterateDuringReshard(t *testing.T) {
+	s := NewShardedStore(3).(*shardedStore)
+	defer s.Close()
+
+	const keys = 3000
+	for i := 0; i < keys; i++ {
+		_ = s.Write("key:"+strconv.Itoa(i), Entry{Value: []byte("old")}, PutOverwrite)
+	}
+
+	view := s.Snapshot()
+	defer view.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, n := range []int{11, 2, 9, 4} {
+			_ = s.Reshard(n)
+			for i := 0; i < keys; i += 7 {
+				_ = s.Write("key:"+strconv.Itoa(i), Entry{Value: []byte("new")}, PutOverwrite)
+			}
+		}
+	}()
+
+	for {
+		seen := make(map[string]int, keys)
+		view.Iterate(func(key string, value Entry) bool {
+			if string(value.Value) != "old" {
+				t.Fatalf("view saw %s=%s written after it", key, value.Value)
+			}
+			seen[key]++
+			return true
+		})
+
+		if len(seen) != keys {
+			t.Fatalf("view saw %d keys, want %d", len(seen), keys)
+		}
+		for key, n := range seen {
+			if n != 1 {
+				t.Fatalf("view saw %s %d times", key, n)
+			}
+		}
+
+		select {
+		case <-done:
+			return
+		default:
+		}
+	}
+}
@@ -0,0 +1,437 @@
+package store
+
+import (
+	"errors"
+	"strconv"
+)
+
+/*
+Errors returned by ops on typed values.
+*/
+var (
+	ErrUnknownOp = errors.New("unknown operation")
+
+	// ErrOpArgs is returned when an op is given the wrong number of
+	// arguments.
+	ErrOpArgs = errors.New("wrong number of arguments for operation")
+
+	// ErrNotInteger is returned when an op expects an integer, as an
+	// argument or as the value it works on, and gets something else.
+	ErrNotInteger = errors.New("value is not an integer or out of range")
+
+	// ErrOverflow is returned when an increment would overflow.
+	ErrOverflow = errors.New("increment or decrement would overflow")
+
+	// ErrNotFloat is returned when an op expects a number, such as a
+	// score, and gets something else.
+	ErrNotFloat = errors.New("value is not a valid float")
+
+	// ErrScoreNaN is returned when an increment would leave a score
+	// that is not a number, such as adding -inf to +inf.
+	ErrScoreNaN = errors.New("resulting score is not a number (NaN)")
+
+	// ErrSyntax is returned when an op is given an option it does not
+	// know.
+	ErrSyntax = errors.New("syntax error")
+
+	// ErrInvalidStreamID is returned when a stream ID argument cannot
+	// be parsed.
+	ErrInvalidStreamID = errors.New("invalid stream ID specified as stream command argument")
+
+	// ErrStreamIDTooSmall is returned when XADD is given an ID that does
+	// not follow the stream's last one.
+	ErrStreamIDTooSmall = errors.New("the ID specified in XADD is equal or smaller than the target stream top item")
+
+	// ErrNoGroup is returned when a consumer group does not exist.
+	ErrNoGroup = errors.New("no such key or consumer group")
+
+	// ErrGroupExists is returned when creating a consumer group under a
+	// name already taken.
+	ErrGroupExists = errors.New("consumer group name already exists")
+
+	// ErrNoStream is returned when creating a consumer group on a
+	// missing stream without MKSTREAM.
+	ErrNoStream = errors.New("the stream must exist to create a group, unless MKSTREAM is given")
+
+	// ErrInvalidPath is returned when a JSON path cannot be parsed.
+	ErrInvalidPath = errors.New("invalid JSON path")
+
+	// ErrInvalidJSON is returned when a JSON op is given a value that
+	// is not JSON.
+	ErrInvalidJSON = errors.New("invalid JSON value")
+
+	// ErrJSONNewRoot is returned when JSON.SET creates a document at
+	// another path than the root.
+	ErrJSONNewRoot = errors.New("new JSON documents must be created at the root path")
+
+	// errCorruptValue is returned when a stored typed value cannot be
+	// decoded.
+	errCorruptValue = errors.New("corrupt typed value")
+)
+
+/*
+Op is an operation on a value of a type other than string: a query,
+such as LRANGE, or a mutation, such as LPUSH. Ops are named after the
+commands that run them.
+
+A mutation changes the value in place rather than replacing it, and
+stores that log their writes log the op itself: pushing onto a long
+list costs a record of the pushed elements, not of the whole list.
+Replay must rebuild the same value, so an op depends only on the value
+it runs against, its Args and Time, never on the clock.
+*/
+type Op struct {
+	Name string
+	Args []string
+
+	// Time is when the op runs, in Unix milliseconds. A mutation
+	// stamps it as the value's write time.
+	Time int64
+}
+
+/*
+Applier is implemented by stores, and by their transactions, that run
+ops themselves instead of reading and rewriting the value: the
+in-memory stores, which keep typed values decoded between ops, and
+the stores wrapping them, such as the walStore, which logs mutations
+as ops.
+*/
+type Applier interface {
+	Apply(key string, op Op) (any, error)
+}
+
+/*
+Apply runs op against the value of key and returns its result: nil,
+an int64, a string, or a []string or []any of results.
+
+A missing key holds an empty value of the op's type, and a mutation
+that leaves the value empty removes the key. A key holding another
+type fails with ErrWrongType. The key's TTL is kept.
+
+Stores implementing Applier run op themselves. Otherwise a query reads
+and decodes the value, and a mutation also encodes and rewrites it:
+under Atomic on a whole DataStore, directly on a Tx, which already
+holds the key.
+*/
+func Apply(st Tx, key string, op Op) (any, error) {
+	if a, ok := st.(Applier); ok {
+		return a.Apply(key, op)
+	}
+
+	spec, ok := opSpecs[op.Name]
+	if !ok {
+		return nil, ErrUnknownOp
+	}
+	if ds, ok := st.(DataStore); ok && !spec.query {
+		return applyAtomic(ds, key, op)
+	}
+
+	res, err := runOp(st, key, op)
+	if err != nil {
+		return nil, err
+	}
+	return res.reply, res.commit(st, key)
+}
+
+/*
+applyAtomic runs op under Atomic on key, through the Tx of ds.
+*/
+func applyAtomic(ds DataStore, key string, op Op) (any, error) {
+	var reply any
+	err := ds.Atomic([]string{key}, func(tx Tx) error {
+		var err error
+		reply, err = Apply(tx, key, op)
+		return err
+	})
+	return reply, err
+}
+
+/*
+isQuery reports whether op only reads. Unknown ops are not queries;
+running them fails.
+*/
+func isQuery(op Op) bool {
+	spec, ok := opSpecs[op.Name]
+	return ok && spec.query
+}
+
+/*
+typedValue is the decoded form of a value of a type other than string.
+Ops change it in place. The in-memory stores keep it as is between
+ops (see liveTx); other stores encode it to store it.
+*/
+type typedValue interface {
+	encode() []byte
+
+	// empty reports whether the value holds nothing, which removes
+	// its key.
+	empty() bool
+}
+
+/*
+valueDecoders decode the stored values of each type. Decoding no
+bytes returns an empty value.
+*/
+var valueDecoders = map[ValueType]func(data []byte) (typedValue, error){
+	TypeList:   decodeList,
+	TypeHash:   decodeHash,
+	TypeSet:    decodeSet,
+	TypeZSet:   decodeZSet,
+	TypeStream: decodeStream,
+	TypeJSON:   decodeJSON,
+}
+
+/*
+opSpec describes an op: the type it works on, whether it only reads,
+and how it runs against a decoded value.
+
+run never changes the value itself. It returns the op's reply and, for
+a mutation that changes something, a function applying the change,
+which its caller calls once the op is committed, after it was logged.
+A mutation that changes nothing returns no function, and so does one
+on a missing key that would leave it empty: either logs nothing.
+*/
+type opSpec struct {
+	typ   ValueType
+	query bool
+	run   func(v typedValue, op Op) (reply any, change func(), err error)
+}
+
+// opSpecs holds every op by name, gathered from the op table of each type.
+var opSpecs = joinOps(listOps, hashOps, setOps, zsetOps, streamOps, streamGroupOps, jsonOps)
+
+/*
+typedOp adapts an op on values of type typ, decoded as V, to an
+opSpec. minArgs and maxArgs bound the number of arguments, maxArgs < 0
+meaning no bound.
+*/
+func typedOp[V typedValue](typ ValueType, query bool, minArgs, maxArgs int, fn func(v V, args []string) (any, func(), error)) opSpec {
+	return opSpec{
+		typ:   typ,
+		query: query,
+		run: func(v typedValue, op Op) (any, func(), error) {
+			if len(op.Args) < minArgs || (maxArgs >= 0 && len(op.Args) > maxArgs) {
+				return nil, nil, ErrOpArgs
+			}
+			return fn(v.(V), op.Args)
+		},
+	}
+}
+
+/*
+queryOp adapts a query, which never changes its value, to the
+signature of typedOp.
+*/
+func queryOp[V typedValue](fn func(v V, args []string) (any, error)) func(v V, args []string) (any, func(), error) {
+	return func(v V, args []string) (any, func(), error) {
+		reply, err := fn(v, args)
+		return reply, nil, err
+	}
+}
+
+func joinOps(tables ...map[string]opSpec) map[string]opSpec {
+	all := make(map[string]opSpec)
+	for _, table := range tables {
+		for name, spec := range table {
+			all[name] = spec
+		}
+	}
+	return all
+}
+
+/*
+opResult is the outcome of running an op against a key's value: its
+reply, and the change it makes if it is a mutation that changes
+something.
+*/
+type opResult struct {
+	reply  any
+	change func()
+
+	// value is the value the op ran against, of type typ, which change
+	// changes. found reports whether the key existed, with TTL
+	// expiresAt.
+	value     typedValue
+	typ       ValueType
+	found     bool
+	expiresAt int64
+
+	// time is the op's time, which becomes the value's write time.
+	time int64
+}
+
+/*
+runOp runs op against the value of key read through tx, the live one
+if tx keeps values decoded. Nothing is changed: the caller applies the
+result with commit, or logs it first.
+*/
+func runOp(tx Tx, key string, op Op) (opResult, error) {
+	spec, ok := opSpecs[op.Name]
+	if !ok {
+		return opResult{}, ErrUnknownOp
+	}
+
+	lt, ok := tx.(liveTx)
+	if !ok {
+		cur, found := tx.Read(key)
+		return runOpOn(cur, found, op)
+	}
+
+	cur, v, found, err := lt.readLive(key, spec.typ)
+	if err != nil {
+		return opResult{}, err
+	}
+	return spec.plan(v, op, cur, found)
+}
+
+/*
+runOpOn runs op against cur, the entry of a key that exists if found,
+decoded into a value of its own.
+*/
+func runOpOn(cur Entry, found bool, op Op) (opResult, error) {
+	spec, ok := opSpecs[op.Name]
+	if !ok {
+		return opResult{}, ErrUnknownOp
+	}
+
+	v, err := decodeTyped(cur, found, spec.typ)
+	if err != nil {
+		return opResult{}, err
+	}
+	return spec.plan(v, op, cur, found)
+}
+
+/*
+plan runs op against v, the value of an entry cur that exists if found.
+*/
+func (spec opSpec) plan(v typedValue, op Op, cur Entry, found bool) (opResult, error) {
+	reply, change, err := spec.run(v, op)
+	if err != nil {
+		return opResult{}, err
+	}
+	return opResult{
+		reply:     reply,
+		change:    change,
+		value:     v,
+		typ:       spec.typ,
+		found:     found,
+		expiresAt: cur.ExpiresAtMillis,
+		time:      op.Time,
+	}, nil
+}
+
+/*
+decodeTyped decodes cur, the entry of a key that exists if found, as a
+value of type typ. A missing key decodes as an empty value.
+*/
+func decodeTyped(cur Entry, found bool, typ ValueType) (typedValue, error) {
+	if !found {
+		return valueDecoders[typ](nil)
+	}
+	if cur.Type != typ {
+		return nil, ErrWrongType
+	}
+	cur, ok := DecodeEntry(cur)
+	if !ok {
+		return nil, ErrUnknownEncoding
+	}
+	return valueDecoders[typ](cur.Value)
+}
+
+/*
+changes reports whether the op changes its value, and so whether it is
+committed and logged at all.
+*/
+func (r opResult) changes() bool {
+	return r.change != nil
+}
+
+/*
+commit applies the change of a mutation through tx: in place on a live
+value, otherwise by writing the value encoded. There is no delete, so
+a key is removed by expiring it at once, like a snapshot tombstone: it
+reads as missing from then on and the sweeper drops it.
+*/
+func (r opResult) commit(tx Tx, key string) error {
+	if !r.changes() {
+		return nil
+	}
+	if lt, ok := tx.(liveTx); ok {
+		return lt.commitLive(key, r)
+	}
+
+	r.change()
+	switch {
+	case !r.value.empty():
+		return tx.Write(key, r.entry(r.value.encode()), PutOverwrite)
+	case r.found:
+		tx.Expire(key, tombstoneExpiry)
+	}
+	return nil
+}
+
+/*
+entry returns the entry of the changed value, holding value as its
+encoded form.
+*/
+func (r opResult) entry(value []byte) Entry {
+	return Entry{
+		Value:           value,
+		Type:            r.typ,
+		ExpiresAtMillis: r.expiresAt,
+		WrittenAtMillis: r.time,
+	}
+}
+
+/*
+intArg parses an integer argument, such as an index.
+*/
+func intArg(arg string) (int, error) {
+	n, err := strconv.Atoi(arg)
+	if err != nil {
+		return 0, ErrNotInteger
+	}
+	return n, nil
+}
+
+/*
+int64Arg parses an integer argument used as a counter.
+*/
+func int64Arg(arg string) (int64, error) {
+	n, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return 0, ErrNotInteger
+	}
+	return n, nil
+}
+
+/*
+addInt64 returns a+b, or ErrOverflow if it does not fit in an int64.
+*/
+func addInt64(a, b int64) (int64, error) {
+	sum := a + b
+	if (b > 0 && sum < a) || (b < 0 && sum > a) {
+		return 0, ErrOverflow
+	}
+	return sum, nil
+}
+
+/*
+indexRange resolves a start/stop pair of indexes into n elements,
+inclusive and counting from the end when negative like in Redis, to a
+slice range. The range is empty when nothing lies between them.
+*/
+func indexRange(n, start, stop int) (int, int) {
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	start = max(start, 0)
+	stop = min(stop, n-1)
+	if start > stop {
+		return 0, 0
+	}
+	return start, stop + 1
+}
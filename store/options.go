@@ -0,0 +1,144 @@
+package store
+
+import (
+	"time"
+
+	"hermes/wal"
+)
+
+/*
+Option configures optional behavior of a store at construction time.
+
+Options keep constructor signatures stable as stores gain knobs:
+callers that do not care pass nothing and get the defaults.
+*/
+type Option func(*options)
+
+/*
+options collects every optional setting. Each constructor reads
+only the fields relevant to it.
+*/
+type options struct {
+	hash          HashFunc
+	sweepInterval time.Duration
+	history       historyPolicies
+	clock         Clock
+	syncPolicy    wal.SyncPolicy
+	maxFileSize   int64
+	mergeInterval time.Duration
+	slab          bool
+
+	// views is not a setting: it is the view registry of the store
+	// built from these options, shared by all of its shards or loops
+	// since a view spans them and keys move between them.
+	views *viewRegistry
+}
+
+/*
+WithHashFunc replaces the hash used by the sharded store to map keys
+(or their hash tags) to shards. The default is 32-bit FNV-1a.
+*/
+func WithHashFunc(hash HashFunc) Option {
+	return func(o *options) {
+		o.hash = hash
+	}
+}
+
+/*
+WithSweepInterval sets how often expired entries are actively removed.
+Reads treat expired entries as absent without deleting them, so the
+sweeper is what reclaims memory. A non-positive interval disables it;
+SweepExpired can then be called manually.
+*/
+func WithSweepInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.sweepInterval = interval
+	}
+}
+
+/*
+WithHistory records the history of the keys covered by the policies
+(see HistoryPolicy), served through Historian. Without it no history
+is kept.
+*/
+func WithHistory(policies ...HistoryPolicy) Option {
+	return func(o *options) {
+		o.history = newHistoryPolicies(policies)
+	}
+}
+
+/*
+WithClock sets the clock used for expiration, write times and views.
+The default is SystemClock; tests pass a ManualClock to control TTLs.
+Stores and the walStore wrapping them should share one clock.
+*/
+func WithClock(clock Clock) Option {
+	return func(o *options) {
+		o.clock = clock
+	}
+}
+
+/*
+WithSyncPolicy sets when the Bitcask store fsyncs its active data
+file, with the WAL's meaning: after every write (the default), or
+every given interval.
+*/
+func WithSyncPolicy(policy wal.SyncPolicy) Option {
+	return func(o *options) {
+		o.syncPolicy = policy
+	}
+}
+
+/*
+WithMaxFileSize sets the size at which the Bitcask store seals its
+active data file and starts a new one. The default is 64 MiB.
+*/
+func WithMaxFileSize(size int64) Option {
+	return func(o *options) {
+		o.maxFileSize = size
+	}
+}
+
+/*
+WithMergeInterval sets how often the Bitcask store checks whether its
+sealed data files hold enough stale records to be merged. A
+non-positive interval disables automatic merges; Merge can then be
+called manually.
+*/
+func WithMergeInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.mergeInterval = interval
+	}
+}
+
+/*
+WithSlabStorage packs the in-memory stores' keys and values into large
+slabs indexed by pointer-free maps (see slabTable), instead of a map of
+strings to entries. The garbage collector then no longer scans every
+key and value, which keeps GC cycles short on stores with millions of
+small entries, at the cost of copying values out on reads.
+*/
+func WithSlabStorage() Option {
+	return func(o *options) {
+		o.slab = true
+	}
+}
+
+/*
+newOptions applies opts on top of the defaults.
+*/
+func newOptions(opts []Option) options {
+	o := options{
+		hash:          hashString,
+		sweepInterval: defaultSweepInterval,
+		clock:         systemClock,
+		syncPolicy:    wal.SyncEveryWrite,
+		maxFileSize:   defaultMaxFileSize,
+		mergeInterval: defaultMergeInterval,
+		views:         &viewRegistry{},
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
@@ -0,0 +1,156 @@
+package store
+
+/*
+Ordered is implemented by stores that keep their keys sorted and can
+serve range and prefix queries.
+
+Ranges are half-open: start is inclusive, end is exclusive, and an
+empty end means no upper bound. fn sees live entries only, in
+ascending key order (descending for ReverseRange); returning false
+stops the traversal.
+*/
+type Ordered interface {
+	Range(start, end string, fn func(key string, value Entry) bool)
+	ReverseRange(start, end string, fn func(key string, value Entry) bool)
+	Prefix(prefix string, fn func(key string, value Entry) bool)
+}
+
+/*
+AsOrdered returns st as Ordered if it serves ordered traversals: it
+implements Ordered and, if it wraps another store, that store does
+too. Callers check for the interface with AsOrdered rather than a
+type assertion, which decorators always pass (see decorator).
+*/
+func AsOrdered(st any) (Ordered, bool) {
+	o, ok := st.(Ordered)
+	if !ok {
+		return nil, false
+	}
+	if d, ok := st.(decorator); ok {
+		if _, ok := AsOrdered(d.unwrap()); !ok {
+			return nil, false
+		}
+	}
+	return o, true
+}
+
+/*
+orderedStore is a lockedStore whose keyspace also keeps its keys in a
+skip list.
+
+Point operations, batches, transactions, scans and sweeping are the
+lockedStore ones: the skip list follows every insert and delete inside
+the keyspace, so no write path needs to know about it. On top of that
+the store serves ordered traversals, and Iterate runs in key order,
+which also makes its snapshots sorted.
+*/
+type orderedStore struct {
+	*lockedStore
+}
+
+/*
+NewOrderedStore creates a store with ordered keys behind a global
+lock. It accepts the same options as NewLockedStore.
+*/
+func NewOrderedStore(opts ...Option) DataStore {
+	st := &store{
+		data: keyspace{index: newSkipList()},
+	}
+	return &orderedStore{
+		lockedStore: newLockedStore(st, newOptions(opts)),
+	}
+}
+
+/*
+Range visits the keys in [start, end) in ascending order under the
+read lock. fn must not call back into the store.
+*/
+func (s *orderedStore) Range(start, end string, fn func(key string, value Entry) bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	s.store.ascend(start, end, fn)
+}
+
+/*
+ReverseRange visits the keys in [start, end) in descending order.
+*/
+func (s *orderedStore) ReverseRange(start, end string, fn func(key string, value Entry) bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	s.store.descend(start, end, fn)
+}
+
+/*
+Prefix visits every key starting with prefix in ascending order.
+*/
+func (s *orderedStore) Prefix(prefix string, fn func(key string, value Entry) bool) {
+	s.Range(prefix, prefixEnd(prefix), fn)
+}
+
+/*
+Iterate visits every live entry in ascending key order.
+*/
+func (s *orderedStore) Iterate(fn func(key string, value Entry) bool) {
+	s.Range("", "", fn)
+}
+
+/*
+prefixEnd returns the smallest key greater than every key starting
+with prefix, or "" when there is none (the prefix is empty or all
+0xff bytes).
+*/
+func prefixEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+	return ""
+}
+
+/*
+ascend walks the index from start up to end, skipping expired entries.
+The store must have an ordered keyspace.
+*/
+func (s *store) ascend(start, end string, fn func(key string, value Entry) bool) {
+	now := s.now()
+	for n := s.data.index.seek(start); n != nil; n = n.next[0] {
+		if end != "" && n.key >= end {
+			return
+		}
+
+		val, _ := s.data.get(n.key)
+		if val.expired(now) {
+			continue
+		}
+		if !fn(n.key, val.materialized()) {
+			return
+		}
+	}
+}
+
+/*
+descend is ascend in reverse, starting from the last key before end.
+*/
+func (s *store) descend(start, end string, fn func(key string, value Entry) bool) {
+	now := s.now()
+
+	n := s.data.index.tail
+	if end != "" {
+		n = s.data.index.seekBefore(end)
+	}
+
+	for ; n != nil && n.key >= start; n = n.prev {
+		val, _ := s.data.get(n.key)
+		if val.expired(now) {
+			continue
+		}
+		if !fn(n.key, val.materialized()) {
+			return
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package store
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSkipList_MatchesSortedModel(t *testing.T) {
+	l := newSkipList()
+	model := make(map[string]bool)
+
+	for i := 0; i < 5000; i++ {
+		key := fmt.Sprintf("k%03d", rand.IntN(500))
+		if model[key] {
+			l.delete(key)
+			delete(model, key)
+		} else {
+			l.insert(key)
+			model[key] = true
+		}
+	}
+
+	want := make([]string, 0, len(model))
+	for key := range model {
+		want = append(want, key)
+	}
+	slices.Sort(want)
+
+	var forward []string
+	for n := l.seek(""); n != nil; n = n.next[0] {
+		forward = append(forward, n.key)
+	}
+	if !slices.Equal(forward, want) {
+		t.Fatalf("forward order mismatch:\n got %v\nwant %v", forward, want)
+	}
+
+	var backward []string
+	for n := l.tail; n != nil; n = n.prev {
+		backward = append(backward, n.key)
+	}
+	slices.Reverse(backward)
+	if !slices.Equal(backward, want) {
+		t.Fatalf("backward order mismatch:\n got %v\nwant %v", backward, want)
+	}
+}
+
+func collectKeys(visit func(fn func(key string, value Entry) bool)) []string {
+	var keys []string
+	visit(func(key string, _ Entry) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return keys
+}
+
+func TestOrderedStore_RangePrefixReverse(t *testing.T) {
+	s := NewOrderedStore()
+	defer s.Close()
+
+	for _, key := range []string{"b", "user:2", "a", "user:10", "user:1", "users", "c"} {
+		_ = s.Write(key, Entry{Value: []byte(key)}, PutOverwrite)
+	}
+	o := s.(Ordered)
+
+	tests := []struct {
+		name  string
+		visit func(fn func(key string, value Entry) bool)
+		want  []string
+	}{
+		{
+			name:  "iterate in key order",
+			visit: s.(Iterable).Iterate,
+			want:  []string{"a", "b", "c", "user:1", "user:10", "user:2", "users"},
+		},
+		{
+			name:  "half-open range",
+			visit: func(fn func(string, Entry) bool) { o.Range("b", "user:10", fn) },
+			want:  []string{"b", "c", "user:1"},
+		},
+		{
+			name:  "unbounded end",
+			visit: func(fn func(string, Entry) bool) { o.Range("user:2", "", fn) },
+			want:  []string{"user:2", "users"},
+		},
+		{
+			name:  "reverse range",
+			visit: func(fn func(string, Entry) bool) { o.ReverseRange("b", "user:10", fn) },
+			want:  []string{"user:1", "c", "b"},
+		},
+		{
+			name:  "reverse unbounded",
+			visit: func(fn func(string, Entry) bool) { o.ReverseRange("", "", fn) },
+			want:  []string{"users", "user:2", "user:10", "user:1", "c", "b", "a"},
+		},
+		{
+			name:  "prefix",
+			visit: func(fn func(string, Entry) bool) { o.Prefix("user:", fn) },
+			want:  []string{"user:1", "user:10", "user:2"},
+		},
+		{
+			name:  "prefix without matches",
+			visit: func(fn func(string, Entry) bool) { o.Prefix("zzz", fn) },
+			want:  nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := collectKeys(tt.visit); !slices.Equal(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOrderedStore_SkipsExpiredAndFollowsDeletes(t *testing.T) {
+	s := NewOrderedStore(WithSweepInterval(0))
+	defer s.Close()
+
+	for _, key := range []string{"a", "b", "c"} {
+		_ = s.Write(key, Entry{Value: []byte(key)}, PutOverwrite)
+	}
+	s.Expire("b", GetUnixTimestamp(time.Now().Add(-time.Millisecond)))
+
+	if got := collectKeys(s.(Iterable).Iterate); !slices.Equal(got, []string{"a", "c"}) {
+		t.Fatalf("expected expired key to be skipped, got %v", got)
+	}
+
+	// Sweeping drops the key from the index too
+	s.(Sweeper).SweepExpired()
+	if got := collectKeys(func(fn func(string, Entry) bool) {
+		s.(Ordered).ReverseRange("", "", fn)
+	}); !slices.Equal(got, []string{"c", "a"}) {
+		t.Fatalf("expected swept key gone from index, got %v", got)
+	}
+}
+
+func TestPrefixEnd(t *testing.T) {
+	tests := map[string]string{
+		"":         "",
+		"abc":      "abd",
+		"a\xff":    "b",
+		"\xff\xff": "",
+		"user:":    "user;",
+	}
+	for prefix, want := range tests {
+		if got := prefixEnd(prefix); got != want {
+			t.Errorf("prefixEnd(%q) = %q, want %q", prefix, got, want)
+		}
+	}
+}
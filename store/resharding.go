@@ -0,0 +1,475 @@
+package store
+
+import (
+	"errors"
+	"slices"
+)
+
+// ErrInvalidShardCount is returned when resharding to fewer than one shard.
+var ErrInvalidShardCount = errors.New("shard count must be positive")
+
+/*
+Resharder is implemented by stores whose partition count can change
+at runtime without blocking traffic.
+*/
+type Resharder interface {
+	Reshard(numShards int) error
+}
+
+// migrationBatchSize bounds how many keys move per lock acquisition.
+const migrationBatchSize = 256
+
+/*
+shardLayout is an immutable description of key placement.
+
+Keys are placed with jump consistent hashing, so changing the shard
+count only moves the minimum number of keys:
+
+- growing n -> m moves keys from old shards to new shards only
+- shrinking n -> m moves keys from removed shards to kept shards only
+
+While a reshard is in progress prev holds the old shards and a key may
+still live in its old shard (the source) until it is migrated. Lookups
+check both: the dual-lookup phase.
+*/
+type shardLayout struct {
+	shards []*shard
+	prev   []*shard
+
+	// epoch counts published layouts. Scan cursors carry it so a step
+	// can tell whether the shard order changed since the last one.
+	epoch uint64
+}
+
+func (l *shardLayout) migrating() bool {
+	return l.prev != nil
+}
+
+/*
+shardRoute names the shards a key may live in.
+
+owner is where the key belongs in the current layout; src is the
+shard it may still occupy during migration, or nil.
+*/
+type shardRoute struct {
+	owner *shard
+	src   *shard
+}
+
+func (l *shardLayout) route(h uint32) shardRoute {
+	owner := l.shards[jumpHash(uint64(h), len(l.shards))]
+	if l.prev == nil {
+		return shardRoute{owner: owner}
+	}
+
+	src := l.prev[jumpHash(uint64(h), len(l.prev))]
+	if src == owner || src.drained.Load() {
+		return shardRoute{owner: owner}
+	}
+	return shardRoute{owner: owner, src: src}
+}
+
+/*
+iterationOrder lists every shard once, sources before targets.
+
+Keys only move from sources to targets, so visiting all sources first
+guarantees a migrating key is never skipped: it is either seen in its
+source, or found later in its target.
+*/
+func (l *shardLayout) iterationOrder() []*shard {
+	if l.prev == nil {
+		return l.shards
+	}
+
+	current := make(map[*shard]struct{}, len(l.shards))
+	for _, sh := range l.shards {
+		current[sh] = struct{}{}
+	}
+
+	order := make([]*shard, 0, len(l.shards)+len(l.prev))
+	kept := make(map[*shard]struct{}, len(l.prev))
+
+	// Removed shards (sources when shrinking)
+	for _, sh := range l.prev {
+		if _, ok := current[sh]; ok {
+			kept[sh] = struct{}{}
+			continue
+		}
+		order = append(order, sh)
+	}
+
+	// Shards in both layouts (sources when growing, targets when shrinking)
+	for _, sh := range l.prev {
+		if _, ok := kept[sh]; ok {
+			order = append(order, sh)
+		}
+	}
+
+	// New shards (targets when growing)
+	for _, sh := range l.shards {
+		if _, ok := kept[sh]; !ok {
+			order = append(order, sh)
+		}
+	}
+	return order
+}
+
+/*
+jumpHash maps a key hash to one of buckets using jump consistent
+hashing (Lamping & Veach). Changing the bucket count from n to m moves
+only keys that must move, and never between two surviving buckets.
+*/
+func jumpHash(key uint64, buckets int) int {
+	var b, j int64 = -1, 0
+	for j < int64(buckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
+
+/*
+shardIndex returns the position of the shard owning key in the
+current layout.
+*/
+func (s *shardedStore) shardIndex(key string) int {
+	return jumpHash(uint64(s.keyHash(key)), len(s.layout.Load().shards))
+}
+
+/*
+lockShards locks the given shards in ascending id order, in shared
+mode for reads or exclusively for writes.
+*/
+func lockShards(shards []*shard, shared bool) {
+	slices.SortFunc(shards, func(a, b *shard) int {
+		switch {
+		case a.id < b.id:
+			return -1
+		case a.id > b.id:
+			return 1
+		}
+		return 0
+	})
+	for _, sh := range shards {
+		lockShard(sh, shared)
+	}
+}
+
+func unlockShards(shards []*shard, shared bool) {
+	for _, sh := range shards {
+		unlockShard(sh, shared)
+	}
+}
+
+func lockShard(sh *shard, shared bool) {
+	if shared {
+		sh.mu.RLock()
+	} else {
+		sh.mu.Lock()
+	}
+}
+
+func unlockShard(sh *shard, shared bool) {
+	if shared {
+		sh.mu.RUnlock()
+	} else {
+		sh.mu.Unlock()
+	}
+}
+
+func (r shardRoute) shards() []*shard {
+	if r.src == nil {
+		return []*shard{r.owner}
+	}
+	return []*shard{r.owner, r.src}
+}
+
+/*
+holder returns the store currently holding key. A key lives in exactly
+one shard, so while it waits in its source it is found there. Versions
+kept for views and recorded history travel with the key, so a removed
+key is found where those are.
+The caller holds every shard of the route.
+*/
+func (r shardRoute) holder(key string) *store {
+	if r.src != nil && r.src.store.data.holds(key) {
+		return r.src.store
+	}
+	return r.owner.store
+}
+
+/*
+lock locks the route's shards in ascending id order without allocating,
+since it sits on every single-key operation.
+*/
+func (r shardRoute) lock(shared bool) {
+	first, second := r.owner, r.src
+	if second != nil && second.id < first.id {
+		first, second = second, first
+	}
+
+	lockShard(first, shared)
+	if second != nil {
+		lockShard(second, shared)
+	}
+}
+
+func (r shardRoute) unlock(shared bool) {
+	unlockShard(r.owner, shared)
+	if r.src != nil {
+		unlockShard(r.src, shared)
+	}
+}
+
+/*
+lockKey locks every shard the key may live in and returns the store
+that holds it, together with the route to unlock.
+
+The route is recomputed after locking: if a reshard changed it in the
+meantime, the locks are released and the lookup retried.
+
+Exclusive callers then pull a key still waiting in its source shard
+into its owner, so writes always land on the owner. Shared callers
+must not mutate and simply read the key where it currently is.
+*/
+func (s *shardedStore) lockKey(key string, shared bool) (*store, shardRoute) {
+	h := s.keyHash(key)
+	for {
+		r := s.layout.Load().route(h)
+		r.lock(shared)
+
+		if s.layout.Load().route(h) == r {
+			if r.src != nil && !shared {
+				r.src.store.moveTo(r.owner.store, key)
+			}
+			return r.holder(key), r
+		}
+		r.unlock(shared)
+	}
+}
+
+/*
+heldShards is the result of lockKeys: the routes of a set of keys,
+with every shard involved locked.
+*/
+type heldShards struct {
+	routes map[string]shardRoute
+	locked []*shard
+	shared bool
+}
+
+/*
+store returns the store holding a key locked by h.
+*/
+func (h *heldShards) store(key string) *store {
+	return h.routes[key].holder(key)
+}
+
+func (h *heldShards) unlock() {
+	unlockShards(h.locked, h.shared)
+}
+
+/*
+lockKeys is the multi-key form of lockKey. Every shard involved is
+locked in one ascending-id pass, so batches and transactions never
+deadlock against each other or against migration.
+*/
+func (s *shardedStore) lockKeys(keys []string, shared bool) *heldShards {
+	hashes := make(map[string]uint32, len(keys))
+	for _, key := range keys {
+		hashes[key] = s.keyHash(key)
+	}
+
+	for {
+		l := s.layout.Load()
+		held := &heldShards{
+			routes: make(map[string]shardRoute, len(hashes)),
+			shared: shared,
+		}
+
+		involved := make(map[*shard]struct{})
+		for key, h := range hashes {
+			r := l.route(h)
+			held.routes[key] = r
+			for _, sh := range r.shards() {
+				if _, ok := involved[sh]; !ok {
+					involved[sh] = struct{}{}
+					held.locked = append(held.locked, sh)
+				}
+			}
+		}
+		lockShards(held.locked, shared)
+
+		if s.routesStable(held.routes, hashes) {
+			if !shared {
+				for key, r := range held.routes {
+					if r.src != nil {
+						r.src.store.moveTo(r.owner.store, key)
+					}
+				}
+			}
+			return held
+		}
+		held.unlock()
+	}
+}
+
+func (s *shardedStore) routesStable(routes map[string]shardRoute, hashes map[string]uint32) bool {
+	l := s.layout.Load()
+	for key, r := range routes {
+		if l.route(hashes[key]) != r {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+Reshard changes the number of shards while the store keeps serving.
+
+1. A migrating layout is published: new operations look a key up in
+   both its old and its new shard
+2. Each source shard is drained in small batches. Only the source and
+   the owners of the batch are locked, so traffic on other shards is
+   unaffected and no global pause is needed
+3. Once every source is drained the final layout is published
+
+Reshard returns when migration is complete. Calls are serialized;
+callers that do not want to wait can run it in a goroutine.
+*/
+func (s *shardedStore) Reshard(numShards int) error {
+	if numShards <= 0 {
+		return ErrInvalidShardCount
+	}
+
+	s.reshardMu.Lock()
+	defer s.reshardMu.Unlock()
+
+	old := s.layout.Load().shards
+	if numShards == len(old) {
+		return nil
+	}
+
+	shards := make([]*shard, numShards)
+	copy(shards, old)
+	for i := len(old); i < numShards; i++ {
+		shards[i] = newShard(s.opts)
+	}
+
+	var sources, targets []*shard
+	if numShards > len(old) {
+		sources, targets = old, shards[len(old):]
+	} else {
+		sources, targets = old[numShards:], shards
+	}
+
+	for _, src := range sources {
+		src.drained.Store(false)
+	}
+
+	target := &shardLayout{shards: shards, prev: old}
+	s.publish(target)
+
+	for _, src := range sources {
+		s.drain(src, targets, target)
+	}
+
+	s.publish(&shardLayout{shards: shards})
+	return nil
+}
+
+/*
+publish swaps the layout. Iterate holds layoutMu for reading, so a
+swap never happens in the middle of a traversal.
+*/
+func (s *shardedStore) publish(l *shardLayout) {
+	s.layoutMu.Lock()
+	l.epoch = s.layout.Load().epoch + 1
+	s.layout.Store(l)
+	s.layoutMu.Unlock()
+}
+
+/*
+drain moves every key that src no longer owns to its owner in l.
+
+Batches are collected under the source lock alone, then moved under
+the source and owner locks. A final pass holds the source and every
+target at once, so keys written by operations that routed before the
+layout swap are not left behind, and only then is src marked drained.
+*/
+func (s *shardedStore) drain(src *shard, targets []*shard, l *shardLayout) {
+	for {
+		src.mu.Lock()
+		keys := s.foreignKeys(src, l, migrationBatchSize)
+		src.mu.Unlock()
+
+		if len(keys) == 0 {
+			break
+		}
+		s.migrate(src, keys, l)
+	}
+
+	locked := append([]*shard{src}, targets...)
+	lockShards(locked, false)
+	defer unlockShards(locked, false)
+
+	for _, key := range s.foreignKeys(src, l, 0) {
+		src.store.moveTo(s.owner(l, key).store, key)
+	}
+	src.drained.Store(true)
+}
+
+/*
+foreignKeys lists up to limit keys stored in src that belong to another
+shard in l. A limit of 0 means no limit. The caller holds src.mu.
+*/
+func (s *shardedStore) foreignKeys(src *shard, l *shardLayout, limit int) []string {
+	var keys []string
+	src.store.data.each(func(key string, _ Entry) bool {
+		if s.owner(l, key) == src {
+			return true
+		}
+		keys = append(keys, key)
+		return limit == 0 || len(keys) < limit
+	})
+	if limit != 0 && len(keys) >= limit {
+		return keys
+	}
+
+	// Removed keys whose old versions or history are kept move too
+	src.store.data.detached(func(key string) bool {
+		if s.owner(l, key) != src {
+			keys = append(keys, key)
+		}
+		return limit == 0 || len(keys) < limit
+	})
+	return keys
+}
+
+/*
+migrate moves a batch of keys out of src under the source and owner
+locks. Keys already pulled by concurrent operations are skipped.
+*/
+func (s *shardedStore) migrate(src *shard, keys []string, l *shardLayout) {
+	locked := []*shard{src}
+	owners := make(map[string]*shard, len(keys))
+	for _, key := range keys {
+		owner := s.owner(l, key)
+		owners[key] = owner
+		if !slices.Contains(locked, owner) {
+			locked = append(locked, owner)
+		}
+	}
+
+	lockShards(locked, false)
+	defer unlockShards(locked, false)
+
+	for _, key := range keys {
+		src.store.moveTo(owners[key].store, key)
+	}
+}
+
+func (s *shardedStore) owner(l *shardLayout, key string) *shard {
+	return l.shards[jumpHash(uint64(s.keyHash(key)), len(l.shards))]
+}
@@ -0,0 +1,25 @@
+package store
+
+// iterateChunk is the scan count used when Iterate is served in steps.
+const iterateChunk = 256
+
+/*
+iterateByScan implements Iterate on top of a Scanner, calling fn on
+the caller goroutine between steps.
+*/
+func iterateByScan(sc Scanner, fn func(key string, value Entry) bool) {
+	var cursor uint64
+	for {
+		entries, next := sc.ScanEntries(cursor, iterateChunk)
+		for _, kv := range entries {
+			if !fn(kv.Key, kv.Entry) {
+				return
+			}
+		}
+
+		if next == 0 {
+			return
+		}
+		cursor = next
+	}
+}
@@ -0,0 +1,113 @@
+package store
+
+import "slices"
+
+/*
+setValue is the decoded form of a TypeSet value: a set of members,
+stored as their number followed by each member, in order so that
+equal sets encode alike.
+*/
+type setValue struct {
+	members map[string]struct{}
+}
+
+func decodeSet(data []byte) (typedValue, error) {
+	r := valueReader{data: data}
+	s := &setValue{}
+	if len(data) == 0 {
+		s.members = make(map[string]struct{})
+		return s, nil
+	}
+
+	n := r.count()
+	s.members = make(map[string]struct{}, n)
+	for i := 0; i < n; i++ {
+		s.members[r.string()] = struct{}{}
+	}
+	return s, r.finish()
+}
+
+func (s *setValue) encode() []byte {
+	size := 1
+	for member := range s.members {
+		size += len(member) + 2
+	}
+
+	buf := appendUvarint(make([]byte, 0, size), uint64(len(s.members)))
+	for _, member := range s.sorted() {
+		buf = appendString(buf, member)
+	}
+	return buf
+}
+
+func (s *setValue) empty() bool {
+	return len(s.members) == 0
+}
+
+func (s *setValue) sorted() []string {
+	members := make([]string, 0, len(s.members))
+	for member := range s.members {
+		members = append(members, member)
+	}
+	slices.Sort(members)
+	return members
+}
+
+/*
+setOp adapts an op on a set to an opSpec.
+*/
+func setOp(query bool, minArgs, maxArgs int, fn func(s *setValue, args []string) (any, func(), error)) opSpec {
+	return typedOp(TypeSet, query, minArgs, maxArgs, fn)
+}
+
+/*
+setOps are the ops on sets. Intersections, unions and differences
+span several keys, so callers combine the members of each instead.
+*/
+var setOps = map[string]opSpec{
+	"SADD": setOp(false, 1, -1, func(s *setValue, args []string) (any, func(), error) {
+		added := make(map[string]struct{}, len(args))
+		for _, member := range args {
+			if _, ok := s.members[member]; !ok {
+				added[member] = struct{}{}
+			}
+		}
+		if len(added) == 0 {
+			return int64(0), nil, nil
+		}
+		return int64(len(added)), func() {
+			for member := range added {
+				s.members[member] = struct{}{}
+			}
+		}, nil
+	}),
+
+	"SREM": setOp(false, 1, -1, func(s *setValue, args []string) (any, func(), error) {
+		removed := make(map[string]struct{}, len(args))
+		for _, member := range args {
+			if _, ok := s.members[member]; ok {
+				removed[member] = struct{}{}
+			}
+		}
+		if len(removed) == 0 {
+			return int64(0), nil, nil
+		}
+		return int64(len(removed)), func() {
+			for member := range removed {
+				delete(s.members, member)
+			}
+		}, nil
+	}),
+
+	"SISMEMBER": setOp(true, 1, 1, queryOp(func(s *setValue, args []string) (any, error) {
+		if _, ok := s.members[args[0]]; ok {
+			return int64(1), nil
+		}
+		return int64(0), nil
+	})),
+
+	// SMEMBERS replies with the members in order
+	"SMEMBERS": setOp(true, 0, 0, queryOp(func(s *setValue, _ []string) (any, error) {
+		return s.sorted(), nil
+	})),
+}
@@ -2,18 +2,47 @@ package store
 
 import (
 	"hash/fnv"
+	"strings"
 	"sync"
+	"sync/atomic"
 )
 
 /*
 shardedStore partitions keys across multiple independent shards
 to reduce lock contention under concurrent access.
+
+The shard count can change at runtime (see Reshard); the current
+placement of keys is described by an immutable shardLayout that is
+swapped atomically.
 */
 type shardedStore struct {
-	numShards int
-	shards    []shard
+	layout atomic.Pointer[shardLayout]
+
+	// hash maps a key's hash tag to a shard; it never changes
+	// after construction, so it is read without locking.
+	hash HashFunc
+
+	// opts configure every shard, including those created by
+	// Reshard.
+	opts options
+
+	// layoutMu orders layout swaps against Iterate.
+	// Iterate holds RLock for its whole traversal so the set of shards
+	// (and the direction keys may move in) is fixed while it runs.
+	layoutMu sync.RWMutex
+
+	// reshardMu serializes Reshard calls.
+	reshardMu sync.Mutex
+
+	sweeper *sweeper
 }
 
+/*
+HashFunc computes the hash used for shard selection.
+It must be deterministic and safe for concurrent use.
+*/
+type HashFunc func(key string) uint32
+
 /*
 Each shard owns its own store and mutex.
 Operations on different shards can proceed concurrently.
@@ -21,68 +50,179 @@ Operations on different shards can proceed concurrently.
 type shard struct {
 	mu    sync.RWMutex
 	store *store
+
+	// id is unique and increasing in creation order. Whenever several
+	// shards are locked together they are locked in ascending id order,
+	// which rules out lock-order deadlocks.
+	id uint64
+
+	// drained is set, under mu, once a resharding source no longer
+	// holds keys that belong to another shard in the target layout.
+	drained atomic.Bool
+}
+
+// shardIDs allocates shard ids.
+var shardIDs atomic.Uint64
+
+func newShard(o options) *shard {
+	return &shard{
+		store: newStore(o),
+		id: shardIDs.Add(1),
+	}
 }
 
 /*
 NewShardedStore creates a sharded store with the given number
 of shards. Each shard maintains its own isolated state.
+
+Keys are placed by hashing their hash tag (see hashTag), so keys
+sharing a tag always land on the same shard. The hash function can be
+replaced with WithHashFunc. Expired entries are removed by a
+background sweeper, configured with WithSweepInterval.
 */
-func NewShardedStore(numShards int) DataStore {
-	shards := make([]shard, numShards)
+func NewShardedStore(numShards int, opts ...Option) DataStore {
+	o := newOptions(opts)
+
+	shards := make([]*shard, numShards)
 	for i := range numShards {
-		shards[i] = shard{
-			store: &store{
-				data: make(map[string]Entry),
-			},
-		}
+		shards[i] = newShard(o)
 	}
-	return &shardedStore{
-		numShards: numShards,
-		shards:    shards,
+
+	s := &shardedStore{
+		hash: o.hash,
+		opts: o,
 	}
+	s.layout.Store(&shardLayout{shards: shards})
+	s.sweeper = startSweeper(o.sweepInterval, s.sweepTick)
+	return s
 }
 
 /*
-Read locks only the shard responsible for the given key.
-Reads acquire an exclusive lock due to lazy expiration.
+Read takes a shared lock on the shard responsible for the given key.
+Reads never delete expired keys, so readers of the same shard run
+concurrently.
 */
 func (s *shardedStore) Read(key string) (Entry, bool) {
-	shard := s.getShard(key)
-	shard.mu.Lock()
-	defer shard.mu.Unlock()
-	return shard.store.Read(key)
+	st, r := s.lockKey(key, true)
+	defer r.unlock(true)
+	return st.Read(key)
 }
 
 /*
 Write applies write semantics within the owning shard.
 */
 func (s *shardedStore) Write(key string, value Entry, mode PutMode) error {
-	shard := s.getShard(key)
-	shard.mu.Lock()
-	defer shard.mu.Unlock()
-	return shard.store.Write(key, value, mode)
+	st, r := s.lockKey(key, false)
+	defer r.unlock(false)
+	return st.Write(key, value, mode)
 }
 
 /*
 Expire updates TTL metadata within the owning shard.
 */
 func (s *shardedStore) Expire(key string, unixTimestampMilli int64) bool {
-	shard := s.getShard(key)
-	shard.mu.Lock()
-	defer shard.mu.Unlock()
-	return shard.store.Expire(key, unixTimestampMilli)
+	st, r := s.lockKey(key, false)
+	defer r.unlock(false)
+	return st.Expire(key, unixTimestampMilli)
 }
 
-func (s *shardedStore) Close() error {
-	return s.shards[0].store.Close()
+/*
+ReadBatch read-locks every shard owning one of the keys before
+reading, so the result is a consistent cross-shard view.
+*/
+func (s *shardedStore) ReadBatch(keys []string) map[string]Entry {
+	held := s.lockKeys(keys, true)
+	defer held.unlock()
+
+	result := make(map[string]Entry, len(keys))
+	for _, key := range keys {
+		if val, ok := held.store(key).Read(key); ok {
+			result[key] = val
+		}
+	}
+	return result
+}
+
+/*
+WriteBatch locks every involved shard and applies the batch atomically.
+Keys on different shards are validated together, so a rejected batch
+never leaves a partial write on any shard.
+*/
+func (s *shardedStore) WriteBatch(entries []KeyValue, mode PutMode) error {
+	keys := make([]string, len(entries))
+	for i, kv := range entries {
+		keys[i] = kv.Key
+	}
+
+	held := s.lockKeys(keys, false)
+	defer held.unlock()
+
+	return writeBatch(held.store, entries, mode, nextVersion())
+}
+
+/*
+Atomic locks every shard owning a declared key, in the same
+deterministic order as batches, and holds them while fn runs.
+*/
+func (s *shardedStore) Atomic(keys []string, fn func(tx Tx) error) error {
+	held := s.lockKeys(keys, false)
+	defer held.unlock()
+
+	return fn(&routedTx{
+		route: func(key string) *store {
+			// Undeclared keys are rejected: their shards are not held
+			if _, ok := held.routes[key]; !ok {
+				return nil
+			}
+			return held.store(key)
+		},
+	})
 }
 
 /*
-getShard deterministically maps a key to its shard.
+Apply runs a query under the shared lock of the key's shard when the
+value is already kept decoded; see lockedStore.Apply.
 */
-func (s *shardedStore) getShard(key string) *shard {
-	shardIndex := getShardIndex(key, s.numShards)
-	return &s.shards[shardIndex]
+func (s *shardedStore) Apply(key string, op Op) (any, error) {
+	if isQuery(op) {
+		st, r := s.lockKey(key, true)
+		reply, done, err := st.queryLive(key, op)
+		r.unlock(true)
+		if done {
+			return reply, err
+		}
+	}
+	return applyAtomic(s, key, op)
+}
+
+/*
+SweepExpired removes expired entries shard by shard. Each shard is
+locked exclusively for one small step at a time, so the sweep never
+blocks the whole store.
+*/
+func (s *shardedStore) SweepExpired() int {
+	return s.sweep(scanSlots)
+}
+
+/*
+sweepTick is one run of the background sweeper: a bounded part of
+SweepExpired in every shard.
+*/
+func (s *shardedStore) sweepTick() int {
+	return s.sweep(sweepTickSlots)
+}
+
+func (s *shardedStore) sweep(slots int) int {
+	total := 0
+	for _, sh := range s.layout.Load().iterationOrder() {
+		total += sweepLocked(&sh.mu, sh.store, slots)
+	}
+	return total
+}
+
+func (s *shardedStore) Close() error {
+	s.sweeper.stop()
+	return nil
 }
 
 /*
@@ -99,19 +239,68 @@ func hashString(s string) uint32 {
 }
 
 /*
-getShardIndex returns the shard index for a given key.
+keyHash returns the placement hash of a key.
+Only the key's hash tag takes part in the hash.
 */
-func getShardIndex(key string, numShards int) int {
-	return int(hashString(key) % uint32(numShards))
+func (s *shardedStore) keyHash(key string) uint32 {
+	return s.hash(hashTag(key))
 }
 
+/*
+hashTag returns the part of the key used for shard selection,
+following the Redis Cluster convention:
+
+- If the key contains "{...}" with at least one character between the
+  first "{" and the first "}" after it, only that substring is hashed.
+- Otherwise the whole key is hashed.
+
+This lets callers co-locate related keys, e.g. "user:{42}:profile" and
+"user:{42}:session", so multi-key operations touch a single shard.
+*/
+func hashTag(key string) string {
+	start := strings.IndexByte(key, '{')
+	if start < 0 {
+		return key
+	}
+
+	end := strings.IndexByte(key[start+1:], '}')
+	if end <= 0 {
+		return key
+	}
+	return key[start+1 : start+1+end]
+}
+
+/*
+Iterate traverses every live entry exactly once.
+
+While a reshard is migrating keys, shards are visited so that keys
+only ever move from an already-visited shard to a not-yet-visited one
+(see shardLayout.iterationOrder). A key can then be seen twice but
+never missed, and a seen-set filters the duplicates.
+*/
 func (s *shardedStore) Iterate(fn func(key string, value Entry) bool) {
-	for i := range s.shards {
-		shard := &s.shards[i]
+	s.layoutMu.RLock()
+	defer s.layoutMu.RUnlock()
+
+	l := s.layout.Load()
+
+	var seen map[string]struct{}
+	if l.migrating() {
+		seen = make(map[string]struct{})
+	}
+
+	for _, shard := range l.iterationOrder() {
 		shard.mu.RLock()
-		
+
 		shouldStop := false
 		shard.store.Iterate(func(k string, v Entry) bool {
+			if seen != nil {
+				if _, dup := seen[k]; dup {
+					return true
+				}
+				seen[k] = struct{}{}
+			}
+
 			if !fn(k, v) {
 				shouldStop = true
 				return false
@@ -124,4 +313,219 @@ func (s *shardedStore) Iterate(fn func(key string, value Entry) bool) {
 			break
 		}
 	}
-}
\ No newline at end of file
+}
+
+/*
+ScanEntries returns one step of a scan. The cursor encodes the layout
+epoch (upper 16 bits), a keyspace slot (next 16 bits) and a position
+in the shard iteration order (lower 32 bits).
+
+Scans go slot by slot, visiting a slot in every shard before moving to
+the next one. A key keeps its slot when it migrates between shards, so
+once a slot is finished no key present for the whole scan can still be
+hiding in it, whatever reshards happen between steps.
+
+A step may stop part way through a slot. Within one layout keys only
+move forward in the shard order (see shardLayout.iterationOrder), so
+resuming from the stored position is safe. If the layout changed since,
+positions in the old shard order mean nothing and the slot is visited
+again from its first shard; keys may then be returned twice, which
+SCAN allows.
+
+Shard locks are held only while a slot is copied, and nothing is held
+between steps.
+*/
+func (s *shardedStore) ScanEntries(cursor uint64, count int) ([]KeyValue, uint64) {
+	if count <= 0 {
+		count = 1
+	}
+
+	epoch, slot, pos := cursor>>48, cursor>>32&0xffff, cursor&0xffffffff
+	if slot >= scanSlots {
+		return nil, 0
+	}
+
+	s.layoutMu.RLock()
+	defer s.layoutMu.RUnlock()
+
+	l := s.layout.Load()
+	order := l.iterationOrder()
+	if epoch != l.epoch&0xffff || pos >= uint64(len(order)) {
+		pos = 0
+	}
+
+	now := unixNow(s.opts.clock)
+	var entries []KeyValue
+	for {
+		shard := order[pos]
+		shard.mu.RLock()
+		entries = shard.store.appendSlot(entries, int(slot), now)
+		shard.mu.RUnlock()
+
+		if pos++; pos == uint64(len(order)) {
+			slot, pos = slot+1, 0
+			if slot == scanSlots {
+				return entries, 0
+			}
+		}
+
+		if len(entries) >= count {
+			return entries, (l.epoch&0xffff)<<48 | slot<<32 | pos
+		}
+	}
+}
+
+/*
+Snapshot returns a view of the store. Reads through it lock only the
+shards of the key being read, in shared mode.
+*/
+func (s *shardedStore) Snapshot() View {
+	return newView(s, s.opts.views, s.opts.clock)
+}
+
+func (s *shardedStore) readAt(key string, rp readPoint) (Entry, bool) {
+	st, r := s.lockKey(key, true)
+	defer r.unlock(true)
+
+	return st.readAt(key, rp)
+}
+
+/*
+scanAt visits whole slots across every shard; the cursor is the next
+slot. A migrating key is found in its source or its target (see
+shardLayout.iterationOrder) and keeps its slot, so deduplicating
+within a step returns it exactly once.
+*/
+func (s *shardedStore) scanAt(cursor uint64, count int, rp readPoint) ([]KeyValue, uint64) {
+	s.layoutMu.RLock()
+	defer s.layoutMu.RUnlock()
+
+	order := s.layout.Load().iterationOrder()
+
+	var entries []KeyValue
+	for slot := cursor; slot < scanSlots; slot++ {
+		var found []KeyValue
+		for _, shard := range order {
+			shard.mu.RLock()
+			found = shard.store.appendSlotAt(found, int(slot), rp)
+			shard.mu.RUnlock()
+		}
+
+		seen := make(map[string]struct{}, len(found))
+		for _, kv := range found {
+			if _, dup := seen[kv.Key]; !dup {
+				seen[kv.Key] = struct{}{}
+				entries = append(entries, kv)
+			}
+		}
+
+		if len(entries) >= count && slot+1 < scanSlots {
+			return entries, slot + 1
+		}
+	}
+	return entries, 0
+}
+
+/*
+History reads the history of key under the shared lock of its shard.
+Recorded history moves with its key during a reshard.
+*/
+func (s *shardedStore) History(key string) History {
+	st, r := s.lockKey(key, true)
+	defer r.unlock(true)
+
+	return st.History(key)
+}
+
+/*
+histories visits the shards like Iterate, copying each one's histories
+under its read lock. A migrating key's history is seen once even if
+it moved while the shards were visited.
+*/
+func (s *shardedStore) histories(fn func(key string, h History) bool) {
+	s.layoutMu.RLock()
+	defer s.layoutMu.RUnlock()
+
+	seen := make(map[string]struct{})
+	for _, shard := range s.layout.Load().iterationOrder() {
+		shard.mu.RLock()
+		all := shard.store.collectHistories()
+		shard.mu.RUnlock()
+
+		fresh := all[:0]
+		for _, kh := range all {
+			if _, dup := seen[kh.key]; !dup {
+				seen[kh.key] = struct{}{}
+				fresh = append(fresh, kh)
+			}
+		}
+		if !yieldHistories(fresh, fn) {
+			return
+		}
+	}
+}
+
+func (s *shardedStore) restoreHistory(key string, h History) {
+	st, r := s.lockKey(key, false)
+	defer r.unlock(false)
+
+	st.restoreHistory(key, h)
+}
+
+/*
+ShardStats describes how keys are distributed across shards.
+
+Keys counts stored entries per shard. Expired entries are counted
+until the sweeper removes them, so it is a cheap approximation of
+live keys.
+*/
+type ShardStats struct {
+	Keys  []int
+	Total int
+	Min   int
+	Max   int
+
+	// Imbalance is Max divided by the mean shard size; 1.0 is a
+	// perfectly even distribution. It is 0 for an empty store.
+	Imbalance float64
+
+	// Migrating reports an in-progress reshard. Keys then describes
+	// the target layout and excludes keys still waiting in shards
+	// that are being removed.
+	Migrating bool
+}
+
+/*
+ShardStats snapshots the per-shard key counts.
+
+Each shard is read-locked only while it is counted, so the result is
+not a single point in time across shards.
+*/
+func (s *shardedStore) ShardStats() ShardStats {
+	l := s.layout.Load()
+	stats := ShardStats{
+		Keys:      make([]int, len(l.shards)),
+		Migrating: l.migrating(),
+	}
+
+	for i, shard := range l.shards {
+		shard.mu.RLock()
+		n := shard.store.data.len()
+		shard.mu.RUnlock()
+
+		stats.Keys[i] = n
+		stats.Total += n
+		if i == 0 || n < stats.Min {
+			stats.Min = n
+		}
+		if n > stats.Max {
+			stats.Max = n
+		}
+	}
+
+	if stats.Total > 0 {
+		mean := float64(stats.Total) / float64(len(l.shards))
+		stats.Imbalance = float64(stats.Max) / mean
+	}
+	return stats
+}
@@ -0,0 +1,305 @@
+package store
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestHashTag(t *testing.T) {
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{key: "plain", want: "plain"},
+		{key: "user:{42}:profile", want: "42"},
+		{key: "{42}", want: "42"},
+		{key: "{}empty", want: "{}empty"},
+		{key: "open{only", want: "open{only"},
+		{key: "close}only{", want: "close}only{"},
+		{key: "a{b}{c}", want: "b"},
+		{key: "a{{b}}", want: "{b"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			if got := hashTag(tt.key); got != tt.want {
+				t.Fatalf("hashTag(%q) = %q, want %q", tt.key, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestShardedStore_HashTagsCoLocateKeys(t *testing.T) {
+	s := NewShardedStore(64).(*shardedStore)
+
+	want := s.shardIndex("user:{42}:profile")
+	for _, key := range []string{"user:{42}:session", "{42}", "cart:{42}"} {
+		if got := s.shardIndex(key); got != want {
+			t.Fatalf("%q landed on shard %d, want %d", key, got, want)
+		}
+	}
+
+	// A tagged batch only needs a single shard lock
+	held := s.lockKeys([]string{"user:{42}:profile", "user:{42}:session"}, false)
+	held.unlock()
+	if len(held.locked) != 1 {
+		t.Fatalf("expected one shard for co-located keys, got %d", len(held.locked))
+	}
+}
+
+func TestShardedStore_CustomHashFunc(t *testing.T) {
+	var hashed []string
+	s := NewShardedStore(4, WithHashFunc(func(key string) uint32 {
+		hashed = append(hashed, key)
+		return 2
+	}))
+
+	_ = s.Write("user:{7}:a", Entry{Value: []byte("1")}, PutOverwrite)
+	_ = s.Write("b", Entry{Value: []byte("2")}, PutOverwrite)
+
+	if len(hashed) != 2 || hashed[0] != "7" || hashed[1] != "b" {
+		t.Fatalf("hash func must receive hash tags, got %v", hashed)
+	}
+
+	want := jumpHash(2, 4)
+	stats := s.(*shardedStore).ShardStats()
+	if stats.Keys[want] != 2 || stats.Total != 2 || stats.Min != 0 || stats.Max != 2 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	if stats.Imbalance != 4 {
+		t.Fatalf("expected imbalance 4, got %v", stats.Imbalance)
+	}
+}
+
+func TestShardedStore_ShardStatsDistribution(t *testing.T) {
+	s := NewShardedStore(16).(*shardedStore)
+
+	if stats := s.ShardStats(); stats.Total != 0 || stats.Imbalance != 0 {
+		t.Fatalf("unexpected stats for empty store: %+v", stats)
+	}
+
+	const keys = 16000
+	for i := 0; i < keys; i++ {
+		_ = s.Write(fmt.Sprintf("key:%d", i), Entry{Value: []byte("v")}, PutOverwrite)
+	}
+
+	stats := s.ShardStats()
+	if stats.Total != keys {
+		t.Fatalf("expected %d keys, got %d", keys, stats.Total)
+	}
+	if stats.Imbalance > 1.2 {
+		t.Fatalf("default hash distributes poorly: %+v", stats)
+	}
+}
+
+func TestJumpHash_MovesOnlyNecessaryKeys(t *testing.T) {
+	for key := uint64(0); key < 10000; key++ {
+		before := jumpHash(key, 8)
+		after := jumpHash(key, 12)
+		if after != before && after < 8 {
+			t.Fatalf("key %d moved between surviving buckets %d -> %d", key, before, after)
+		}
+	}
+}
+
+func TestShardedStore_ReshardPreservesKeys(t *testing.T) {
+	s := NewShardedStore(4).(*shardedStore)
+
+	const keys = 2000
+	versions := make(map[string]uint64, keys)
+	for i := 0; i < keys; i++ {
+		key := fmt.Sprintf("key:%d", i)
+		_ = s.Write(key, Entry{Value: []byte(key)}, PutOverwrite)
+		val, _ := s.Read(key)
+		versions[key] = val.Version
+	}
+
+	for _, n := range []int{16, 5, 1, 7} {
+		if err := s.Reshard(n); err != nil {
+			t.Fatalf("reshard to %d failed: %v", n, err)
+		}
+
+		stats := s.ShardStats()
+		if len(stats.Keys) != n || stats.Total != keys || stats.Migrating {
+			t.Fatalf("unexpected stats after reshard to %d: %+v", n, stats)
+		}
+
+		for key, version := range versions {
+			val, ok := s.Read(key)
+			if !ok || string(val.Value) != key || val.Version != version {
+				t.Fatalf("key %q lost or changed after reshard to %d: %+v", key, n, val)
+			}
+		}
+	}
+}
+
+func TestShardedStore_ReshardInvalidCount(t *testing.T) {
+	s := NewShardedStore(4).(*shardedStore)
+
+	if err := s.Reshard(0); err != ErrInvalidShardCount {
+		t.Fatalf("expected ErrInvalidShardCount, got %v", err)
+	}
+	if _, ok := DataStore(s).(Resharder); !ok {
+		t.Fatalf("sharded store must implement Resharder")
+	}
+}
+
+func TestShardedStore_ConcurrentOpsDuringReshard(t *testing.T) {
+	s := NewShardedStore(2).(*shardedStore)
+
+	const (
+		workers = 8
+		rounds  = 300
+	)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, n := range []int{8, 3, 16, 4} {
+			if err := s.Reshard(n); err != nil {
+				t.Errorf("reshard to %d failed: %v", n, err)
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				key := fmt.Sprintf("w%d:%d", w, i%50)
+				_ = s.Write(key, Entry{Value: []byte(strconv.Itoa(i))}, PutOverwrite)
+
+				if val, ok := s.Read(key); !ok || string(val.Value) != strconv.Itoa(i) {
+					t.Errorf("worker %d lost its own write to %q", w, key)
+					return
+				}
+
+				// Batches spanning shards stay atomic while keys move
+				pair := []KeyValue{
+					{Key: fmt.Sprintf("pair:%d:a", w), Entry: Entry{Value: []byte(strconv.Itoa(i))}},
+					{Key: fmt.Sprintf("pair:%d:b", w), Entry: Entry{Value: []byte(strconv.Itoa(i))}},
+				}
+				_ = s.WriteBatch(pair, PutOverwrite)
+
+				got := s.ReadBatch([]string{pair[0].Key, pair[1].Key})
+				if string(got[pair[0].Key].Value) != string(got[pair[1].Key].Value) {
+					t.Errorf("torn batch observed: %+v", got)
+					return
+				}
+			}
+		}(w)
+	}
+
+	wg.Wait()
+	<-done
+
+	for w := 0; w < workers; w++ {
+		for k := 0; k < 50; k++ {
+			key := fmt.Sprintf("w%d:%d", w, k)
+			last := rounds - 50 + k
+			if val, ok := s.Read(key); !ok || string(val.Value) != strconv.Itoa(last) {
+				t.Fatalf("key %q = %q, want %d", key, val.Value, last)
+			}
+		}
+	}
+}
+
+func TestShardedStore_IterateDuringReshardSeesEveryKeyOnce(t *testing.T) {
+	s := NewShardedStore(3).(*shardedStore)
+
+	const keys = 5000
+	for i := 0; i < keys; i++ {
+		_ = s.Write(fmt.Sprintf("key:%d", i), Entry{Value: []byte("v")}, PutOverwrite)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, n := range []int{11, 2, 9} {
+			_ = s.Reshard(n)
+		}
+	}()
+
+	// Readers keep pulling keys across shards while iterating
+	go func() {
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+				s.Read(fmt.Sprintf("key:%d", i%keys))
+			}
+		}
+	}()
+
+	for iterations := 0; ; iterations++ {
+		counts := make(map[string]int, keys)
+		s.Iterate(func(key string, _ Entry) bool {
+			counts[key]++
+			return true
+		})
+
+		if len(counts) != keys {
+			t.Fatalf("iteration %d saw %d keys, want %d", iterations, len(counts), keys)
+		}
+		for key, n := range counts {
+			if n != 1 {
+				t.Fatalf("iteration %d saw %q %d times", iterations, key, n)
+			}
+		}
+
+		select {
+		case <-done:
+			return
+		default:
+		}
+	}
+}
+
+func TestShardedStore_ScanDuringReshardMissesNoKey(t *testing.T) {
+	s := NewShardedStore(3).(*shardedStore)
+
+	const keys = 5000
+	for i := 0; i < keys; i++ {
+		_ = s.Write(fmt.Sprintf("key:%d", i), Entry{Value: []byte("v")}, PutOverwrite)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, n := range []int{11, 2, 9, 4} {
+			_ = s.Reshard(n)
+		}
+	}()
+
+	for scans := 0; ; scans++ {
+		seen := make(map[string]bool, keys)
+		var cursor uint64
+		for {
+			// Small steps so reshards land between them, mid-slot too
+			entries, next := s.ScanEntries(cursor, 3)
+			for _, kv := range entries {
+				seen[kv.Key] = true
+			}
+			if next == 0 {
+				break
+			}
+			cursor = next
+		}
+
+		if len(seen) != keys {
+			t.Fatalf("scan %d saw %d keys, want %d", scans, len(seen), keys)
+		}
+
+		select {
+		case <-done:
+			return
+		default:
+		}
+	}
+}
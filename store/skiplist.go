@@ -0,0 +1,139 @@
+package store
+
+import "math/rand/v2"
+
+const (
+	// skipListMaxLevel bounds tower height; 2^32 keys at p = 1/4.
+	skipListMaxLevel = 16
+
+	// skipListP is the chance a node is promoted one level up.
+	skipListP = 0.25
+)
+
+/*
+skipList keeps keys in lexicographic order.
+
+It is the ordering index of an ordered keyspace: entries still live in
+the hash slots, which serve point lookups and scans, while the skip
+list answers range, prefix and reverse traversals. Redis sorted sets
+pair a dict with a skip list the same way.
+
+The bottom level is doubly linked so traversals run in both
+directions. It has no concurrency control of its own.
+*/
+type skipList struct {
+	head  *skipNode
+	tail  *skipNode
+	level int
+}
+
+type skipNode struct {
+	key  string
+	next []*skipNode
+	prev *skipNode
+}
+
+func newSkipList() *skipList {
+	return &skipList{
+		head:  &skipNode{next: make([]*skipNode, skipListMaxLevel)},
+		level: 1,
+	}
+}
+
+func randomLevel() int {
+	level := 1
+	for level < skipListMaxLevel && rand.Float64() < skipListP {
+		level++
+	}
+	return level
+}
+
+/*
+findPath fills update with the last node before key on every level and
+returns the first node whose key is >= key, or nil.
+*/
+func (l *skipList) findPath(key string, update []*skipNode) *skipNode {
+	x := l.head
+	for i := l.level - 1; i >= 0; i-- {
+		for x.next[i] != nil && x.next[i].key < key {
+			x = x.next[i]
+		}
+		if update != nil {
+			update[i] = x
+		}
+	}
+	return x.next[0]
+}
+
+/*
+insert adds key. The caller guarantees it is not already present.
+*/
+func (l *skipList) insert(key string) {
+	var update [skipListMaxLevel]*skipNode
+	next := l.findPath(key, update[:])
+
+	level := randomLevel()
+	if level > l.level {
+		for i := l.level; i < level; i++ {
+			update[i] = l.head
+		}
+		l.level = level
+	}
+
+	n := &skipNode{key: key, next: make([]*skipNode, level)}
+	for i := 0; i < level; i++ {
+		n.next[i] = update[i].next[i]
+		update[i].next[i] = n
+	}
+
+	if update[0] != l.head {
+		n.prev = update[0]
+	}
+	if next != nil {
+		next.prev = n
+	} else {
+		l.tail = n
+	}
+}
+
+/*
+delete removes key if present.
+*/
+func (l *skipList) delete(key string) {
+	var update [skipListMaxLevel]*skipNode
+	n := l.findPath(key, update[:])
+	if n == nil || n.key != key {
+		return
+	}
+
+	for i := 0; i < len(n.next); i++ {
+		update[i].next[i] = n.next[i]
+	}
+
+	if n.next[0] != nil {
+		n.next[0].prev = n.prev
+	} else {
+		l.tail = n.prev
+	}
+
+	for l.level > 1 && l.head.next[l.level-1] == nil {
+		l.level--
+	}
+}
+
+/*
+seek returns the first node whose key is >= key, or nil.
+*/
+func (l *skipList) seek(key string) *skipNode {
+	return l.findPath(key, nil)
+}
+
+/*
+seekBefore returns the last node whose key is < key, or nil.
+*/
+func (l *skipList) seekBefore(key string) *skipNode {
+	if n := l.seek(key); n != nil {
+		return n.prev
+	}
+	return l.tail
+}
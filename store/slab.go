@@ -0,0 +1,346 @@
+package store
+
+import "math/bits"
+
+/*
+Slab geometry. Chunks are power-of-two sized, from 16 bytes up to a
+whole slab; anything larger gets a dedicated slab of its exact size.
+*/
+const (
+	slabSize      = 1 << 20
+	minChunkClass = 4
+	maxChunkClass = 20 // log2(slabSize)
+
+	// minDefragBytes is the free space the slabs must hold before
+	// defragmentation is worth copying every live record.
+	minDefragBytes = 4 * slabSize
+)
+
+/*
+slabRecord describes one entry: where its key and value bytes sit and
+the rest of its Entry. It holds no pointers.
+*/
+type slabRecord struct {
+	slab      uint32
+	offset    uint32
+	keyLen    uint32
+	valLen    uint32
+	next      uint32 // next record of the same hash, 0 ends the chain
+	class     uint8
+	encoding  Encoding
+	valueType ValueType
+	expiresAt int64
+	writtenAt int64
+	version   uint64
+}
+
+/*
+slabTable stores a keyspace's entries in a form the garbage collector
+does not have to scan.
+
+Keys and values are packed into large byte slabs. Each entry is a
+pointer-free slabRecord in one big slice, and each slot maps key
+hashes to the first record of that hash; colliding keys are chained
+through next. Neither the maps nor the record slice hold pointers, so
+a GC cycle scans only the few slab headers instead of two pointers per
+key.
+
+Freed chunks go to a free list per size class and are reused by later
+writes of that class. Chunks freed faster than they are reused leave
+holes; once free space outweighs the live data, the sweeper
+defragments the table by copying every live record into fresh slabs.
+
+Reads copy values out, since the chunk may be reused once the entry is
+overwritten, so a read allocates like a read of any other store.
+
+Typed values kept decoded (see liveTx) cannot be packed; they are held
+in typed by record id instead, and their records have no value bytes.
+*/
+type slabTable struct {
+	heads   [scanSlots]map[uint64]uint32
+	records []slabRecord
+	freeIDs []uint32
+
+	slabs     [][]byte
+	freeSlabs []uint32 // released dedicated slabs, for reuse
+	current   int      // slab being filled, -1 if none
+	used      int      // bytes filled in current
+	free      [maxChunkClass + 1][]uint64
+
+	live  int64 // bytes of chunks in use
+	holes int64 // bytes of chunks free for reuse or stranded at slab ends
+
+	typed map[uint32]typedValue
+}
+
+func newSlabTable() *slabTable {
+	// Record 0 is never used: an id of 0 ends a chain
+	return &slabTable{records: make([]slabRecord, 1), current: -1, typed: make(map[uint32]typedValue)}
+}
+
+func chunkClass(size int) uint8 {
+	if size <= 1<<minChunkClass {
+		return minChunkClass
+	}
+	return uint8(bits.Len(uint(size - 1)))
+}
+
+func packChunk(slab, offset uint32) uint64 {
+	return uint64(slab)<<32 | uint64(offset)
+}
+
+/*
+chunkSize returns the bytes r occupies in its slab.
+*/
+func (t *slabTable) chunkSize(r *slabRecord) int64 {
+	if r.class > maxChunkClass {
+		return int64(len(t.slabs[r.slab]))
+	}
+	return 1 << r.class
+}
+
+func (t *slabTable) addSlab(buf []byte) uint32 {
+	if n := len(t.freeSlabs); n > 0 {
+		i := t.freeSlabs[n-1]
+		t.freeSlabs = t.freeSlabs[:n-1]
+		t.slabs[i] = buf
+		return i
+	}
+	t.slabs = append(t.slabs, buf)
+	return uint32(len(t.slabs) - 1)
+}
+
+/*
+alloc reserves a chunk for size bytes: from the free list of its class
+if possible, else at the end of the current slab.
+*/
+func (t *slabTable) alloc(size int) (slab, offset uint32, class uint8) {
+	class = chunkClass(size)
+	if class > maxChunkClass {
+		t.live += int64(size)
+		return t.addSlab(make([]byte, size)), 0, class
+	}
+
+	chunk := 1 << class
+	t.live += int64(chunk)
+	if list := t.free[class]; len(list) > 0 {
+		c := list[len(list)-1]
+		t.free[class] = list[:len(list)-1]
+		t.holes -= int64(chunk)
+		return uint32(c >> 32), uint32(c), class
+	}
+
+	if t.current < 0 || t.used+chunk > slabSize {
+		if t.current >= 0 {
+			t.holes += int64(slabSize - t.used)
+		}
+		t.current, t.used = int(t.addSlab(make([]byte, slabSize))), 0
+	}
+	offset = uint32(t.used)
+	t.used += chunk
+	return uint32(t.current), offset, class
+}
+
+/*
+release returns the chunk of r to its free list, or drops its
+dedicated slab.
+*/
+func (t *slabTable) release(r *slabRecord) {
+	size := t.chunkSize(r)
+	t.live -= size
+	if r.class > maxChunkClass {
+		t.slabs[r.slab] = nil
+		t.freeSlabs = append(t.freeSlabs, r.slab)
+		return
+	}
+	t.free[r.class] = append(t.free[r.class], packChunk(r.slab, r.offset))
+	t.holes += size
+}
+
+/*
+bytes returns the key and value bytes of r, in place.
+*/
+func (t *slabTable) bytes(r *slabRecord) []byte {
+	return t.slabs[r.slab][r.offset : r.offset+r.keyLen+r.valLen]
+}
+
+func (t *slabTable) entry(id uint32) Entry {
+	r := &t.records[id]
+	val := Entry{
+		ExpiresAtMillis: r.expiresAt,
+		Version:         r.version,
+		WrittenAtMillis: r.writtenAt,
+		Encoding:        r.encoding,
+		Type:            r.valueType,
+		live:            t.typed[id],
+	}
+	if r.valLen > 0 {
+		val.Value = append([]byte(nil), t.bytes(r)[r.keyLen:]...)
+	}
+	return val
+}
+
+/*
+find returns the id of key's record, 0 if it has none. h is the key's
+hash, which also selects its slot.
+*/
+func (t *slabTable) find(h uint64, key string) uint32 {
+	id := t.heads[h&(scanSlots-1)][h]
+	for id != 0 {
+		r := &t.records[id]
+		if int(r.keyLen) == len(key) && string(t.bytes(r)[:r.keyLen]) == key {
+			return id
+		}
+		id = r.next
+	}
+	return 0
+}
+
+func (t *slabTable) get(h uint64, key string) (Entry, bool) {
+	id := t.find(h, key)
+	if id == 0 {
+		return Entry{}, false
+	}
+	return t.entry(id), true
+}
+
+/*
+put stores value for key and reports whether the key is new. An
+overwrite keeps the key's chunk when the new size fits its class.
+*/
+func (t *slabTable) put(h uint64, key string, value Entry) bool {
+	size := len(key) + len(value.Value)
+
+	id := t.find(h, key)
+	inserted := id == 0
+	if inserted {
+		id = t.newRecord()
+		slot := h & (scanSlots - 1)
+		if t.heads[slot] == nil {
+			t.heads[slot] = make(map[uint64]uint32)
+		}
+		t.records[id].next = t.heads[slot][h]
+		t.heads[slot][h] = id
+	}
+
+	r := &t.records[id]
+	if inserted || chunkClass(size) != r.class || r.class > maxChunkClass {
+		if !inserted {
+			t.release(r)
+		}
+		r.slab, r.offset, r.class = t.alloc(size)
+	}
+
+	r.keyLen, r.valLen = uint32(len(key)), uint32(len(value.Value))
+	buf := t.bytes(r)
+	copy(buf, key)
+	copy(buf[len(key):], value.Value)
+
+	if value.live != nil {
+		t.typed[id] = value.live
+	} else {
+		delete(t.typed, id)
+	}
+
+	r.encoding = value.Encoding
+	r.valueType = value.Type
+	r.expiresAt = value.ExpiresAtMillis
+	r.writtenAt = value.WrittenAtMillis
+	r.version = value.Version
+	return inserted
+}
+
+func (t *slabTable) newRecord() uint32 {
+	if n := len(t.freeIDs); n > 0 {
+		id := t.freeIDs[n-1]
+		t.freeIDs = t.freeIDs[:n-1]
+		return id
+	}
+	t.records = append(t.records, slabRecord{})
+	return uint32(len(t.records) - 1)
+}
+
+/*
+del removes key and reports whether it was present.
+*/
+func (t *slabTable) del(h uint64, key string) bool {
+	slot := h & (scanSlots - 1)
+	prev := uint32(0)
+	for id := t.heads[slot][h]; id != 0; prev, id = id, t.records[id].next {
+		r := &t.records[id]
+		if int(r.keyLen) != len(key) || string(t.bytes(r)[:r.keyLen]) != key {
+			continue
+		}
+
+		switch {
+		case prev != 0:
+			t.records[prev].next = r.next
+		case r.next != 0:
+			t.heads[slot][h] = r.next
+		default:
+			delete(t.heads[slot], h)
+		}
+
+		t.release(r)
+		*r = slabRecord{}
+		delete(t.typed, id)
+		t.freeIDs = append(t.freeIDs, id)
+		return true
+	}
+	return false
+}
+
+/*
+each calls fn for every entry of one slot until fn returns false. fn
+may delete the entry it is given.
+*/
+func (t *slabTable) each(slot int, fn func(key string, value Entry) bool) bool {
+	for _, head := range t.heads[slot] {
+		for id := head; id != 0; {
+			r := &t.records[id]
+			next := r.next
+			if !fn(string(t.bytes(r)[:r.keyLen]), t.entry(id)) {
+				return false
+			}
+			id = next
+		}
+	}
+	return true
+}
+
+/*
+fragmented reports whether free space outweighs the live data enough
+to be worth a defragmentation.
+*/
+func (t *slabTable) fragmented() bool {
+	return t.holes >= minDefragBytes && t.holes > t.live
+}
+
+/*
+defragment copies every live record into fresh slabs, packed, and
+drops the old ones with all their holes. Dedicated slabs are kept.
+*/
+func (t *slabTable) defragment() {
+	old := t.slabs
+	t.slabs, t.freeSlabs = nil, nil
+	t.current, t.used = -1, 0
+	t.free = [maxChunkClass + 1][]uint64{}
+	t.live, t.holes = 0, 0
+
+	for slot := range t.heads {
+		for _, head := range t.heads[slot] {
+			for id := head; id != 0; id = t.records[id].next {
+				r := &t.records[id]
+				if r.class > maxChunkClass {
+					r.slab = t.addSlab(old[r.slab])
+					t.live += int64(len(t.slabs[r.slab]))
+					continue
+				}
+
+				src := old[r.slab][r.offset : r.offset+r.keyLen+r.valLen]
+				r.slab, r.offset, r.class = t.alloc(len(src))
+				copy(t.bytes(r), src)
+			}
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package store
+
+import (
+	"flag"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var gcBenchKeys = flag.Int("gcbench.keys", 10_000_000, "keys loaded by BenchmarkGC")
+
+func TestSlab_PutGetDelete(t *testing.T) {
+	tbl := newSlabTable()
+
+	big := strings.Repeat("b", 3*slabSize)
+	values := map[string]string{
+		"empty": "",
+		"small": "v",
+		"mid":   strings.Repeat("m", 1000),
+		"big":   big,
+	}
+	for key, value := range values {
+		if !tbl.put(hashOf(key), key, Entry{Value: []byte(value), ExpiresAtMillis: 7, Version: 3, Encoding: EncodingFlate, Type: TypeHash}) {
+			t.Fatalf("expected %s to be new", key)
+		}
+	}
+
+	for key, value := range values {
+		val, ok := tbl.get(hashOf(key), key)
+		if !ok || string(val.Value) != value {
+			t.Fatalf("expected %s=%q, got %q (found=%v)", key, value, val.Value, ok)
+		}
+		if val.ExpiresAtMillis != 7 || val.Version != 3 || val.Encoding != EncodingFlate || val.Type != TypeHash {
+			t.Fatalf("expected %s to keep its metadata, got %+v", key, val)
+		}
+	}
+
+	// Overwrites move between classes and in and out of dedicated slabs
+	if tbl.put(hashOf("small"), "small", Entry{Value: []byte(big)}) {
+		t.Fatalf("expected an overwrite to report an existing key")
+	}
+	tbl.put(hashOf("big"), "big", Entry{Value: []byte("tiny")})
+	if val, _ := tbl.get(hashOf("small"), "small"); string(val.Value) != big {
+		t.Fatalf("expected small to grow")
+	}
+	if val, _ := tbl.get(hashOf("big"), "big"); string(val.Value) != "tiny" {
+		t.Fatalf("expected big to shrink")
+	}
+
+	for key := range values {
+		if !tbl.del(hashOf(key), key) {
+			t.Fatalf("expected %s to be deleted", key)
+		}
+		if _, ok := tbl.get(hashOf(key), key); ok {
+			t.Fatalf("expected %s to be gone", key)
+		}
+	}
+	if tbl.live != 0 {
+		t.Fatalf("expected no live bytes after deleting everything, got %d", tbl.live)
+	}
+}
+
+func TestSlab_HashCollisions(t *testing.T) {
+	tbl := newSlabTable()
+
+	// Force every key onto one hash chain
+	const h = 42
+	for i := 0; i < 5; i++ {
+		key := "key:" + strconv.Itoa(i)
+		tbl.put(h, key, Entry{Value: []byte(strconv.Itoa(i))})
+	}
+
+	for _, i := range []int{2, 0, 4} {
+		if !tbl.del(h, "key:"+strconv.Itoa(i)) {
+			t.Fatalf("expected key:%d to be deleted", i)
+		}
+	}
+	if tbl.del(h, "key:9") {
+		t.Fatalf("expected a missing key not to be deleted")
+	}
+
+	for _, i := range []int{1, 3} {
+		key := "key:" + strconv.Itoa(i)
+		if val, ok := tbl.get(h, key); !ok || string(val.Value) != strconv.Itoa(i) {
+			t.Fatalf("expected %s=%d on the shared chain", key, i)
+		}
+	}
+
+	seen := 0
+	tbl.each(h&(scanSlots-1), func(string, Entry) bool {
+		seen++
+		return true
+	})
+	if seen != 2 {
+		t.Fatalf("expected 2 keys left on the chain, got %d", seen)
+	}
+}
+
+func TestSlab_ReusesFreedChunks(t *testing.T) {
+	tbl := newSlabTable()
+
+	for i := 0; i < 1000; i++ {
+		key := "key:" + strconv.Itoa(i)
+		tbl.put(hashOf(key), key, Entry{Value: []byte("value")})
+	}
+	slabs, records := len(tbl.slabs), len(tbl.records)
+
+	for round := 0; round < 10; round++ {
+		for i := 0; i < 1000; i++ {
+			key := "key:" + strconv.Itoa(i)
+			tbl.del(hashOf(key), key)
+		}
+		for i := 0; i < 1000; i++ {
+			key := "key:" + strconv.Itoa(i)
+			tbl.put(hashOf(key), key, Entry{Value: []byte("value")})
+		}
+	}
+
+	if len(tbl.slabs) != slabs || len(tbl.records) != records {
+		t.Fatalf("expected freed chunks and records to be reused, slabs %d -> %d, records %d -> %d",
+			slabs, len(tbl.slabs), records, len(tbl.records))
+	}
+}
+
+func TestSlab_Defragment(t *testing.T) {
+	tbl := newSlabTable()
+
+	value := []byte(strings.Repeat("v", 1000))
+	n := 8 * slabSize / 1024
+	for i := 0; i < n; i++ {
+		key := "key:" + strconv.Itoa(i)
+		tbl.put(hashOf(key), key, Entry{Value: value})
+	}
+
+	// Keep one key in eight: the slabs are now mostly holes
+	for i := 0; i < n; i++ {
+		if i%8 != 0 {
+			key := "key:" + strconv.Itoa(i)
+			tbl.del(hashOf(key), key)
+		}
+	}
+	if !tbl.fragmented() {
+		t.Fatalf("expected the table to be fragmented, live %d holes %d", tbl.live, tbl.holes)
+	}
+
+	before := len(tbl.slabs)
+	tbl.defragment()
+	if len(tbl.slabs) >= before/4 || tbl.fragmented() {
+		t.Fatalf("expected defragmentation to pack %d slabs, got %d", before, len(tbl.slabs))
+	}
+
+	for i := 0; i < n; i += 8 {
+		key := "key:" + strconv.Itoa(i)
+		if val, ok := tbl.get(hashOf(key), key); !ok || string(val.Value) != string(value) {
+			t.Fatalf("expected %s to survive defragmentation", key)
+		}
+	}
+}
+
+func TestSlab_SweepDefragments(t *testing.T) {
+	clock := NewManualClock(time.Unix(1_700_000_000, 0))
+	s := NewLockedStore(WithClock(clock), WithSlabStorage(), WithSweepInterval(0)).(*lockedStore)
+
+	value := []byte(strings.Repeat("v", 1000))
+	n := 8 * slabSize / 1024
+	for i := 0; i < n; i++ {
+		_ = s.Write("key:"+strconv.Itoa(i), Entry{Value: value}, PutOverwrite)
+		if i%8 != 0 {
+			s.Expire("key:"+strconv.Itoa(i), unixNow(clock)+1000)
+		}
+	}
+
+	clock.Advance(2 * time.Second)
+	if removed := s.SweepExpired(); removed != n-n/8 {
+		t.Fatalf("expected %d expired keys removed, got %d", n-n/8, removed)
+	}
+
+	// The next sweep finds the slabs mostly empty
+	s.SweepExpired()
+	if s.store.data.slab.fragmented() {
+		t.Fatalf("expected the sweep to defragment the slabs")
+	}
+	mustHold(t, s, "key:0", string(value))
+}
+
+/*
+BenchmarkGC loads -gcbench.keys small entries into a keyspace, backed
+by a map or by slabs, and measures the garbage collection cycles that
+follow. Each cycle has to mark the whole live heap, so its duration
+grows with the pointers the keyspace holds; STW pauses are reported
+alongside.
+
+	go test ./store -run '^$' -bench GC -benchtime 10x
+*/
+func BenchmarkGC(b *testing.B) {
+	for _, bc := range []struct {
+		name string
+		opts []Option
+	}{
+		{name: "Map"},
+		{name: "Slab", opts: []Option{WithSlabStorage()}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			st := newStore(newOptions(bc.opts))
+			value := []byte("value-0123456789")
+			for i := 0; i < *gcBenchKeys; i++ {
+				st.data.put("key:"+strconv.Itoa(i), Entry{Value: value, Version: 1})
+			}
+			runtime.GC()
+
+			var before, after debug.GCStats
+			debug.ReadGCStats(&before)
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				runtime.GC()
+			}
+
+			b.StopTimer()
+			debug.ReadGCStats(&after)
+			cycles := after.NumGC - before.NumGC
+			if cycles > 0 {
+				b.ReportMetric(float64((after.PauseTotal-before.PauseTotal).Nanoseconds())/float64(cycles), "pause-ns/gc")
+			}
+			runtime.KeepAlive(st)
+		})
+	}
+}
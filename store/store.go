@@ -1,44 +1,54 @@
 package store
 
-import "time"
-
 /*
 store is the core in-memory key-value store.
 It contains no concurrency control and must be accessed
 by a single goroutine or protected by an external mechanism.
 */
 type store struct {
-	data map[string]Entry
+	data keyspace
+
+	// policies selects the keys whose history is recorded.
+	policies historyPolicies
+
+	// clock decides when entries expire and stamps write times.
+	clock Clock
+
+	// views registers the open views of the store this one is part of,
+	// whose versions it keeps.
+	views *viewRegistry
+
+	// sweepSlot is where the next bounded sweep resumes, so repeated
+	// sweeps make progress instead of rescanning the same slots.
+	sweepSlot int
 }
 
 /*
 NewStore creates a non-concurrent store.
 Callers are responsible for ensuring safe access.
 */
-func NewStore() DataStore {
-	return &store{
-		data: make(map[string]Entry),
+func NewStore(opts ...Option) DataStore {
+	return newStore(newOptions(opts))
+}
+
+func newStore(o options) *store {
+	st := &store{policies: o.history, clock: o.clock, views: o.views}
+	if o.slab {
+		st.data.slab = newSlabTable()
 	}
+	return st
 }
 
 /*
 Read returns the value for a key if present and not expired.
 
-Expired keys are removed lazily during reads to ensure
-they are never observable.
+Read never mutates the store: expired keys are reported absent and
+left in place until a write touches them or the sweeper removes them.
+This lets concurrent wrappers serve reads under a shared lock.
 */
 func (s *store) Read(key string) (Entry, bool) {
 	val, ok := s.get(key)
-	if !ok {
-		return Entry{}, false
-	}
-
-	now := GetUnixTimestamp(time.Now())
-	if val.ExpiresAtMillis != 0 && now >= val.ExpiresAtMillis {
-		s.remove(key)
-		return Entry{}, false
-	}
-	return val, true
+	return val.materialized(), ok
 }
 
 /*
@@ -61,22 +71,62 @@ If the key is already expired, it is removed and the
 operation fails.
 */
 func (s *store) Expire(key string, unixTimestampMilli int64) bool{
+	return s.expireAt(key, unixTimestampMilli, nextVersion())
+}
+
+/*
+expireAt is Expire committed at sequence seq.
+*/
+func (s *store) expireAt(key string, unixTimestampMilli int64, seq uint64) bool {
 	val, ok := s.get(key)
 	if !ok {
-		return false
-	}
-
-	now := GetUnixTimestamp(time.Now())
-	if val.ExpiresAtMillis != 0 && now >= val.ExpiresAtMillis {
-		s.remove(key)
+		// Expire is a write, so it may drop an expired leftover
+		s.removeAt(key, seq)
 		return false
 	}
 
 	val.ExpiresAtMillis = unixTimestampMilli
-	s.set(key, val)
+	s.putAt(key, val, seq)
+	s.retimeRevision(key, unixTimestampMilli)
 	return true
 }
 
+/*
+ReadBatch reads every key, treating expired entries as absent like Read.
+*/
+func (s *store) ReadBatch(keys []string) map[string]Entry {
+	result := make(map[string]Entry, len(keys))
+	for _, key := range keys {
+		if val, ok := s.Read(key); ok {
+			result[key] = val
+		}
+	}
+	return result
+}
+
+/*
+WriteBatch applies all-or-nothing write semantics to the batch.
+*/
+func (s *store) WriteBatch(entries []KeyValue, mode PutMode) error {
+	return writeBatch(s.route, entries, mode, nextVersion())
+}
+
+/*
+Atomic runs fn against the store; the caller already guarantees
+exclusive access. Every write fn makes shares one commit sequence.
+*/
+func (s *store) Atomic(keys []string, fn func(tx Tx) error) error {
+	return fn(&routedTx{route: s.route})
+}
+
+/*
+route sends every key to s itself, for code shared with partitioned
+stores.
+*/
+func (s *store) route(string) *store {
+	return s
+}
+
 func (s *store) Close() error {
 	return nil
 }
@@ -90,36 +140,194 @@ never persist dead keys.
 Early-exit is honored to support efficient snapshot streaming.
 */
 func (s *store) Iterate(fn func(key string, value Entry) bool) {
-	for k, v := range s.data {
-		if v.ExpiresAtMillis > 0 && GetUnixTimestamp(time.Now()) >= v.ExpiresAtMillis {
-			continue
+	now := s.now()
+	s.data.each(func(k string, v Entry) bool {
+		if v.expired(now) {
+			return true
 		}
+		return fn(k, v.materialized())
+	})
+}
 
-		if !fn(k, v) {
-			break
-		}
+/*
+ScanEntries copies the live entries of whole slots starting at cursor.
+See keyspace.scan for the cursor contract.
+*/
+func (s *store) ScanEntries(cursor uint64, count int) ([]KeyValue, uint64) {
+	if cursor >= scanSlots {
+		return nil, 0
 	}
+
+	now := s.now()
+	var entries []KeyValue
+	next := s.data.scan(cursor, count, func(key string, value Entry) {
+		if !value.expired(now) {
+			entries = append(entries, KeyValue{Key: key, Entry: value.materialized()})
+		}
+	})
+	return entries, next
+}
+
+/*
+appendSlot appends the live entries of one slot to entries.
+*/
+func (s *store) appendSlot(entries []KeyValue, slot int, now int64) []KeyValue {
+	s.data.eachIn(slot, func(key string, val Entry) bool {
+		if !val.expired(now) {
+			entries = append(entries, KeyValue{Key: key, Entry: val.materialized()})
+		}
+		return true
+	})
+	return entries
 }
 
 /*
-get retrieves the raw entry without applying expiration logic.
-Intended for internal use only.
+get retrieves a live entry. Expired entries are reported absent
+but not removed. Intended for internal use only.
 */
 func (s *store) get(key string) (Entry, bool) {
-	val, ok := s.data[key]
-	return val, ok
+	val, ok := s.data.get(key)
+	if !ok || val.expired(s.now()) {
+		return Entry{}, false
+	}
+	return val, true
+}
+
+/*
+now returns the store's current time in Unix milliseconds.
+*/
+func (s *store) now() int64 {
+	return unixNow(s.clock)
 }
 
 /*
 set inserts or overwrites a value in the store.
+Every call stamps the entry with a fresh version.
 */
 func (s *store) set(key string, value Entry) {
-	s.data[key] = value
+	s.setAt(key, value, nextVersion())
+}
+
+/*
+setAt writes a new value committed at sequence seq, which becomes its
+version. An entry without a write time is stamped with the current
+time, and the value is recorded in the key's history if a policy
+covers it.
+*/
+func (s *store) setAt(key string, value Entry, seq uint64) {
+	now := s.now()
+	if value.WrittenAtMillis == 0 {
+		value.WrittenAtMillis = now
+	}
+
+	value = s.putAt(key, value, seq)
+	s.recordRevision(key, value, now)
+}
+
+/*
+putAt stores value at sequence seq and returns it as stored. The
+replaced version is kept if an open view needs it.
+*/
+func (s *store) putAt(key string, value Entry, seq uint64) Entry {
+	s.retire(key, seq)
+	value.Version = seq
+	s.data.put(key, value)
+	return value
 }
 
 /*
 remove deletes a key from the store.
 */
 func (s *store) remove(key string) {
-	delete(s.data, key)
+	s.removeAt(key, 0)
+}
+
+/*
+removeAt deletes a key at sequence seq, keeping its last version if an
+open view needs it. A seq of 0 draws a sequence only when views are
+open: only expired entries are removed, and views opened later never
+see those.
+*/
+func (s *store) removeAt(key string, seq uint64) {
+	if seq == 0 && s.views.active() {
+		seq = nextVersion()
+	}
+	if seq != 0 {
+		s.retire(key, seq)
+	}
+	s.data.del(key)
+}
+
+/*
+moveTo transfers a key's raw entry, version included, to dst, together
+with the versions kept for views and its recorded history. Missing
+keys are ignored. The caller holds both stores exclusively.
+*/
+func (s *store) moveTo(dst *store, key string) {
+	if chain := s.data.versions(key); chain != nil {
+		dst.data.setVersions(key, chain)
+		s.data.setVersions(key, nil)
+	}
+	if h := s.data.history(key); h != nil {
+		dst.data.setHistory(key, h)
+		s.data.setHistory(key, nil)
+	}
+
+	val, ok := s.data.get(key)
+	if !ok {
+		return
+	}
+	dst.data.put(key, val)
+	s.data.del(key)
+}
+
+/*
+sweepExpired visits up to slots slots from where the last call stopped
+and removes their expired entries, at most limit of them, 0 meaning no
+limit. It reports how many entries it removed and how many slots it
+finished: one whose entries hit the limit is resumed by the next call.
+
+Slots without any entry that has a TTL are skipped without visiting
+their entries, so a keyspace with few expiring keys is swept for the
+cost of its slot count. Each finished slot also drops the old versions
+no open view needs any more and the revisions its history policies no
+longer retain, and a slab-backed store is defragmented first if it
+needs it.
+The caller must hold the store exclusively.
+*/
+func (s *store) sweepExpired(slots, limit int) (removed, swept int) {
+	now := s.now()
+	s.data.defragment()
+
+	for ; swept < slots; swept++ {
+		s.collectVersions(s.sweepSlot)
+		s.trimHistories(s.sweepSlot, now)
+
+		if s.data.hasTTLs(s.sweepSlot) {
+			stopped := !s.data.eachIn(s.sweepSlot, func(key string, val Entry) bool {
+				if !val.expired(now) {
+					return true
+				}
+				s.remove(key)
+				removed++
+				return limit == 0 || removed < limit
+			})
+
+			// Resume from this slot: it may hold more expired keys
+			if stopped {
+				return removed, swept
+			}
+		}
+		s.sweepSlot = (s.sweepSlot + 1) % scanSlots
+	}
+	return removed, swept
+}
+
+/*
+SweepExpired removes every expired entry. The plain store has no
+concurrency control, so this runs in one pass.
+*/
+func (s *store) SweepExpired() int {
+	removed, _ := s.sweepExpired(scanSlots, 0)
+	return removed
 }
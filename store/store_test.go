@@ -1,6 +1,7 @@
 package store
 
 import (
+	"strconv"
 	"testing"
 	"time"
 )
@@ -113,13 +114,14 @@ func TestExpireExistingKey(t *testing.T) {
 	}
 }
 
-func TestExpiredKeyIsDeletedOnRead(t *testing.T) {
-	store := NewStore()
+func TestExpiredKeyIsAbsentOnRead(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	store := NewStore(WithClock(clock))
 
 	_ = store.Write("a", Entry{Value: []byte("1")}, PutOverwrite)
-	_ = store.Expire("a", GetUnixTimestamp(time.Now().Add(10*time.Millisecond)))
+	_ = store.Expire("a", GetUnixTimestamp(clock.Now().Add(10*time.Millisecond)))
 
-	time.Sleep(20 * time.Millisecond)
+	clock.Advance(20 * time.Millisecond)
 
 	_, ok := store.Read("a")
 	if ok {
@@ -130,6 +132,144 @@ func TestExpiredKeyIsDeletedOnRead(t *testing.T) {
 	if ok {
 		t.Fatalf("expired key should not reappear")
 	}
+
+	// Reads do not mutate; deletion is left to the sweeper
+	if storedEntries(store) != 1 {
+		t.Fatalf("read must not delete expired key")
+	}
+	if n := store.(Sweeper).SweepExpired(); n != 1 {
+		t.Fatalf("expected sweep to remove 1 key, removed %d", n)
+	}
+}
+
+func TestExpiredKeyCanBeReplacedWithPutIfAbsent(t *testing.T) {
+	store := NewStore()
+
+	_ = store.Write("a", Entry{Value: []byte("old")}, PutOverwrite)
+	_ = store.Expire("a", GetUnixTimestamp(time.Now().Add(-time.Millisecond)))
+
+	if err := store.Write("a", Entry{Value: []byte("new")}, PutIfAbsent); err != nil {
+		t.Fatalf("expired key must count as absent, got %v", err)
+	}
+	if err := store.Write("b", Entry{Value: []byte("new")}, PutUpdate); err != ErrKeyNotFound {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+}
+
+func TestSweeperRemovesExpiredKeys(t *testing.T) {
+	stores := map[string]DataStore{
+		"Locked":    NewLockedStore(WithSweepInterval(5 * time.Millisecond)),
+		"Sharded":   NewShardedStore(4, WithSweepInterval(5*time.Millisecond)),
+		"EventLoop": NewEventloopStore(16, WithSweepInterval(5*time.Millisecond)),
+		"Ordered":   NewOrderedStore(WithSweepInterval(5 * time.Millisecond)),
+	}
+
+	for name, s := range stores {
+		t.Run(name, func(t *testing.T) {
+			defer s.Close()
+
+			past := GetUnixTimestamp(time.Now().Add(-time.Millisecond))
+			for i := 0; i < 300; i++ {
+				key := strconv.Itoa(i)
+				_ = s.Write(key, Entry{Value: []byte("v")}, PutOverwrite)
+				if i%2 == 0 {
+					_ = s.Expire(key, past)
+				}
+			}
+
+			deadline := time.Now().Add(2 * time.Second)
+			for storedEntries(s) != 150 {
+				if time.Now().After(deadline) {
+					t.Fatalf("sweeper did not remove expired keys, %d stored", storedEntries(s))
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+		})
+	}
+}
+
+/*
+storedEntries counts raw entries, expired ones included, which only
+the sweeper or a write can remove.
+*/
+func storedEntries(s DataStore) int {
+	if sharded, ok := s.(*shardedStore); ok {
+		return sharded.ShardStats().Total
+	}
+
+	n := 0
+	_ = s.Atomic(nil, func(tx Tx) error {
+		n = tx.(*routedTx).route("").data.len()
+		return nil
+	})
+	return n
+}
+
+func TestManualSweepWithoutBackgroundSweeper(t *testing.T) {
+	s := NewShardedStore(4, WithSweepInterval(0))
+	defer s.Close()
+
+	past := GetUnixTimestamp(time.Now().Add(-time.Millisecond))
+	for i := 0; i < 1000; i++ {
+		key := strconv.Itoa(i)
+		_ = s.Write(key, Entry{Value: []byte("v")}, PutOverwrite)
+		_ = s.Expire(key, past)
+	}
+
+	// Expired entries linger until swept
+	if stats := s.(*shardedStore).ShardStats(); stats.Total != 1000 {
+		t.Fatalf("expected expired entries to remain, got %+v", stats)
+	}
+	if n := s.(Sweeper).SweepExpired(); n != 1000 {
+		t.Fatalf("expected 1000 swept keys, got %d", n)
+	}
+	if stats := s.(*shardedStore).ShardStats(); stats.Total != 0 {
+		t.Fatalf("expected empty store after sweep, got %+v", stats)
+	}
+}
+
+func TestSweepTickCoversKeyspaceInSteps(t *testing.T) {
+	s := NewLockedStore(WithSweepInterval(0)).(*lockedStore)
+	defer s.Close()
+
+	past := GetUnixTimestamp(time.Now().Add(-time.Millisecond))
+	for i := 0; i < 4096; i++ {
+		key := strconv.Itoa(i)
+		_ = s.Write(key, Entry{Value: []byte("v")}, PutOverwrite)
+		_ = s.Expire(key, past)
+	}
+
+	// One tick visits only part of the keyspace
+	removed := s.sweepTick()
+	if removed == 0 || removed >= 4096 {
+		t.Fatalf("expected a partial sweep, removed %d", removed)
+	}
+
+	for i := 1; i < scanSlots/sweepTickSlots; i++ {
+		removed += s.sweepTick()
+	}
+	if removed != 4096 || s.store.data.len() != 0 {
+		t.Fatalf("expected every key swept after a full cycle, removed %d, left %d", removed, s.store.data.len())
+	}
+}
+
+/*
+BenchmarkSweepTick measures one background sweep over a large
+keyspace where nothing expires, the common case it must stay cheap
+for: the store is held for the whole tick.
+*/
+func BenchmarkSweepTick(b *testing.B) {
+	s := NewLockedStore(WithSweepInterval(0)).(*lockedStore)
+	defer s.Close()
+
+	for i := 0; i < 1<<20; i++ {
+		_ = s.Write("key:"+strconv.Itoa(i), Entry{Value: []byte("v")}, PutOverwrite)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.sweepTick()
+	}
 }
 
 func TestExpireMissingKey(t *testing.T) {
@@ -142,14 +282,15 @@ func TestExpireMissingKey(t *testing.T) {
 }
 
 func TestExpireDoesNotResurrectExpiredKey(t *testing.T) {
-	store := NewStore()
+	clock := NewManualClock(time.Now())
+	store := NewStore(WithClock(clock))
 
 	_ = store.Write("a", Entry{Value: []byte("1")}, PutOverwrite)
-	_ = store.Expire("a", GetUnixTimestamp(time.Now().Add(10*time.Millisecond)))
+	_ = store.Expire("a", GetUnixTimestamp(clock.Now().Add(10*time.Millisecond)))
 
-	time.Sleep(20 * time.Millisecond)
+	clock.Advance(20 * time.Millisecond)
 
-	ok := store.Expire("a", GetUnixTimestamp(time.Now().Add(time.Second)))
+	ok := store.Expire("a", GetUnixTimestamp(clock.Now().Add(time.Second)))
 	if ok {
 		t.Fatalf("expected expire to fail on expired key")
 	}
@@ -201,7 +342,7 @@ func TestShardedStore_Close(t *testing.T) {
 
 
 func TestStore_IterateSkipsExpired(t *testing.T) {
-	s := &store{data: make(map[string]Entry)}
+	s := NewStore().(*store)
 
 	s.set("live", Entry{Value: []byte("ok")})
 	s.set("dead", Entry{
@@ -224,7 +365,7 @@ func TestStore_IterateSkipsExpired(t *testing.T) {
 }
 
 func TestStore_IterateEarlyStop(t *testing.T) {
-	s := &store{data: make(map[string]Entry)}
+	s := NewStore().(*store)
 	s.set("a", Entry{Value: []byte("1")})
 	s.set("b", Entry{Value: []byte("2")})
 
@@ -240,8 +381,126 @@ func TestStore_IterateEarlyStop(t *testing.T) {
 }
 
 func TestStore_Close(t *testing.T) {
-	s := &store{data: make(map[string]Entry)}
+	s := NewStore().(*store)
 	if err := s.Close(); err != nil {
 		t.Fatalf("close failed")
 	}
 }
+
+func TestWriteBatch_PutIfAbsentIsAllOrNothing(t *testing.T) {
+	store := NewStore()
+
+	_ = store.Write("b", Entry{Value: []byte("old")}, PutOverwrite)
+
+	err := store.WriteBatch([]KeyValue{
+		{Key: "a", Entry: Entry{Value: []byte("1")}},
+		{Key: "b", Entry: Entry{Value: []byte("2")}},
+	}, PutIfAbsent)
+	if err != ErrKeyExists {
+		t.Fatalf("expected ErrKeyExists, got %v", err)
+	}
+
+	if _, ok := store.Read("a"); ok {
+		t.Fatalf("rejected batch must not write any key")
+	}
+
+	val, _ := store.Read("b")
+	if string(val.Value) != "old" {
+		t.Fatalf("rejected batch overwrote existing key")
+	}
+}
+
+func TestWriteBatch_PutUpdateRequiresAllKeys(t *testing.T) {
+	store := NewStore()
+
+	_ = store.Write("a", Entry{Value: []byte("1")}, PutOverwrite)
+
+	err := store.WriteBatch([]KeyValue{
+		{Key: "a", Entry: Entry{Value: []byte("2")}},
+		{Key: "missing", Entry: Entry{Value: []byte("2")}},
+	}, PutUpdate)
+	if err != ErrKeyNotFound {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+
+	val, _ := store.Read("a")
+	if string(val.Value) != "1" {
+		t.Fatalf("rejected batch updated a key")
+	}
+}
+
+func TestWriteBatch_InvalidPutMode(t *testing.T) {
+	store := NewStore()
+
+	err := store.WriteBatch([]KeyValue{{Key: "a"}}, PutMode(10))
+	if err != ErrInvalidPutMode {
+		t.Fatalf("expected ErrInvalidPutMode, got %v", err)
+	}
+}
+
+func TestReadBatch_SkipsMissingAndExpired(t *testing.T) {
+	store := NewStore()
+
+	_ = store.Write("live", Entry{Value: []byte("1")}, PutOverwrite)
+	_ = store.Write("dead", Entry{
+		Value:           []byte("x"),
+		ExpiresAtMillis: GetUnixTimestamp(time.Now()) - 1,
+	}, PutOverwrite)
+
+	got := store.ReadBatch([]string{"live", "dead", "missing"})
+	if len(got) != 1 || string(got["live"].Value) != "1" {
+		t.Fatalf("unexpected batch read result: %v", got)
+	}
+}
+
+func TestEntryVersion_ChangesOnEveryMutation(t *testing.T) {
+	store := NewStore()
+
+	_ = store.Write("a", Entry{Value: []byte("1")}, PutOverwrite)
+	v1, _ := store.Read("a")
+
+	_ = store.Expire("a", GetUnixTimestamp(time.Now().Add(time.Hour)))
+	v2, _ := store.Read("a")
+
+	_ = store.Write("a", Entry{Value: []byte("1")}, PutOverwrite)
+	v3, _ := store.Read("a")
+
+	if v1.Version == 0 || v1.Version >= v2.Version || v2.Version >= v3.Version {
+		t.Fatalf("versions must strictly increase: %d, %d, %d", v1.Version, v2.Version, v3.Version)
+	}
+}
+
+func TestEntryVersion_IgnoresCallerValue(t *testing.T) {
+	store := NewStore()
+
+	_ = store.Write("a", Entry{Value: []byte("1"), Version: 1}, PutOverwrite)
+	val, _ := store.Read("a")
+
+	if val.Version == 1 {
+		t.Fatalf("store must assign versions itself")
+	}
+}
+
+func TestShardedStore_AtomicRejectsUndeclaredKeys(t *testing.T) {
+	s := NewShardedStore(64)
+	sharded := s.(*shardedStore)
+
+	// Find a key living on a different shard than "a"
+	other := ""
+	for i := 0; other == ""; i++ {
+		key := string(rune('b' + i))
+		if sharded.shardIndex(key) != sharded.shardIndex("a") {
+			other = key
+		}
+	}
+
+	err := s.Atomic([]string{"a"}, func(tx Tx) error {
+		if err := tx.Write("a", Entry{Value: []byte("1")}, PutOverwrite); err != nil {
+			return err
+		}
+		return tx.Write(other, Entry{Value: []byte("1")}, PutOverwrite)
+	})
+	if err != ErrKeyNotDeclared {
+		t.Fatalf("expected ErrKeyNotDeclared, got %v", err)
+	}
+}
@@ -0,0 +1,390 @@
+package storetest
+
+import (
+	"math/rand"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"hermes/store"
+)
+
+/*
+testConcurrency races operations against each other. Every store must
+be safe for concurrent use, and single-key operations, batches and
+transactions must each take effect at one instant: no reader sees a
+torn batch, no update is lost, and conflicting inserts have exactly
+one winner. Run it with -race.
+*/
+func testConcurrency(t *testing.T, newStore Factory) {
+	t.Run("WritesSameKey", func(t *testing.T) { testConcurrentWritesSameKey(t, newStore) })
+	t.Run("ReadsAndWrites", func(t *testing.T) { testConcurrentReadsAndWrites(t, newStore) })
+	t.Run("ExpireAndRead", func(t *testing.T) { testConcurrentExpireAndRead(t, newStore) })
+	t.Run("BatchesAreAtomic", func(t *testing.T) { testConcurrentBatchesAreAtomic(t, newStore) })
+	t.Run("PutIfAbsentBatches", func(t *testing.T) { testConcurrentPutIfAbsentBatches(t, newStore) })
+	t.Run("AtomicReadModifyWrite", func(t *testing.T) { testConcurrentAtomicReadModifyWrite(t, newStore) })
+	t.Run("ScanDuringWrites", func(t *testing.T) { testScanDuringWrites(t, newStore) })
+	t.Run("Linearizable", func(t *testing.T) { testLinearizable(t, newStore) })
+}
+
+/*
+Multiple goroutines writing the same key concurrently.
+Final value must be one of the written values and no corruption
+or panic should occur.
+*/
+func testConcurrentWritesSameKey(t *testing.T, newStore Factory) {
+	s, _ := open(t, newStore)
+
+	const writers = 50
+	var wg sync.WaitGroup
+	wg.Add(writers)
+
+	for i := 0; i < writers; i++ {
+		go func(i int) {
+			defer wg.Done()
+			_ = s.Write("key", store.Entry{Value: []byte{byte(i)}}, store.PutOverwrite)
+		}(i)
+	}
+
+	wg.Wait()
+
+	val, ok := s.Read("key")
+	if !ok {
+		t.Fatalf("expected key to exist")
+	}
+	if len(val.Value) != 1 || val.Value[0] >= writers {
+		t.Fatalf("unexpected value corruption: %v", val.Value)
+	}
+}
+
+/*
+Readers and writers operate concurrently.
+Reads must only ever observe a value that was written.
+*/
+func testConcurrentReadsAndWrites(t *testing.T, newStore Factory) {
+	s, _ := open(t, newStore)
+
+	_ = s.Write("key", entry("init"), store.PutOverwrite)
+
+	const goroutines = 50
+	var wg sync.WaitGroup
+	wg.Add(goroutines * 2)
+
+	for i := 0; i < goroutines; i++ {
+		go func() {
+			defer wg.Done()
+			if val, ok := s.Read("key"); !ok || (string(val.Value) != "init" && string(val.Value) != "updated") {
+				t.Errorf("read observed %q %v", val.Value, ok)
+			}
+		}()
+
+		go func() {
+			defer wg.Done()
+			_ = s.Write("key", entry("updated"), store.PutOverwrite)
+		}()
+	}
+
+	wg.Wait()
+	mustRead(t, s, "key", "updated")
+}
+
+/*
+Readers race with the clock passing a key's deadline. Once a reader
+has seen the key expired, no later read may see it again.
+*/
+func testConcurrentExpireAndRead(t *testing.T, newStore Factory) {
+	s, clock := open(t, newStore)
+
+	_ = s.Write("key", entry("value"), store.PutOverwrite)
+	_ = s.Expire("key", at(clock, 20*time.Millisecond))
+
+	const readers = 8
+	var wg sync.WaitGroup
+	wg.Add(readers)
+
+	for i := 0; i < readers; i++ {
+		go func() {
+			defer wg.Done()
+
+			expired := false
+			for j := 0; j < 200; j++ {
+				_, ok := s.Read("key")
+				if expired && ok {
+					t.Errorf("expired key became visible again")
+					return
+				}
+				expired = !ok
+			}
+		}()
+	}
+
+	for i := 0; i < 40; i++ {
+		clock.Advance(time.Millisecond)
+	}
+	wg.Wait()
+
+	mustBeAbsent(t, s, "key")
+}
+
+/*
+Batches over the same keys race with batch readers.
+Keys are written in opposite orders to provoke lock-order deadlocks,
+and readers must never observe a partially applied batch.
+*/
+func testConcurrentBatchesAreAtomic(t *testing.T, newStore Factory) {
+	s, _ := open(t, newStore)
+
+	keys := []string{"a", "b", "c", "d"}
+
+	const writers = 20
+	var wg sync.WaitGroup
+	wg.Add(writers * 2)
+
+	for i := 0; i < writers; i++ {
+		go func(i int) {
+			defer wg.Done()
+
+			batch := make([]store.KeyValue, len(keys))
+			for j, key := range keys {
+				batch[j] = store.KeyValue{Key: key, Entry: store.Entry{Value: []byte{byte(i)}}}
+			}
+			if i%2 == 1 {
+				for l, r := 0, len(batch)-1; l < r; l, r = l+1, r-1 {
+					batch[l], batch[r] = batch[r], batch[l]
+				}
+			}
+			_ = s.WriteBatch(batch, store.PutOverwrite)
+		}(i)
+
+		go func() {
+			defer wg.Done()
+
+			got := s.ReadBatch(keys)
+			if len(got) == 0 {
+				return
+			}
+			if len(got) != len(keys) {
+				t.Errorf("partial batch observed: %d of %d keys", len(got), len(keys))
+				return
+			}
+			for _, key := range keys {
+				if got[key].Value[0] != got[keys[0]].Value[0] {
+					t.Errorf("torn batch observed: %v", got)
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+}
+
+/*
+Overlapping PutIfAbsent batches race for the same keys.
+Exactly one batch may win, and no key from a losing batch may leak.
+*/
+func testConcurrentPutIfAbsentBatches(t *testing.T, newStore Factory) {
+	s, _ := open(t, newStore)
+
+	const writers = 20
+	var wg sync.WaitGroup
+	wg.Add(writers)
+
+	results := make(chan int, writers)
+	for i := 0; i < writers; i++ {
+		go func(i int) {
+			defer wg.Done()
+
+			err := s.WriteBatch([]store.KeyValue{
+				{Key: "x", Entry: store.Entry{Value: []byte{byte(i)}}},
+				{Key: "y", Entry: store.Entry{Value: []byte{byte(i)}}},
+			}, store.PutIfAbsent)
+			if err == nil {
+				results <- i
+			}
+		}(i)
+	}
+
+	wg.Wait()
+	close(results)
+
+	winners := 0
+	winner := -1
+	for i := range results {
+		winners++
+		winner = i
+	}
+	if winners != 1 {
+		t.Fatalf("expected exactly one winning batch, got %d", winners)
+	}
+
+	got := s.ReadBatch([]string{"x", "y"})
+	if got["x"].Value[0] != byte(winner) || got["y"].Value[0] != byte(winner) {
+		t.Fatalf("losing batch leaked into store")
+	}
+}
+
+/*
+Atomic read-modify-write cycles over two keys run concurrently.
+Without isolation increments would be lost; with it both counters
+end up equal to the number of transactions.
+*/
+func testConcurrentAtomicReadModifyWrite(t *testing.T, newStore Factory) {
+	s, _ := open(t, newStore)
+
+	const txns = 50
+	var wg sync.WaitGroup
+	wg.Add(txns)
+
+	increment := func(tx store.Tx, key string) error {
+		n := 0
+		if val, ok := tx.Read(key); ok {
+			n, _ = strconv.Atoi(string(val.Value))
+		}
+		return tx.Write(key, entry(strconv.Itoa(n+1)), store.PutOverwrite)
+	}
+
+	for i := 0; i < txns; i++ {
+		go func() {
+			defer wg.Done()
+			err := s.Atomic([]string{"left", "right"}, func(tx store.Tx) error {
+				if err := increment(tx, "left"); err != nil {
+					return err
+				}
+				return increment(tx, "right")
+			})
+			if err != nil {
+				t.Errorf("atomic failed: %v", err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	got := s.ReadBatch([]string{"left", "right"})
+	for _, key := range []string{"left", "right"} {
+		if string(got[key].Value) != strconv.Itoa(txns) {
+			t.Fatalf("lost update on %s: got %s", key, got[key].Value)
+		}
+	}
+}
+
+/*
+A scan stepping through the store while other goroutines add and
+remove keys must return every key that stays present throughout.
+*/
+func testScanDuringWrites(t *testing.T, newStore Factory) {
+	s, clock := open(t, newStore)
+	scanner, ok := store.AsScanner(s)
+	if !ok {
+		t.Skip("store is not a Scanner")
+	}
+
+	const stable = 2000
+	for i := 0; i < stable; i++ {
+		_ = s.Write("stable:"+strconv.Itoa(i), entry("v"), store.PutOverwrite)
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			past := at(clock, -time.Second)
+			for i := 0; ; i++ {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				key := "churn:" + strconv.Itoa(w) + ":" + strconv.Itoa(i%100)
+				_ = s.Write(key, entry("v"), store.PutOverwrite)
+				s.Expire(key, past)
+			}
+		}(w)
+	}
+
+	seen := make(map[string]bool, stable)
+	var cursor uint64
+	for {
+		entries, next := scanner.ScanEntries(cursor, 10)
+		for _, kv := range entries {
+			seen[kv.Key] = true
+		}
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+
+	close(done)
+	wg.Wait()
+
+	for i := 0; i < stable; i++ {
+		if !seen["stable:"+strconv.Itoa(i)] {
+			t.Fatalf("stable key %d was never returned", i)
+		}
+	}
+}
+
+/*
+Randomized single-key operations from many clients, on few keys and
+while the clock moves, are recorded and must form a linearizable
+history. The seed is logged on failure; the interleaving is up to the
+scheduler, so a replay may not fail the same way.
+*/
+func testLinearizable(t *testing.T, newStore Factory) {
+	const (
+		clients = 8
+		ops     = 150
+	)
+	keys := []string{"a", "b", "c"}
+
+	s, clock := open(t, newStore)
+	rec := NewRecorder(s, clock)
+
+	seed := time.Now().UnixNano()
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(clients)
+
+	for c := 0; c < clients; c++ {
+		go func(rnd *rand.Rand) {
+			defer wg.Done()
+			<-start
+			for i := 0; i < ops; i++ {
+				randomOperation(rec, clock, rnd, keys[rnd.Intn(len(keys))])
+			}
+		}(rand.New(rand.NewSource(seed + int64(c))))
+	}
+
+	close(start)
+	wg.Wait()
+
+	if err := CheckLinearizable(rec.History()); err != nil {
+		t.Fatalf("seed %d: %v", seed, err)
+	}
+}
+
+/*
+randomOperation issues one operation on key: reads, writes in every
+put mode, expires with deadlines around the current time, and now and
+then a clock tick so that those deadlines pass.
+*/
+func randomOperation(s store.DataStore, clock *store.ManualClock, rnd *rand.Rand, key string) {
+	switch n := rnd.Intn(20); {
+	case n < 2:
+		clock.Advance(time.Millisecond)
+	case n < 9:
+		s.Read(key)
+	case n < 16:
+		modes := []store.PutMode{store.PutOverwrite, store.PutIfAbsent, store.PutUpdate}
+		value := strconv.Itoa(rnd.Intn(1000))
+		_ = s.Write(key, entry(value), modes[rnd.Intn(len(modes))])
+	default:
+		deadline := at(clock, time.Duration(rnd.Intn(10)-2)*time.Millisecond)
+		s.Expire(key, max(deadline, 1))
+	}
+}
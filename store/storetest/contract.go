@@ -0,0 +1,413 @@
+package storetest
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"hermes/store"
+)
+
+/*
+testPutModes checks single-key writes: overwrite always wins, insert
+only succeeds on absent keys, update only on present ones, and a
+rejected write leaves the key as it was.
+*/
+func testPutModes(t *testing.T, newStore Factory) {
+	t.Run("Overwrite", func(t *testing.T) {
+		s, _ := open(t, newStore)
+
+		if err := s.Write("a", entry("1"), store.PutOverwrite); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := s.Write("a", entry("2"), store.PutOverwrite); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		mustRead(t, s, "a", "2")
+	})
+
+	t.Run("IfAbsent", func(t *testing.T) {
+		s, _ := open(t, newStore)
+
+		if err := s.Write("a", entry("1"), store.PutIfAbsent); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := s.Write("a", entry("2"), store.PutIfAbsent); !errors.Is(err, store.ErrKeyExists) {
+			t.Fatalf("expected ErrKeyExists, got %v", err)
+		}
+		mustRead(t, s, "a", "1")
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		s, _ := open(t, newStore)
+
+		if err := s.Write("a", entry("1"), store.PutUpdate); !errors.Is(err, store.ErrKeyNotFound) {
+			t.Fatalf("expected ErrKeyNotFound, got %v", err)
+		}
+		mustBeAbsent(t, s, "a")
+
+		_ = s.Write("a", entry("1"), store.PutOverwrite)
+		if err := s.Write("a", entry("2"), store.PutUpdate); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		mustRead(t, s, "a", "2")
+	})
+
+	t.Run("InvalidMode", func(t *testing.T) {
+		s, _ := open(t, newStore)
+
+		if err := s.Write("a", entry("1"), store.PutMode(10)); !errors.Is(err, store.ErrInvalidPutMode) {
+			t.Fatalf("expected ErrInvalidPutMode, got %v", err)
+		}
+		mustBeAbsent(t, s, "a")
+	})
+
+	t.Run("BinaryValues", func(t *testing.T) {
+		s, _ := open(t, newStore)
+
+		value := []byte{0, '\n', ' ', 0xff, '\r'}
+		_ = s.Write("a", store.Entry{Value: value}, store.PutOverwrite)
+		if got, _ := s.Read("a"); !slices.Equal(got.Value, value) {
+			t.Fatalf("expected %v, got %v", value, got.Value)
+		}
+	})
+}
+
+/*
+testBatches checks that batches are all-or-nothing and that batch
+reads report only live keys.
+*/
+func testBatches(t *testing.T, newStore Factory) {
+	t.Run("WriteThenRead", func(t *testing.T) {
+		s, _ := open(t, newStore)
+
+		err := s.WriteBatch([]store.KeyValue{
+			{Key: "a", Entry: entry("1")},
+			{Key: "b", Entry: entry("2")},
+		}, store.PutOverwrite)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		got := s.ReadBatch([]string{"a", "missing", "b"})
+		if len(got) != 2 || string(got["a"].Value) != "1" || string(got["b"].Value) != "2" {
+			t.Fatalf("unexpected batch read: %v", got)
+		}
+	})
+
+	t.Run("IfAbsentIsAllOrNothing", func(t *testing.T) {
+		s, _ := open(t, newStore)
+
+		_ = s.Write("b", entry("old"), store.PutOverwrite)
+		err := s.WriteBatch([]store.KeyValue{
+			{Key: "a", Entry: entry("1")},
+			{Key: "b", Entry: entry("2")},
+		}, store.PutIfAbsent)
+		if !errors.Is(err, store.ErrKeyExists) {
+			t.Fatalf("expected ErrKeyExists, got %v", err)
+		}
+
+		mustBeAbsent(t, s, "a")
+		mustRead(t, s, "b", "old")
+	})
+
+	t.Run("UpdateRequiresAllKeys", func(t *testing.T) {
+		s, _ := open(t, newStore)
+
+		_ = s.Write("a", entry("1"), store.PutOverwrite)
+		err := s.WriteBatch([]store.KeyValue{
+			{Key: "a", Entry: entry("2")},
+			{Key: "missing", Entry: entry("2")},
+		}, store.PutUpdate)
+		if !errors.Is(err, store.ErrKeyNotFound) {
+			t.Fatalf("expected ErrKeyNotFound, got %v", err)
+		}
+
+		mustRead(t, s, "a", "1")
+		mustBeAbsent(t, s, "missing")
+	})
+
+	t.Run("InvalidMode", func(t *testing.T) {
+		s, _ := open(t, newStore)
+
+		err := s.WriteBatch([]store.KeyValue{{Key: "a", Entry: entry("1")}}, store.PutMode(10))
+		if !errors.Is(err, store.ErrInvalidPutMode) {
+			t.Fatalf("expected ErrInvalidPutMode, got %v", err)
+		}
+		mustBeAbsent(t, s, "a")
+	})
+
+	t.Run("SharesOneVersion", func(t *testing.T) {
+		s, _ := open(t, newStore)
+
+		_ = s.WriteBatch([]store.KeyValue{
+			{Key: "a", Entry: entry("1")},
+			{Key: "b", Entry: entry("2")},
+		}, store.PutOverwrite)
+
+		got := s.ReadBatch([]string{"a", "b"})
+		if got["a"].Version == 0 || got["a"].Version != got["b"].Version {
+			t.Fatalf("a batch must stamp one version, got %d and %d", got["a"].Version, got["b"].Version)
+		}
+	})
+}
+
+/*
+testVersions checks that the store assigns versions itself and that
+every mutation of a key moves its version forward.
+*/
+func testVersions(t *testing.T, newStore Factory) {
+	s, clock := open(t, newStore)
+
+	const callerVersion = 1 << 60
+	_ = s.Write("a", store.Entry{Value: []byte("1"), Version: callerVersion}, store.PutOverwrite)
+	v1, _ := s.Read("a")
+
+	_ = s.Expire("a", at(clock, time.Hour))
+	v2, _ := s.Read("a")
+
+	_ = s.Write("a", entry("1"), store.PutOverwrite)
+	v3, _ := s.Read("a")
+
+	if v1.Version == callerVersion {
+		t.Fatalf("store must assign versions itself")
+	}
+	if v1.Version == 0 || v1.Version >= v2.Version || v2.Version >= v3.Version {
+		t.Fatalf("versions must strictly increase: %d, %d, %d", v1.Version, v2.Version, v3.Version)
+	}
+}
+
+/*
+testTTL checks expiry against the store's clock. An expired key is
+absent to every read and counts as absent for put modes; Expire never
+brings it back.
+*/
+func testTTL(t *testing.T, newStore Factory) {
+	t.Run("LivesUntilDeadline", func(t *testing.T) {
+		s, clock := open(t, newStore)
+
+		_ = s.Write("a", entry("1"), store.PutOverwrite)
+		if !s.Expire("a", at(clock, 10*time.Second)) {
+			t.Fatalf("expected expire to succeed")
+		}
+
+		clock.Advance(10*time.Second - time.Millisecond)
+		if val := mustRead(t, s, "a", "1"); val.ExpiresAtMillis != at(clock, time.Millisecond) {
+			t.Fatalf("unexpected expiry %d", val.ExpiresAtMillis)
+		}
+
+		clock.Advance(time.Millisecond)
+		mustBeAbsent(t, s, "a")
+		if got := s.ReadBatch([]string{"a"}); len(got) != 0 {
+			t.Fatalf("batch read returned expired key: %v", got)
+		}
+	})
+
+	t.Run("ExpireMissingKey", func(t *testing.T) {
+		s, clock := open(t, newStore)
+
+		if s.Expire("missing", at(clock, time.Second)) {
+			t.Fatalf("expected expire to fail for a missing key")
+		}
+		mustBeAbsent(t, s, "missing")
+	})
+
+	t.Run("ExpireDoesNotResurrect", func(t *testing.T) {
+		s, clock := open(t, newStore)
+
+		_ = s.Write("a", entry("1"), store.PutOverwrite)
+		_ = s.Expire("a", at(clock, time.Second))
+		clock.Advance(2 * time.Second)
+
+		if s.Expire("a", at(clock, time.Hour)) {
+			t.Fatalf("expected expire to fail on an expired key")
+		}
+		mustBeAbsent(t, s, "a")
+	})
+
+	t.Run("PastDeadlineExpiresNow", func(t *testing.T) {
+		s, clock := open(t, newStore)
+
+		_ = s.Write("a", entry("1"), store.PutOverwrite)
+		_ = s.Expire("a", at(clock, -time.Millisecond))
+		mustBeAbsent(t, s, "a")
+	})
+
+	t.Run("ExpiredCountsAsAbsent", func(t *testing.T) {
+		s, clock := open(t, newStore)
+
+		_ = s.Write("a", entry("old"), store.PutOverwrite)
+		_ = s.Write("b", entry("old"), store.PutOverwrite)
+		_ = s.Expire("a", at(clock, time.Second))
+		_ = s.Expire("b", at(clock, time.Second))
+		clock.Advance(time.Second)
+
+		if err := s.Write("a", entry("new"), store.PutIfAbsent); err != nil {
+			t.Fatalf("expired key must count as absent, got %v", err)
+		}
+		mustRead(t, s, "a", "new")
+
+		if err := s.Write("b", entry("new"), store.PutUpdate); !errors.Is(err, store.ErrKeyNotFound) {
+			t.Fatalf("expected ErrKeyNotFound, got %v", err)
+		}
+		mustBeAbsent(t, s, "b")
+	})
+
+	t.Run("OverwriteClearsTTL", func(t *testing.T) {
+		s, clock := open(t, newStore)
+
+		_ = s.Write("a", entry("1"), store.PutOverwrite)
+		_ = s.Expire("a", at(clock, time.Second))
+		_ = s.Write("a", entry("2"), store.PutOverwrite)
+
+		clock.Advance(time.Hour)
+		mustRead(t, s, "a", "2")
+	})
+}
+
+/*
+testAtomic checks that a transaction reads its own writes and that
+its writes all become visible.
+*/
+func testAtomic(t *testing.T, newStore Factory) {
+	s, clock := open(t, newStore)
+	_ = s.Write("a", entry("1"), store.PutOverwrite)
+
+	err := s.Atomic([]string{"a", "b"}, func(tx store.Tx) error {
+		if err := tx.Write("b", entry("2"), store.PutIfAbsent); err != nil {
+			return err
+		}
+		if val, ok := tx.Read("b"); !ok || string(val.Value) != "2" {
+			t.Errorf("transaction must read its own write, got %q %v", val.Value, ok)
+		}
+		if !tx.Expire("a", at(clock, time.Second)) {
+			t.Errorf("expected expire inside the transaction to succeed")
+		}
+		if err := tx.Write("a", entry("x"), store.PutIfAbsent); !errors.Is(err, store.ErrKeyExists) {
+			t.Errorf("expected ErrKeyExists inside the transaction, got %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("atomic failed: %v", err)
+	}
+
+	mustRead(t, s, "b", "2")
+	clock.Advance(time.Second)
+	mustBeAbsent(t, s, "a")
+}
+
+/*
+testIteration checks whole-store traversals: views always, Iterate and
+ScanEntries when implemented. Each visits every live key exactly once,
+skips expired ones, and stops when asked.
+*/
+func testIteration(t *testing.T, newStore Factory) {
+	const keys = 500
+
+	seed := func(t *testing.T) (store.DataStore, *store.ManualClock) {
+		s, clock := open(t, newStore)
+		for i := 0; i < keys; i++ {
+			_ = s.Write("key:"+strconv.Itoa(i), entry("v"), store.PutOverwrite)
+		}
+		_ = s.Write("dead", entry("v"), store.PutOverwrite)
+		_ = s.Expire("dead", at(clock, -time.Millisecond))
+		return s, clock
+	}
+
+	checkSeen := func(t *testing.T, seen map[string]int) {
+		t.Helper()
+
+		if len(seen) != keys {
+			t.Fatalf("expected %d keys, got %d", keys, len(seen))
+		}
+		for key, n := range seen {
+			if n != 1 {
+				t.Fatalf("%s visited %d times", key, n)
+			}
+		}
+	}
+
+	t.Run("Iterate", func(t *testing.T) {
+		s, _ := seed(t)
+		iterable, ok := s.(store.Iterable)
+		if !ok {
+			t.Skip("store is not Iterable")
+		}
+
+		seen := make(map[string]int)
+		iterable.Iterate(func(key string, _ store.Entry) bool {
+			seen[key]++
+			return true
+		})
+		checkSeen(t, seen)
+
+		count := 0
+		iterable.Iterate(func(string, store.Entry) bool {
+			count++
+			return false
+		})
+		if count != 1 {
+			t.Fatalf("expected early stop, got %d", count)
+		}
+	})
+
+	t.Run("Scan", func(t *testing.T) {
+		s, _ := seed(t)
+		scanner, ok := store.AsScanner(s)
+		if !ok {
+			t.Skip("store is not a Scanner")
+		}
+
+		seen := make(map[string]int)
+		var cursor uint64
+		for {
+			entries, next := scanner.ScanEntries(cursor, 10)
+			for _, kv := range entries {
+				seen[kv.Key]++
+			}
+			if next == 0 {
+				break
+			}
+			cursor = next
+		}
+		checkSeen(t, seen)
+	})
+
+	t.Run("View", func(t *testing.T) {
+		s, clock := seed(t)
+		_ = s.Expire("key:0", at(clock, time.Second))
+
+		view := s.Snapshot()
+		defer view.Close()
+
+		_ = s.Write("key:1", entry("new"), store.PutOverwrite)
+		_ = s.Write("late", entry("v"), store.PutOverwrite)
+		clock.Advance(time.Second)
+
+		if val, ok := view.Read("key:1"); !ok || string(val.Value) != "v" {
+			t.Fatalf("view must keep key:1=v, got %q %v", val.Value, ok)
+		}
+		if _, ok := view.Read("key:0"); !ok {
+			t.Fatalf("view must evaluate TTLs when it was taken")
+		}
+		mustBeAbsent(t, s, "key:0")
+
+		seen := make(map[string]int)
+		view.Iterate(func(key string, value store.Entry) bool {
+			if string(value.Value) != "v" {
+				t.Fatalf("view saw %s=%q written after it", key, value.Value)
+			}
+			seen[key]++
+			return true
+		})
+		checkSeen(t, seen)
+
+		view.Close()
+		if _, ok := view.Read("key:2"); ok {
+			t.Fatalf("closed view must report keys absent")
+		}
+	})
+}
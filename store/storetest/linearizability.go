@@ -0,0 +1,248 @@
+package storetest
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"hermes/store"
+)
+
+// ErrNotLinearizable is returned by CheckLinearizable for a history
+// no sequential execution can explain.
+var ErrNotLinearizable = errors.New("history is not linearizable")
+
+/*
+CheckLinearizable reports whether history, as recorded by a Recorder,
+is linearizable for a key-value store: whether every operation can be
+given an instant within its call and return at which it took effect,
+such that the operations, applied in that order to a single map of
+registers, return exactly what was recorded.
+
+The register of a key follows the store's semantics: put modes decide
+whether a write applies, an overwrite clears the TTL, an expire only
+applies to a live key, and a key whose deadline has passed is absent.
+Whether a deadline has passed depends on the store clock at the
+chosen instant, which must lie within the operation's CallClock and
+ReturnClock and never go back along the order.
+
+Keys are independent, so each key's history is checked on its own
+(as in Porcupine), with a depth-first search over the possible orders
+that remembers the states it already ruled out (Wing & Gong, with
+Lowe's memoization).
+*/
+func CheckLinearizable(history []Operation) error {
+	byKey := make(map[string][]Operation)
+	for _, op := range history {
+		byKey[op.Key] = append(byKey[op.Key], op)
+	}
+
+	keys := make([]string, 0, len(byKey))
+	for key := range byKey {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		if err := checkKey(key, byKey[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+register is the model state of one key. now is the earliest store
+time the next operation can take effect at.
+*/
+type register struct {
+	present   bool
+	value     string
+	expiresAt int64
+	now       int64
+}
+
+/*
+step returns the states op can lead to from r. An operation has at
+most two outcomes: it took effect while the key was live, at the
+earliest possible time, or once it had expired, at the earliest time
+it was. Taking effect any later only constrains what follows.
+*/
+func (r register) step(op Operation) []register {
+	earliest := max(r.now, op.CallClock)
+	if earliest > op.ReturnClock {
+		return nil
+	}
+
+	var next []register
+	if r.present && (r.expiresAt == 0 || r.expiresAt > earliest) {
+		if s, ok := r.apply(op, true, earliest); ok {
+			next = append(next, s)
+		}
+	}
+	if !r.present || r.expiresAt != 0 {
+		if at := max(earliest, r.expiresAt); at <= op.ReturnClock {
+			if s, ok := r.apply(op, false, at); ok {
+				next = append(next, s)
+			}
+		}
+	}
+	return next
+}
+
+/*
+apply applies op at time now, with the key live or not, and reports
+whether op's recorded output matches.
+*/
+func (r register) apply(op Operation, live bool, now int64) (register, bool) {
+	if !live {
+		r = register{}
+	}
+	r.now = now
+
+	switch op.Kind {
+	case OpRead:
+		if op.OK != live || (live && op.Value != r.value) {
+			return r, false
+		}
+
+	case OpWrite:
+		applies := true
+		switch op.Mode {
+		case store.PutIfAbsent:
+			applies = !live
+		case store.PutUpdate:
+			applies = live
+		}
+		if op.OK != applies {
+			return r, false
+		}
+		if applies {
+			r.present, r.value, r.expiresAt = true, op.Value, 0
+		}
+
+	case OpExpire:
+		if op.OK != live {
+			return r, false
+		}
+		if live {
+			r.expiresAt = op.ExpiresAt
+		}
+	}
+	return r, true
+}
+
+type memoKey struct {
+	done  string
+	state register
+}
+
+/*
+linearizer searches the orders of one key's operations, sorted by
+call time.
+*/
+type linearizer struct {
+	ops  []Operation
+	done []byte
+	seen map[memoKey]struct{}
+
+	// deepest is the most operations any order managed to place, and
+	// stuck the operations that could not follow them.
+	deepest int
+	stuck   []Operation
+}
+
+func checkKey(key string, ops []Operation) error {
+	slices.SortFunc(ops, func(a, b Operation) int {
+		return cmp.Compare(a.Call, b.Call)
+	})
+
+	l := &linearizer{
+		ops:  ops,
+		done: make([]byte, (len(ops)+7)/8),
+		seen: make(map[memoKey]struct{}),
+	}
+	if l.search(0, register{}) {
+		return nil
+	}
+
+	described := make([]string, 0, len(l.stuck))
+	for _, op := range l.stuck {
+		described = append(described, describe(op))
+	}
+	return fmt.Errorf("%w: key %q: %d of %d operations placed, none of these can follow: %s",
+		ErrNotLinearizable, key, l.deepest, len(ops), strings.Join(described, "; "))
+}
+
+func (l *linearizer) search(placed int, state register) bool {
+	if placed == len(l.ops) {
+		return true
+	}
+
+	mk := memoKey{done: string(l.done), state: state}
+	if _, ok := l.seen[mk]; ok {
+		return false
+	}
+	l.seen[mk] = struct{}{}
+
+	// Only an operation called before every pending one returned can
+	// take effect next
+	deadline := int64(-1)
+	for i, op := range l.ops {
+		if !l.isDone(i) && (deadline < 0 || op.Return < deadline) {
+			deadline = op.Return
+		}
+	}
+
+	var candidates []Operation
+	for i, op := range l.ops {
+		if op.Call > deadline {
+			break
+		}
+		if l.isDone(i) {
+			continue
+		}
+		candidates = append(candidates, op)
+
+		for _, next := range state.step(op) {
+			l.setDone(i, true)
+			if l.search(placed+1, next) {
+				return true
+			}
+			l.setDone(i, false)
+		}
+	}
+
+	if placed >= l.deepest {
+		l.deepest, l.stuck = placed, candidates
+	}
+	return false
+}
+
+func (l *linearizer) isDone(i int) bool {
+	return l.done[i/8]&(1<<(i%8)) != 0
+}
+
+func (l *linearizer) setDone(i int, done bool) {
+	if done {
+		l.done[i/8] |= 1 << (i % 8)
+	} else {
+		l.done[i/8] &^= 1 << (i % 8)
+	}
+}
+
+func describe(op Operation) string {
+	var b strings.Builder
+	switch op.Kind {
+	case OpRead:
+		fmt.Fprintf(&b, "Read -> %q found=%v", op.Value, op.OK)
+	case OpWrite:
+		fmt.Fprintf(&b, "Write(%q, mode %d) -> ok=%v", op.Value, op.Mode, op.OK)
+	case OpExpire:
+		fmt.Fprintf(&b, "Expire(%d) -> ok=%v", op.ExpiresAt, op.OK)
+	}
+	fmt.Fprintf(&b, " [%d, %d] clock [%d, %d]", op.Call, op.Return, op.CallClock, op.ReturnClock)
+	return b.String()
+}
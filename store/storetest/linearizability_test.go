@@ -0,0 +1,208 @@
+package storetest
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+	"testing"
+
+	"hermes/store"
+)
+
+/*
+op builds a recorded operation spanning [call, ret] in both real time
+and clock time.
+*/
+func op(kind OpKind, value string, ok bool, call, ret int64) Operation {
+	return Operation{
+		Kind:        kind,
+		Key:         "k",
+		Mode:        store.PutOverwrite,
+		Value:       value,
+		OK:          ok,
+		Call:        call,
+		Return:      ret,
+		CallClock:   call,
+		ReturnClock: ret,
+	}
+}
+
+func TestCheckLinearizable(t *testing.T) {
+	expire := func(at int64, ok bool, call, ret int64) Operation {
+		o := op(OpExpire, "", ok, call, ret)
+		o.ExpiresAt = at
+		return o
+	}
+	ifAbsent := func(value string, ok bool, call, ret int64) Operation {
+		o := op(OpWrite, value, ok, call, ret)
+		o.Mode = store.PutIfAbsent
+		return o
+	}
+
+	tests := []struct {
+		name    string
+		history []Operation
+		ok      bool
+	}{
+		{
+			name: "sequential",
+			history: []Operation{
+				op(OpRead, "", false, 0, 1),
+				op(OpWrite, "1", true, 2, 3),
+				op(OpRead, "1", true, 4, 5),
+			},
+			ok: true,
+		},
+		{
+			name: "reads cannot see a write undone",
+			history: []Operation{
+				op(OpWrite, "1", true, 0, 1),
+				op(OpWrite, "2", true, 2, 10),
+				op(OpRead, "2", true, 3, 4),
+				op(OpRead, "1", true, 5, 6),
+			},
+			ok: false,
+		},
+		{
+			name: "overlapping reads agree with one order",
+			history: []Operation{
+				op(OpWrite, "1", true, 0, 1),
+				op(OpWrite, "2", true, 2, 10),
+				op(OpRead, "1", true, 3, 4),
+				op(OpRead, "2", true, 5, 6),
+			},
+			ok: true,
+		},
+		{
+			name: "stale read after a completed write",
+			history: []Operation{
+				op(OpWrite, "1", true, 0, 1),
+				op(OpWrite, "2", true, 2, 3),
+				op(OpRead, "1", true, 4, 5),
+			},
+			ok: false,
+		},
+		{
+			name: "two inserts cannot both win",
+			history: []Operation{
+				ifAbsent("1", true, 0, 10),
+				ifAbsent("2", true, 1, 11),
+			},
+			ok: false,
+		},
+		{
+			name: "insert wins once the key expired",
+			history: []Operation{
+				op(OpWrite, "1", true, 0, 1),
+				expire(5, true, 2, 3),
+				ifAbsent("2", false, 3, 4),
+				ifAbsent("3", true, 5, 6),
+				op(OpRead, "3", true, 7, 8),
+			},
+			ok: true,
+		},
+		{
+			name: "key visible after its deadline",
+			history: []Operation{
+				op(OpWrite, "1", true, 0, 1),
+				expire(5, true, 2, 3),
+				op(OpRead, "1", true, 6, 7),
+			},
+			ok: false,
+		},
+		{
+			name: "read across the deadline may see the key",
+			history: []Operation{
+				op(OpWrite, "1", true, 0, 1),
+				expire(5, true, 2, 3),
+				op(OpRead, "1", true, 4, 6),
+				op(OpRead, "", false, 4, 6),
+			},
+			ok: true,
+		},
+		{
+			name: "an expired key does not come back",
+			history: []Operation{
+				op(OpWrite, "1", true, 0, 1),
+				expire(5, true, 2, 3),
+				op(OpRead, "", false, 4, 6),
+				op(OpRead, "1", true, 4, 6),
+				op(OpRead, "1", true, 7, 8),
+			},
+			ok: false,
+		},
+		{
+			name: "expire of an expired key fails",
+			history: []Operation{
+				op(OpWrite, "1", true, 0, 1),
+				expire(2, true, 1, 1),
+				expire(9, true, 3, 4),
+			},
+			ok: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckLinearizable(tt.history)
+			if tt.ok && err != nil {
+				t.Fatalf("expected a linearizable history, got %v", err)
+			}
+			if !tt.ok && !errors.Is(err, ErrNotLinearizable) {
+				t.Fatalf("expected ErrNotLinearizable, got %v", err)
+			}
+		})
+	}
+}
+
+/*
+racyStore checks PutIfAbsent and then writes, without holding the key
+in between: the classic check-then-act race.
+*/
+type racyStore struct {
+	store.DataStore
+}
+
+func (s racyStore) Write(key string, value store.Entry, mode store.PutMode) error {
+	if mode != store.PutIfAbsent {
+		return s.DataStore.Write(key, value, mode)
+	}
+	if _, ok := s.DataStore.Read(key); ok {
+		return store.ErrKeyExists
+	}
+	runtime.Gosched()
+	return s.DataStore.Write(key, value, store.PutOverwrite)
+}
+
+func TestCheckLinearizable_FindsRace(t *testing.T) {
+	const clients = 8
+
+	for attempt := 0; attempt < 50; attempt++ {
+		clock := store.NewManualClock(epoch)
+		s := racyStore{store.NewLockedStore(store.WithClock(clock))}
+		rec := NewRecorder(s, clock)
+
+		start := make(chan struct{})
+		var wg sync.WaitGroup
+		wg.Add(clients)
+		for c := 0; c < clients; c++ {
+			go func(c int) {
+				defer wg.Done()
+				<-start
+				_ = rec.Write("k", entry(string(rune('a'+c))), store.PutIfAbsent)
+				rec.Read("k")
+			}(c)
+		}
+		close(start)
+		wg.Wait()
+		_ = s.Close()
+
+		if err := CheckLinearizable(rec.History()); err != nil {
+			if !errors.Is(err, ErrNotLinearizable) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			return
+		}
+	}
+	t.Fatalf("the check-then-act race was never detected")
+}
@@ -0,0 +1,433 @@
+package storetest
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"hermes/store"
+)
+
+/*
+testOps checks ops on typed values run through store.Apply: their
+replies, that emptying a value removes its key, that the key's TTL
+survives, that they run inside transactions, that views do not see
+them, and that concurrent mutations of one key are never lost.
+*/
+func testOps(t *testing.T, newStore Factory) {
+	t.Run("Lists", func(t *testing.T) { testListOps(t, newStore) })
+	t.Run("Hashes", func(t *testing.T) { testHashOps(t, newStore) })
+	t.Run("Sets", func(t *testing.T) { testSetOps(t, newStore) })
+	t.Run("SortedSets", func(t *testing.T) { testSortedSetOps(t, newStore) })
+	t.Run("Streams", func(t *testing.T) { testStreamOps(t, newStore) })
+	t.Run("JSON", func(t *testing.T) { testJSONOps(t, newStore) })
+	t.Run("WrongType", func(t *testing.T) { testOpWrongType(t, newStore) })
+	t.Run("KeepTTL", func(t *testing.T) { testOpKeepTTL(t, newStore) })
+	t.Run("InAtomic", func(t *testing.T) { testOpInAtomic(t, newStore) })
+	t.Run("Views", func(t *testing.T) { testOpViews(t, newStore) })
+	t.Run("Concurrent", func(t *testing.T) { testConcurrentOps(t, newStore) })
+}
+
+/*
+apply runs op against key at the clock's time and fails the test on
+error.
+*/
+func apply(t *testing.T, st store.Tx, clock store.Clock, key, name string, args ...string) any {
+	t.Helper()
+
+	reply, err := store.Apply(st, key, store.Op{Name: name, Args: args, Time: store.GetUnixTimestamp(clock.Now())})
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", name, key, err)
+	}
+	return reply
+}
+
+func testListOps(t *testing.T, newStore Factory) {
+	s, clock := open(t, newStore)
+
+	if n := apply(t, s, clock, "l", "RPUSH", "b", "c"); n != int64(2) {
+		t.Fatalf("expected RPUSH to reply 2, got %v", n)
+	}
+	if n := apply(t, s, clock, "l", "LPUSH", "a", "0"); n != int64(4) {
+		t.Fatalf("expected LPUSH to reply 4, got %v", n)
+	}
+	if got := apply(t, s, clock, "l", "LRANGE", "0", "-1"); !slices.Equal(got.([]string), []string{"0", "a", "b", "c"}) {
+		t.Fatalf("unexpected list %v", got)
+	}
+	if val, ok := s.Read("l"); !ok || val.Type != store.TypeList {
+		t.Fatalf("expected l to hold a list, got %+v", val)
+	}
+
+	if got := apply(t, s, clock, "l", "LPOP"); got != "0" {
+		t.Fatalf("expected LPOP to reply 0, got %v", got)
+	}
+	if got := apply(t, s, clock, "l", "RPOP"); got != "c" {
+		t.Fatalf("expected RPOP to reply c, got %v", got)
+	}
+	apply(t, s, clock, "l", "LTRIM", "1", "1")
+	if got := apply(t, s, clock, "l", "LINDEX", "0"); got != "b" {
+		t.Fatalf("expected LTRIM to keep b, got %v", got)
+	}
+
+	// Popping the last element removes the key
+	apply(t, s, clock, "l", "LPOP")
+	mustBeAbsent(t, s, "l")
+	if got := apply(t, s, clock, "l", "LPOP"); got != nil {
+		t.Fatalf("expected LPOP on a missing key to reply nil, got %v", got)
+	}
+	if n := apply(t, s, clock, "l", "LLEN"); n != int64(0) {
+		t.Fatalf("expected a missing key to have length 0, got %v", n)
+	}
+	mustBeAbsent(t, s, "l")
+}
+
+func testHashOps(t *testing.T, newStore Factory) {
+	s, clock := open(t, newStore)
+
+	if n := apply(t, s, clock, "h", "HSET", "a", "1", "b", "2"); n != int64(2) {
+		t.Fatalf("expected HSET to add 2 fields, got %v", n)
+	}
+	if n := apply(t, s, clock, "h", "HSET", "a", "3", "c", "4"); n != int64(1) {
+		t.Fatalf("expected HSET to add 1 field, got %v", n)
+	}
+	if got := apply(t, s, clock, "h", "HGET", "a"); got != "3" {
+		t.Fatalf("expected a=3, got %v", got)
+	}
+	if got := apply(t, s, clock, "h", "HMGET", "c", "missing"); !slices.Equal(got.([]any), []any{"4", nil}) {
+		t.Fatalf("unexpected HMGET reply %v", got)
+	}
+	if n := apply(t, s, clock, "h", "HINCRBY", "b", "10"); n != int64(12) {
+		t.Fatalf("expected HINCRBY to reply 12, got %v", n)
+	}
+	if got := apply(t, s, clock, "h", "HGETALL"); !slices.Equal(got.([]string), []string{"a", "3", "b", "12", "c", "4"}) {
+		t.Fatalf("unexpected HGETALL reply %v", got)
+	}
+	if val, ok := s.Read("h"); !ok || val.Type != store.TypeHash {
+		t.Fatalf("expected h to hold a hash, got %+v", val)
+	}
+
+	if n := apply(t, s, clock, "h", "HDEL", "a", "b", "missing"); n != int64(2) {
+		t.Fatalf("expected HDEL to remove 2 fields, got %v", n)
+	}
+	if n := apply(t, s, clock, "h", "HEXISTS", "a"); n != int64(0) {
+		t.Fatalf("expected a to be gone, got %v", n)
+	}
+	if n := apply(t, s, clock, "h", "HLEN"); n != int64(1) {
+		t.Fatalf("expected 1 field left, got %v", n)
+	}
+
+	// Deleting the last field removes the key
+	apply(t, s, clock, "h", "HDEL", "c")
+	mustBeAbsent(t, s, "h")
+}
+
+func testSetOps(t *testing.T, newStore Factory) {
+	s, clock := open(t, newStore)
+
+	if n := apply(t, s, clock, "s", "SADD", "b", "a", "b"); n != int64(2) {
+		t.Fatalf("expected SADD to add 2 members, got %v", n)
+	}
+	if n := apply(t, s, clock, "s", "SISMEMBER", "a"); n != int64(1) {
+		t.Fatalf("expected a to be a member, got %v", n)
+	}
+	if got := apply(t, s, clock, "s", "SMEMBERS"); !slices.Equal(got.([]string), []string{"a", "b"}) {
+		t.Fatalf("unexpected members %v", got)
+	}
+	if val, ok := s.Read("s"); !ok || val.Type != store.TypeSet {
+		t.Fatalf("expected s to hold a set, got %+v", val)
+	}
+
+	if n := apply(t, s, clock, "s", "SREM", "a", "b", "c"); n != int64(2) {
+		t.Fatalf("expected SREM to remove 2 members, got %v", n)
+	}
+	mustBeAbsent(t, s, "s")
+}
+
+func testSortedSetOps(t *testing.T, newStore Factory) {
+	s, clock := open(t, newStore)
+
+	if n := apply(t, s, clock, "z", "ZADD", "3", "c", "1", "a", "2", "b"); n != int64(3) {
+		t.Fatalf("expected ZADD to add 3 members, got %v", n)
+	}
+	if got := apply(t, s, clock, "z", "ZINCRBY", "2.5", "a"); got != "3.5" {
+		t.Fatalf("expected ZINCRBY to reply 3.5, got %v", got)
+	}
+	if got := apply(t, s, clock, "z", "ZRANGE", "0", "-1", "WITHSCORES"); !slices.Equal(got.([]string), []string{"b", "2", "c", "3", "a", "3.5"}) {
+		t.Fatalf("unexpected ZRANGE reply %v", got)
+	}
+	if got := apply(t, s, clock, "z", "ZRANGEBYSCORE", "(2", "3"); !slices.Equal(got.([]string), []string{"c"}) {
+		t.Fatalf("unexpected ZRANGEBYSCORE reply %v", got)
+	}
+	if n := apply(t, s, clock, "z", "ZRANK", "a"); n != int64(2) {
+		t.Fatalf("expected a at rank 2, got %v", n)
+	}
+	if n := apply(t, s, clock, "z", "ZRANK", "missing"); n != nil {
+		t.Fatalf("expected no rank for a missing member, got %v", n)
+	}
+	if val, ok := s.Read("z"); !ok || val.Type != store.TypeZSet {
+		t.Fatalf("expected z to hold a sorted set, got %+v", val)
+	}
+
+	if n := apply(t, s, clock, "z", "ZREM", "a", "b"); n != int64(2) {
+		t.Fatalf("expected ZREM to remove 2 members, got %v", n)
+	}
+	if n := apply(t, s, clock, "z", "ZCARD"); n != int64(1) {
+		t.Fatalf("expected 1 member left, got %v", n)
+	}
+	apply(t, s, clock, "z", "ZREM", "c")
+	mustBeAbsent(t, s, "z")
+}
+
+func testStreamOps(t *testing.T, newStore Factory) {
+	s, clock := open(t, newStore)
+
+	apply(t, s, clock, "s", "XGROUP", "CREATE", "g", "$", "MKSTREAM")
+	first := apply(t, s, clock, "s", "XADD", "*", "n", "1")
+	second := apply(t, s, clock, "s", "XADD", "*", "n", "2")
+	if first == second {
+		t.Fatalf("expected distinct IDs, got %v twice", first)
+	}
+	if val, ok := s.Read("s"); !ok || val.Type != store.TypeStream {
+		t.Fatalf("expected s to hold a stream, got %+v", val)
+	}
+	if n := apply(t, s, clock, "s", "XLEN"); n != int64(2) {
+		t.Fatalf("expected 2 entries, got %v", n)
+	}
+	got := apply(t, s, clock, "s", "XREVRANGE", "+", "-", "COUNT", "1").([]any)
+	if len(got) != 1 || got[0].([]any)[0] != second || !slices.Equal(got[0].([]any)[1].([]string), []string{"n", "2"}) {
+		t.Fatalf("unexpected XREVRANGE reply %v", got)
+	}
+
+	if got := apply(t, s, clock, "s", "XREADGROUP", "g", "c1", ">", "1").([]any); len(got) != 1 || got[0].([]any)[0] != first {
+		t.Fatalf("expected c1 to get the first entry, got %v", got)
+	}
+	if got := apply(t, s, clock, "s", "XREADGROUP", "g", "c2", ">", "0").([]any); len(got) != 1 || got[0].([]any)[0] != second {
+		t.Fatalf("expected c2 to get the second entry, got %v", got)
+	}
+	if n := apply(t, s, clock, "s", "XACK", "g", first.(string), second.(string)); n != int64(2) {
+		t.Fatalf("expected both entries acknowledged, got %v", n)
+	}
+	if got := apply(t, s, clock, "s", "XPENDING", "g").([]any); got[0] != int64(0) {
+		t.Fatalf("expected nothing pending, got %v", got)
+	}
+
+	// A stream trimmed to nothing stays, with its groups
+	if n := apply(t, s, clock, "s", "XTRIM", "MAXLEN", "0"); n != int64(2) {
+		t.Fatalf("expected XTRIM to remove 2 entries, got %v", n)
+	}
+	if _, ok := s.Read("s"); !ok {
+		t.Fatalf("expected a trimmed stream to keep its key")
+	}
+
+	// Concurrent consumers of one group never get the same entry
+	const entries, consumers = 100, 10
+	for i := 0; i < entries; i++ {
+		apply(t, s, clock, "s", "XADD", "*", "i", strconv.Itoa(i))
+	}
+	var mu sync.Mutex
+	delivered := make(map[string]int)
+	var wg sync.WaitGroup
+	wg.Add(consumers)
+	for i := 0; i < consumers; i++ {
+		go func() {
+			defer wg.Done()
+			for {
+				got, err := store.Apply(s, "s", store.Op{Name: "XREADGROUP", Args: []string{"g", "c" + strconv.Itoa(i), ">", "1"}, Time: store.GetUnixTimestamp(clock.Now())})
+				if err != nil || len(got.([]any)) == 0 {
+					return
+				}
+				mu.Lock()
+				delivered[got.([]any)[0].([]any)[0].(string)]++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if len(delivered) != entries {
+		t.Fatalf("expected %d entries delivered, got %d", entries, len(delivered))
+	}
+	for id, n := range delivered {
+		if n != 1 {
+			t.Fatalf("expected %s delivered once, got %d", id, n)
+		}
+	}
+	if got := apply(t, s, clock, "s", "XPENDING", "g").([]any); got[0] != int64(entries) {
+		t.Fatalf("expected every delivered entry to be pending, got %v", got[0])
+	}
+}
+
+func testJSONOps(t *testing.T, newStore Factory) {
+	s, clock := open(t, newStore)
+
+	if n := apply(t, s, clock, "doc", "JSON.SET", "$", `{"user":{"name":"hermes","visits":1},"tags":[]}`); n != int64(1) {
+		t.Fatalf("expected JSON.SET to set the root, got %v", n)
+	}
+	if val, ok := s.Read("doc"); !ok || val.Type != store.TypeJSON {
+		t.Fatalf("expected doc to hold a JSON document, got %+v", val)
+	}
+	apply(t, s, clock, "doc", "JSON.SET", "$.user.name", `"zeus"`)
+	if got := apply(t, s, clock, "doc", "JSON.NUMINCRBY", "$.user.visits", "2"); got != "[3]" {
+		t.Fatalf("expected JSON.NUMINCRBY to reply [3], got %v", got)
+	}
+	apply(t, s, clock, "doc", "JSON.ARRAPPEND", "$.tags", `"a"`, `"b"`)
+	if n := apply(t, s, clock, "doc", "JSON.DEL", "$.tags[0]"); n != int64(1) {
+		t.Fatalf("expected JSON.DEL to remove 1 value, got %v", n)
+	}
+	want := `{"tags":["b"],"user":{"name":"zeus","visits":3}}`
+	if got := apply(t, s, clock, "doc", "JSON.GET"); got != want {
+		t.Fatalf("expected %s, got %v", want, got)
+	}
+	if got := apply(t, s, clock, "doc", "JSON.GET", "$.user.name"); got != `["zeus"]` {
+		t.Fatalf("unexpected JSON.GET reply %v", got)
+	}
+
+	apply(t, s, clock, "doc", "JSON.DEL", "$")
+	mustBeAbsent(t, s, "doc")
+}
+
+func testOpWrongType(t *testing.T, newStore Factory) {
+	s, clock := open(t, newStore)
+
+	_ = s.Write("s", entry("v"), store.PutOverwrite)
+	_, err := store.Apply(s, "s", store.Op{Name: "RPUSH", Args: []string{"x"}, Time: store.GetUnixTimestamp(clock.Now())})
+	if !errors.Is(err, store.ErrWrongType) {
+		t.Fatalf("expected ErrWrongType, got %v", err)
+	}
+	mustRead(t, s, "s", "v")
+
+	if _, err := store.Apply(s, "s", store.Op{Name: "NOPE"}); !errors.Is(err, store.ErrUnknownOp) {
+		t.Fatalf("expected ErrUnknownOp, got %v", err)
+	}
+}
+
+func testOpKeepTTL(t *testing.T, newStore Factory) {
+	s, clock := open(t, newStore)
+
+	apply(t, s, clock, "l", "RPUSH", "a")
+	s.Expire("l", at(clock, time.Second))
+	apply(t, s, clock, "l", "RPUSH", "b")
+
+	if val, ok := s.Read("l"); !ok || val.ExpiresAtMillis != at(clock, time.Second) {
+		t.Fatalf("expected the push to keep the TTL, got %+v", val)
+	}
+
+	clock.Advance(2 * time.Second)
+	mustBeAbsent(t, s, "l")
+	if n := apply(t, s, clock, "l", "RPUSH", "c"); n != int64(1) {
+		t.Fatalf("expected an expired list to start over, got length %v", n)
+	}
+}
+
+func testOpInAtomic(t *testing.T, newStore Factory) {
+	s, clock := open(t, newStore)
+
+	apply(t, s, clock, "src", "RPUSH", "a", "b")
+	err := s.Atomic([]string{"src", "dst"}, func(tx store.Tx) error {
+		for {
+			v := apply(t, tx, clock, "src", "LPOP")
+			if v == nil {
+				return nil
+			}
+			apply(t, tx, clock, "dst", "LPUSH", v.(string))
+		}
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	mustBeAbsent(t, s, "src")
+	if got := apply(t, s, clock, "dst", "LRANGE", "0", "-1"); !slices.Equal(got.([]string), []string{"b", "a"}) {
+		t.Fatalf("expected the transaction to move the list reversed, got %v", got)
+	}
+
+	// Ops on one key see each other, and reads between them see both
+	err = s.Atomic([]string{"dst"}, func(tx store.Tx) error {
+		apply(t, tx, clock, "dst", "RPUSH", "c")
+		before, _ := tx.Read("dst")
+		apply(t, tx, clock, "dst", "RPUSH", "d")
+		if after, _ := tx.Read("dst"); bytes.Equal(before.Value, after.Value) {
+			t.Errorf("expected a read to see the second push")
+		}
+		if n := apply(t, tx, clock, "dst", "LLEN"); n != int64(4) {
+			t.Errorf("expected the transaction to see 4 elements, got %v", n)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := apply(t, s, clock, "dst", "LRANGE", "0", "-1"); !slices.Equal(got.([]string), []string{"b", "a", "c", "d"}) {
+		t.Fatalf("expected both pushes to commit, got %v", got)
+	}
+}
+
+func testOpViews(t *testing.T, newStore Factory) {
+	s, clock := open(t, newStore)
+
+	apply(t, s, clock, "z", "ZADD", "1", "a", "2", "b")
+	before, _ := s.Read("z")
+
+	v := s.Snapshot()
+	defer v.Close()
+	apply(t, s, clock, "z", "ZADD", "3", "c")
+	apply(t, s, clock, "z", "ZREM", "a")
+
+	seen, ok := v.Read("z")
+	if !ok || !bytes.Equal(seen.Value, before.Value) {
+		t.Fatalf("expected the view to keep the value before the ops, got %+v", seen)
+	}
+	if got := apply(t, s, clock, "z", "ZRANGE", "0", "-1"); !slices.Equal(got.([]string), []string{"b", "c"}) {
+		t.Fatalf("expected the ops to apply, got %v", got)
+	}
+}
+
+func testConcurrentOps(t *testing.T, newStore Factory) {
+	s, clock := open(t, newStore)
+
+	const workers, pushes = 20, 50
+	apply(t, s, clock, "doc", "JSON.SET", "$", `{"n":0}`)
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for j := 0; j < pushes; j++ {
+				_, _ = store.Apply(s, "l", store.Op{Name: "RPUSH", Args: []string{"x"}, Time: store.GetUnixTimestamp(clock.Now())})
+			}
+		}()
+	}
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for j := 0; j < pushes; j++ {
+				_, _ = store.Apply(s, "h", store.Op{Name: "HINCRBY", Args: []string{"n", "1"}, Time: store.GetUnixTimestamp(clock.Now())})
+			}
+		}()
+	}
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for j := 0; j < pushes; j++ {
+				_, _ = store.Apply(s, "doc", store.Op{Name: "JSON.NUMINCRBY", Args: []string{"$.n", "1"}, Time: store.GetUnixTimestamp(clock.Now())})
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := apply(t, s, clock, "l", "LLEN"); n != int64(workers*pushes) {
+		t.Fatalf("expected %d elements, got %v", workers*pushes, n)
+	}
+	if n := apply(t, s, clock, "h", "HGET", "n"); n != strconv.Itoa(workers*pushes) {
+		t.Fatalf("expected %d increments, got %v", workers*pushes, n)
+	}
+	if got := apply(t, s, clock, "doc", "JSON.GET", "$.n"); got != "["+strconv.Itoa(workers*pushes)+"]" {
+		t.Fatalf("expected %d document increments, got %v", workers*pushes, got)
+	}
+}
@@ -0,0 +1,137 @@
+package storetest
+
+import (
+	"sync"
+	"time"
+
+	"hermes/store"
+)
+
+/*
+OpKind is the kind of a recorded operation.
+*/
+type OpKind int
+
+const (
+	OpRead OpKind = iota
+	OpWrite
+	OpExpire
+)
+
+func (k OpKind) String() string {
+	switch k {
+	case OpRead:
+		return "Read"
+	case OpWrite:
+		return "Write"
+	case OpExpire:
+		return "Expire"
+	default:
+		return "Unknown"
+	}
+}
+
+/*
+Operation is one completed call recorded by a Recorder.
+
+Call and Return are monotonic nanoseconds since the recorder was
+created: the operation took effect somewhere between them. CallClock
+and ReturnClock are the store clock's time (Unix milliseconds) read at
+the same two points, bounding the instant TTLs were evaluated at.
+
+Inputs are Key, Mode and Value for writes, and Key and ExpiresAt for
+expires. Outputs are OK (the key was found, the write succeeded or the
+expire applied) and, for reads, Value.
+*/
+type Operation struct {
+	Kind      OpKind
+	Key       string
+	Mode      store.PutMode
+	Value     string
+	ExpiresAt int64
+	OK        bool
+
+	Call, Return           int64
+	CallClock, ReturnClock int64
+}
+
+/*
+Recorder is a DataStore that logs the single-key operations (Read,
+Write and Expire) made through it, from any number of goroutines.
+Every other method goes straight to the wrapped store unrecorded.
+*/
+type Recorder struct {
+	store.DataStore
+
+	clock store.Clock
+	start time.Time
+
+	mu  sync.Mutex
+	ops []Operation
+}
+
+/*
+NewRecorder wraps ds, which must take its time from clock.
+*/
+func NewRecorder(ds store.DataStore, clock store.Clock) *Recorder {
+	return &Recorder{
+		DataStore: ds,
+		clock:     clock,
+		start:     time.Now(),
+	}
+}
+
+func (r *Recorder) Read(key string) (store.Entry, bool) {
+	op := r.begin(OpRead, key)
+	val, ok := r.DataStore.Read(key)
+	op.Value, op.OK = string(val.Value), ok
+	r.end(op)
+	return val, ok
+}
+
+func (r *Recorder) Write(key string, value store.Entry, mode store.PutMode) error {
+	op := r.begin(OpWrite, key)
+	op.Mode, op.Value = mode, string(value.Value)
+	err := r.DataStore.Write(key, value, mode)
+	op.OK = err == nil
+	r.end(op)
+	return err
+}
+
+func (r *Recorder) Expire(key string, unixTimestampMilli int64) bool {
+	op := r.begin(OpExpire, key)
+	op.ExpiresAt = unixTimestampMilli
+	ok := r.DataStore.Expire(key, unixTimestampMilli)
+	op.OK = ok
+	r.end(op)
+	return ok
+}
+
+/*
+History returns a copy of the operations completed so far, in order of
+completion.
+*/
+func (r *Recorder) History() []Operation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]Operation(nil), r.ops...)
+}
+
+func (r *Recorder) begin(kind OpKind, key string) Operation {
+	return Operation{
+		Kind:      kind,
+		Key:       key,
+		CallClock: store.GetUnixTimestamp(r.clock.Now()),
+		Call:      int64(time.Since(r.start)),
+	}
+}
+
+func (r *Recorder) end(op Operation) {
+	op.Return = int64(time.Since(r.start))
+	op.ReturnClock = store.GetUnixTimestamp(r.clock.Now())
+
+	r.mu.Lock()
+	r.ops = append(r.ops, op)
+	r.mu.Unlock()
+}
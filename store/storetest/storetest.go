@@ -0,0 +1,111 @@
+/*
+Package storetest checks that a store.DataStore honors the behavioral
+contract shared by every store in this repository.
+
+Any implementation, including decorators wrapping another store
+(metrics, tenancy, ...), can run the whole suite from its own tests:
+
+	func TestConformance(t *testing.T) {
+		storetest.Run(t, func(t *testing.T, clock store.Clock) store.DataStore {
+			return metrics.Wrap(store.NewLockedStore(store.WithClock(clock)))
+		})
+	}
+
+The suite covers put modes, batches, versions, TTL semantics,
+transactions, iteration and views, ops on typed values, and concurrent
+behavior. Optional capabilities (Iterable, Scanner) are checked only
+when implemented.
+
+Time is driven by a store.ManualClock handed to the factory, so TTL
+checks never sleep: the factory must build the store, and anything
+else that turns time into expiry, on that clock.
+
+Beyond final states, the concurrency checks record randomized
+workloads with a Recorder and require CheckLinearizable to accept
+them. Both are exported for custom workloads.
+*/
+package storetest
+
+import (
+	"testing"
+	"time"
+
+	"hermes/store"
+)
+
+/*
+Factory creates an empty store that uses clock for every time
+decision. The suite closes the store when the test ends; a factory
+needing files should place them under t.TempDir.
+*/
+type Factory func(t *testing.T, clock store.Clock) store.DataStore
+
+/*
+Run runs the whole suite against stores created by newStore, each
+group as a subtest.
+*/
+func Run(t *testing.T, newStore Factory) {
+	t.Run("PutModes", func(t *testing.T) { testPutModes(t, newStore) })
+	t.Run("Batches", func(t *testing.T) { testBatches(t, newStore) })
+	t.Run("Versions", func(t *testing.T) { testVersions(t, newStore) })
+	t.Run("TTL", func(t *testing.T) { testTTL(t, newStore) })
+	t.Run("Atomic", func(t *testing.T) { testAtomic(t, newStore) })
+	t.Run("Iteration", func(t *testing.T) { testIteration(t, newStore) })
+	t.Run("Ops", func(t *testing.T) { testOps(t, newStore) })
+	t.Run("Concurrency", func(t *testing.T) { testConcurrency(t, newStore) })
+}
+
+// epoch is where every suite clock starts.
+var epoch = time.Unix(1_700_000_000, 0)
+
+/*
+open creates a store on a fresh manual clock and closes it when the
+test ends.
+*/
+func open(t *testing.T, newStore Factory) (store.DataStore, *store.ManualClock) {
+	t.Helper()
+
+	clock := store.NewManualClock(epoch)
+	s := newStore(t, clock)
+	t.Cleanup(func() {
+		if err := s.Close(); err != nil {
+			t.Errorf("close failed: %v", err)
+		}
+	})
+	return s, clock
+}
+
+/*
+at returns the Unix milliseconds d away from the clock's time.
+*/
+func at(clock store.Clock, d time.Duration) int64 {
+	return store.GetUnixTimestamp(clock.Now().Add(d))
+}
+
+func entry(value string) store.Entry {
+	return store.Entry{Value: []byte(value)}
+}
+
+/*
+mustRead fails the test unless key holds want.
+*/
+func mustRead(t *testing.T, s store.DataStore, key, want string) store.Entry {
+	t.Helper()
+
+	val, ok := s.Read(key)
+	if !ok {
+		t.Fatalf("expected %s=%q, key is absent", key, want)
+	}
+	if string(val.Value) != want {
+		t.Fatalf("expected %s=%q, got %q", key, want, val.Value)
+	}
+	return val
+}
+
+func mustBeAbsent(t *testing.T, s store.DataStore, key string) {
+	t.Helper()
+
+	if val, ok := s.Read(key); ok {
+		t.Fatalf("expected %s to be absent, got %q", key, val.Value)
+	}
+}
@@ -0,0 +1,114 @@
+package storetest
+
+import (
+	"path/filepath"
+	"testing"
+
+	"hermes/store"
+	"hermes/wal"
+)
+
+var models = []struct {
+	name string
+	new  func(opts ...store.Option) store.DataStore
+}{
+	{name: "Locked", new: func(opts ...store.Option) store.DataStore { return store.NewLockedStore(opts...) }},
+	{name: "Sharded", new: func(opts ...store.Option) store.DataStore { return store.NewShardedStore(8, opts...) }},
+	{name: "EventLoop", new: func(opts ...store.Option) store.DataStore { return store.NewEventloopStore(128, opts...) }},
+	{name: "MultiLoop", new: func(opts ...store.Option) store.DataStore { return store.NewMultiLoopStore(4, 128, opts...) }},
+	{name: "Ordered", new: func(opts ...store.Option) store.DataStore { return store.NewOrderedStore(opts...) }},
+}
+
+func TestConformance(t *testing.T) {
+	for _, m := range models {
+		t.Run(m.name, func(t *testing.T) {
+			Run(t, func(t *testing.T, clock store.Clock) store.DataStore {
+				return m.new(store.WithClock(clock))
+			})
+		})
+	}
+}
+
+func TestConformance_WAL(t *testing.T) {
+	for _, m := range models {
+		t.Run(m.name, func(t *testing.T) {
+			Run(t, func(t *testing.T, clock store.Clock) store.DataStore {
+				dir := t.TempDir()
+
+				w, err := wal.NewWAL(wal.Config{
+					Path:       filepath.Join(dir, "wal.log"),
+					SyncPolicy: wal.SyncEverySecond,
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				ds, err := store.NewWalStore(
+					m.new(store.WithClock(clock)),
+					w,
+					filepath.Join(dir, "snapshot.bin"),
+					0,
+					store.WithClock(clock),
+				)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return ds
+			})
+		})
+	}
+}
+
+func TestConformance_Bitcask(t *testing.T) {
+	Run(t, func(t *testing.T, clock store.Clock) store.DataStore {
+		ds, err := store.NewBitcaskStore(
+			t.TempDir(),
+			store.WithClock(clock),
+			store.WithSyncPolicy(wal.SyncEverySecond),
+			store.WithMaxFileSize(4<<10),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ds
+	})
+}
+
+func TestConformance_Tiered(t *testing.T) {
+	for _, m := range models {
+		t.Run(m.name, func(t *testing.T) {
+			Run(t, func(t *testing.T, clock store.Clock) store.DataStore {
+				// A tiny budget spills nearly every value
+				ds, err := store.NewTieredStore(
+					m.new(store.WithClock(clock)),
+					filepath.Join(t.TempDir(), "values.log"),
+					16,
+				)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return ds
+			})
+		})
+	}
+}
+
+func TestConformance_Compressed(t *testing.T) {
+	for _, m := range models {
+		t.Run(m.name, func(t *testing.T) {
+			Run(t, func(t *testing.T, clock store.Clock) store.DataStore {
+				return store.NewCompressedStore(m.new(store.WithClock(clock)), 0)
+			})
+		})
+	}
+}
+
+func TestConformance_Slab(t *testing.T) {
+	for _, m := range models {
+		t.Run(m.name, func(t *testing.T) {
+			Run(t, func(t *testing.T, clock store.Clock) store.DataStore {
+				return m.new(store.WithClock(clock), store.WithSlabStorage())
+			})
+		})
+	}
+}
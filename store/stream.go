@@ -0,0 +1,487 @@
+package store
+
+import (
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+streamID identifies a stream entry: the Unix milliseconds it was added
+at, and a sequence number telling apart entries of the same
+millisecond. IDs only grow along a stream.
+*/
+type streamID struct {
+	ms, seq uint64
+}
+
+var maxStreamID = streamID{math.MaxUint64, math.MaxUint64}
+
+func (id streamID) String() string {
+	return strconv.FormatUint(id.ms, 10) + "-" + strconv.FormatUint(id.seq, 10)
+}
+
+func (id streamID) less(other streamID) bool {
+	return id.ms < other.ms || (id.ms == other.ms && id.seq < other.seq)
+}
+
+func compareStreamIDs(a, b streamID) int {
+	switch {
+	case a.less(b):
+		return -1
+	case b.less(a):
+		return 1
+	}
+	return 0
+}
+
+/*
+next returns the smallest ID after id, or false if there is none.
+*/
+func (id streamID) next() (streamID, bool) {
+	switch {
+	case id.seq < math.MaxUint64:
+		return streamID{id.ms, id.seq + 1}, true
+	case id.ms < math.MaxUint64:
+		return streamID{id.ms + 1, 0}, true
+	}
+	return id, false
+}
+
+/*
+prev returns the largest ID before id, or false if there is none.
+*/
+func (id streamID) prev() (streamID, bool) {
+	switch {
+	case id.seq > 0:
+		return streamID{id.ms, id.seq - 1}, true
+	case id.ms > 0:
+		return streamID{id.ms - 1, math.MaxUint64}, true
+	}
+	return id, false
+}
+
+/*
+parseStreamID parses "ms-seq", or "ms" alone, which takes missingSeq
+as its sequence number.
+*/
+func parseStreamID(arg string, missingSeq uint64) (streamID, error) {
+	msPart, seqPart, hasSeq := strings.Cut(arg, "-")
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return streamID{}, ErrInvalidStreamID
+	}
+	if !hasSeq {
+		return streamID{ms, missingSeq}, nil
+	}
+
+	seq, err := strconv.ParseUint(seqPart, 10, 64)
+	if err != nil {
+		return streamID{}, ErrInvalidStreamID
+	}
+	return streamID{ms, seq}, nil
+}
+
+/*
+parseRangeStart parses the start of an ID range: an ID, "-" for the
+first possible one, or an ID prefixed with "(" to exclude it.
+*/
+func parseRangeStart(arg string) (streamID, error) {
+	if arg == "-" {
+		return streamID{}, nil
+	}
+	if rest, ok := strings.CutPrefix(arg, "("); ok {
+		id, err := parseStreamID(rest, 0)
+		if err != nil {
+			return streamID{}, err
+		}
+		if id, ok = id.next(); !ok {
+			return streamID{}, ErrInvalidStreamID
+		}
+		return id, nil
+	}
+	return parseStreamID(arg, 0)
+}
+
+/*
+parseRangeEnd parses the end of an ID range: an ID, "+" for the last
+possible one, or an ID prefixed with "(" to exclude it. An ID without
+a sequence number includes its whole millisecond.
+*/
+func parseRangeEnd(arg string) (streamID, error) {
+	if arg == "+" {
+		return maxStreamID, nil
+	}
+	if rest, ok := strings.CutPrefix(arg, "("); ok {
+		id, err := parseStreamID(rest, math.MaxUint64)
+		if err != nil {
+			return streamID{}, err
+		}
+		if id, ok = id.prev(); !ok {
+			return streamID{}, ErrInvalidStreamID
+		}
+		return id, nil
+	}
+	return parseStreamID(arg, math.MaxUint64)
+}
+
+/*
+streamEntry is one entry of a stream: its ID and its fields, each
+followed by its value.
+*/
+type streamEntry struct {
+	id     streamID
+	fields []string
+}
+
+/*
+reply is how ops return an entry: [id, [field, value, ...]].
+*/
+func (e streamEntry) reply() any {
+	return []any{e.id.String(), e.fields}
+}
+
+/*
+streamValue is the decoded form of a TypeStream value: entries in ID
+order, the last ID ever added, which trimming does not lower, and the
+stream's consumer groups.
+
+It is stored as the last ID, the entries with their fields, and the
+groups by name with their pending entries in ID order. The in-memory
+stores keep it decoded between ops, so XADD appends to entries and
+trimming reslices them, neither rewriting the stream. An empty
+stream that never had an entry nor a group is no stream at all; once
+it had one, the key stays even when trimmed to nothing, since its
+last ID must keep growing.
+*/
+type streamValue struct {
+	entries []streamEntry
+	lastID  streamID
+	groups  map[string]*streamGroup
+}
+
+func decodeStream(data []byte) (typedValue, error) {
+	r := valueReader{data: data}
+	s := &streamValue{groups: make(map[string]*streamGroup)}
+	if len(data) == 0 {
+		return s, nil
+	}
+
+	s.lastID = readStreamID(&r)
+	n := r.count()
+	s.entries = make([]streamEntry, 0, n)
+	for i := 0; i < n && r.err == nil; i++ {
+		e := streamEntry{id: readStreamID(&r)}
+		fields := r.count()
+		e.fields = make([]string, 0, fields)
+		for j := 0; j < fields; j++ {
+			e.fields = append(e.fields, r.string())
+		}
+		s.entries = append(s.entries, e)
+	}
+
+	groups := r.count()
+	for i := 0; i < groups && r.err == nil; i++ {
+		name := r.string()
+		s.groups[name] = decodeGroup(&r)
+	}
+	return s, r.finish()
+}
+
+func readStreamID(r *valueReader) streamID {
+	return streamID{r.uvarint(), r.uvarint()}
+}
+
+func appendStreamID(buf []byte, id streamID) []byte {
+	return appendUvarint(appendUvarint(buf, id.ms), id.seq)
+}
+
+func (s *streamValue) encode() []byte {
+	buf := appendStreamID(nil, s.lastID)
+	buf = appendUvarint(buf, uint64(len(s.entries)))
+	for _, e := range s.entries {
+		buf = appendStreamID(buf, e.id)
+		buf = appendUvarint(buf, uint64(len(e.fields)))
+		for _, f := range e.fields {
+			buf = appendString(buf, f)
+		}
+	}
+
+	names := make([]string, 0, len(s.groups))
+	for name := range s.groups {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	buf = appendUvarint(buf, uint64(len(names)))
+	for _, name := range names {
+		buf = appendString(buf, name)
+		buf = s.groups[name].encode(buf)
+	}
+	return buf
+}
+
+func (s *streamValue) empty() bool {
+	return len(s.entries) == 0 && s.lastID == (streamID{}) && len(s.groups) == 0
+}
+
+/*
+after returns the index of the first entry whose ID is greater than
+id.
+*/
+func (s *streamValue) after(id streamID) int {
+	return sort.Search(len(s.entries), func(i int) bool { return id.less(s.entries[i].id) })
+}
+
+/*
+find returns the entry with the given ID.
+*/
+func (s *streamValue) find(id streamID) (streamEntry, bool) {
+	i, ok := slices.BinarySearchFunc(s.entries, id, func(e streamEntry, id streamID) int {
+		return compareStreamIDs(e.id, id)
+	})
+	if !ok {
+		return streamEntry{}, false
+	}
+	return s.entries[i], true
+}
+
+/*
+nextID returns the ID of an entry added at time now, from the XADD
+argument arg: "*" to generate it, "ms-*" to generate only its
+sequence number, or a complete ID. Generated IDs follow the last one
+even if the clock went back.
+*/
+func (s *streamValue) nextID(arg string, now int64) (streamID, error) {
+	if arg == "*" {
+		ms := uint64(max(now, 0))
+		if ms > s.lastID.ms {
+			return streamID{ms, 0}, nil
+		}
+		if id, ok := s.lastID.next(); ok {
+			return id, nil
+		}
+		return streamID{}, ErrStreamIDTooSmall
+	}
+
+	var id streamID
+	if msPart, ok := strings.CutSuffix(arg, "-*"); ok {
+		ms, err := strconv.ParseUint(msPart, 10, 64)
+		if err != nil {
+			return streamID{}, ErrInvalidStreamID
+		}
+		id = streamID{ms, 0}
+		if ms == s.lastID.ms && s.lastID != (streamID{}) {
+			id.seq = s.lastID.seq + 1
+			if id.seq == 0 {
+				return streamID{}, ErrStreamIDTooSmall
+			}
+		}
+	} else {
+		var err error
+		if id, err = parseStreamID(arg, 0); err != nil {
+			return streamID{}, err
+		}
+	}
+
+	if id == (streamID{}) || !s.lastID.less(id) {
+		return streamID{}, ErrStreamIDTooSmall
+	}
+	return id, nil
+}
+
+/*
+trim removes the first n entries of the stream. The rest are copied
+only when fewer than the removed ones remain, which keeps the cost per
+removed entry constant; otherwise the entries are resliced, and the
+removed ones cleared so their fields can be collected.
+*/
+func (s *streamValue) trim(n int) {
+	if rest := len(s.entries) - n; rest < n {
+		s.entries = append(make([]streamEntry, 0, rest), s.entries[n:]...)
+		return
+	}
+	clear(s.entries[:n])
+	s.entries = s.entries[n:]
+}
+
+/*
+countArg parses the optional COUNT n that ends the args of a range,
+and returns the args without it. No COUNT, or 0, means no limit.
+*/
+func countArg(args []string, fixed int) ([]string, int, error) {
+	if len(args) == fixed {
+		return args, math.MaxInt, nil
+	}
+	if len(args) != fixed+2 || !strings.EqualFold(args[fixed], "COUNT") {
+		return nil, 0, ErrOpArgs
+	}
+
+	count, err := intArg(args[fixed+1])
+	if err != nil || count < 0 {
+		return nil, 0, ErrNotInteger
+	}
+	if count == 0 {
+		count = math.MaxInt
+	}
+	return args[:fixed], count, nil
+}
+
+/*
+streamOp adapts an op on a stream to an opSpec.
+*/
+func streamOp(query bool, minArgs, maxArgs int, fn func(s *streamValue, op Op) (any, func(), error)) opSpec {
+	return opSpec{
+		typ:   TypeStream,
+		query: query,
+		run: func(v typedValue, op Op) (any, func(), error) {
+			if len(op.Args) < minArgs || (maxArgs >= 0 && len(op.Args) > maxArgs) {
+				return nil, nil, ErrOpArgs
+			}
+			return fn(v.(*streamValue), op)
+		},
+	}
+}
+
+/*
+queryStreamOp adapts a query on a stream to the signature of streamOp.
+*/
+func queryStreamOp(fn func(s *streamValue, op Op) (any, error)) func(s *streamValue, op Op) (any, func(), error) {
+	return func(s *streamValue, op Op) (any, func(), error) {
+		reply, err := fn(s, op)
+		return reply, nil, err
+	}
+}
+
+/*
+streamOps are the ops on stream entries. Entries are returned as
+[id, [field, value, ...]]; the ops of consumer groups are in
+streamGroupOps.
+*/
+var streamOps = map[string]opSpec{
+	// XADD id field value [field value ...] replies with the new ID
+	"XADD": streamOp(false, 3, -1, func(s *streamValue, op Op) (any, func(), error) {
+		if len(op.Args)%2 != 1 {
+			return nil, nil, ErrOpArgs
+		}
+		id, err := s.nextID(op.Args[0], op.Time)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return id.String(), func() {
+			s.entries = append(s.entries, streamEntry{id: id, fields: slices.Clone(op.Args[1:])})
+			s.lastID = id
+		}, nil
+	}),
+
+	// XRANGE start end [COUNT n]
+	"XRANGE": streamOp(true, 2, 4, queryStreamOp(func(s *streamValue, op Op) (any, error) {
+		args, count, err := countArg(op.Args, 2)
+		if err != nil {
+			return nil, err
+		}
+		start, err := parseRangeStart(args[0])
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseRangeEnd(args[1])
+		if err != nil {
+			return nil, err
+		}
+
+		out := []any{}
+		for i := sort.Search(len(s.entries), func(i int) bool { return !s.entries[i].id.less(start) }); i < len(s.entries) && len(out) < count; i++ {
+			if end.less(s.entries[i].id) {
+				break
+			}
+			out = append(out, s.entries[i].reply())
+		}
+		return out, nil
+	})),
+
+	// XREVRANGE end start [COUNT n]
+	"XREVRANGE": streamOp(true, 2, 4, queryStreamOp(func(s *streamValue, op Op) (any, error) {
+		args, count, err := countArg(op.Args, 2)
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseRangeEnd(args[0])
+		if err != nil {
+			return nil, err
+		}
+		start, err := parseRangeStart(args[1])
+		if err != nil {
+			return nil, err
+		}
+
+		out := []any{}
+		for i := s.after(end) - 1; i >= 0 && len(out) < count; i-- {
+			if s.entries[i].id.less(start) {
+				break
+			}
+			out = append(out, s.entries[i].reply())
+		}
+		return out, nil
+	})),
+
+	"XLEN": streamOp(true, 0, 0, queryStreamOp(func(s *streamValue, _ Op) (any, error) {
+		return int64(len(s.entries)), nil
+	})),
+
+	// XTRIM MAXLEN n | MINID id replies with the entries removed
+	"XTRIM": streamOp(false, 2, 2, func(s *streamValue, op Op) (any, func(), error) {
+		var n int
+		switch strings.ToUpper(op.Args[0]) {
+		case "MAXLEN":
+			maxLen, err := intArg(op.Args[1])
+			if err != nil || maxLen < 0 {
+				return nil, nil, ErrNotInteger
+			}
+			n = max(len(s.entries)-maxLen, 0)
+
+		case "MINID":
+			minID, err := parseStreamID(op.Args[1], 0)
+			if err != nil {
+				return nil, nil, err
+			}
+			n = sort.Search(len(s.entries), func(i int) bool { return !s.entries[i].id.less(minID) })
+
+		default:
+			return nil, nil, ErrSyntax
+		}
+
+		if n == 0 {
+			return int64(0), nil, nil
+		}
+		return int64(n), func() { s.trim(n) }, nil
+	}),
+
+	// XREAD id count replies with [id, entries]: the entries after id,
+	// and id itself, resolved to the last ID if given as "$" so that
+	// a blocked reader can wait for what comes next
+	"XREAD": streamOp(true, 2, 2, queryStreamOp(func(s *streamValue, op Op) (any, error) {
+		id := s.lastID
+		if op.Args[0] != "$" {
+			var err error
+			if id, err = parseStreamID(op.Args[0], 0); err != nil {
+				return nil, err
+			}
+		}
+		count, err := intArg(op.Args[1])
+		if err != nil || count < 0 {
+			return nil, ErrNotInteger
+		}
+		if count == 0 {
+			count = math.MaxInt
+		}
+
+		out := []any{}
+		for i := s.after(id); i < len(s.entries) && len(out) < count; i++ {
+			out = append(out, s.entries[i].reply())
+		}
+		return []any{id.String(), out}, nil
+	})),
+}
@@ -0,0 +1,391 @@
+package store
+
+import (
+	"math"
+	"slices"
+	"strings"
+)
+
+/*
+streamGroup is a consumer group of a stream: the last entry it
+delivered, and its pending entries, delivered to a consumer but not
+yet acknowledged. An entry stays pending until XACK, so that a
+consumer that dies holding it does not lose it: another one claims it
+with XCLAIM.
+*/
+type streamGroup struct {
+	lastDelivered streamID
+	pending       map[streamID]*pendingEntry
+}
+
+/*
+pendingEntry records who an entry was last delivered to, when, and how
+many times it was delivered.
+*/
+type pendingEntry struct {
+	consumer    string
+	deliveredAt int64
+	deliveries  uint64
+}
+
+func newStreamGroup(lastDelivered streamID) *streamGroup {
+	return &streamGroup{lastDelivered: lastDelivered, pending: make(map[streamID]*pendingEntry)}
+}
+
+func decodeGroup(r *valueReader) *streamGroup {
+	g := newStreamGroup(readStreamID(r))
+	n := r.count()
+	for i := 0; i < n && r.err == nil; i++ {
+		id := readStreamID(r)
+		g.pending[id] = &pendingEntry{
+			consumer:    r.string(),
+			deliveredAt: int64(r.uvarint()),
+			deliveries:  r.uvarint(),
+		}
+	}
+	return g
+}
+
+func (g *streamGroup) encode(buf []byte) []byte {
+	buf = appendStreamID(buf, g.lastDelivered)
+	ids := g.pendingIDs()
+	buf = appendUvarint(buf, uint64(len(ids)))
+	for _, id := range ids {
+		p := g.pending[id]
+		buf = appendStreamID(buf, id)
+		buf = appendString(buf, p.consumer)
+		buf = appendUvarint(buf, uint64(p.deliveredAt))
+		buf = appendUvarint(buf, p.deliveries)
+	}
+	return buf
+}
+
+/*
+pendingIDs returns the IDs of the pending entries in order.
+*/
+func (g *streamGroup) pendingIDs() []streamID {
+	ids := make([]streamID, 0, len(g.pending))
+	for id := range g.pending {
+		ids = append(ids, id)
+	}
+	slices.SortFunc(ids, compareStreamIDs)
+	return ids
+}
+
+/*
+deliver records the delivery of id to consumer at now.
+*/
+func (g *streamGroup) deliver(id streamID, consumer string, now int64) {
+	p, ok := g.pending[id]
+	if !ok {
+		p = &pendingEntry{}
+		g.pending[id] = p
+	}
+	p.consumer = consumer
+	p.deliveredAt = now
+	p.deliveries++
+}
+
+/*
+group returns the consumer group name of s, or ErrNoGroup.
+*/
+func (s *streamValue) group(name string) (*streamGroup, error) {
+	g, ok := s.groups[name]
+	if !ok {
+		return nil, ErrNoGroup
+	}
+	return g, nil
+}
+
+/*
+groupStartID parses the ID a group starts delivering after: an ID, or
+"$" for the stream's last one.
+*/
+func (s *streamValue) groupStartID(arg string) (streamID, error) {
+	if arg == "$" {
+		return s.lastID, nil
+	}
+	return parseStreamID(arg, 0)
+}
+
+/*
+unread returns the entries the group has not delivered yet, at most
+count.
+*/
+func (s *streamValue) unread(g *streamGroup, count int) []streamEntry {
+	from := s.after(g.lastDelivered)
+	return s.entries[from : from+min(count, len(s.entries)-from)]
+}
+
+/*
+readPending returns the entries pending for consumer after id, at most
+count, without delivering them again. An entry trimmed from the stream
+since is returned as [id, nil].
+*/
+func (s *streamValue) readPending(g *streamGroup, consumer string, after streamID, count int) []any {
+	out := []any{}
+	for _, id := range g.pendingIDs() {
+		if len(out) == count {
+			break
+		}
+		if !after.less(id) || g.pending[id].consumer != consumer {
+			continue
+		}
+		if e, ok := s.find(id); ok {
+			out = append(out, e.reply())
+		} else {
+			out = append(out, []any{id.String(), nil})
+		}
+	}
+	return out
+}
+
+/*
+streamGroupOps are the ops of consumer groups. Those that deliver or
+claim entries are mutations: the pending entries are part of the
+stream's value, so they are logged and survive restarts with it.
+*/
+var streamGroupOps = map[string]opSpec{
+	// XGROUP CREATE group id [MKSTREAM] | SETID group id | DESTROY group
+	// replies nil for CREATE and SETID, and the groups destroyed for
+	// DESTROY
+	"XGROUP": streamOp(false, 2, 4, func(s *streamValue, op Op) (any, func(), error) {
+		sub, name, rest := strings.ToUpper(op.Args[0]), op.Args[1], op.Args[2:]
+		switch {
+		case sub == "CREATE" && len(rest) >= 1:
+			mkStream := len(rest) == 2 && strings.EqualFold(rest[1], "MKSTREAM")
+			if len(rest) == 2 && !mkStream {
+				return nil, nil, ErrSyntax
+			}
+			if s.empty() && !mkStream {
+				return nil, nil, ErrNoStream
+			}
+			if _, ok := s.groups[name]; ok {
+				return nil, nil, ErrGroupExists
+			}
+			start, err := s.groupStartID(rest[0])
+			if err != nil {
+				return nil, nil, err
+			}
+			return nil, func() { s.groups[name] = newStreamGroup(start) }, nil
+
+		case sub == "SETID" && len(rest) == 1:
+			g, err := s.group(name)
+			if err != nil {
+				return nil, nil, err
+			}
+			start, err := s.groupStartID(rest[0])
+			if err != nil || start == g.lastDelivered {
+				return nil, nil, err
+			}
+			return nil, func() { g.lastDelivered = start }, nil
+
+		case sub == "DESTROY" && len(rest) == 0:
+			if _, ok := s.groups[name]; !ok {
+				return int64(0), nil, nil
+			}
+			return int64(1), func() { delete(s.groups, name) }, nil
+		}
+		return nil, nil, ErrSyntax
+	}),
+
+	// XREADGROUP group consumer id count [NOACK] replies with the
+	// entries never delivered to the group if id is ">", else with
+	// those pending for the consumer after id; a count of 0 is no limit
+	"XREADGROUP": streamOp(false, 4, 5, func(s *streamValue, op Op) (any, func(), error) {
+		noAck := len(op.Args) == 5
+		if noAck && !strings.EqualFold(op.Args[4], "NOACK") {
+			return nil, nil, ErrSyntax
+		}
+		count, err := intArg(op.Args[3])
+		if err != nil || count < 0 {
+			return nil, nil, ErrNotInteger
+		}
+		if count == 0 {
+			count = math.MaxInt
+		}
+		g, err := s.group(op.Args[0])
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if op.Args[2] != ">" {
+			after, err := parseStreamID(op.Args[2], 0)
+			if err != nil {
+				return nil, nil, err
+			}
+			return s.readPending(g, op.Args[1], after, count), nil, nil
+		}
+
+		// New entries are delivered, and left pending unless NOACK
+		unread := s.unread(g, count)
+		out := make([]any, 0, len(unread))
+		for _, e := range unread {
+			out = append(out, e.reply())
+		}
+		if len(unread) == 0 {
+			return out, nil, nil
+		}
+		return out, func() {
+			for _, e := range unread {
+				if !noAck {
+					g.deliver(e.id, op.Args[1], op.Time)
+				}
+			}
+			g.lastDelivered = unread[len(unread)-1].id
+		}, nil
+	}),
+
+	// XACK group id [id ...] replies with the entries acknowledged
+	"XACK": streamOp(false, 2, -1, func(s *streamValue, op Op) (any, func(), error) {
+		ids := make([]streamID, 0, len(op.Args)-1)
+		for _, arg := range op.Args[1:] {
+			id, err := parseStreamID(arg, 0)
+			if err != nil {
+				return nil, nil, err
+			}
+			ids = append(ids, id)
+		}
+
+		g, ok := s.groups[op.Args[0]]
+		if !ok {
+			return int64(0), nil, nil
+		}
+		acked := make(map[streamID]struct{}, len(ids))
+		for _, id := range ids {
+			if _, ok := g.pending[id]; ok {
+				acked[id] = struct{}{}
+			}
+		}
+		if len(acked) == 0 {
+			return int64(0), nil, nil
+		}
+		return int64(len(acked)), func() {
+			for id := range acked {
+				delete(g.pending, id)
+			}
+		}, nil
+	}),
+
+	// XPENDING group replies with [count, first id, last id,
+	// [[consumer, count], ...]]; XPENDING group start end count
+	// [consumer] with [[id, consumer, idle ms, deliveries], ...]
+	"XPENDING": streamOp(true, 1, 5, queryStreamOp(func(s *streamValue, op Op) (any, error) {
+		if len(op.Args) != 1 && len(op.Args) < 4 {
+			return nil, ErrOpArgs
+		}
+		g, err := s.group(op.Args[0])
+		if err != nil {
+			return nil, err
+		}
+		ids := g.pendingIDs()
+
+		if len(op.Args) == 1 {
+			if len(ids) == 0 {
+				return []any{int64(0), nil, nil, []any{}}, nil
+			}
+			counts := make(map[string]int64)
+			for _, id := range ids {
+				counts[g.pending[id].consumer]++
+			}
+			consumers := make([]string, 0, len(counts))
+			for c := range counts {
+				consumers = append(consumers, c)
+			}
+			slices.Sort(consumers)
+			perConsumer := make([]any, 0, len(consumers))
+			for _, c := range consumers {
+				perConsumer = append(perConsumer, []any{c, counts[c]})
+			}
+			return []any{int64(len(ids)), ids[0].String(), ids[len(ids)-1].String(), perConsumer}, nil
+		}
+
+		start, err := parseRangeStart(op.Args[1])
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseRangeEnd(op.Args[2])
+		if err != nil {
+			return nil, err
+		}
+		count, err := intArg(op.Args[3])
+		if err != nil || count < 0 {
+			return nil, ErrNotInteger
+		}
+
+		out := []any{}
+		for _, id := range ids {
+			if len(out) == count || end.less(id) {
+				break
+			}
+			p := g.pending[id]
+			if id.less(start) || (len(op.Args) == 5 && p.consumer != op.Args[4]) {
+				continue
+			}
+			out = append(out, []any{id.String(), p.consumer, max(op.Time-p.deliveredAt, 0), int64(p.deliveries)})
+		}
+		return out, nil
+	})),
+
+	// XCLAIM group consumer min-idle-ms id [id ...] hands the pending
+	// entries idle for at least min-idle-ms over to consumer, and
+	// replies with them; pending entries trimmed from the stream are
+	// dropped instead
+	"XCLAIM": streamOp(false, 4, -1, func(s *streamValue, op Op) (any, func(), error) {
+		minIdle, err := int64Arg(op.Args[2])
+		if err != nil {
+			return nil, nil, err
+		}
+		ids := make([]streamID, 0, len(op.Args)-3)
+		for _, arg := range op.Args[3:] {
+			id, err := parseStreamID(arg, 0)
+			if err != nil {
+				return nil, nil, err
+			}
+			ids = append(ids, id)
+		}
+		g, err := s.group(op.Args[0])
+		if err != nil {
+			return nil, nil, err
+		}
+
+		var claimed []streamID
+		seen := make(map[streamID]struct{})
+		dropped := make(map[streamID]struct{})
+		out := []any{}
+		for _, id := range ids {
+			p, ok := g.pending[id]
+			if _, gone := dropped[id]; !ok || gone {
+				continue
+			}
+			// An ID given twice was just delivered, so it is idle no more
+			idle := op.Time - p.deliveredAt
+			if _, ok := seen[id]; ok {
+				idle = 0
+			}
+			if idle < minIdle {
+				continue
+			}
+			e, ok := s.find(id)
+			if !ok {
+				dropped[id] = struct{}{}
+				continue
+			}
+			seen[id] = struct{}{}
+			claimed = append(claimed, id)
+			out = append(out, e.reply())
+		}
+
+		if len(claimed) == 0 && len(dropped) == 0 {
+			return out, nil, nil
+		}
+		return out, func() {
+			for id := range dropped {
+				delete(g.pending, id)
+			}
+			for _, id := range claimed {
+				g.deliver(id, op.Args[1], op.Time)
+			}
+		}, nil
+	}),
+}
@@ -0,0 +1,266 @@
+package store
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+/*
+xadd adds an entry to the stream at key at time now and returns its ID.
+*/
+func xadd(t *testing.T, s Tx, key, id string, now int64, fields ...string) string {
+	t.Helper()
+
+	got, err := Apply(s, key, Op{Name: "XADD", Args: append([]string{id}, fields...), Time: now})
+	if err != nil {
+		t.Fatalf("XADD %s %s failed: %v", key, id, err)
+	}
+	return got.(string)
+}
+
+/*
+entryIDs returns the IDs of the entries replied by a stream op.
+*/
+func entryIDs(reply any) []string {
+	ids := []string{}
+	for _, e := range reply.([]any) {
+		ids = append(ids, e.([]any)[0].(string))
+	}
+	return ids
+}
+
+func TestStream_IDsAlwaysGrow(t *testing.T) {
+	s := NewLockedStore()
+
+	steps := []struct {
+		id   string
+		now  int64
+		want string
+	}{
+		{"*", 1000, "1000-0"},
+		{"*", 1000, "1000-1"},
+		{"*", 900, "1000-2"}, // the clock went back
+		{"1000-*", 0, "1000-3"},
+		{"1500-*", 0, "1500-0"},
+		{"1500-7", 0, "1500-7"},
+		{"*", 2000, "2000-0"},
+	}
+	for _, step := range steps {
+		if got := xadd(t, s, "s", step.id, step.now, "f", "v"); got != step.want {
+			t.Fatalf("XADD %s at %d: expected %s, got %s", step.id, step.now, step.want, got)
+		}
+	}
+
+	for _, id := range []string{"2000-0", "1999", "1999-*", "0-0"} {
+		if _, err := Apply(s, "s", Op{Name: "XADD", Args: []string{id, "f", "v"}}); !errors.Is(err, ErrStreamIDTooSmall) {
+			t.Fatalf("XADD %s: expected ErrStreamIDTooSmall, got %v", id, err)
+		}
+	}
+	if _, err := Apply(s, "s", Op{Name: "XADD", Args: []string{"x-1", "f", "v"}}); !errors.Is(err, ErrInvalidStreamID) {
+		t.Fatalf("expected ErrInvalidStreamID, got %v", err)
+	}
+
+	// Trimming everything keeps the key, and the last ID with it
+	_, _ = Apply(s, "s", Op{Name: "XTRIM", Args: []string{"MAXLEN", "0"}})
+	if got := xadd(t, s, "s", "*", 1, "f", "v"); got != "2000-1" {
+		t.Fatalf("expected IDs to keep growing after a trim, got %s", got)
+	}
+}
+
+func TestStream_Ranges(t *testing.T) {
+	s := NewLockedStore()
+	for _, id := range []string{"1-0", "1-1", "2-0", "3-0", "3-5"} {
+		xadd(t, s, "s", id, 0, "id", id)
+	}
+
+	for _, tc := range []struct {
+		op   string
+		args []string
+		want []string
+	}{
+		{"XRANGE", []string{"-", "+"}, []string{"1-0", "1-1", "2-0", "3-0", "3-5"}},
+		{"XRANGE", []string{"1", "1"}, []string{"1-0", "1-1"}},
+		{"XRANGE", []string{"(1-0", "(3-5"}, []string{"1-1", "2-0", "3-0"}},
+		{"XRANGE", []string{"-", "+", "COUNT", "2"}, []string{"1-0", "1-1"}},
+		{"XRANGE", []string{"4", "+"}, []string{}},
+		{"XREVRANGE", []string{"+", "-", "count", "3"}, []string{"3-5", "3-0", "2-0"}},
+		{"XREVRANGE", []string{"2", "1-1"}, []string{"2-0", "1-1"}},
+		{"XREAD", []string{"1-1", "0"}, []string{"2-0", "3-0", "3-5"}},
+	} {
+		got, err := Apply(s, "s", Op{Name: tc.op, Args: tc.args})
+		if err != nil {
+			t.Fatalf("%s %v failed: %v", tc.op, tc.args, err)
+		}
+		if tc.op == "XREAD" {
+			got = got.([]any)[1]
+		}
+		if ids := entryIDs(got); !reflect.DeepEqual(ids, tc.want) {
+			t.Fatalf("%s %v: expected %v, got %v", tc.op, tc.args, tc.want, ids)
+		}
+	}
+
+	// "$" resolves to the last ID, with nothing after it yet
+	got, _ := Apply(s, "s", Op{Name: "XREAD", Args: []string{"$", "0"}})
+	if want := []any{"3-5", []any{}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+
+	removed, _ := Apply(s, "s", Op{Name: "XTRIM", Args: []string{"MINID", "3"}})
+	length, _ := Apply(s, "s", Op{Name: "XLEN"})
+	if removed != int64(3) || length != int64(2) {
+		t.Fatalf("expected MINID to remove 3 entries and keep 2, got %v and %v", removed, length)
+	}
+}
+
+func TestStream_AppendsInPlace(t *testing.T) {
+	s := NewLockedStore()
+	defer s.Close()
+
+	xadd(t, s, "s", "*", 1, "f", "v")
+	stream := liveOf(s, "s").(*streamValue)
+
+	// Entries are appended to: the stream's array moves only when
+	// it grows, a logarithmic number of times
+	moves, first := 0, &stream.entries[0]
+	for i := 0; i < 1000; i++ {
+		xadd(t, s, "s", "*", 1, "f", "v")
+		if &stream.entries[0] != first {
+			moves, first = moves+1, &stream.entries[0]
+		}
+	}
+	if liveOf(s, "s") != stream || len(stream.entries) != 1001 {
+		t.Fatalf("expected XADD to append to the same stream")
+	}
+	if moves > 20 {
+		t.Fatalf("expected the entries to be appended to, moved %d times", moves)
+	}
+
+	// Trimming a few entries reslices the rest
+	second := &stream.entries[1]
+	if _, err := Apply(s, "s", Op{Name: "XTRIM", Args: []string{"MAXLEN", "1000"}}); err != nil {
+		t.Fatalf("XTRIM failed: %v", err)
+	}
+	if &stream.entries[0] != second {
+		t.Fatalf("expected XTRIM to keep the remaining entries in place")
+	}
+}
+
+func TestStream_ConsumerGroups(t *testing.T) {
+	s := NewLockedStore()
+	read := func(consumer, id string, now int64) []string {
+		t.Helper()
+		got, err := Apply(s, "s", Op{Name: "XREADGROUP", Args: []string{"g", consumer, id, "0"}, Time: now})
+		if err != nil {
+			t.Fatalf("XREADGROUP %s %s failed: %v", consumer, id, err)
+		}
+		return entryIDs(got)
+	}
+
+	if _, err := Apply(s, "s", Op{Name: "XGROUP", Args: []string{"CREATE", "g", "$"}}); !errors.Is(err, ErrNoStream) {
+		t.Fatalf("expected ErrNoStream, got %v", err)
+	}
+	if _, err := Apply(s, "s", Op{Name: "XGROUP", Args: []string{"CREATE", "g", "$", "MKSTREAM"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Apply(s, "s", Op{Name: "XGROUP", Args: []string{"CREATE", "g", "0"}}); !errors.Is(err, ErrGroupExists) {
+		t.Fatalf("expected ErrGroupExists, got %v", err)
+	}
+	for _, id := range []string{"1-0", "2-0", "3-0"} {
+		xadd(t, s, "s", id, 0, "job", id)
+	}
+
+	// New entries go to one consumer each, and stay pending for it
+	if got := read("alice", ">", 100); !reflect.DeepEqual(got, []string{"1-0", "2-0", "3-0"}) {
+		t.Fatalf("expected alice to get every entry, got %v", got)
+	}
+	if got := read("bob", ">", 100); len(got) != 0 {
+		t.Fatalf("expected nothing left for bob, got %v", got)
+	}
+	acked, _ := Apply(s, "s", Op{Name: "XACK", Args: []string{"g", "1-0", "9-0"}})
+	if acked != int64(1) {
+		t.Fatalf("expected one entry acknowledged, got %v", acked)
+	}
+	if got := read("alice", "0", 100); !reflect.DeepEqual(got, []string{"2-0", "3-0"}) {
+		t.Fatalf("expected alice's history to hold the unacknowledged entries, got %v", got)
+	}
+
+	// Alice died: bob claims what has been idle long enough
+	claimed, _ := Apply(s, "s", Op{Name: "XCLAIM", Args: []string{"g", "bob", "50", "2-0", "3-0"}, Time: 120})
+	if len(entryIDs(claimed)) != 0 {
+		t.Fatalf("expected nothing idle long enough yet, got %v", claimed)
+	}
+	claimed, _ = Apply(s, "s", Op{Name: "XCLAIM", Args: []string{"g", "bob", "50", "2-0"}, Time: 200})
+	if got := entryIDs(claimed); !reflect.DeepEqual(got, []string{"2-0"}) {
+		t.Fatalf("expected bob to claim 2-0, got %v", got)
+	}
+
+	summary, _ := Apply(s, "s", Op{Name: "XPENDING", Args: []string{"g"}})
+	want := []any{int64(2), "2-0", "3-0", []any{[]any{"alice", int64(1)}, []any{"bob", int64(1)}}}
+	if !reflect.DeepEqual(summary, want) {
+		t.Fatalf("expected summary %v, got %v", want, summary)
+	}
+	detail, _ := Apply(s, "s", Op{Name: "XPENDING", Args: []string{"g", "-", "+", "10", "bob"}, Time: 230})
+	if want := []any{[]any{"2-0", "bob", int64(30), int64(2)}}; !reflect.DeepEqual(detail, want) {
+		t.Fatalf("expected %v, got %v", want, detail)
+	}
+
+	// A pending entry trimmed away is dropped when claimed
+	_, _ = Apply(s, "s", Op{Name: "XTRIM", Args: []string{"MAXLEN", "0"}})
+	claimed, _ = Apply(s, "s", Op{Name: "XCLAIM", Args: []string{"g", "bob", "0", "3-0"}, Time: 300})
+	summary, _ = Apply(s, "s", Op{Name: "XPENDING", Args: []string{"g"}})
+	if len(entryIDs(claimed)) != 0 || summary.([]any)[0] != int64(1) {
+		t.Fatalf("expected the trimmed entry to leave the pending list, got %v and %v", claimed, summary)
+	}
+
+	if _, err := Apply(s, "s", Op{Name: "XREADGROUP", Args: []string{"nope", "c", ">", "0"}}); !errors.Is(err, ErrNoGroup) {
+		t.Fatalf("expected ErrNoGroup, got %v", err)
+	}
+}
+
+func TestWalStore_StreamsRecoverWithGroups(t *testing.T) {
+	dir := t.TempDir()
+	clock := NewManualClock(time.Unix(1_700_000_000, 0))
+	apply := func(s Tx, name string, args ...string) any {
+		t.Helper()
+		got, err := Apply(s, "events", Op{Name: name, Args: args, Time: unixNow(clock)})
+		if err != nil {
+			t.Fatalf("%s %v failed: %v", name, args, err)
+		}
+		return got
+	}
+
+	s := openCompressedWal(t, dir, clock)
+	apply(s, "XGROUP", "CREATE", "workers", "0", "MKSTREAM")
+	first := apply(s, "XADD", "*", "n", "1").(string)
+	apply(s, "XREADGROUP", "workers", "w1", ">", "0")
+
+	// Close snapshots the stream, then the WAL logs what follows
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r := openCompressedWal(t, dir, clock)
+	clock.Advance(time.Second)
+	second := apply(r, "XADD", "*", "n", "2").(string)
+	apply(r, "XREADGROUP", "workers", "w2", ">", "0")
+	apply(r, "XACK", "workers", first)
+	r.(*compressedStore).store.(*walStore).wal.Close()
+
+	r = openCompressedWal(t, dir, clock)
+	defer r.Close()
+
+	if got := entryIDs(apply(r, "XRANGE", "-", "+")); !reflect.DeepEqual(got, []string{first, second}) {
+		t.Fatalf("expected the entries to recover, got %v", got)
+	}
+	summary := apply(r, "XPENDING", "workers")
+	if want := []any{int64(1), second, second, []any{[]any{"w2", int64(1)}}}; !reflect.DeepEqual(summary, want) {
+		t.Fatalf("expected the group to recover as %v, got %v", want, summary)
+	}
+	if got := entryIDs(apply(r, "XREADGROUP", "workers", "w3", ">", "0")); len(got) != 0 {
+		t.Fatalf("expected the group to remember what it delivered, got %v", got)
+	}
+	if next := apply(r, "XADD", "*", "n", "3").(string); next <= second {
+		t.Fatalf("expected IDs to keep growing after recovery, got %s after %s", next, second)
+	}
+}
@@ -0,0 +1,103 @@
+package store
+
+import (
+	"sync"
+	"time"
+)
+
+/*
+Sweeps run in steps, each holding the store exclusively. A step
+visits at most sweepStepSlots slots and removes at most sweepBatchSize
+expired entries, so it never stalls readers for long however large
+the keyspace is.
+*/
+const (
+	sweepStepSlots = 16
+	sweepBatchSize = 128
+
+	// sweepTickSlots is how many slots the background sweeper visits
+	// per tick: the whole keyspace is covered every
+	// scanSlots/sweepTickSlots ticks, instead of at every tick.
+	sweepTickSlots = 64
+)
+
+// defaultSweepInterval mirrors Redis' active expiry running 10 times a second.
+const defaultSweepInterval = 100 * time.Millisecond
+
+/*
+sweepLocked sweeps the next slots slots of st in steps, taking mu
+exclusively for each step only, and reports how many expired entries
+it removed. Sweeping scanSlots slots removes every expired entry.
+*/
+func sweepLocked(mu *sync.RWMutex, st *store, slots int) int {
+	total := 0
+	for slots > 0 {
+		mu.Lock()
+		n, swept := st.sweepExpired(min(slots, sweepStepSlots), sweepBatchSize)
+		mu.Unlock()
+
+		total += n
+		slots -= swept
+	}
+	return total
+}
+
+/*
+sweeper runs a store's active expiry in the background.
+
+Reads only skip expired entries; the sweeper is what eventually frees
+them when they are never written again. Each tick sweeps only part of
+the keyspace (see sweepTickSlots), like Redis' active expiry, so a
+large store is not locked for a full pass ten times a second.
+*/
+type sweeper struct {
+	doneChan chan struct{}
+	wg       sync.WaitGroup
+	once     sync.Once
+}
+
+/*
+startSweeper calls sweep every interval until stop is called.
+It returns nil when interval is not positive (sweeping disabled).
+*/
+func startSweeper(interval time.Duration, sweep func() int) *sweeper {
+	if interval <= 0 {
+		return nil
+	}
+
+	sw := &sweeper{
+		doneChan: make(chan struct{}),
+	}
+
+	sw.wg.Add(1)
+	go func() {
+		defer sw.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				sweep()
+			case <-sw.doneChan:
+				return
+			}
+		}
+	}()
+	return sw
+}
+
+/*
+stop terminates the sweeper and waits for it to exit.
+It is safe to call on a nil sweeper and more than once.
+*/
+func (sw *sweeper) stop() {
+	if sw == nil {
+		return
+	}
+	sw.once.Do(func() {
+		close(sw.doneChan)
+	})
+	sw.wg.Wait()
+}
@@ -0,0 +1,642 @@
+package store
+
+import (
+	"container/list"
+	"sync"
+)
+
+/*
+tieredStore is a decorator that keeps hot values in the wrapped
+in-memory store and spills cold ones to an on-disk value log.
+
+Every key stays in the wrapped store with its TTL, write time and
+version. Spilling a value replaces it there by a small stub pointing
+into the log, so Expire, put modes, sweeping and views keep working
+unchanged, and only reads returning a spilled value touch the disk.
+A read of a spilled key loads it back in.
+
+Hot values are tracked in LRU order, and whenever their total size
+exceeds the budget the least recently used ones are spilled. The
+budget counts value bytes only, and values written straight into the
+wrapped store are not tracked.
+
+Swapping a value for its stub and back is a write to the wrapped
+store, done under its Atomic so it never races a user write, and it
+moves the key's version there. The tiered store reports the version
+the value had before instead (see versionAlias), so spilling stays
+invisible to WATCH.
+
+The wrapped store should not record history, since stubs would be
+recorded too. To make the whole stack durable, wrap the tiered store
+in a walStore, not the reverse: the WAL then logs values, not stubs.
+*/
+type tieredStore struct {
+	store  DataStore
+	log    *valueLog
+	budget int64
+
+	// mu guards the LRU, the aliases and the view count. Like the
+	// log's locks, it is never held while calling the wrapped store.
+	mu       sync.Mutex
+	lru      *list.List // of *hotValue, most recent first
+	hot      map[string]*list.Element
+	hotBytes int64
+	aliases  map[string][]versionAlias
+	views    int
+
+	// spillMu lets one goroutine spill at a time.
+	spillMu sync.Mutex
+}
+
+type hotValue struct {
+	key  string
+	size int64
+}
+
+/*
+versionAlias maps the version a spill or load gave a key in the
+wrapped store to the version the tiered store reports, which is the
+one the value had before. A later write gives the key a version with
+no alias, so reported versions still move forward with every write.
+*/
+type versionAlias struct {
+	inner    uint64
+	reported uint64
+}
+
+/*
+NewTieredStore wraps st, keeping at most budget bytes of values in
+it and spilling the rest to a value log at logPath. The log is
+created empty and removed on Close.
+*/
+func NewTieredStore(st DataStore, logPath string, budget int64) (DataStore, error) {
+	log, err := openValueLog(logPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tieredStore{
+		store:   st,
+		log:     log,
+		budget:  budget,
+		lru:     list.New(),
+		hot:     make(map[string]*list.Element),
+		aliases: make(map[string][]versionAlias),
+	}, nil
+}
+
+/*
+resolve turns an entry read from the wrapped store into the entry the
+tiered store reports: the value of a stub is loaded from the log, and
+the version follows the key's aliases. It also returns the stub's id,
+0 for a hot value, and fails when the stub died meanwhile.
+*/
+func (t *tieredStore) resolve(key string, val Entry) (Entry, uint64, bool) {
+	val.Version = t.reported(key, val.Version)
+
+	id, ok := t.log.stubID(val.Value)
+	if !ok {
+		return val, 0, true
+	}
+	value, encoding, ok := t.log.load(id)
+	if !ok {
+		return Entry{}, 0, false
+	}
+	val.Value, val.Encoding = value, encoding
+	return val, id, true
+}
+
+func (t *tieredStore) reported(key string, version uint64) uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, a := range t.aliases[key] {
+		if a.inner == version {
+			return a.reported
+		}
+	}
+	return version
+}
+
+/*
+alias records that key's version inner reports as reported. Older
+aliases are kept only while a view may read the versions they map.
+*/
+func (t *tieredStore) alias(key string, inner, reported uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.views == 0 {
+		t.aliases[key] = t.aliases[key][:0]
+	}
+	t.aliases[key] = append(t.aliases[key], versionAlias{inner: inner, reported: reported})
+}
+
+/*
+unalias forgets the aliases of a key that was just written, unless a
+view may still read the versions they map.
+*/
+func (t *tieredStore) unalias(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.views == 0 {
+		delete(t.aliases, key)
+	}
+}
+
+/*
+Read returns the value of key, loading a spilled value back in.
+
+A stub can die between the read and its resolution, when another
+goroutine loads or overwrites the key; the read is then retried.
+*/
+func (t *tieredStore) Read(key string) (Entry, bool) {
+	val, id, ok := t.lookup(key)
+	if !ok {
+		return Entry{}, false
+	}
+
+	if id == 0 {
+		t.touch(key)
+	} else {
+		t.promote(key, id, val)
+	}
+	return val, true
+}
+
+/*
+lookup reads and resolves key, retrying while its stub dies under it.
+*/
+func (t *tieredStore) lookup(key string) (Entry, uint64, bool) {
+	for {
+		val, ok := t.store.Read(key)
+		if !ok {
+			return Entry{}, 0, false
+		}
+		if val, id, ok := t.resolve(key, val); ok {
+			return val, id, true
+		}
+	}
+}
+
+/*
+touch marks a hot key as just used.
+*/
+func (t *tieredStore) touch(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if el, ok := t.hot[key]; ok {
+		t.lru.MoveToFront(el)
+	}
+}
+
+/*
+track records that key now holds a hot value of the given size.
+*/
+func (t *tieredStore) track(key string, size int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if el, ok := t.hot[key]; ok {
+		hv := el.Value.(*hotValue)
+		t.hotBytes += int64(size) - hv.size
+		hv.size = int64(size)
+		t.lru.MoveToFront(el)
+		return
+	}
+	t.hot[key] = t.lru.PushFront(&hotValue{key: key, size: int64(size)})
+	t.hotBytes += int64(size)
+}
+
+func (t *tieredStore) untrack(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if el, ok := t.hot[key]; ok {
+		t.hotBytes -= el.Value.(*hotValue).size
+		t.lru.Remove(el)
+		delete(t.hot, key)
+	}
+}
+
+/*
+promote loads a spilled value back into the wrapped store, unless the
+key changed since it was read, and then spills whatever no longer
+fits the budget.
+*/
+func (t *tieredStore) promote(key string, id uint64, val Entry) {
+	_ = t.store.Atomic([]string{key}, func(tx Tx) error {
+		cur, ok := tx.Read(key)
+		if !ok {
+			return nil
+		}
+		if curID, isStub := t.log.stubID(cur.Value); !isStub || curID != id {
+			return nil
+		}
+
+		loaded := Entry{Value: val.Value, ExpiresAtMillis: cur.ExpiresAtMillis, WrittenAtMillis: cur.WrittenAtMillis, Encoding: val.Encoding, Type: cur.Type}
+		if err := tx.Write(key, loaded, PutUpdate); err != nil {
+			return err
+		}
+		now, _ := tx.Read(key)
+		t.alias(key, now.Version, val.Version)
+		t.log.release(id)
+		t.track(key, len(val.Value))
+		return nil
+	})
+	t.evict()
+}
+
+/*
+evict spills least recently used values until the hot ones fit the
+budget.
+*/
+func (t *tieredStore) evict() {
+	t.mu.Lock()
+	over := t.hotBytes > t.budget
+	t.mu.Unlock()
+	if !over {
+		return
+	}
+
+	t.spillMu.Lock()
+	defer t.spillMu.Unlock()
+
+	for {
+		t.mu.Lock()
+		if t.hotBytes <= t.budget || t.lru.Len() == 0 {
+			t.mu.Unlock()
+			break
+		}
+		victim := t.lru.Back().Value.(*hotValue).key
+		t.mu.Unlock()
+
+		t.untrack(victim)
+		t.spill(victim)
+	}
+
+	if t.log.needsCompaction() {
+		t.compact()
+	}
+}
+
+/*
+spill moves the value of key to the log and leaves a stub in its
+place, unless the key is gone or already spilled.
+*/
+func (t *tieredStore) spill(key string) {
+	_ = t.store.Atomic([]string{key}, func(tx Tx) error {
+		cur, ok := tx.Read(key)
+		if !ok {
+			return nil
+		}
+		if _, isStub := t.log.stubID(cur.Value); isStub {
+			return nil
+		}
+
+		stub, err := t.log.spill(key, cur.Value, cur.Encoding)
+		if err != nil {
+			// The value stays hot
+			t.track(key, len(cur.Value))
+			return err
+		}
+		id, _ := t.log.stubID(stub)
+
+		err = tx.Write(key, Entry{Value: stub, ExpiresAtMillis: cur.ExpiresAtMillis, WrittenAtMillis: cur.WrittenAtMillis, Type: cur.Type}, PutUpdate)
+		if err != nil {
+			t.log.release(id)
+			return err
+		}
+		now, _ := tx.Read(key)
+		t.alias(key, now.Version, t.reported(key, cur.Version))
+		return nil
+	})
+}
+
+/*
+compact kills the stubs of keys that expired or were overwritten
+without the tiered store noticing, then compacts the log. Each key is
+checked under Atomic, so a concurrent spill of it is not mistaken
+for a dead stub.
+*/
+func (t *tieredStore) compact() {
+	t.log.meta.Lock()
+	current := make(map[string]uint64, len(t.log.current))
+	for key, id := range t.log.current {
+		current[key] = id
+	}
+	t.log.meta.Unlock()
+
+	for key, id := range current {
+		_ = t.store.Atomic([]string{key}, func(tx Tx) error {
+			cur, ok := tx.Read(key)
+			if curID, isStub := t.log.stubID(cur.Value); !ok || !isStub || curID != id {
+				t.log.release(id)
+			}
+			return nil
+		})
+	}
+
+	_ = t.log.compact()
+}
+
+/*
+Write goes through Atomic on the key, so the stub it may replace is
+known and released.
+*/
+func (t *tieredStore) Write(key string, value Entry, mode PutMode) error {
+	err := t.store.Atomic([]string{key}, func(tx Tx) error {
+		return t.write(tx, key, value, mode)
+	})
+	if err == nil {
+		t.evict()
+	}
+	return err
+}
+
+/*
+write applies a user write through tx, a transaction of the wrapped
+store holding key.
+*/
+func (t *tieredStore) write(tx Tx, key string, value Entry, mode PutMode) error {
+	cur, _ := tx.Read(key)
+	if err := tx.Write(key, value, mode); err != nil {
+		return err
+	}
+
+	if id, isStub := t.log.stubID(cur.Value); isStub {
+		t.log.release(id)
+	}
+	t.unalias(key)
+	t.track(key, len(value.Value))
+	return nil
+}
+
+/*
+Expire only changes the key's TTL, stub or not, so it goes straight to
+the wrapped store without touching the disk.
+*/
+func (t *tieredStore) Expire(key string, unixTimestampMilli int64) bool {
+	return t.store.Expire(key, unixTimestampMilli)
+}
+
+/*
+ReadBatch resolves the wrapped store's consistent batch. Spilled
+values are read from the log but not loaded back.
+*/
+func (t *tieredStore) ReadBatch(keys []string) map[string]Entry {
+	for {
+		result := t.store.ReadBatch(keys)
+		if t.resolveAll(result) {
+			return result
+		}
+	}
+}
+
+/*
+resolveAll resolves every entry of batch in place, and reports false
+if a stub died meanwhile.
+*/
+func (t *tieredStore) resolveAll(batch map[string]Entry) bool {
+	for key, val := range batch {
+		val, _, ok := t.resolve(key, val)
+		if !ok {
+			return false
+		}
+		batch[key] = val
+	}
+	return true
+}
+
+func (t *tieredStore) WriteBatch(entries []KeyValue, mode PutMode) error {
+	keys := make([]string, len(entries))
+	for i, kv := range entries {
+		keys[i] = kv.Key
+	}
+
+	err := t.store.Atomic(keys, func(tx Tx) error {
+		return t.writeBatch(tx, entries, mode)
+	})
+	if err == nil {
+		t.evict()
+	}
+	return err
+}
+
+func (t *tieredStore) writeBatch(tx Tx, entries []KeyValue, mode PutMode) error {
+	keys := make([]string, len(entries))
+	for i, kv := range entries {
+		keys[i] = kv.Key
+	}
+	prev := tx.ReadBatch(keys)
+
+	if err := tx.WriteBatch(entries, mode); err != nil {
+		return err
+	}
+
+	for _, kv := range entries {
+		if id, isStub := t.log.stubID(prev[kv.Key].Value); isStub {
+			t.log.release(id)
+		}
+		t.unalias(kv.Key)
+		t.track(kv.Key, len(kv.Entry.Value))
+	}
+	return nil
+}
+
+/*
+Atomic runs fn in a transaction of the wrapped store, through which
+stubs are resolved and replaced like by Read and Write. Spilled values
+read by fn are not loaded back, and budget overruns are spilled once
+the transaction is over.
+*/
+func (t *tieredStore) Atomic(keys []string, fn func(tx Tx) error) error {
+	err := t.store.Atomic(keys, func(inner Tx) error {
+		return fn(&tieredTx{t: t, inner: inner})
+	})
+	t.evict()
+	return err
+}
+
+/*
+tieredTx is the Tx handed to an Atomic callback. The wrapped store
+holds its keys, so the stubs it reads cannot die under it.
+*/
+type tieredTx struct {
+	t     *tieredStore
+	inner Tx
+}
+
+func (tx *tieredTx) Read(key string) (Entry, bool) {
+	val, ok := tx.inner.Read(key)
+	if !ok {
+		return Entry{}, false
+	}
+	val, _, ok = tx.t.resolve(key, val)
+	return val, ok
+}
+
+func (tx *tieredTx) Write(key string, value Entry, mode PutMode) error {
+	return tx.t.write(tx.inner, key, value, mode)
+}
+
+func (tx *tieredTx) Expire(key string, unixTimestampMilli int64) bool {
+	return tx.inner.Expire(key, unixTimestampMilli)
+}
+
+func (tx *tieredTx) ReadBatch(keys []string) map[string]Entry {
+	result := tx.inner.ReadBatch(keys)
+	tx.t.resolveAll(result)
+	return result
+}
+
+func (tx *tieredTx) WriteBatch(entries []KeyValue, mode PutMode) error {
+	return tx.t.writeBatch(tx.inner, entries, mode)
+}
+
+/*
+Iterate visits the wrapped store's entries, reading spilled values from
+the log. Stores that cannot iterate are traversed through a view.
+*/
+func (t *tieredStore) Iterate(fn func(key string, value Entry) bool) {
+	it, ok := t.store.(Iterable)
+	if !ok {
+		v := t.Snapshot()
+		defer v.Close()
+		v.Iterate(fn)
+		return
+	}
+
+	// A stub that died since the wrapped store copied it is read
+	// again once the traversal is over
+	var retry []string
+	stopped := false
+	it.Iterate(func(key string, value Entry) bool {
+		val, _, ok := t.resolve(key, value)
+		if !ok {
+			retry = append(retry, key)
+			return true
+		}
+		stopped = !fn(key, val)
+		return !stopped
+	})
+
+	for _, key := range retry {
+		if stopped {
+			return
+		}
+		if val, _, ok := t.lookup(key); ok {
+			stopped = !fn(key, val)
+		}
+	}
+}
+
+/*
+ScanEntries resolves one step of the wrapped store's scan. Over a
+store that cannot scan, which AsScanner reports, it returns an empty,
+finished scan.
+*/
+func (t *tieredStore) ScanEntries(cursor uint64, count int) ([]KeyValue, uint64) {
+	sc, ok := t.store.(Scanner)
+	if !ok {
+		return nil, 0
+	}
+
+	entries, next := sc.ScanEntries(cursor, count)
+	resolved := entries[:0]
+	for _, kv := range entries {
+		val, _, ok := t.resolve(kv.Key, kv.Entry)
+		if !ok {
+			val, _, ok = t.lookup(kv.Key)
+		}
+		if ok {
+			resolved = append(resolved, KeyValue{Key: kv.Key, Entry: val})
+		}
+	}
+	return resolved, next
+}
+
+/*
+Snapshot wraps a view of the wrapped store. While a view is open, dead
+stubs and old aliases are kept and the log is not compacted, so the
+view can still resolve what it sees.
+*/
+func (t *tieredStore) Snapshot() View {
+	t.mu.Lock()
+	t.views++
+	t.mu.Unlock()
+	t.log.pin()
+
+	return &tieredView{t: t, view: t.store.Snapshot()}
+}
+
+func (t *tieredStore) Close() error {
+	err := t.store.Close()
+	if lerr := t.log.close(); err == nil {
+		err = lerr
+	}
+	return err
+}
+
+/*
+tieredView resolves the stubs its wrapped view sees.
+*/
+type tieredView struct {
+	t      *tieredStore
+	view   View
+	closed sync.Once
+}
+
+func (v *tieredView) Read(key string) (Entry, bool) {
+	val, ok := v.view.Read(key)
+	if !ok {
+		return Entry{}, false
+	}
+	val, _, ok = v.t.resolve(key, val)
+	return val, ok
+}
+
+func (v *tieredView) ReadBatch(keys []string) map[string]Entry {
+	result := v.view.ReadBatch(keys)
+	for key, val := range result {
+		if val, _, ok := v.t.resolve(key, val); ok {
+			result[key] = val
+		} else {
+			delete(result, key)
+		}
+	}
+	return result
+}
+
+func (v *tieredView) Iterate(fn func(key string, value Entry) bool) {
+	v.view.Iterate(func(key string, value Entry) bool {
+		val, _, ok := v.t.resolve(key, value)
+		return !ok || fn(key, val)
+	})
+}
+
+func (v *tieredView) Sequence() uint64 {
+	return v.view.Sequence()
+}
+
+func (v *tieredView) Close() {
+	v.closed.Do(func() {
+		v.view.Close()
+
+		v.t.log.unpin()
+
+		t := v.t
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		t.views--
+		if t.views > 0 {
+			return
+		}
+		for key, chain := range t.aliases {
+			t.aliases[key] = chain[len(chain)-1:]
+		}
+	})
+}
@@ -0,0 +1,282 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand/v2"
+	"os"
+	"sync"
+)
+
+/*
+minLogCompaction is the garbage, in bytes, a value log must hold
+before it is compacted.
+*/
+const minLogCompaction = 1 << 20
+
+/*
+stubSize is the size of the stub a spilled value is replaced with in
+the wrapped store: an 8-byte magic, the log's 8-byte nonce and the
+spill id.
+*/
+const stubSize = 24
+
+var stubMagic = []byte("\xffspill\x00\xff")
+
+/*
+spillRef locates a spilled value in the value log.
+*/
+type spillRef struct {
+	key    string
+	offset int64
+	size   uint32
+}
+
+/*
+valueLog is the cold tier of a tieredStore: an append-only file of
+spilled values, reusing the Bitcask record format, and the index of
+the stubs pointing into it.
+
+A stub carries the log's random nonce and a spill id that is never
+reused, so it cannot be mistaken for a user value nor for another
+stub of the same key. A stub is dead once the wrapped store no longer
+holds it; its record is garbage, reclaimed by compaction.
+
+The log is a cache, not a durable tier: it is truncated when opened
+and removed on Close. Durability, if wanted, comes from a walStore
+wrapping the tiered store, which logs whole values.
+
+Locks are taken in the order mu, then meta; the wrapped store is never
+called with either held.
+*/
+type valueLog struct {
+	path string
+
+	// mu guards the file: readers hold it shared from resolving a
+	// stub to reading its record, so compaction cannot move records
+	// under them.
+	mu   sync.RWMutex
+	file *dataFile
+
+	meta    sync.Mutex
+	prefix  []byte
+	nextID  uint64
+	stubs   map[uint64]spillRef
+	current map[string]uint64 // key -> id of its latest stub
+	garbage int64
+	live    int64
+
+	// pins counts open views. While a view is open dead stubs stay
+	// resolvable, since it may still see them.
+	pins int
+	dead map[uint64]struct{}
+}
+
+func openValueLog(path string) (*valueLog, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := binary.LittleEndian.AppendUint64(append([]byte(nil), stubMagic...), rand.Uint64())
+	return &valueLog{
+		path:    path,
+		file:    &dataFile{f: f},
+		prefix:  prefix,
+		stubs:   make(map[uint64]spillRef),
+		current: make(map[string]uint64),
+		dead:    make(map[uint64]struct{}),
+	}, nil
+}
+
+/*
+stubID returns the spill id of value if it is one of this log's stubs.
+*/
+func (l *valueLog) stubID(value []byte) (uint64, bool) {
+	if len(value) != stubSize || !bytes.HasPrefix(value, l.prefix) {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint64(value[len(l.prefix):]), true
+}
+
+/*
+spill appends the value of key, kept in the given encoding, and returns
+the stub to store instead.
+*/
+func (l *valueLog) spill(key string, value []byte, encoding Encoding) ([]byte, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	rec := record{kind: recordPut, key: key, value: value, encoding: encoding}
+	offset, err := l.file.append(rec.appendTo(nil))
+	if err != nil {
+		return nil, err
+	}
+
+	l.meta.Lock()
+	defer l.meta.Unlock()
+
+	l.nextID++
+	id := l.nextID
+	l.stubs[id] = spillRef{key: key, offset: offset, size: uint32(rec.size())}
+	if old, ok := l.current[key]; ok {
+		l.kill(old)
+	}
+	l.current[key] = id
+	l.live += int64(rec.size())
+
+	return binary.LittleEndian.AppendUint64(append([]byte(nil), l.prefix...), id), nil
+}
+
+/*
+load reads the value of stub id and its encoding. It fails when the
+stub is unknown, which happens once it died and no view needs it any
+more.
+*/
+func (l *valueLog) load(id uint64) ([]byte, Encoding, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	l.meta.Lock()
+	ref, ok := l.stubs[id]
+	l.meta.Unlock()
+	if !ok {
+		return nil, 0, false
+	}
+
+	rec, err := l.file.readRecordAt(ref.offset, ref.size)
+	if err != nil {
+		return nil, 0, false
+	}
+	return rec.value, rec.encoding, true
+}
+
+/*
+release marks stub id dead: the wrapped store replaced it.
+*/
+func (l *valueLog) release(id uint64) {
+	l.meta.Lock()
+	defer l.meta.Unlock()
+
+	l.kill(id)
+}
+
+/*
+kill marks a stub dead, forgetting it unless a view is open. The
+caller holds meta.
+*/
+func (l *valueLog) kill(id uint64) {
+	ref, ok := l.stubs[id]
+	if !ok {
+		return
+	}
+	if _, ok := l.dead[id]; ok {
+		return
+	}
+
+	if l.current[ref.key] == id {
+		delete(l.current, ref.key)
+	}
+	l.garbage += int64(ref.size)
+	l.live -= int64(ref.size)
+
+	if l.pins > 0 {
+		l.dead[id] = struct{}{}
+	} else {
+		delete(l.stubs, id)
+	}
+}
+
+func (l *valueLog) pin() {
+	l.meta.Lock()
+	defer l.meta.Unlock()
+
+	l.pins++
+}
+
+/*
+unpin releases a view; once none is open the dead stubs are forgotten.
+*/
+func (l *valueLog) unpin() {
+	l.meta.Lock()
+	defer l.meta.Unlock()
+
+	l.pins--
+	if l.pins > 0 {
+		return
+	}
+	for id := range l.dead {
+		delete(l.stubs, id)
+	}
+	clear(l.dead)
+}
+
+/*
+needsCompaction reports whether garbage outweighs the live values.
+*/
+func (l *valueLog) needsCompaction() bool {
+	l.meta.Lock()
+	defer l.meta.Unlock()
+
+	return l.pins == 0 && l.garbage >= minLogCompaction && l.garbage > l.live
+}
+
+/*
+compact rewrites the live stubs' records into a fresh log, dropping
+the garbage. It does nothing while a view is open.
+*/
+func (l *valueLog) compact() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.meta.Lock()
+	defer l.meta.Unlock()
+	if l.pins > 0 {
+		return nil
+	}
+
+	tmp := l.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	next := &dataFile{f: f}
+
+	moved := make(map[uint64]spillRef, len(l.stubs))
+	for id, ref := range l.stubs {
+		rec, err := l.file.readRecordAt(ref.offset, ref.size)
+		if err == nil {
+			ref.offset, err = next.append(rec.appendTo(nil))
+		}
+		if err != nil {
+			f.Close()
+			os.Remove(tmp)
+			return err
+		}
+		moved[id] = ref
+	}
+
+	if err := os.Rename(tmp, l.path); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	l.file.f.Close()
+	l.file, l.stubs = next, moved
+	l.garbage = 0
+	return nil
+}
+
+/*
+close closes and removes the log.
+*/
+func (l *valueLog) close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	err := l.file.f.Close()
+	if rerr := os.Remove(l.path); err == nil {
+		err = rerr
+	}
+	return err
+}
@@ -0,0 +1,184 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+/*
+openTiered wraps a locked store on clock with a tiered store of the
+given budget.
+*/
+func openTiered(t *testing.T, clock Clock, budget int64) (*tieredStore, DataStore) {
+	t.Helper()
+
+	inner := NewLockedStore(WithClock(clock), WithSweepInterval(0))
+	ds, err := NewTieredStore(inner, filepath.Join(t.TempDir(), "values.log"), budget)
+	if err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	t.Cleanup(func() { ds.Close() })
+	return ds.(*tieredStore), inner
+}
+
+func isSpilled(t *testing.T, ts *tieredStore, inner DataStore, key string) bool {
+	t.Helper()
+
+	val, ok := inner.Read(key)
+	if !ok {
+		t.Fatalf("expected %s in the wrapped store", key)
+	}
+	_, isStub := ts.log.stubID(val.Value)
+	return isStub
+}
+
+func TestTiered_SpillsLeastRecentlyUsed(t *testing.T) {
+	clock := NewManualClock(time.Unix(1_700_000_000, 0))
+	ts, inner := openTiered(t, clock, 20)
+
+	value := strings.Repeat("x", 10)
+	_ = ts.Write("a", Entry{Value: []byte(value)}, PutOverwrite)
+	_ = ts.Write("b", Entry{Value: []byte(value)}, PutOverwrite)
+	mustHold(t, ts, "a", value)
+	_ = ts.Write("c", Entry{Value: []byte(value)}, PutOverwrite)
+
+	if !isSpilled(t, ts, inner, "b") {
+		t.Fatalf("expected the least recently used value to be spilled")
+	}
+	if isSpilled(t, ts, inner, "a") || isSpilled(t, ts, inner, "c") {
+		t.Fatalf("expected recently used values to stay hot")
+	}
+
+	// Reading b loads it back and spills the coldest remaining value
+	mustHold(t, ts, "b", value)
+	if isSpilled(t, ts, inner, "b") {
+		t.Fatalf("expected a read to load the spilled value back")
+	}
+	if !isSpilled(t, ts, inner, "a") {
+		t.Fatalf("expected a to be spilled to make room for b")
+	}
+}
+
+func TestTiered_ExpireWithoutLoading(t *testing.T) {
+	clock := NewManualClock(time.Unix(1_700_000_000, 0))
+	ts, inner := openTiered(t, clock, 0)
+
+	_ = ts.Write("k", Entry{Value: []byte("cold")}, PutOverwrite)
+	if !isSpilled(t, ts, inner, "k") {
+		t.Fatalf("expected the value to be spilled")
+	}
+
+	if !ts.Expire("k", unixNow(clock)+1000) {
+		t.Fatalf("expected Expire to find the spilled key")
+	}
+	if !isSpilled(t, ts, inner, "k") {
+		t.Fatalf("expected Expire to leave the value on disk")
+	}
+
+	clock.Advance(2 * time.Second)
+	if _, ok := ts.Read("k"); ok {
+		t.Fatalf("expected the spilled key to expire")
+	}
+}
+
+func TestTiered_SpillKeepsVersion(t *testing.T) {
+	clock := NewManualClock(time.Unix(1_700_000_000, 0))
+	ts, inner := openTiered(t, clock, 8)
+
+	_ = ts.Write("k", Entry{Value: []byte("12345678")}, PutOverwrite)
+	before, _ := ts.Read("k")
+
+	_ = ts.Write("other", Entry{Value: []byte("12345678")}, PutOverwrite)
+	if !isSpilled(t, ts, inner, "k") {
+		t.Fatalf("expected k to be spilled")
+	}
+
+	after, _ := ts.Read("k")
+	if after.Version != before.Version {
+		t.Fatalf("expected spilling to keep version %d, got %d", before.Version, after.Version)
+	}
+
+	_ = ts.Write("k", Entry{Value: []byte("new")}, PutOverwrite)
+	written, _ := ts.Read("k")
+	if written.Version <= before.Version {
+		t.Fatalf("expected a write to move the version past %d, got %d", before.Version, written.Version)
+	}
+}
+
+func TestTiered_IterateAndView(t *testing.T) {
+	clock := NewManualClock(time.Unix(1_700_000_000, 0))
+	ts, _ := openTiered(t, clock, 0)
+
+	for i := 0; i < 10; i++ {
+		_ = ts.Write("key:"+strconv.Itoa(i), Entry{Value: []byte(strconv.Itoa(i))}, PutOverwrite)
+	}
+
+	view := ts.Snapshot()
+	defer view.Close()
+
+	// Overwriting kills the stubs the view still sees
+	for i := 0; i < 10; i++ {
+		_ = ts.Write("key:"+strconv.Itoa(i), Entry{Value: []byte("new")}, PutOverwrite)
+	}
+
+	seen := 0
+	ts.Iterate(func(key string, value Entry) bool {
+		if string(value.Value) != "new" {
+			t.Fatalf("expected %s=new, got %q", key, value.Value)
+		}
+		seen++
+		return true
+	})
+	if seen != 10 {
+		t.Fatalf("expected 10 keys, got %d", seen)
+	}
+
+	for i := 0; i < 10; i++ {
+		key := "key:" + strconv.Itoa(i)
+		val, ok := view.Read(key)
+		if !ok || string(val.Value) != strconv.Itoa(i) {
+			t.Fatalf("expected the view to read %s=%d, got %q", key, i, val.Value)
+		}
+	}
+}
+
+func TestTiered_CompactsLog(t *testing.T) {
+	clock := NewManualClock(time.Unix(1_700_000_000, 0))
+	ts, _ := openTiered(t, clock, 0)
+
+	value := []byte(strings.Repeat("v", 4<<10))
+	for i := 0; i < 1000; i++ {
+		_ = ts.Write("key:"+strconv.Itoa(i%10), Entry{Value: value}, PutOverwrite)
+	}
+
+	info, err := os.Stat(ts.log.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() > 2*minLogCompaction {
+		t.Fatalf("expected the log to be compacted, size is %d", info.Size())
+	}
+	for i := 0; i < 10; i++ {
+		mustHold(t, ts, "key:"+strconv.Itoa(i), string(value))
+	}
+}
+
+func TestTiered_CloseRemovesLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "values.log")
+	ds, err := NewTieredStore(NewLockedStore(), path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = ds.Write("k", Entry{Value: []byte("v")}, PutOverwrite)
+
+	if err := ds.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected the log to be removed, stat returned %v", err)
+	}
+}
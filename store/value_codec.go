@@ -0,0 +1,99 @@
+package store
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+/*
+Typed values are stored as a flat sequence of fields: unsigned
+varints, strings prefixed by their length as a varint, and floats as
+their 8 IEEE 754 bytes. Each type lays out its own fields; none of
+them holds pointers or needs a schema, and decoding fails cleanly on
+truncated data.
+*/
+
+func appendUvarint(buf []byte, n uint64) []byte {
+	return binary.AppendUvarint(buf, n)
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+func appendFloat(buf []byte, f float64) []byte {
+	return binary.LittleEndian.AppendUint64(buf, math.Float64bits(f))
+}
+
+/*
+valueReader decodes the fields of a typed value in order. The first
+failure sticks: later reads return zero values and err reports it.
+*/
+type valueReader struct {
+	data []byte
+	err  error
+}
+
+func (r *valueReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	n, size := binary.Uvarint(r.data)
+	if size <= 0 {
+		r.err = errCorruptValue
+		return 0
+	}
+	r.data = r.data[size:]
+	return n
+}
+
+/*
+count reads the number of items that follow, each taking at least one
+byte, so corrupt data cannot trigger a huge allocation.
+*/
+func (r *valueReader) count() int {
+	n := r.uvarint()
+	if n > uint64(len(r.data)) {
+		r.err = errCorruptValue
+		return 0
+	}
+	return int(n)
+}
+
+func (r *valueReader) string() string {
+	n := r.uvarint()
+	if r.err != nil {
+		return ""
+	}
+	if n > uint64(len(r.data)) {
+		r.err = errCorruptValue
+		return ""
+	}
+	s := string(r.data[:n])
+	r.data = r.data[n:]
+	return s
+}
+
+func (r *valueReader) float() float64 {
+	if r.err != nil {
+		return 0
+	}
+	if len(r.data) < 8 {
+		r.err = errCorruptValue
+		return 0
+	}
+	f := math.Float64frombits(binary.LittleEndian.Uint64(r.data))
+	r.data = r.data[8:]
+	return f
+}
+
+/*
+finish reports the first failure, or a failure if data is left over.
+*/
+func (r *valueReader) finish() error {
+	if r.err == nil && len(r.data) > 0 {
+		r.err = errCorruptValue
+	}
+	return r.err
+}
@@ -0,0 +1,48 @@
+package store
+
+import "errors"
+
+/*
+ValueType tags the kind of value an entry holds. Strings are opaque
+bytes; every other type keeps its own encoding of the whole value in
+Value, which only code of that type reads. The in-memory stores keep
+such values decoded between ops instead (see liveTx).
+
+Its values are persisted by the WAL, snapshots and Bitcask data files,
+so existing ones must never be renumbered.
+*/
+type ValueType uint8
+
+const (
+	TypeString ValueType = iota
+	TypeList
+	TypeHash
+	TypeSet
+	TypeZSet
+	TypeStream
+	TypeJSON
+)
+
+// ErrWrongType is returned when an operation is applied to a key
+// holding a value of another type.
+var ErrWrongType = errors.New("operation against a key holding the wrong kind of value")
+
+var valueTypeNames = [...]string{
+	TypeString: "string",
+	TypeList:   "list",
+	TypeHash:   "hash",
+	TypeSet:    "set",
+	TypeZSet:   "zset",
+	TypeStream: "stream",
+	TypeJSON:   "json",
+}
+
+/*
+String returns the name of the type, as reported by TYPE.
+*/
+func (t ValueType) String() string {
+	if int(t) < len(valueTypeNames) {
+		return valueTypeNames[t]
+	}
+	return "unknown"
+}
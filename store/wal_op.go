@@ -0,0 +1,158 @@
+package store
+
+import "hermes/wal"
+
+/*
+Apply runs op against key durably, logging a mutation as the op itself
+instead of the value it produces.
+
+It follows the same ordering as Write: the op runs against the key's
+current value while the underlying store holds the key, and only once
+its record is on disk does the new value become visible. An op that
+fails, that only reads, or that leaves a missing key empty logs
+nothing.
+
+Unlike Write, the append happens while the underlying store holds
+the key: the op's change applies to the value it ran against, which
+must not change before the commit. The key's lock (see keyLocks) is
+taken first, ordering the op with plain writes of the key. The cost is
+that a slow append or fsync stalls every key sharing the held lock (a
+shard, or the whole store), as it does for walStore.Atomic.
+*/
+func (s *walStore) Apply(key string, op Op) (any, error) {
+	if isQuery(op) {
+		return Apply(s.store, key, op)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	defer s.keys.lock(key)()
+
+	var reply any
+	err := s.store.Atomic([]string{key}, func(inner Tx) error {
+		res, err := runOp(inner, key, op)
+		reply = res.reply
+		if err != nil || !res.changes() {
+			return err
+		}
+
+		if err := s.wal.Append(opRecord(key, op, res)); err != nil {
+			return err
+		}
+		s.markDirty(key)
+		return res.commit(inner, key)
+	})
+	return reply, err
+}
+
+/*
+Apply runs op inside the transaction and adds it to the transaction's
+WAL group.
+
+The first op on a key the transaction has not touched yet runs
+against the wrapped store's value, kept decoded by in-memory stores,
+and its change waits for the commit: a push onto a long list costs no
+more in a transaction than outside one. Anything else the transaction
+does to that key afterwards first settles the op into the overlay,
+which decodes a copy of the value.
+*/
+func (tx *walTx) Apply(key string, op Op) (any, error) {
+	if _, ok := tx.declared[key]; !ok {
+		return nil, ErrKeyNotDeclared
+	}
+
+	_, touched := tx.overlay[key]
+	if _, staged := tx.staged[key]; !touched && !staged {
+		res, err := runOp(tx.inner, key, op)
+		if err != nil || !res.changes() {
+			return res.reply, err
+		}
+		tx.staged[key] = stagedOp{op: op, res: res}
+		tx.order = append(tx.order, key)
+		tx.records = append(tx.records, opRecord(key, op, res))
+		return res.reply, nil
+	}
+
+	cur, found := tx.Read(key)
+	res, err := runOpOn(cur, found, op)
+	if err != nil || !res.changes() {
+		return res.reply, err
+	}
+	tx.stage(key, tx.result(key, cur, res), opRecord(key, op, res))
+	return res.reply, nil
+}
+
+/*
+stagedOp is an op of a transaction whose change is applied to the
+wrapped store's value at commit.
+*/
+type stagedOp struct {
+	op  Op
+	res opResult
+}
+
+/*
+settle replaces the staged op on key, if any, by its result in the
+overlay. The op is run again on a copy of the value it ran against,
+with the same outcome, since the transaction holds the key.
+*/
+func (tx *walTx) settle(key string) {
+	st, ok := tx.staged[key]
+	if !ok {
+		return
+	}
+	delete(tx.staged, key)
+
+	cur, found := tx.inner.Read(key)
+	res, err := runOpOn(cur, found, st.op)
+	if err != nil || !res.changes() {
+		return // unreachable: the op already succeeded on this value
+	}
+	tx.overlay[key] = tx.result(key, cur, res)
+}
+
+/*
+result applies the change of res to its value and returns the entry to
+stage for key, whose current entry is cur.
+*/
+func (tx *walTx) result(key string, cur Entry, res opResult) Entry {
+	res.change()
+	if res.value.empty() {
+		// Staged as a TTL only, so the commit expires the key
+		cur.ExpiresAtMillis = tombstoneExpiry
+		return cur
+	}
+	tx.rewritten[key] = struct{}{}
+	return res.entry(res.value.encode())
+}
+
+/*
+opRecord is the WAL record of a mutation: the op, and the TTL of the
+key it ran on. Replay removes the key itself if the op empties it.
+*/
+func opRecord(key string, op Op, res opResult) wal.WALRecord {
+	return wal.WALRecord{
+		Type:   wal.RecordOp,
+		Key:    key,
+		Op:     op.Name,
+		Args:   op.Args,
+		Time:   op.Time,
+		Expire: res.expiresAt,
+	}
+}
+
+/*
+replayOp re-runs a logged op against store. The value takes the TTL
+logged with the op rather than the one it replays against, which a
+snapshot taken after the op may already have changed.
+*/
+func replayOp(store DataStore, r wal.WALRecord) error {
+	return store.Atomic([]string{r.Key}, func(tx Tx) error {
+		res, err := runOp(tx, r.Key, Op{Name: r.Op, Args: r.Args, Time: r.Time})
+		if err != nil {
+			return err
+		}
+		res.expiresAt = r.Expire
+		return res.commit(tx, r.Key)
+	})
+}
@@ -1,6 +1,7 @@
 package store
 
 import (
+	"context"
 	"hermes/snapshot"
 	"hermes/wal"
 	"os"
@@ -28,6 +29,9 @@ type walStore struct {
 	// It records intent (SET / EXPIRE), not internal mutations.
 	wal   wal.WAL
 
+	// clock stamps the write times logged with each record.
+	clock Clock
+
 	// snapshotPath is the on-disk snapshot location.
 	// Snapshot + WAL together form the full recovery state.
 	snapshotPath string
@@ -41,13 +45,26 @@ type walStore struct {
 
 		Lock:
 		- Used by Compact()
-		- Stops the world to take a consistent snapshot
+		- Briefly stops writes to pin a view, and again to
+		  finish the snapshot and rotate the WAL
 
 		This mirrors real-world designs (Redis, RocksDB early phases)
 		where compaction is rare but correctness-critical.
 	*/
 	mu           sync.RWMutex
 
+	// keys orders the writers of each key, logging included.
+	keys keyLocks
+
+	// compactMu serializes Compact calls.
+	compactMu sync.Mutex
+
+	// dirty collects the keys written while Compact streams its view,
+	// guarded by dirtyMu since writers only hold mu.RLock. It is nil
+	// when no compaction is running.
+	dirtyMu sync.Mutex
+	dirty   map[string]struct{}
+
 	// doneChan signals background goroutines (snapshot supervisor)
 	// to shut down gracefully.
 	doneChan     chan struct{}
@@ -70,7 +87,8 @@ Startup sequence (very intentional order):
 
 2. Replay WAL
    - WAL is the source of truth
-   - Re-applies mutations AFTER snapshot
+   - Re-applies mutations AFTER snapshot, skipping those it already
+     holds if the WAL was not rotated after it (see Compact)
 
 3. Start snapshot supervisor (optional)
    - Periodic background compaction
@@ -78,15 +96,21 @@ Startup sequence (very intentional order):
 
 Note: Replay is synchronous and blocking. The system is not available for reads
 until the entire log is processed.
+
+The only option read is WithClock, which should be the clock store was
+built with.
 */
 func NewWalStore(
 	store DataStore,
 	w wal.WAL,
 	snapshotPath string,
 	snapshotInterval time.Duration,
+	opts ...Option,
 ) (DataStore, error) {
+	o := newOptions(opts)
 
 	// Phase 1: Load snapshot if it exists
+	var checkpoint uint64
 	if f, err := os.Open(snapshotPath); err == nil {
 		defer f.Close()
 
@@ -95,13 +119,23 @@ func NewWalStore(
 			back into store.Entry.
 
 			PutOverwrite is forced because snapshots
-			represent authoritative state.
+			represent authoritative state. An item carrying a
+			history replaces the key's history as a whole.
 		*/
 		loader := func(item snapshot.Item) {
-			store.Write(item.Key, Entry{
-				Value:           item.Value,
-				ExpiresAtMillis: item.ExpiresAt,
-			}, PutOverwrite)
+			if item.Checkpoint != 0 {
+				checkpoint = item.Checkpoint
+				return
+			}
+			if hs, ok := store.(historyStore); ok && len(item.History) > 0 {
+				h := make(History, len(item.History))
+				for i, rev := range item.History {
+					h[i] = itemEntry(rev)
+				}
+				hs.restoreHistory(item.Key, h)
+				return
+			}
+			store.Write(item.Key, itemEntry(item), PutOverwrite)
 		}
 
 		if err = snapshot.Load(f, loader); err != nil {
@@ -109,8 +143,23 @@ func NewWalStore(
 		}
 	}
 
-	// Phase 2: Replay WAL
+	// Phase 2: Replay WAL, from the snapshot's checkpoint if the WAL
+	// was not rotated after it (see Compact)
+	skip := false
+	if checkpoint != 0 {
+		held, err := holdsCheckpoint(w, checkpoint)
+		if err != nil {
+			return nil, err
+		}
+		skip = held
+	}
+
 	err := w.Replay(func(r wal.WALRecord) error {
+		if skip {
+			skip = r.Type != wal.RecordCheckpoint || r.Checkpoint != checkpoint
+			return nil
+		}
+
 		switch r.Type {
 		case wal.RecordSet:
 			// Replay Logic:
@@ -119,7 +168,7 @@ func NewWalStore(
 			// in order naturally results in the correct final state "A=2".
 			return store.Write(
 				r.Key,
-				Entry{Value: []byte(r.Value), ExpiresAtMillis: 0},
+				Entry{Value: []byte(r.Value), WrittenAtMillis: r.Time, Encoding: Encoding(r.Encoding), Type: ValueType(r.ValueType)},
 				PutOverwrite,
 			)
 
@@ -129,6 +178,21 @@ func NewWalStore(
 				return wal.ErrInvalidRecord
 			}
 			_ = store.Expire(r.Key, r.Expire)
+
+		case wal.RecordBatch:
+			// A batch is a single record, so it is either
+			// fully present in the log or not at all.
+			entries := make([]KeyValue, len(r.Batch))
+			for i, set := range r.Batch {
+				entries[i] = KeyValue{
+					Key:   set.Key,
+					Entry: Entry{Value: []byte(set.Value), WrittenAtMillis: r.Time, Encoding: Encoding(set.Encoding), Type: ValueType(set.ValueType)},
+				}
+			}
+			return store.WriteBatch(entries, PutOverwrite)
+
+		case wal.RecordOp:
+			return replayOp(store, r)
 		}
 
 		return nil
@@ -140,6 +204,7 @@ func NewWalStore(
 	ws := &walStore{
 		store:        store,
 		wal:          w,
+		clock:        o.clock,
 		snapshotPath: snapshotPath,
 		doneChan:     make(chan struct{}),
 	}
@@ -179,40 +244,253 @@ Why validation BEFORE WAL append:
 Locking:
 - RLock allows concurrent writers
 - Blocks if compaction is running
+- The key's lock (see keyLocks) orders writers of the same key, so
+  the log holds its records in the order memory applied them; the
+  wrapped store is not held during the append
 */
 func (s *walStore) Write(key string, value Entry, mode PutMode) error {
+	return s.WriteContext(context.Background(), key, value, mode)
+}
+
+/*
+WriteContext is Write that gives up when ctx ends, while it waits for
+the wrapped store to validate the write. Once the write is logged it
+is applied whatever ctx does, or memory would miss a logged write.
+*/
+func (s *walStore) WriteContext(ctx context.Context, key string, value Entry, mode PutMode) error {
 	s.mu.RLock() // Allows concurrent writes, but blocks if Compact holds Lock
 	defer s.mu.RUnlock()
+	defer s.keys.lock(key)()
 
 	// 1. Validation Logic (Fail Fast)
 	// We check memory state BEFORE touching disk to prevent "Phantom Writes"
 	// (failed writes that end up in the log anyway).
 	switch mode {
 	case PutIfAbsent:
-		if _, exists := s.store.Read(key); exists {
+		if _, exists, err := s.ReadContext(ctx, key); err != nil {
+			return err
+		} else if exists {
 			return ErrKeyExists
 		}
 
 	case PutUpdate:
-		if _, exists := s.store.Read(key); !exists {
+		if _, exists, err := s.ReadContext(ctx, key); err != nil {
+			return err
+		} else if !exists {
 			return ErrKeyNotFound
 		}
 	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
 	value.ExpiresAtMillis = 0
+	value.WrittenAtMillis = s.writeTime(value)
 	err := s.wal.Append(wal.WALRecord{
-		Type:  wal.RecordSet,
-		Key:   key,
-		Value: string(value.Value),
+		Type:      wal.RecordSet,
+		Key:       key,
+		Value:     string(value.Value),
+		Time:      value.WrittenAtMillis,
+		Encoding:  uint8(value.Encoding),
+		ValueType: uint8(value.Type),
 	})
 	if err != nil {
 		return err
 	}
+	s.markDirty(key)
 
 	// Only after disk success do we make the data visible to readers
 	return s.store.Write(key, value, mode)
 }
 
+/*
+ReadContext bypasses the WAL, like Read. It gives up when ctx ends if
+the wrapped store is a ContextStore, and is Read otherwise.
+*/
+func (s *walStore) ReadContext(ctx context.Context, key string) (Entry, bool, error) {
+	if cs, ok := s.store.(ContextStore); ok {
+		return cs.ReadContext(ctx, key)
+	}
+	val, ok := s.store.Read(key)
+	return val, ok, nil
+}
+
+/*
+ReadBatch bypasses the WAL, like Read.
+*/
+func (s *walStore) ReadBatch(keys []string) map[string]Entry {
+	return s.store.ReadBatch(keys)
+}
+
+/*
+ScanEntries bypasses the WAL, like Read. Over a store that cannot
+scan, which AsScanner reports, it returns an empty, finished scan.
+*/
+func (s *walStore) ScanEntries(cursor uint64, count int) ([]KeyValue, uint64) {
+	sc, ok := s.store.(Scanner)
+	if !ok {
+		return nil, 0
+	}
+	return sc.ScanEntries(cursor, count)
+}
+
+/*
+Snapshot returns a view of the wrapped store. Views are read-only, so
+they bypass the WAL like Read.
+*/
+func (s *walStore) Snapshot() View {
+	return s.store.Snapshot()
+}
+
+/*
+History bypasses the WAL, like Read. Stores without history return
+nothing.
+*/
+func (s *walStore) History(key string) History {
+	h, ok := s.store.(Historian)
+	if !ok {
+		return nil
+	}
+	return h.History(key)
+}
+
+/*
+writeTime returns the write time logged for value: its own, or now if
+the caller left it unset, so the log and memory agree.
+*/
+func (s *walStore) writeTime(value Entry) int64 {
+	if value.WrittenAtMillis != 0 {
+		return value.WrittenAtMillis
+	}
+	return unixNow(s.clock)
+}
+
+/*
+itemEntry converts a snapshot item back into an entry.
+*/
+func itemEntry(item snapshot.Item) Entry {
+	return Entry{
+		Value:           item.Value,
+		ExpiresAtMillis: item.ExpiresAt,
+		WrittenAtMillis: item.WrittenAt,
+		Encoding:        Encoding(item.Encoding),
+		Type:            ValueType(item.Type),
+	}
+}
+
+/*
+markDirty records that key changed while a compaction is streaming.
+Callers hold mu.RLock.
+*/
+func (s *walStore) markDirty(key string) {
+	s.dirtyMu.Lock()
+	defer s.dirtyMu.Unlock()
+
+	if s.dirty != nil {
+		s.dirty[key] = struct{}{}
+	}
+}
+
+/*
+Range, ReverseRange and Prefix bypass the WAL, like Read. They visit
+nothing when the wrapped store is not Ordered, which AsOrdered
+reports.
+*/
+func (s *walStore) Range(start, end string, fn func(key string, value Entry) bool) {
+	if o, ok := s.store.(Ordered); ok {
+		o.Range(start, end, fn)
+	}
+}
+
+func (s *walStore) ReverseRange(start, end string, fn func(key string, value Entry) bool) {
+	if o, ok := s.store.(Ordered); ok {
+		o.ReverseRange(start, end, fn)
+	}
+}
+
+func (s *walStore) Prefix(prefix string, fn func(key string, value Entry) bool) {
+	if o, ok := s.store.(Ordered); ok {
+		o.Prefix(prefix, fn)
+	}
+}
+
+/*
+WriteBatch performs a durable batch write.
+
+The batch follows the same ordering as Write, holding the lock of
+every key:
+1. Validate every key against in-memory state (fail fast)
+2. Append the whole batch as ONE WAL record
+3. Mutate memory atomically via the underlying store
+
+Logging the batch as a single record is what makes it atomic on
+disk: replay either sees the complete batch or none of it.
+*/
+func (s *walStore) WriteBatch(entries []KeyValue, mode PutMode) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if len(entries) == 0 {
+		return nil
+	}
+
+	keys := make([]string, len(entries))
+	for i, kv := range entries {
+		keys[i] = kv.Key
+	}
+	defer s.keys.lock(keys...)()
+
+	// 1. Validation Logic (Fail Fast), mirroring Write
+	existing := s.store.ReadBatch(keys)
+	for _, key := range keys {
+		_, exists := existing[key]
+
+		switch mode {
+		case PutIfAbsent:
+			if exists {
+				return ErrKeyExists
+			}
+		case PutUpdate:
+			if !exists {
+				return ErrKeyNotFound
+			}
+		}
+	}
+
+	// Like Write, a batch SET clears any TTL. entries is copied so the
+	// caller's slice is left untouched. The batch is logged with one
+	// write time, shared by every entry.
+	written := unixNow(s.clock)
+	applied := make([]KeyValue, len(entries))
+	batch := make([]wal.WALRecord, len(entries))
+	for i, kv := range entries {
+		kv.Entry.ExpiresAtMillis = 0
+		kv.Entry.WrittenAtMillis = written
+		applied[i] = kv
+		batch[i] = wal.WALRecord{
+			Type:      wal.RecordSet,
+			Key:       kv.Key,
+			Value:     string(kv.Entry.Value),
+			Encoding:  uint8(kv.Entry.Encoding),
+			ValueType: uint8(kv.Entry.Type),
+		}
+	}
+
+	err := s.wal.Append(wal.WALRecord{
+		Type:  wal.RecordBatch,
+		Time:  written,
+		Batch: batch,
+	})
+	if err != nil {
+		return err
+	}
+	for _, kv := range entries {
+		s.markDirty(kv.Key)
+	}
+
+	return s.store.WriteBatch(applied, mode)
+}
+
 /*
 Expire sets an absolute expiration timestamp.
 
@@ -222,18 +500,32 @@ Design choices:
 - Expire of non-existent keys is ignored
 
 Consistency:
-- WAL append happens BEFORE memory mutation
+- WAL append happens BEFORE memory mutation, under the key's lock
+  like Write
 */
 func (s *walStore) Expire(key string, unixTimestampMilli int64) bool {
+	ok, _ := s.ExpireContext(context.Background(), key, unixTimestampMilli)
+	return ok
+}
+
+/*
+ExpireContext is Expire that gives up when ctx ends, like
+WriteContext: only until the expiry is logged.
+*/
+func (s *walStore) ExpireContext(ctx context.Context, key string, unixTimestampMilli int64) (bool, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
+	defer s.keys.lock(key)()
 
-	if _, exists := s.store.Read(key); !exists {
-		return false
+	if _, exists, err := s.ReadContext(ctx, key); !exists || err != nil {
+		return false, err
 	}
 
 	if unixTimestampMilli < 0 {
-		return false
+		return false, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return false, err
 	}
 
 	err := s.wal.Append(wal.WALRecord{
@@ -243,10 +535,11 @@ func (s *walStore) Expire(key string, unixTimestampMilli int64) bool {
 	})
 	if err != nil {
 		// If persistence fails, we fail the operation to maintain consistency properties.
-		return false
+		return false, nil
 	}
+	s.markDirty(key)
 
-	return s.store.Expire(key, unixTimestampMilli)
+	return s.store.Expire(key, unixTimestampMilli), nil
 }
 
 /*
@@ -258,6 +551,7 @@ Shutdown order (important):
 2. Wait for background goroutines
 3. Perform final snapshot (best-effort)
 4. Close WAL (flush + fsync)
+5. Close the wrapped store (stops its expiry sweeper)
 
 Why Close() exists on DataStore:
 - Allows composite stores (walStore) to own resources
@@ -274,5 +568,9 @@ func (s *walStore) Close() error {
 		return err
 	}
 
-	return s.wal.Close()
+	err := s.wal.Close()
+	if serr := s.store.Close(); err == nil {
+		err = serr
+	}
+	return err
 }
@@ -2,8 +2,12 @@ package store
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"hermes/wal"
 	"os"
+	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 )
@@ -32,6 +36,30 @@ var storeCases = []storeCase{
 			return NewEventloopStore(100)
 		},
 	},
+	{
+		name: "MultiLoop",
+		new: func() DataStore {
+			return NewMultiLoopStore(4, 16)
+		},
+	},
+	{
+		name: "Ordered",
+		new: func() DataStore {
+			return NewOrderedStore()
+		},
+	},
+}
+
+// optionCases builds every store with the given options.
+var optionCases = []struct {
+	name string
+	new  func(opts ...Option) DataStore
+}{
+	{name: "Locked", new: func(opts ...Option) DataStore { return NewLockedStore(opts...) }},
+	{name: "Sharded", new: func(opts ...Option) DataStore { return NewShardedStore(16, opts...) }},
+	{name: "EventLoop", new: func(opts ...Option) DataStore { return NewEventloopStore(100, opts...) }},
+	{name: "MultiLoop", new: func(opts ...Option) DataStore { return NewMultiLoopStore(4, 16, opts...) }},
+	{name: "Ordered", new: func(opts ...Option) DataStore { return NewOrderedStore(opts...) }},
 }
 
 // Returns: store, walPath, snapPath, closeFn, cleanup
@@ -247,7 +275,7 @@ func testOrdering(t *testing.T, factory StoreFactory) {
 }
 
 func TestWalStore_Expire(t *testing.T) {
-	factory := setupFactory(t, NewLockedStore)
+	factory := setupFactory(t, func() DataStore { return NewLockedStore() })
 	store, walPath, snapPath, closeFn, cleanup := factory()
 	defer closeFn()
 	defer cleanup()
@@ -286,7 +314,7 @@ func TestWalStore_Expire(t *testing.T) {
 }
 
 func TestWalStore_SnapshotRecovery(t *testing.T) {
-	factory := setupFactory(t, NewLockedStore)
+	factory := setupFactory(t, func() DataStore { return NewLockedStore() })
 
 	store, walPath, snapPath, closeFn, cleanup := factory()
 	defer cleanup()
@@ -316,7 +344,7 @@ func TestWalStore_SnapshotRecovery(t *testing.T) {
 }
 
 func TestWalStore_SnapshotPhantomProtection(t *testing.T) {
-	factory := setupFactory(t, NewLockedStore)
+	factory := setupFactory(t, func() DataStore { return NewLockedStore() })
 	store, _, _, closeFn, cleanup := factory()
 	defer cleanup()
 
@@ -335,7 +363,7 @@ func TestWalStore_SnapshotPhantomProtection(t *testing.T) {
 }
 
 func TestWalStore_SnapshotExpire(t *testing.T) {
-	factory := setupFactory(t, NewLockedStore)
+	factory := setupFactory(t, func() DataStore { return NewLockedStore() })
 	store, walPath, snapPath, closeFn, cleanup := factory()
 	defer cleanup()
 
@@ -394,7 +422,7 @@ func TestSnapshotSupervisor_RunsAndStops(t *testing.T) {
 }
 
 func TestWalStore_PutUpdateSemantics(t *testing.T) {
-	factory := setupFactory(t, NewLockedStore)
+	factory := setupFactory(t, func() DataStore { return NewLockedStore() })
 	store, walPath, _, closeFn, cleanup := factory()
 	defer closeFn()
 	defer cleanup()
@@ -430,7 +458,7 @@ func TestWalStore_PutUpdateSemantics(t *testing.T) {
 }
 
 func TestWalStore_ExpireOnMissingKey(t *testing.T) {
-	factory := setupFactory(t, NewLockedStore)
+	factory := setupFactory(t, func() DataStore { return NewLockedStore() })
 	store, _, _, closeFn, cleanup := factory()
 	defer closeFn()
 	defer cleanup()
@@ -441,7 +469,7 @@ func TestWalStore_ExpireOnMissingKey(t *testing.T) {
 }
 
 func TestWalStore_ExpireNegativeTimestamp(t *testing.T) {
-	factory := setupFactory(t, NewLockedStore)
+	factory := setupFactory(t, func() DataStore { return NewLockedStore() })
 	store, _, _, closeFn, cleanup := factory()
 	defer closeFn()
 	defer cleanup()
@@ -494,3 +522,482 @@ func TestWalStore_CorruptSnapshotFailsRecovery(t *testing.T) {
 		t.Fatalf("expected snapshot load failure")
 	}
 }
+
+func TestWalStore_BatchRecovery(t *testing.T) {
+	for _, sc := range storeCases {
+		t.Run(sc.name, func(t *testing.T) {
+			factory := setupFactory(t, sc.new)
+			store, walPath, snapPath, closeFn, cleanup := factory()
+			defer closeFn()
+			defer cleanup()
+
+			err := store.WriteBatch([]KeyValue{
+				{Key: "a", Entry: Entry{Value: []byte("1")}},
+				{Key: "b", Entry: Entry{Value: []byte("2")}},
+			}, PutOverwrite)
+			if err != nil {
+				t.Fatalf("batch write failed: %v", err)
+			}
+
+			w2, err := wal.NewWAL(wal.Config{Path: walPath, SyncPolicy: wal.SyncEveryWrite})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer w2.Close()
+
+			recovered, err := NewWalStore(sc.new(), w2, snapPath, 0)
+			if err != nil {
+				t.Fatalf("recovery failed: %v", err)
+			}
+
+			got := recovered.ReadBatch([]string{"a", "b"})
+			if string(got["a"].Value) != "1" || string(got["b"].Value) != "2" {
+				t.Fatalf("batch lost during recovery: %v", got)
+			}
+		})
+	}
+}
+
+func TestWalStore_BatchPhantomProtection(t *testing.T) {
+	factory := setupFactory(t, func() DataStore { return NewLockedStore() })
+	store, walPath, _, closeFn, cleanup := factory()
+	defer closeFn()
+	defer cleanup()
+
+	_ = store.Write("b", Entry{Value: []byte("old")}, PutOverwrite)
+
+	err := store.WriteBatch([]KeyValue{
+		{Key: "a", Entry: Entry{Value: []byte("1")}},
+		{Key: "b", Entry: Entry{Value: []byte("2")}},
+	}, PutIfAbsent)
+	if err != ErrKeyExists {
+		t.Fatalf("expected ErrKeyExists, got %v", err)
+	}
+
+	raw, err := wal.NewWAL(wal.Config{Path: walPath, SyncPolicy: wal.SyncEveryWrite})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+
+	raw.Replay(func(r wal.WALRecord) error {
+		if r.Type == wal.RecordBatch {
+			t.Fatalf("rejected batch was logged")
+		}
+		return nil
+	})
+}
+
+func TestWalStore_TornBatchIsNotReplayed(t *testing.T) {
+	walFile, _ := os.CreateTemp("", "wal_*.log")
+	snapFile, _ := os.CreateTemp("", "snap_*.bin")
+	defer os.Remove(walFile.Name())
+	defer os.Remove(snapFile.Name())
+
+	line, err := wal.EncodeRecord(wal.WALRecord{
+		Type: wal.RecordBatch,
+		Batch: []wal.WALRecord{
+			{Type: wal.RecordSet, Key: "a", Value: "1"},
+			{Type: wal.RecordSet, Key: "b", Value: "2"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate a crash halfway through appending the batch
+	walFile.WriteString(line[:len(line)/2+3])
+	walFile.Close()
+
+	w, _ := wal.NewWAL(wal.Config{
+		Path:       walFile.Name(),
+		SyncPolicy: wal.SyncEveryWrite,
+	})
+	defer w.Close()
+
+	recovered, err := NewWalStore(NewLockedStore(), w, snapFile.Name(), 0)
+	if err != nil {
+		t.Fatalf("recovery failed: %v", err)
+	}
+
+	if got := recovered.ReadBatch([]string{"a", "b"}); len(got) != 0 {
+		t.Fatalf("torn batch partially applied: %v", got)
+	}
+}
+
+func TestWalStore_AtomicRecovery(t *testing.T) {
+	for _, sc := range storeCases {
+		t.Run(sc.name, func(t *testing.T) {
+			factory := setupFactory(t, sc.new)
+			store, walPath, snapPath, closeFn, cleanup := factory()
+			defer closeFn()
+			defer cleanup()
+
+			exp := time.Now().Add(time.Hour).UnixMilli()
+			err := store.Atomic([]string{"a", "b"}, func(tx Tx) error {
+				if err := tx.Write("a", Entry{Value: []byte("1")}, PutOverwrite); err != nil {
+					return err
+				}
+
+				// Reads inside the transaction see its own writes
+				if val, ok := tx.Read("a"); !ok || string(val.Value) != "1" {
+					t.Errorf("transaction did not observe its own write")
+				}
+				if !tx.Expire("a", exp) {
+					t.Errorf("expire inside transaction failed")
+				}
+				return tx.Write("b", Entry{Value: []byte("2")}, PutOverwrite)
+			})
+			if err != nil {
+				t.Fatalf("atomic failed: %v", err)
+			}
+
+			w2, err := wal.NewWAL(wal.Config{Path: walPath, SyncPolicy: wal.SyncEveryWrite})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer w2.Close()
+
+			recovered, err := NewWalStore(sc.new(), w2, snapPath, 0)
+			if err != nil {
+				t.Fatalf("recovery failed: %v", err)
+			}
+
+			got := recovered.ReadBatch([]string{"a", "b"})
+			if string(got["a"].Value) != "1" || got["a"].ExpiresAtMillis != exp || string(got["b"].Value) != "2" {
+				t.Fatalf("transaction lost during recovery: %v", got)
+			}
+		})
+	}
+}
+
+func TestWalStore_AtomicRejectedWritesAreNotLogged(t *testing.T) {
+	factory := setupFactory(t, func() DataStore { return NewLockedStore() })
+	store, walPath, _, closeFn, cleanup := factory()
+	defer closeFn()
+	defer cleanup()
+
+	_ = store.Write("a", Entry{Value: []byte("1")}, PutOverwrite)
+
+	err := store.Atomic([]string{"a"}, func(tx Tx) error {
+		return tx.Write("a", Entry{Value: []byte("2")}, PutIfAbsent)
+	})
+	if err != ErrKeyExists {
+		t.Fatalf("expected ErrKeyExists, got %v", err)
+	}
+
+	err = store.Atomic([]string{"a"}, func(tx Tx) error {
+		return tx.Write("undeclared", Entry{Value: []byte("2")}, PutOverwrite)
+	})
+	if err != ErrKeyNotDeclared {
+		t.Fatalf("expected ErrKeyNotDeclared, got %v", err)
+	}
+
+	raw, err := wal.NewWAL(wal.Config{Path: walPath, SyncPolicy: wal.SyncEveryWrite})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+
+	count := 0
+	raw.Replay(func(r wal.WALRecord) error {
+		count++
+		return nil
+	})
+	if count != 1 {
+		t.Fatalf("rejected transactional writes were logged: %d records", count)
+	}
+}
+
+func TestWalStore_TornTransactionIsNotReplayed(t *testing.T) {
+	walFile, _ := os.CreateTemp("", "wal_*.log")
+	snapFile, _ := os.CreateTemp("", "snap_*.bin")
+	defer os.Remove(walFile.Name())
+	defer os.Remove(snapFile.Name())
+
+	// Crash after the first record of the group reached disk
+	walFile.WriteString("BEGIN\nSET a MQ==\n")
+	walFile.Close()
+
+	w, _ := wal.NewWAL(wal.Config{
+		Path:       walFile.Name(),
+		SyncPolicy: wal.SyncEveryWrite,
+	})
+	defer w.Close()
+
+	recovered, err := NewWalStore(NewLockedStore(), w, snapFile.Name(), 0)
+	if err != nil {
+		t.Fatalf("recovery failed: %v", err)
+	}
+
+	if _, ok := recovered.Read("a"); ok {
+		t.Fatalf("half a transaction was replayed")
+	}
+}
+
+func TestWalStore_OrderedRecovery(t *testing.T) {
+	factory := setupFactory(t, func() DataStore { return NewOrderedStore() })
+
+	store, walPath, snapPath, closeFn, cleanup := factory()
+	defer cleanup()
+
+	_ = store.Write("b", Entry{Value: []byte("2")}, PutOverwrite)
+	_ = store.Write("a", Entry{Value: []byte("1")}, PutOverwrite)
+	closeFn()
+
+	w2, err := wal.NewWAL(wal.Config{Path: walPath, SyncPolicy: wal.SyncEveryWrite})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w2.Close()
+
+	recovered, err := NewWalStore(NewOrderedStore(), w2, snapPath, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var keys []string
+	recovered.(Ordered).Prefix("", func(key string, _ Entry) bool {
+		keys = append(keys, key)
+		return true
+	})
+	if len(keys) != 2 || keys[0] != "a" || keys[1] != "b" {
+		t.Fatalf("expected ordered keys [a b] after recovery, got %v", keys)
+	}
+}
+
+func TestWalStore_ValueTypeRecovery(t *testing.T) {
+	dir := t.TempDir()
+	clock := NewManualClock(time.Unix(1_700_000_000, 0))
+
+	s := openCompressedWal(t, dir, clock)
+	_ = s.Write("list", Entry{Value: []byte("l"), Type: TypeList}, PutOverwrite)
+	_ = s.WriteBatch([]KeyValue{
+		{Key: "hash", Entry: Entry{Value: []byte(document), Type: TypeHash}},
+		{Key: "str", Entry: Entry{Value: []byte("s")}},
+	}, PutOverwrite)
+
+	// Close snapshots the values, then the WAL logs one more
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r := openCompressedWal(t, dir, clock)
+	_ = r.Atomic([]string{"set"}, func(tx Tx) error {
+		return tx.Write("set", Entry{Value: []byte("m"), Type: TypeSet}, PutOverwrite)
+	})
+	r.(*compressedStore).store.(*walStore).wal.Close()
+
+	r = openCompressedWal(t, dir, clock)
+	defer r.Close()
+	for key, want := range map[string]ValueType{"list": TypeList, "hash": TypeHash, "str": TypeString, "set": TypeSet} {
+		val, ok := r.Read(key)
+		if !ok || val.Type != want {
+			t.Fatalf("expected %s to recover as a %s, got %s (found=%v)", key, want, val.Type, ok)
+		}
+	}
+	mustHold(t, r, "hash", document)
+}
+
+func TestWalStore_ConcurrentWritersReplayInOrder(t *testing.T) {
+	dir := t.TempDir()
+	open := func() DataStore {
+		t.Helper()
+		w, err := wal.NewWAL(wal.Config{Path: filepath.Join(dir, "wal.log"), SyncPolicy: wal.SyncEverySecond})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ws, err := NewWalStore(NewShardedStore(4), w, filepath.Join(dir, "snapshot.bin"), 0)
+		if err != nil {
+			t.Fatalf("replay failed: %v", err)
+		}
+		return ws
+	}
+
+	// One writer turns the key into a string, the other into a list:
+	// each mutation is only valid in the order memory applied them
+	s := open()
+	exp := time.Now().Add(time.Hour).UnixMilli()
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 5000; i++ {
+			_ = s.Write("k", Entry{Value: []byte("v")}, PutOverwrite)
+			s.Expire("k", exp)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 5000; i++ {
+			_, _ = Apply(s, "k", Op{Name: "LPUSH", Args: []string{"a", "b"}})
+			_, _ = Apply(s, "k", Op{Name: "LPOP"})
+		}
+	}()
+	wg.Wait()
+
+	want, wantOK := s.Read("k")
+	s.(*walStore).wal.Close()
+
+	r := open()
+	defer r.Close()
+	got, ok := r.Read("k")
+	if ok != wantOK || got.Type != want.Type || !bytes.Equal(got.Value, want.Value) || got.ExpiresAtMillis != want.ExpiresAtMillis {
+		t.Fatalf("expected replay to rebuild %+v (found=%v), got %+v (found=%v)", want, wantOK, got, ok)
+	}
+}
+
+/*
+walBlockingAppend is a WAL whose appends wait to be released, like a
+slow disk.
+*/
+type walBlockingAppend struct {
+	walWithoutRotate
+	appending chan struct{}
+	release   chan struct{}
+}
+
+func (w *walBlockingAppend) Append(wal.WALRecord) error {
+	w.appending <- struct{}{}
+	<-w.release
+	return nil
+}
+
+func TestWalStore_WriteLogsWithoutHoldingTheStore(t *testing.T) {
+	inner := NewEventloopStore(100)
+	_ = inner.Write("other", Entry{Value: []byte("v")}, PutOverwrite)
+
+	w := &walBlockingAppend{appending: make(chan struct{}), release: make(chan struct{})}
+	s, err := NewWalStore(inner, w, filepath.Join(t.TempDir(), "snapshot.bin"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, write := range map[string]func(){
+		"Write":  func() { _ = s.Write("k", Entry{Value: []byte("v")}, PutOverwrite) },
+		"Expire": func() { s.Expire("other", time.Now().Add(time.Hour).UnixMilli()) },
+	} {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			write()
+		}()
+		<-w.appending
+
+		// The loop keeps serving reads while the append waits
+		read := make(chan struct{})
+		go func() {
+			defer close(read)
+			s.Read("other")
+		}()
+		select {
+		case <-read:
+		case <-time.After(time.Second):
+			t.Fatalf("%s: expected reads to go on during the append", name)
+		}
+
+		close(w.release)
+		<-done
+		w.release = make(chan struct{})
+	}
+}
+
+func TestWalStore_ForwardsScansOnlyOverScanners(t *testing.T) {
+	for name, tc := range map[string]struct {
+		inner DataStore
+		want  bool
+	}{
+		"Scanner":    {NewShardedStore(4), true},
+		"NotScanner": {&nonIterableStore{}, false},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ws, err := NewWalStore(tc.inner, &walWithoutRotate{}, filepath.Join(t.TempDir(), "snapshot.bin"), 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tiered, err := NewTieredStore(ws, filepath.Join(t.TempDir(), "values.log"), 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, s := range []DataStore{ws, NewCompressedStore(ws, 64), tiered} {
+				if _, ok := s.(Scanner); !ok {
+					t.Fatalf("expected %T to implement Scanner", s)
+				}
+				if _, ok := AsScanner(s); ok != tc.want {
+					t.Fatalf("expected AsScanner(%T) to report %v", s, tc.want)
+				}
+			}
+		})
+	}
+}
+
+func TestWalStore_ForwardsContextOnlyOverContextStores(t *testing.T) {
+	for name, tc := range map[string]struct {
+		inner DataStore
+		want  bool
+	}{
+		"ContextStore":    {NewEventloopStore(16), true},
+		"NotContextStore": {NewShardedStore(4), false},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ws, err := NewWalStore(tc.inner, &walWithoutRotate{}, filepath.Join(t.TempDir(), "snapshot.bin"), 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ws.Close()
+
+			for _, s := range []DataStore{ws, NewCompressedStore(ws, 64)} {
+				if _, ok := s.(ContextStore); !ok {
+					t.Fatalf("expected %T to implement ContextStore", s)
+				}
+				if _, ok := AsContextStore(s); ok != tc.want {
+					t.Fatalf("expected AsContextStore(%T) to report %v", s, tc.want)
+				}
+			}
+		})
+	}
+}
+
+/*
+walCountingAppends counts the records appended to it.
+*/
+type walCountingAppends struct {
+	walWithoutRotate
+	appended int
+}
+
+func (w *walCountingAppends) Append(wal.WALRecord) error {
+	w.appended++
+	return nil
+}
+
+func TestWalStore_CancelledWriteIsNotLogged(t *testing.T) {
+	w := &walCountingAppends{}
+	ws, err := NewWalStore(NewEventloopStore(16), w, filepath.Join(t.TempDir(), "snapshot.bin"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	_ = ws.Write("k", Entry{Value: []byte("v")}, PutOverwrite)
+	w.appended = 0
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	cs := ws.(ContextStore)
+	if err := cs.WriteContext(ctx, "k", Entry{Value: []byte("v2")}, PutOverwrite); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected WriteContext to be cancelled, got %v", err)
+	}
+	if ok, err := cs.ExpireContext(ctx, "k", 1); ok || !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected ExpireContext to be cancelled, got %v (%v)", ok, err)
+	}
+	if w.appended != 0 {
+		t.Fatalf("expected cancelled writes not to be logged, got %d records", w.appended)
+	}
+
+	val, ok := ws.Read("k")
+	if !ok || string(val.Value) != "v" || val.ExpiresAtMillis != 0 {
+		t.Fatalf("expected the key to keep its value, got %+v (%v)", val, ok)
+	}
+}
@@ -0,0 +1,232 @@
+package store
+
+import "hermes/wal"
+
+/*
+Atomic runs fn as a durable transaction.
+
+The underlying store holds the declared keys for the whole call, while
+walTx buffers every mutation in a private overlay:
+
+1. fn runs against the overlay (reads see the transaction's own writes)
+2. The buffered intents are appended as ONE atomic WAL group
+   (BEGIN ... COMMIT), so replay never applies half a transaction
+3. Only after disk success is the overlay applied to memory
+
+This preserves the same WAL-before-memory ordering as Write: if the
+append fails, nothing becomes visible and the error is returned.
+
+The declared keys' locks (see keyLocks) are taken first, ordering the
+transaction's records with plain writes of its keys.
+
+If fn returns an error, mutations made before it are still committed,
+mirroring Redis EXEC where a failing command does not roll back others.
+*/
+func (s *walStore) Atomic(keys []string, fn func(tx Tx) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	defer s.keys.lock(keys...)()
+
+	return s.store.Atomic(keys, func(inner Tx) error {
+		tx := &walTx{
+			ws:        s,
+			inner:     inner,
+			declared:  make(map[string]struct{}, len(keys)),
+			overlay:   make(map[string]Entry),
+			staged:    make(map[string]stagedOp),
+			rewritten: make(map[string]struct{}),
+		}
+		for _, key := range keys {
+			tx.declared[key] = struct{}{}
+		}
+
+		fnErr := fn(tx)
+		if len(tx.records) == 0 {
+			return fnErr
+		}
+
+		if err := s.wal.AppendAtomic(tx.records); err != nil {
+			return err
+		}
+		for _, key := range tx.order {
+			s.markDirty(key)
+		}
+
+		for _, key := range tx.order {
+			if st, ok := tx.staged[key]; ok {
+				if err := st.res.commit(inner, key); err != nil {
+					return err
+				}
+				continue
+			}
+			val := tx.overlay[key]
+
+			// A key that was only given a TTL keeps its value, and
+			// with it its place in the key's history
+			if _, ok := tx.rewritten[key]; !ok {
+				inner.Expire(key, val.ExpiresAtMillis)
+				continue
+			}
+			if err := inner.Write(key, val, PutOverwrite); err != nil {
+				return err
+			}
+		}
+		return fnErr
+	})
+}
+
+/*
+walTx records transactional intent without touching memory.
+
+Like walStore.Write, every operation is validated before its
+intent is recorded, so rejected operations never reach the WAL.
+*/
+type walTx struct {
+	// ws is the durable store running the transaction, whose clock
+	// stamps its writes.
+	ws    *walStore
+	inner Tx
+
+	// declared rejects intents for keys the store is not holding,
+	// which would otherwise fail only after being logged.
+	declared map[string]struct{}
+
+	// overlay holds the latest buffered entry per key.
+	overlay map[string]Entry
+
+	// staged holds the ops applied to keys not in the overlay (see
+	// walTx.Apply).
+	staged map[string]stagedOp
+
+	// order preserves first-write order for a deterministic apply.
+	order []string
+
+	// rewritten holds the keys given a new value, as opposed to
+	// only a new TTL.
+	rewritten map[string]struct{}
+
+	// records is the intent log flushed as one WAL group.
+	records []wal.WALRecord
+}
+
+func (tx *walTx) Read(key string) (Entry, bool) {
+	tx.settle(key)
+	val, ok := tx.overlay[key]
+	if !ok {
+		return tx.inner.Read(key)
+	}
+
+	if val.expired(unixNow(tx.ws.clock)) {
+		return Entry{}, false
+	}
+	return val, true
+}
+
+func (tx *walTx) Write(key string, value Entry, mode PutMode) error {
+	if err := tx.validate(key, mode); err != nil {
+		return err
+	}
+
+	value.ExpiresAtMillis = 0
+	value.WrittenAtMillis = tx.ws.writeTime(value)
+	tx.rewritten[key] = struct{}{}
+	tx.stage(key, value, wal.WALRecord{
+		Type:      wal.RecordSet,
+		Key:       key,
+		Value:     string(value.Value),
+		Time:      value.WrittenAtMillis,
+		Encoding:  uint8(value.Encoding),
+		ValueType: uint8(value.Type),
+	})
+	return nil
+}
+
+func (tx *walTx) Expire(key string, unixTimestampMilli int64) bool {
+	if _, ok := tx.declared[key]; !ok {
+		return false
+	}
+
+	val, exists := tx.Read(key)
+	if !exists || unixTimestampMilli < 0 {
+		return false
+	}
+
+	val.ExpiresAtMillis = unixTimestampMilli
+	tx.stage(key, val, wal.WALRecord{
+		Type:   wal.RecordExpire,
+		Key:    key,
+		Expire: unixTimestampMilli,
+	})
+	return true
+}
+
+func (tx *walTx) ReadBatch(keys []string) map[string]Entry {
+	result := make(map[string]Entry, len(keys))
+	for _, key := range keys {
+		if val, ok := tx.Read(key); ok {
+			result[key] = val
+		}
+	}
+	return result
+}
+
+func (tx *walTx) WriteBatch(entries []KeyValue, mode PutMode) error {
+	for _, kv := range entries {
+		if err := tx.validate(kv.Key, mode); err != nil {
+			return err
+		}
+	}
+
+	written := unixNow(tx.ws.clock)
+	for _, kv := range entries {
+		val := kv.Entry
+		val.ExpiresAtMillis = 0
+		val.WrittenAtMillis = written
+		tx.rewritten[kv.Key] = struct{}{}
+		tx.stage(kv.Key, val, wal.WALRecord{
+			Type:      wal.RecordSet,
+			Key:       kv.Key,
+			Value:     string(val.Value),
+			Time:      written,
+			Encoding:  uint8(val.Encoding),
+			ValueType: uint8(val.Type),
+		})
+	}
+	return nil
+}
+
+/*
+validate applies put-mode checks against the transaction's view.
+*/
+func (tx *walTx) validate(key string, mode PutMode) error {
+	if _, ok := tx.declared[key]; !ok {
+		return ErrKeyNotDeclared
+	}
+	if _, ok := putFactories[mode]; !ok {
+		return ErrInvalidPutMode
+	}
+
+	_, exists := tx.Read(key)
+	switch mode {
+	case PutIfAbsent:
+		if exists {
+			return ErrKeyExists
+		}
+	case PutUpdate:
+		if !exists {
+			return ErrKeyNotFound
+		}
+	}
+	return nil
+}
+
+/*
+stage buffers a validated mutation and its WAL intent.
+*/
+func (tx *walTx) stage(key string, value Entry, record wal.WALRecord) {
+	if _, ok := tx.overlay[key]; !ok {
+		tx.order = append(tx.order, key)
+	}
+	tx.overlay[key] = value
+	tx.records = append(tx.records, record)
+}
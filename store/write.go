@@ -2,6 +2,7 @@ package store
 
 import (
 	"errors"
+	"sync/atomic"
 	"time"
 )
 
@@ -13,6 +14,10 @@ var (
 	ErrKeyExists      = errors.New("key already exists")
 	ErrKeyNotFound    = errors.New("key not found")
 	ErrInvalidPutMode = errors.New("invalid put mode")
+
+	// ErrKeyNotDeclared is returned when a transaction touches a key
+	// it did not declare up front.
+	ErrKeyNotDeclared = errors.New("key not declared in transaction")
 )
 
 /*
@@ -42,14 +47,94 @@ type DataStore interface {
 	// Returns false if the key does not exist or is already expired.
 	Expire(key string, unixTimestampMilli int64) bool
 
+	// ReadBatch returns the live entries for the given keys, observed
+	// atomically. Missing or expired keys are absent from the result.
+	ReadBatch(keys []string) map[string]Entry
+
+	// WriteBatch applies the same write semantics to every entry atomically.
+	// Either all entries are written or none are.
+	WriteBatch(entries []KeyValue, mode PutMode) error
+
+	// Atomic runs fn with exclusive access to the declared keys.
+	// Operations made through tx are not interleaved with any other
+	// operation on those keys. fn must only touch declared keys and
+	// must not call back into the store itself.
+	Atomic(keys []string, fn func(tx Tx) error) error
+
+	// Snapshot returns a read-only view pinned to the current commit
+	// sequence. Writers are not blocked while it is open; it must be
+	// closed to release the old versions it keeps alive.
+	Snapshot() View
+
 	// Close releases all resources owned by the store.
 	Close() error
 }
 
+/*
+Tx is the view of the store handed to an Atomic callback.
+
+Its method set is a subset of DataStore, so code written against Tx
+runs unchanged against a whole store or inside a transaction.
+Reads of undeclared keys report absence and writes to them fail with
+ErrKeyNotDeclared.
+*/
+type Tx interface {
+	Read(key string) (Entry, bool)
+	Write(key string, value Entry, mode PutMode) error
+	Expire(key string, unixTimestampMilli int64) bool
+	ReadBatch(keys []string) map[string]Entry
+	WriteBatch(entries []KeyValue, mode PutMode) error
+}
+
 type Iterable interface {
 	Iterate(fn func(key string, value Entry) bool)
 }
 
+/*
+Scanner is implemented by stores that can be traversed in resumable
+steps without holding anything between them.
+
+A scan starts with cursor 0 and feeds each returned cursor to the next
+call until it returns 0. Like Redis SCAN:
+- every key present for the whole scan is returned at least once
+- keys added or removed during the scan may or may not be returned
+- a step may return more or fewer than count entries, even none
+- values are those seen when the key's step ran
+*/
+type Scanner interface {
+	ScanEntries(cursor uint64, count int) (entries []KeyValue, next uint64)
+}
+
+/*
+AsScanner returns st as a Scanner if it can be scanned: it implements
+Scanner and, if it wraps another store, that store does too. Like
+AsOrdered, it stands in for a type assertion, which decorators always
+pass.
+*/
+func AsScanner(st any) (Scanner, bool) {
+	sc, ok := st.(Scanner)
+	if !ok {
+		return nil, false
+	}
+	if d, ok := st.(decorator); ok {
+		if _, ok := AsScanner(d.unwrap()); !ok {
+			return nil, false
+		}
+	}
+	return sc, true
+}
+
+/*
+Sweeper is implemented by stores that can actively remove expired
+entries. Reads never delete, so without sweeping an expired key
+occupies memory until it is written again.
+*/
+type Sweeper interface {
+	// SweepExpired removes expired entries and reports how many
+	// were removed.
+	SweepExpired() int
+}
+
 /*
 writeContext is an internal capability interface used by write strategies.
 It intentionally exposes only minimal read/write primitives to avoid
@@ -97,15 +182,216 @@ func updateStrategy(wctx writeContext, key string, value Entry) error {
 	return nil
 }
 
+/*
+KeyValue pairs a key with the entry written by a batch operation.
+*/
+type KeyValue struct {
+	Key   string
+	Entry Entry
+}
+
+/*
+writeBatch applies all-or-nothing write semantics across a batch.
+
+route maps every key to the store that owns it, which lets the same
+logic serve a single store and a set of locked shards. Callers must
+hold whatever locks protect the routed stores. Every entry is
+committed at seq, so views see the batch whole or not at all.
+
+Every key is validated against live state before anything is mutated,
+so a rejected batch leaves no partial writes behind:
+- PutIfAbsent fails if any key exists
+- PutUpdate fails if any key is missing
+*/
+func writeBatch(route func(key string) *store, entries []KeyValue, mode PutMode, seq uint64) error {
+	if _, ok := putFactories[mode]; !ok {
+		return ErrInvalidPutMode
+	}
+
+	for _, kv := range entries {
+		_, exists := route(kv.Key).get(kv.Key)
+
+		switch mode {
+		case PutIfAbsent:
+			if exists {
+				return ErrKeyExists
+			}
+		case PutUpdate:
+			if !exists {
+				return ErrKeyNotFound
+			}
+		}
+	}
+
+	for _, kv := range entries {
+		route(kv.Key).setAt(kv.Key, kv.Entry, seq)
+	}
+	return nil
+}
+
+/*
+routedTx is the Tx of stores that partition keys over several
+underlying stores (shards, event loops).
+
+route maps a key to the store holding it, or nil when the key was not
+declared to Atomic: its partition is not held, and touching it would
+bypass the store's isolation.
+
+Every write of the transaction is committed at one sequence, drawn at
+the first write, so views see the transaction whole or not at all.
+*/
+type routedTx struct {
+	route func(key string) *store
+	seq   uint64
+}
+
+/*
+commitSeq returns the transaction's commit sequence. It is drawn while
+the store holds the declared keys.
+*/
+func (tx *routedTx) commitSeq() uint64 {
+	if tx.seq == 0 {
+		tx.seq = nextVersion()
+	}
+	return tx.seq
+}
+
+/*
+seqWriter is the writeContext of a transaction: the write strategies
+run against st, committing at the transaction's sequence.
+*/
+type seqWriter struct {
+	st  *store
+	seq uint64
+}
+
+func (w seqWriter) get(key string) (Entry, bool) {
+	return w.st.get(key)
+}
+
+func (w seqWriter) set(key string, value Entry) {
+	w.st.setAt(key, value, w.seq)
+}
+
+func (w seqWriter) remove(key string) {
+	w.st.removeAt(key, w.seq)
+}
+
+func (tx *routedTx) Read(key string) (Entry, bool) {
+	st := tx.route(key)
+	if st == nil {
+		return Entry{}, false
+	}
+	return st.Read(key)
+}
+
+func (tx *routedTx) Write(key string, value Entry, mode PutMode) error {
+	st := tx.route(key)
+	if st == nil {
+		return ErrKeyNotDeclared
+	}
+
+	strategy, ok := putFactories[mode]
+	if !ok {
+		return ErrInvalidPutMode
+	}
+	return strategy(seqWriter{st: st, seq: tx.commitSeq()}, key, value)
+}
+
+func (tx *routedTx) Expire(key string, unixTimestampMilli int64) bool {
+	st := tx.route(key)
+	if st == nil {
+		return false
+	}
+	return st.expireAt(key, unixTimestampMilli, tx.commitSeq())
+}
+
+func (tx *routedTx) ReadBatch(keys []string) map[string]Entry {
+	result := make(map[string]Entry, len(keys))
+	for _, key := range keys {
+		if val, ok := tx.Read(key); ok {
+			result[key] = val
+		}
+	}
+	return result
+}
+
+func (tx *routedTx) WriteBatch(entries []KeyValue, mode PutMode) error {
+	for _, kv := range entries {
+		if tx.route(kv.Key) == nil {
+			return ErrKeyNotDeclared
+		}
+	}
+	return writeBatch(tx.route, entries, mode, tx.commitSeq())
+}
+
 /*
 Entry represents a single value stored in memory along with expiry.
-Additional metadata (versioning, etc.) will be added later.
 ExpiresAtUnix store expiration time as Unix milli-seconds; value of 0
 means no expiration
+
+WrittenAtMillis is when the value was written, in Unix milliseconds.
+A TTL change keeps it. The store stamps it on writes that leave it 0,
+which is what callers normally do; replaying a log sets it to keep
+the original times.
+
+Version is the commit sequence of the write that produced the entry.
+It is assigned by the store on every mutation and never reused for a
+key, so a key that is deleted and recreated gets a new version. Keys
+written by one batch or transaction share it. It backs optimistic
+checks such as WATCH and views; callers never set it.
+
+Encoding tells how Value is encoded. Stores keep it with the value and
+persist it, without interpreting it; only the compressed store (see
+NewCompressedStore) writes and reads encoded values.
+
+Type tells what kind of value Value holds (see ValueType), a string
+by default. Like Encoding, stores keep and persist it untouched.
 */
 type Entry struct {
 	Value           []byte
-	ExpiresAtMillis int64 // 0 means no expiration
+	ExpiresAtMillis int64  // 0 means no expiration
+	Version         uint64 // 0 means the key does not exist
+	WrittenAtMillis int64
+	Encoding        Encoding
+	Type            ValueType
+
+	// live is the decoded value the in-memory stores keep in place of
+	// Value for typed values that ops ran on (see liveTx). It never
+	// leaves the store: entries handed out are materialized.
+	live typedValue
+}
+
+/*
+materialized returns the entry with its live value, if any, encoded
+into Value, as it is handed out of the store.
+*/
+func (e Entry) materialized() Entry {
+	if e.live != nil {
+		e.Value, e.live = e.live.encode(), nil
+	}
+	return e
+}
+
+/*
+expired reports whether the entry's TTL has passed at now
+(Unix milliseconds).
+*/
+func (e Entry) expired(now int64) bool {
+	return e.ExpiresAtMillis != 0 && now >= e.ExpiresAtMillis
+}
+
+/*
+versionCounter is the global commit sequence, the source of versions.
+
+It is shared by every store so versions stay unique even when
+a key moves between shards, and so one sequence orders writes
+across every shard and loop of a store.
+*/
+var versionCounter atomic.Uint64
+
+func nextVersion() uint64 {
+	return versionCounter.Add(1)
 }
 
 func GetUnixTimestamp(t time.Time) int64 {
@@ -0,0 +1,316 @@
+package store
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+/*
+zsetValue is the decoded form of a TypeZSet value, a sorted set:
+members with a score each, ranked by score. Like in Redis, a map
+answers score lookups and a skip list ranks the members.
+
+It is stored as the number of members followed by each member and its
+score, in rank order. The in-memory stores keep both the map and the
+skip list between ops, so an op costs O(log n) plus what it returns;
+the WAL logs the op alone.
+*/
+type zsetValue struct {
+	scores map[string]float64
+	ranks  *zskipList
+}
+
+func decodeZSet(data []byte) (typedValue, error) {
+	r := valueReader{data: data}
+	z := &zsetValue{scores: make(map[string]float64), ranks: newZSkipList()}
+	if len(data) == 0 {
+		return z, nil
+	}
+
+	n := r.count()
+	for i := 0; i < n && r.err == nil; i++ {
+		member := r.string()
+		z.add(member, r.float())
+	}
+	return z, r.finish()
+}
+
+func (z *zsetValue) encode() []byte {
+	size := 1
+	for member := range z.scores {
+		size += len(member) + 10
+	}
+
+	buf := appendUvarint(make([]byte, 0, size), uint64(len(z.scores)))
+	for n := z.ranks.byRank(0); n != nil; n = n.levels[0].next {
+		buf = appendString(buf, n.member)
+		buf = appendFloat(buf, n.score)
+	}
+	return buf
+}
+
+func (z *zsetValue) empty() bool {
+	return len(z.scores) == 0
+}
+
+/*
+add sets the score of member and reports whether it is new.
+*/
+func (z *zsetValue) add(member string, score float64) bool {
+	old, ok := z.scores[member]
+	if ok {
+		if old == score {
+			return false
+		}
+		z.ranks.delete(old, member)
+	}
+
+	z.scores[member] = score
+	z.ranks.insert(score, member)
+	return !ok
+}
+
+func (z *zsetValue) remove(member string) bool {
+	score, ok := z.scores[member]
+	if ok {
+		delete(z.scores, member)
+		z.ranks.delete(score, member)
+	}
+	return ok
+}
+
+/*
+scoreBound is one end of a score range: a score, included unless the
+bound is exclusive.
+*/
+type scoreBound struct {
+	score     float64
+	exclusive bool
+}
+
+/*
+parseScoreBound parses a bound of ZRANGEBYSCORE: a score, -inf or
++inf, prefixed with "(" to make it exclusive.
+*/
+func parseScoreBound(arg string) (scoreBound, error) {
+	var b scoreBound
+	if rest, ok := strings.CutPrefix(arg, "("); ok {
+		b.exclusive, arg = true, rest
+	}
+
+	f, err := strconv.ParseFloat(arg, 64)
+	if err != nil || math.IsNaN(f) {
+		return scoreBound{}, ErrNotFloat
+	}
+	b.score = f
+	return b, nil
+}
+
+/*
+lowerExcludes reports whether s lies below b, taken as the lower end
+of a range.
+*/
+func (b scoreBound) lowerExcludes(s float64) bool {
+	return s < b.score || (b.exclusive && s == b.score)
+}
+
+/*
+upperExcludes reports whether s lies above b, taken as the upper end
+of a range.
+*/
+func (b scoreBound) upperExcludes(s float64) bool {
+	return s > b.score || (b.exclusive && s == b.score)
+}
+
+/*
+scoreArg parses a score argument. Infinities are scores; NaN is not.
+*/
+func scoreArg(arg string) (float64, error) {
+	f, err := strconv.ParseFloat(arg, 64)
+	if err != nil || math.IsNaN(f) {
+		return 0, ErrNotFloat
+	}
+	return f, nil
+}
+
+/*
+formatScore formats a score the way sorted set replies show it.
+*/
+func formatScore(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+/*
+withScores reports whether the optional WITHSCORES flag ends args,
+and returns args without it.
+*/
+func withScores(args []string, fixed int) ([]string, bool, error) {
+	switch {
+	case len(args) == fixed:
+		return args, false, nil
+	case len(args) == fixed+1 && strings.EqualFold(args[fixed], "WITHSCORES"):
+		return args[:fixed], true, nil
+	}
+	return nil, false, ErrOpArgs
+}
+
+/*
+appendMember appends the member of n to out, followed by its score if
+scores is set.
+*/
+func appendMember(out []string, n *zskipNode, scores bool) []string {
+	out = append(out, n.member)
+	if scores {
+		out = append(out, formatScore(n.score))
+	}
+	return out
+}
+
+/*
+zsetOp adapts an op on a sorted set to an opSpec.
+*/
+func zsetOp(query bool, minArgs, maxArgs int, fn func(z *zsetValue, args []string) (any, func(), error)) opSpec {
+	return typedOp(TypeZSet, query, minArgs, maxArgs, fn)
+}
+
+/*
+zsetOps are the ops on sorted sets. Ranks count from 0 at the lowest
+score; scores are replied as strings, formatted by formatScore.
+*/
+var zsetOps = map[string]opSpec{
+	// ZADD score member [score member ...] replies with the members added
+	"ZADD": zsetOp(false, 2, -1, func(z *zsetValue, args []string) (any, func(), error) {
+		if len(args)%2 != 0 {
+			return nil, nil, ErrOpArgs
+		}
+
+		// Every score is checked before any member changes. A member
+		// given twice is added once, and the last score wins.
+		scores := make(map[string]float64, len(args)/2)
+		for i := 0; i < len(args); i += 2 {
+			score, err := scoreArg(args[i])
+			if err != nil {
+				return nil, nil, err
+			}
+			scores[args[i+1]] = score
+		}
+
+		added, changed := int64(0), false
+		for member, score := range scores {
+			old, ok := z.scores[member]
+			if !ok {
+				added++
+			}
+			changed = changed || !ok || old != score
+		}
+		if !changed {
+			return added, nil, nil
+		}
+		return added, func() {
+			for member, score := range scores {
+				z.add(member, score)
+			}
+		}, nil
+	}),
+
+	// ZINCRBY increment member replies with the new score
+	"ZINCRBY": zsetOp(false, 2, 2, func(z *zsetValue, args []string) (any, func(), error) {
+		by, err := scoreArg(args[0])
+		if err != nil {
+			return nil, nil, err
+		}
+
+		old, ok := z.scores[args[1]]
+		score := old + by
+		if math.IsNaN(score) {
+			return nil, nil, ErrScoreNaN
+		}
+		if ok && score == old {
+			return formatScore(score), nil, nil
+		}
+		return formatScore(score), func() {
+			z.add(args[1], score)
+		}, nil
+	}),
+
+	// ZRANGE start stop [WITHSCORES]
+	"ZRANGE": zsetOp(true, 2, 3, queryOp(func(z *zsetValue, args []string) (any, error) {
+		args, scores, err := withScores(args, 2)
+		if err != nil {
+			return nil, err
+		}
+		start, err := intArg(args[0])
+		if err != nil {
+			return nil, err
+		}
+		stop, err := intArg(args[1])
+		if err != nil {
+			return nil, err
+		}
+
+		from, to := indexRange(z.ranks.length, start, stop)
+		out := []string{}
+		for n, rank := z.ranks.byRank(from), from; n != nil && rank < to; n, rank = n.levels[0].next, rank+1 {
+			out = appendMember(out, n, scores)
+		}
+		return out, nil
+	})),
+
+	// ZRANGEBYSCORE min max [WITHSCORES]
+	"ZRANGEBYSCORE": zsetOp(true, 2, 3, queryOp(func(z *zsetValue, args []string) (any, error) {
+		args, scores, err := withScores(args, 2)
+		if err != nil {
+			return nil, err
+		}
+		lower, err := parseScoreBound(args[0])
+		if err != nil {
+			return nil, err
+		}
+		upper, err := parseScoreBound(args[1])
+		if err != nil {
+			return nil, err
+		}
+
+		out := []string{}
+		for n := z.ranks.firstFrom(lower); n != nil && !upper.upperExcludes(n.score); n = n.levels[0].next {
+			out = appendMember(out, n, scores)
+		}
+		return out, nil
+	})),
+
+	"ZRANK": zsetOp(true, 1, 1, queryOp(func(z *zsetValue, args []string) (any, error) {
+		score, ok := z.scores[args[0]]
+		if !ok {
+			return nil, nil
+		}
+		return int64(z.ranks.rank(score, args[0])), nil
+	})),
+
+	"ZREM": zsetOp(false, 1, -1, func(z *zsetValue, args []string) (any, func(), error) {
+		removed := make(map[string]struct{}, len(args))
+		for _, member := range args {
+			if _, ok := z.scores[member]; ok {
+				removed[member] = struct{}{}
+			}
+		}
+		if len(removed) == 0 {
+			return int64(0), nil, nil
+		}
+		return int64(len(removed)), func() {
+			for member := range removed {
+				z.remove(member)
+			}
+		}, nil
+	}),
+
+	"ZCARD": zsetOp(true, 0, 0, queryOp(func(z *zsetValue, _ []string) (any, error) {
+		return int64(len(z.scores)), nil
+	})),
+}
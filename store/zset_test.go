@@ -0,0 +1,183 @@
+package store
+
+import (
+	"cmp"
+	"errors"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestZSkipList_MatchesSortedModel(t *testing.T) {
+	type scored struct {
+		member string
+		score  float64
+	}
+
+	l := newZSkipList()
+	model := make(map[string]float64)
+	for i := 0; i < 5000; i++ {
+		member := "m" + strconv.Itoa(rand.IntN(500))
+		if score, ok := model[member]; ok {
+			l.delete(score, member)
+			delete(model, member)
+		} else {
+			score := float64(rand.IntN(50))
+			l.insert(score, member)
+			model[member] = score
+		}
+	}
+
+	want := make([]scored, 0, len(model))
+	for member, score := range model {
+		want = append(want, scored{member, score})
+	}
+	slices.SortFunc(want, func(a, b scored) int {
+		if c := cmp.Compare(a.score, b.score); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.member, b.member)
+	})
+
+	if l.length != len(want) {
+		t.Fatalf("expected length %d, got %d", len(want), l.length)
+	}
+	for rank, w := range want {
+		if got := l.rank(w.score, w.member); got != rank {
+			t.Fatalf("expected %s at rank %d, got %d", w.member, rank, got)
+		}
+		if n := l.byRank(rank); n == nil || n.member != w.member {
+			t.Fatalf("expected rank %d to hold %s, got %+v", rank, w.member, n)
+		}
+	}
+	if l.byRank(len(want)) != nil {
+		t.Fatalf("expected nothing past the last rank")
+	}
+
+	var backward []string
+	for n := l.tail; n != nil; n = n.prev {
+		backward = append(backward, n.member)
+	}
+	for i, member := range backward {
+		if want[len(want)-1-i].member != member {
+			t.Fatalf("backward order mismatch at %d: %s", i, member)
+		}
+	}
+
+	from := l.firstFrom(scoreBound{score: 25, exclusive: true})
+	i, _ := slices.BinarySearchFunc(want, 26.0, func(s scored, f float64) int { return cmp.Compare(s.score, f) })
+	if (from == nil) != (i == len(want)) || (from != nil && from.member != want[i].member) {
+		t.Fatalf("expected the range to start at index %d, got %+v", i, from)
+	}
+}
+
+func TestZSet_Ranges(t *testing.T) {
+	s := NewLockedStore()
+	_, _ = Apply(s, "z", Op{Name: "ZADD", Args: []string{"1", "a", "2", "b", "2", "c", "+inf", "d", "-5.5", "e"}})
+
+	for _, tc := range []struct {
+		op   string
+		args []string
+		want []string
+	}{
+		{"ZRANGE", []string{"0", "-1"}, []string{"e", "a", "b", "c", "d"}},
+		{"ZRANGE", []string{"1", "2", "WITHSCORES"}, []string{"a", "1", "b", "2"}},
+		{"ZRANGE", []string{"-1", "-1", "withscores"}, []string{"d", "inf"}},
+		{"ZRANGE", []string{"4", "2"}, []string{}},
+		{"ZRANGEBYSCORE", []string{"1", "2"}, []string{"a", "b", "c"}},
+		{"ZRANGEBYSCORE", []string{"(1", "+inf"}, []string{"b", "c", "d"}},
+		{"ZRANGEBYSCORE", []string{"-inf", "(2", "WITHSCORES"}, []string{"e", "-5.5", "a", "1"}},
+		{"ZRANGEBYSCORE", []string{"3", "4"}, []string{}},
+	} {
+		got, err := Apply(s, "z", Op{Name: tc.op, Args: tc.args})
+		if err != nil || !slices.Equal(got.([]string), tc.want) {
+			t.Fatalf("%s %v: expected %v, got %v (%v)", tc.op, tc.args, tc.want, got, err)
+		}
+	}
+
+	for _, op := range []Op{
+		{Name: "ZADD", Args: []string{"x", "a"}},
+		{Name: "ZADD", Args: []string{"nan", "a"}},
+		{Name: "ZRANGEBYSCORE", Args: []string{"(x", "1"}},
+	} {
+		if _, err := Apply(s, "z", op); !errors.Is(err, ErrNotFloat) {
+			t.Fatalf("%s %v: expected ErrNotFloat, got %v", op.Name, op.Args, err)
+		}
+	}
+	if _, err := Apply(s, "z", Op{Name: "ZINCRBY", Args: []string{"-inf", "d"}}); !errors.Is(err, ErrScoreNaN) {
+		t.Fatalf("expected ErrScoreNaN, got %v", err)
+	}
+	if _, err := Apply(s, "z", Op{Name: "ZRANGE", Args: []string{"0", "1", "SCORES"}}); !errors.Is(err, ErrOpArgs) {
+		t.Fatalf("expected ErrOpArgs, got %v", err)
+	}
+}
+
+func TestZSet_KeptAcrossOps(t *testing.T) {
+	s := NewLockedStore()
+	defer s.Close()
+
+	_, _ = Apply(s, "z", Op{Name: "ZADD", Args: []string{"1", "a", "2", "b"}})
+	z, ok := liveOf(s, "z").(*zsetValue)
+	if !ok {
+		t.Fatalf("expected the sorted set to be kept decoded")
+	}
+	scores, ranks := z.scores, z.ranks
+
+	_, _ = Apply(s, "z", Op{Name: "ZADD", Args: []string{"3", "c"}})
+	_, _ = Apply(s, "z", Op{Name: "ZINCRBY", Args: []string{"5", "a"}})
+	_, _ = Apply(s, "z", Op{Name: "ZREM", Args: []string{"b"}})
+
+	z = liveOf(s, "z").(*zsetValue)
+	if z.ranks != ranks || len(z.scores) != 2 || len(scores) != 2 {
+		t.Fatalf("expected ops to update the same skip list and score map")
+	}
+	got, err := Apply(s, "z", Op{Name: "ZRANGE", Args: []string{"0", "-1", "WITHSCORES"}})
+	if err != nil || !slices.Equal(got.([]string), []string{"c", "3", "a", "6"}) {
+		t.Fatalf("expected [c 3 a 6], got %v (%v)", got, err)
+	}
+}
+
+func TestWalStore_SetsRecoverWithTTL(t *testing.T) {
+	dir := t.TempDir()
+	clock := NewManualClock(time.Unix(1_700_000_000, 0))
+	exp := unixNow(clock) + time.Hour.Milliseconds()
+
+	s := openCompressedWal(t, dir, clock)
+	_, _ = Apply(s, "tags", Op{Name: "SADD", Args: []string{"go", "kv", "db"}, Time: unixNow(clock)})
+	_, _ = Apply(s, "board", Op{Name: "ZADD", Args: []string{"10", "ann", "20", "bob"}, Time: unixNow(clock)})
+	s.Expire("board", exp)
+	s.Expire("tags", unixNow(clock)+1000)
+
+	// Close snapshots both, then the WAL logs more ops
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r := openCompressedWal(t, dir, clock)
+	_, _ = Apply(r, "tags", Op{Name: "SREM", Args: []string{"db"}, Time: unixNow(clock)})
+	_, _ = Apply(r, "board", Op{Name: "ZINCRBY", Args: []string{"15", "ann"}, Time: unixNow(clock)})
+	r.(*compressedStore).store.(*walStore).wal.Close()
+
+	r = openCompressedWal(t, dir, clock)
+	defer r.Close()
+
+	if got, _ := Apply(r, "board", Op{Name: "ZRANGE", Args: []string{"0", "-1", "WITHSCORES"}}); !slices.Equal(got.([]string), []string{"bob", "20", "ann", "25"}) {
+		t.Fatalf("unexpected board after recovery %v", got)
+	}
+	if val, _ := r.Read("board"); val.ExpiresAtMillis != exp || val.Type != TypeZSet {
+		t.Fatalf("expected the board to keep its type and TTL, got %+v", val)
+	}
+	if got, _ := Apply(r, "tags", Op{Name: "SMEMBERS"}); !slices.Equal(got.([]string), []string{"go", "kv"}) {
+		t.Fatalf("unexpected tags after recovery %v", got)
+	}
+
+	// The tags expire on time, after recovery as before
+	clock.Advance(2 * time.Second)
+	if _, ok := r.Read("tags"); ok {
+		t.Fatalf("expected the tags to expire")
+	}
+	if n, _ := Apply(r, "tags", Op{Name: "SADD", Args: []string{"new"}, Time: unixNow(clock)}); n != int64(1) {
+		t.Fatalf("expected expired tags to start over, got %v", n)
+	}
+}
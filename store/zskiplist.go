@@ -0,0 +1,187 @@
+package store
+
+/*
+zskipList keeps the members of a sorted set ordered by score, then by
+member for equal scores.
+
+It is the skipList of ordered keyspaces with one addition, taken from
+Redis: each forward link records its span, the number of bottom-level
+nodes it jumps over. Summing spans along a search gives a member's
+rank, and descending by span finds the member at a rank, both in
+O(log n) instead of a walk from the head.
+*/
+type zskipList struct {
+	head   *zskipNode
+	tail   *zskipNode
+	level  int
+	length int
+}
+
+type zskipNode struct {
+	member string
+	score  float64
+	prev   *zskipNode
+	levels []zskipLink
+}
+
+type zskipLink struct {
+	next *zskipNode
+	span int
+}
+
+func newZSkipList() *zskipList {
+	return &zskipList{
+		head:  &zskipNode{levels: make([]zskipLink, skipListMaxLevel)},
+		level: 1,
+	}
+}
+
+/*
+before reports whether n sorts before the member with the given score.
+*/
+func (n *zskipNode) before(score float64, member string) bool {
+	return n.score < score || (n.score == score && n.member < member)
+}
+
+/*
+findPath fills update with the last node before (score, member) on
+every level, and rank with the rank of that node, 0 for the head.
+*/
+func (l *zskipList) findPath(score float64, member string, update *[skipListMaxLevel]*zskipNode, rank *[skipListMaxLevel]int) {
+	x := l.head
+	for i := l.level - 1; i >= 0; i-- {
+		if i < l.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.levels[i].next != nil && x.levels[i].next.before(score, member) {
+			rank[i] += x.levels[i].span
+			x = x.levels[i].next
+		}
+		update[i] = x
+	}
+}
+
+/*
+insert adds member with score. The caller guarantees the member is not
+already present.
+*/
+func (l *zskipList) insert(score float64, member string) {
+	var update [skipListMaxLevel]*zskipNode
+	var rank [skipListMaxLevel]int
+	l.findPath(score, member, &update, &rank)
+
+	level := randomLevel()
+	if level > l.level {
+		for i := l.level; i < level; i++ {
+			rank[i] = 0
+			update[i] = l.head
+			update[i].levels[i].span = l.length
+		}
+		l.level = level
+	}
+
+	x := &zskipNode{member: member, score: score, levels: make([]zskipLink, level)}
+	for i := 0; i < level; i++ {
+		x.levels[i].next = update[i].levels[i].next
+		update[i].levels[i].next = x
+
+		// update[i] now spans up to x, and x what update[i] spanned past it
+		x.levels[i].span = update[i].levels[i].span - (rank[0] - rank[i])
+		update[i].levels[i].span = rank[0] - rank[i] + 1
+	}
+	for i := level; i < l.level; i++ {
+		update[i].levels[i].span++
+	}
+
+	if update[0] != l.head {
+		x.prev = update[0]
+	}
+	if next := x.levels[0].next; next != nil {
+		next.prev = x
+	} else {
+		l.tail = x
+	}
+	l.length++
+}
+
+/*
+delete removes member, which must hold score, and reports whether it
+was present.
+*/
+func (l *zskipList) delete(score float64, member string) bool {
+	var update [skipListMaxLevel]*zskipNode
+	var rank [skipListMaxLevel]int
+	l.findPath(score, member, &update, &rank)
+
+	x := update[0].levels[0].next
+	if x == nil || x.score != score || x.member != member {
+		return false
+	}
+
+	for i := 0; i < l.level; i++ {
+		if update[i].levels[i].next == x {
+			update[i].levels[i].span += x.levels[i].span - 1
+			update[i].levels[i].next = x.levels[i].next
+		} else {
+			update[i].levels[i].span--
+		}
+	}
+
+	if next := x.levels[0].next; next != nil {
+		next.prev = x.prev
+	} else {
+		l.tail = x.prev
+	}
+	for l.level > 1 && l.head.levels[l.level-1].next == nil {
+		l.level--
+	}
+	l.length--
+	return true
+}
+
+/*
+rank returns the 0-based rank of member, which must hold score, or -1
+if it is not present.
+*/
+func (l *zskipList) rank(score float64, member string) int {
+	var update [skipListMaxLevel]*zskipNode
+	var rank [skipListMaxLevel]int
+	l.findPath(score, member, &update, &rank)
+
+	x := update[0].levels[0].next
+	if x == nil || x.score != score || x.member != member {
+		return -1
+	}
+	return rank[0]
+}
+
+/*
+byRank returns the node at the 0-based rank, or nil past the end.
+*/
+func (l *zskipList) byRank(rank int) *zskipNode {
+	x := l.head
+	traversed := 0
+	for i := l.level - 1; i >= 0; i-- {
+		for x.levels[i].next != nil && traversed+x.levels[i].span <= rank+1 {
+			traversed += x.levels[i].span
+			x = x.levels[i].next
+		}
+		if traversed == rank+1 {
+			return x
+		}
+	}
+	return nil
+}
+
+/*
+firstFrom returns the first node whose score is not below lower, or nil.
+*/
+func (l *zskipList) firstFrom(lower scoreBound) *zskipNode {
+	x := l.head
+	for i := l.level - 1; i >= 0; i-- {
+		for x.levels[i].next != nil && lower.lowerExcludes(x.levels[i].next.score) {
+			x = x.levels[i].next
+		}
+	}
+	return x.levels[0].next
+}
@@ -19,9 +19,29 @@ type RecordType int
 const (
 	RecordSet RecordType = iota
 	RecordExpire
+	RecordBatch
 
-	commandSet    = "SET"
-	commandExpire = "EXPIRE"
+	// RecordBegin and RecordCommit delimit an atomic group of records.
+	// They are written by AppendAtomic and consumed by Replay, which
+	// never hands them to callers.
+	RecordBegin
+	RecordCommit
+
+	// RecordOp is an operation on a typed value, such as a list push,
+	// logged as itself rather than as the value it produces.
+	RecordOp
+
+	// RecordCheckpoint marks where a snapshot ends: the snapshot
+	// holds every record before it. It is written by Checkpoint.
+	RecordCheckpoint
+
+	commandSet        = "SET"
+	commandExpire     = "EXPIRE"
+	commandBatch      = "MSET"
+	commandBegin      = "BEGIN"
+	commandCommit     = "COMMIT"
+	commandOp         = "OP"
+	commandCheckpoint = "CHECKPOINT"
 )
 
 /*
@@ -38,6 +58,31 @@ type WALRecord struct {
 	Key    string
 	Value  string
 	Expire int64
+
+	// Time is when a SET or batch was written, in Unix milliseconds.
+	// It is 0 in logs written before it was recorded.
+	Time int64
+
+	// Encoding tells how Value is encoded, 0 for a plain value. The
+	// WAL does not interpret it: it is stored and handed back as is.
+	Encoding uint8
+
+	// ValueType tags the kind of value Value holds, 0 for a string.
+	// Like Encoding, it is opaque to the WAL.
+	ValueType uint8
+
+	// Op and Args are the name and arguments of a RecordOp. Its
+	// Expire is the TTL the key had once the op ran, 0 for none.
+	Op   string
+	Args []string
+
+	// Checkpoint identifies a RecordCheckpoint, never 0.
+	Checkpoint uint64
+
+	// Batch holds the SET records of a RecordBatch.
+	// The whole batch is encoded on one line, so a torn write
+	// fails decoding and replay applies it all-or-nothing.
+	Batch []WALRecord
 }
 
 /*
@@ -47,17 +92,25 @@ Design choices:
 - One record per line → simple recovery and debugging
 - Base64 encoding for values → binary-safe without complex framing
 - Human-readable commands → inspectable WAL files
+
+A value with a non-zero Encoding is written as "<encoding>:<base64>",
+and one with a non-zero ValueType as "<encoding>.<type>:<base64>".
+The colon is not in the base64 alphabet, so plain values stay
+unambiguous and logs written before encodings existed still decode.
 */
 func EncodeRecord(rec WALRecord) (string, error) {
 	switch rec.Type {
 
-	// SET key val
+	// SET key val [time]
 	case RecordSet:
-		if rec.Key == "" || rec.Value == "" {
+		if rec.Key == "" || rec.Value == "" || rec.Time < 0 {
 			return "", ErrInvalidRecord
 		}
-		encodedVal := base64.StdEncoding.EncodeToString([]byte(rec.Value))
-		return fmt.Sprintf("%s %s %s\n", commandSet, rec.Key, encodedVal), nil
+		encodedVal := encodeValue(rec.Value, rec.Encoding, rec.ValueType)
+		if rec.Time == 0 {
+			return fmt.Sprintf("%s %s %s\n", commandSet, rec.Key, encodedVal), nil
+		}
+		return fmt.Sprintf("%s %s %s %d\n", commandSet, rec.Key, encodedVal, rec.Time), nil
 
 	// EXPIRE key unix_timestamp_ms
 	case RecordExpire:
@@ -66,6 +119,67 @@ func EncodeRecord(rec WALRecord) (string, error) {
 		}
 		return fmt.Sprintf("%s %s %d\n", commandExpire, rec.Key, rec.Expire), nil
 
+	// MSET key1 val1 key2 val2 ... [time]
+	//
+	// The time follows the pairs, so an even field count tells it
+	// apart from a log written before it was recorded.
+	case RecordBatch:
+		if len(rec.Batch) == 0 || rec.Time < 0 {
+			return "", ErrInvalidRecord
+		}
+
+		var sb strings.Builder
+		sb.WriteString(commandBatch)
+		for _, r := range rec.Batch {
+			if r.Type != RecordSet || r.Key == "" || r.Value == "" {
+				return "", ErrInvalidRecord
+			}
+			sb.WriteString(" ")
+			sb.WriteString(r.Key)
+			sb.WriteString(" ")
+			sb.WriteString(encodeValue(r.Value, r.Encoding, r.ValueType))
+		}
+		if rec.Time != 0 {
+			sb.WriteString(" ")
+			sb.WriteString(strconv.FormatInt(rec.Time, 10))
+		}
+		sb.WriteString("\n")
+		return sb.String(), nil
+
+	// OP key name time expire [arg ...]
+	//
+	// Arguments are base64 encoded like values, "-" standing for an
+	// empty one. Time and expire are always written: replay needs them
+	// to rebuild the same value.
+	case RecordOp:
+		if rec.Key == "" || rec.Op == "" || strings.ContainsAny(rec.Op, " \t\r\n") ||
+			rec.Time < 0 || rec.Expire < 0 {
+			return "", ErrInvalidRecord
+		}
+
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "%s %s %s %d %d", commandOp, rec.Key, rec.Op, rec.Time, rec.Expire)
+		for _, arg := range rec.Args {
+			sb.WriteString(" ")
+			sb.WriteString(encodeArg(arg))
+		}
+		sb.WriteString("\n")
+		return sb.String(), nil
+
+	// CHECKPOINT id
+	case RecordCheckpoint:
+		if rec.Checkpoint == 0 {
+			return "", ErrInvalidRecord
+		}
+		return fmt.Sprintf("%s %d\n", commandCheckpoint, rec.Checkpoint), nil
+
+	// BEGIN / COMMIT
+	case RecordBegin:
+		return commandBegin + "\n", nil
+
+	case RecordCommit:
+		return commandCommit + "\n", nil
+
 	default:
 		return "", ErrInvalidRecord
 	}
@@ -94,19 +208,29 @@ func DecodeRecord(line string) (WALRecord, error) {
 
 	switch strings.ToUpper(parts[0]) {
 	case commandSet:
-		if len(parts) != 3 {
+		if len(parts) != 3 && len(parts) != 4 {
 			return WALRecord{}, ErrInvalidRecord
 		}
 
-		valBytes, err := base64.StdEncoding.DecodeString(parts[2])
+		valBytes, encoding, valueType, err := decodeValue(parts[2])
 		if err != nil {
 			return WALRecord{}, err
 		}
 
+		var written int64
+		if len(parts) == 4 {
+			if written, err = decodeTime(parts[3]); err != nil {
+				return WALRecord{}, err
+			}
+		}
+
 		return WALRecord{
-			Type:  RecordSet,
-			Key:   parts[1],
-			Value: string(valBytes),
+			Type:      RecordSet,
+			Key:       parts[1],
+			Value:     string(valBytes),
+			Time:      written,
+			Encoding:  encoding,
+			ValueType: valueType,
 		}, nil
 
 	case commandExpire:
@@ -125,7 +249,185 @@ func DecodeRecord(line string) (WALRecord, error) {
 			Expire: exp,
 		}, nil
 
+	case commandBatch:
+		if len(parts) < 3 {
+			return WALRecord{}, ErrInvalidRecord
+		}
+
+		var written int64
+		if len(parts)%2 == 0 {
+			var err error
+			if written, err = decodeTime(parts[len(parts)-1]); err != nil {
+				return WALRecord{}, err
+			}
+			parts = parts[:len(parts)-1]
+		}
+		if len(parts) < 3 {
+			return WALRecord{}, ErrInvalidRecord
+		}
+
+		batch := make([]WALRecord, 0, (len(parts)-1)/2)
+		for i := 1; i < len(parts); i += 2 {
+			valBytes, encoding, valueType, err := decodeValue(parts[i+1])
+			if err != nil {
+				return WALRecord{}, err
+			}
+
+			batch = append(batch, WALRecord{
+				Type:      RecordSet,
+				Key:       parts[i],
+				Value:     string(valBytes),
+				Encoding:  encoding,
+				ValueType: valueType,
+			})
+		}
+
+		return WALRecord{
+			Type:  RecordBatch,
+			Time:  written,
+			Batch: batch,
+		}, nil
+
+	case commandOp:
+		if len(parts) < 5 {
+			return WALRecord{}, ErrInvalidRecord
+		}
+
+		written, err := decodeTime(parts[3])
+		if err != nil {
+			return WALRecord{}, err
+		}
+		expire, err := decodeTime(parts[4])
+		if err != nil {
+			return WALRecord{}, err
+		}
+
+		var args []string
+		for _, field := range parts[5:] {
+			arg, err := decodeArg(field)
+			if err != nil {
+				return WALRecord{}, err
+			}
+			args = append(args, arg)
+		}
+
+		return WALRecord{
+			Type:   RecordOp,
+			Key:    parts[1],
+			Op:     parts[2],
+			Time:   written,
+			Expire: expire,
+			Args:   args,
+		}, nil
+
+	case commandCheckpoint:
+		if len(parts) != 2 {
+			return WALRecord{}, ErrInvalidRecord
+		}
+
+		id, err := strconv.ParseUint(parts[1], 10, 64)
+		if err != nil || id == 0 {
+			return WALRecord{}, ErrInvalidRecord
+		}
+		return WALRecord{Type: RecordCheckpoint, Checkpoint: id}, nil
+
+	case commandBegin:
+		if len(parts) != 1 {
+			return WALRecord{}, ErrInvalidRecord
+		}
+		return WALRecord{Type: RecordBegin}, nil
+
+	case commandCommit:
+		if len(parts) != 1 {
+			return WALRecord{}, ErrInvalidRecord
+		}
+		return WALRecord{Type: RecordCommit}, nil
+
 	default:
 		return WALRecord{}, ErrInvalidRecord
 	}
 }
+
+/*
+decodeTime parses a write time field.
+*/
+func decodeTime(field string) (int64, error) {
+	t, err := strconv.ParseInt(field, 10, 64)
+	if err != nil || t < 0 {
+		return 0, ErrInvalidRecord
+	}
+	return t, nil
+}
+
+/*
+encodeValue renders a value field, prefixed with its encoding and
+value type unless both are 0.
+*/
+func encodeValue(value string, encoding, valueType uint8) string {
+	encoded := base64.StdEncoding.EncodeToString([]byte(value))
+	switch {
+	case valueType != 0:
+		return strconv.Itoa(int(encoding)) + "." + strconv.Itoa(int(valueType)) + ":" + encoded
+	case encoding != 0:
+		return strconv.Itoa(int(encoding)) + ":" + encoded
+	default:
+		return encoded
+	}
+}
+
+/*
+decodeValue parses a value field written by encodeValue. A prefix
+that only restates the defaults is rejected, as encodeValue never
+writes one.
+*/
+func decodeValue(field string) ([]byte, uint8, uint8, error) {
+	var encoding, valueType uint8
+	if prefix, encoded, ok := strings.Cut(field, ":"); ok {
+		enc, typ, typed := strings.Cut(prefix, ".")
+
+		n, err := strconv.ParseUint(enc, 10, 8)
+		if err != nil {
+			return nil, 0, 0, ErrInvalidRecord
+		}
+		encoding = uint8(n)
+
+		if typed {
+			n, err = strconv.ParseUint(typ, 10, 8)
+			if err != nil || n == 0 {
+				return nil, 0, 0, ErrInvalidRecord
+			}
+			valueType = uint8(n)
+		} else if encoding == 0 {
+			return nil, 0, 0, ErrInvalidRecord
+		}
+		field = encoded
+	}
+
+	value, err := base64.StdEncoding.DecodeString(field)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	return value, encoding, valueType, nil
+}
+
+// emptyArg stands for an empty op argument, whose base64 form is empty.
+// It is not in the base64 alphabet.
+const emptyArg = "-"
+
+func encodeArg(arg string) string {
+	if arg == "" {
+		return emptyArg
+	}
+	return base64.StdEncoding.EncodeToString([]byte(arg))
+}
+
+func decodeArg(field string) (string, error) {
+	if field == emptyArg {
+		return "", nil
+	}
+	arg, err := base64.StdEncoding.DecodeString(field)
+	if err != nil {
+		return "", ErrInvalidRecord
+	}
+	return string(arg), nil
+}
@@ -27,6 +27,43 @@ func TestEncodeDecode_SuccessPaths(t *testing.T) {
 				Value: "hello world space",
 			},
 		},
+		{
+			name: "Valid Set With Time",
+			input: WALRecord{
+				Type:  RecordSet,
+				Key:   "stamped",
+				Value: "v",
+				Time:  1700000000123,
+			},
+		},
+		{
+			name: "Valid Set With Encoding",
+			input: WALRecord{
+				Type:     RecordSet,
+				Key:      "packed",
+				Value:    "\x01\x02 binary",
+				Time:     1700000000123,
+				Encoding: 1,
+			},
+		},
+		{
+			name: "Valid Set With Value Type",
+			input: WALRecord{
+				Type:      RecordSet,
+				Key:       "list",
+				Value:     "\x02\x01a\x01b",
+				Time:      1700000000123,
+				ValueType: 1,
+			},
+		},
+		{
+			name:  "Valid Begin",
+			input: WALRecord{Type: RecordBegin},
+		},
+		{
+			name:  "Valid Commit",
+			input: WALRecord{Type: RecordCommit},
+		},
 		{
 			name: "Valid Expire",
 			input: WALRecord{
@@ -58,6 +95,15 @@ func TestEncodeDecode_SuccessPaths(t *testing.T) {
 			if tt.input.Type == RecordSet && rec.Value != tt.input.Value {
 				t.Errorf("Value mismatch: got %v want %v", rec.Value, tt.input.Value)
 			}
+			if rec.Time != tt.input.Time {
+				t.Errorf("Time mismatch: got %v want %v", rec.Time, tt.input.Time)
+			}
+			if rec.Encoding != tt.input.Encoding {
+				t.Errorf("Encoding mismatch: got %v want %v", rec.Encoding, tt.input.Encoding)
+			}
+			if rec.ValueType != tt.input.ValueType {
+				t.Errorf("ValueType mismatch: got %v want %v", rec.ValueType, tt.input.ValueType)
+			}
 			if tt.input.Type == RecordExpire && rec.Expire != tt.input.Expire {
 				t.Errorf("Expire mismatch: got %v want %v", rec.Expire, tt.input.Expire)
 			}
@@ -170,3 +216,246 @@ func TestDecodeRecord_ValidUpperLowerCase(t *testing.T) {
 		t.Errorf("Expected RecordSet, got %v", rec.Type)
 	}
 }
+
+func TestEncodeDecode_Batch(t *testing.T) {
+	input := WALRecord{
+		Type: RecordBatch,
+		Batch: []WALRecord{
+			{Type: RecordSet, Key: "a", Value: "1"},
+			{Type: RecordSet, Key: "b", Value: "two words"},
+		},
+	}
+
+	line, err := EncodeRecord(input)
+	if err != nil {
+		t.Fatalf("EncodeRecord failed: %v", err)
+	}
+
+	rec, err := DecodeRecord(line)
+	if err != nil {
+		t.Fatalf("DecodeRecord failed: %v", err)
+	}
+
+	if rec.Type != RecordBatch || len(rec.Batch) != len(input.Batch) {
+		t.Fatalf("batch mismatch: got %+v", rec)
+	}
+	for i := range input.Batch {
+		got := rec.Batch[i]
+		if got.Type != RecordSet || got.Key != input.Batch[i].Key || got.Value != input.Batch[i].Value {
+			t.Errorf("batch entry %d mismatch: got %+v", i, rec.Batch[i])
+		}
+	}
+}
+
+func TestEncodeDecode_BatchWithTime(t *testing.T) {
+	input := WALRecord{
+		Type: RecordBatch,
+		Time: 1700000000123,
+		Batch: []WALRecord{
+			{Type: RecordSet, Key: "a", Value: "1"},
+			{Type: RecordSet, Key: "b", Value: "2"},
+		},
+	}
+
+	line, err := EncodeRecord(input)
+	if err != nil {
+		t.Fatalf("EncodeRecord failed: %v", err)
+	}
+
+	rec, err := DecodeRecord(line)
+	if err != nil {
+		t.Fatalf("DecodeRecord failed: %v", err)
+	}
+
+	if rec.Time != input.Time || len(rec.Batch) != 2 {
+		t.Fatalf("batch mismatch: got %+v", rec)
+	}
+	if rec.Batch[1].Key != "b" || rec.Batch[1].Value != "2" {
+		t.Errorf("batch entry mismatch: got %+v", rec.Batch[1])
+	}
+}
+
+func TestEncodeDecode_BatchWithEncoding(t *testing.T) {
+	input := WALRecord{
+		Type: RecordBatch,
+		Batch: []WALRecord{
+			{Type: RecordSet, Key: "a", Value: "plain"},
+			{Type: RecordSet, Key: "b", Value: "packed", Encoding: 1},
+			{Type: RecordSet, Key: "c", Value: "typed", Encoding: 1, ValueType: 2},
+		},
+	}
+
+	line, err := EncodeRecord(input)
+	if err != nil {
+		t.Fatalf("EncodeRecord failed: %v", err)
+	}
+
+	rec, err := DecodeRecord(line)
+	if err != nil {
+		t.Fatalf("DecodeRecord failed: %v", err)
+	}
+
+	if len(rec.Batch) != 3 || rec.Batch[0].Encoding != 0 || rec.Batch[1].Encoding != 1 {
+		t.Fatalf("expected per-entry encodings to survive, got %+v", rec.Batch)
+	}
+	if rec.Batch[1].ValueType != 0 || rec.Batch[2].Encoding != 1 || rec.Batch[2].ValueType != 2 {
+		t.Fatalf("expected per-entry value types to survive, got %+v", rec.Batch)
+	}
+	if rec.Batch[1].Value != "packed" {
+		t.Errorf("batch entry mismatch: got %+v", rec.Batch[1])
+	}
+}
+
+func TestDecodeRecord_InvalidEncoding(t *testing.T) {
+	for _, line := range []string{
+		"SET k 0:dg==",
+		"SET k x:dg==",
+		"SET k 256:dg==",
+		"SET k 0.0:dg==",
+		"SET k 1.:dg==",
+		"SET k .2:dg==",
+		"MSET a dg== b :dg==",
+	} {
+		if _, err := DecodeRecord(line); err == nil {
+			t.Errorf("expected %q to fail decoding", line)
+		}
+	}
+}
+
+func TestEncodeBatch_Errors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input WALRecord
+	}{
+		{
+			name:  "Empty Batch",
+			input: WALRecord{Type: RecordBatch},
+		},
+		{
+			name: "Non Set Entry",
+			input: WALRecord{
+				Type:  RecordBatch,
+				Batch: []WALRecord{{Type: RecordExpire, Key: "k", Expire: 1}},
+			},
+		},
+		{
+			name: "Empty Value",
+			input: WALRecord{
+				Type:  RecordBatch,
+				Batch: []WALRecord{{Type: RecordSet, Key: "k"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := EncodeRecord(tt.input)
+			if !errors.Is(err, ErrInvalidRecord) {
+				t.Errorf("Expected ErrInvalidRecord, got %v", err)
+			}
+		})
+	}
+}
+
+func TestDecodeBatch_StrictFailures(t *testing.T) {
+	tests := []string{
+		"MSET",
+		"MSET key",
+		"MSET a YQ== b",
+		"MSET a YQ== -5",
+		"MSET 17",
+		"SET a YQ== notatime",
+		"SET a YQ== -1",
+		"BEGIN extra",
+		"COMMIT extra",
+		"MSET a %%%notbase64%%%",
+	}
+
+	for _, input := range tests {
+		t.Run(input, func(t *testing.T) {
+			_, err := DecodeRecord(input)
+			if err == nil {
+				t.Fatalf("Expected error, got nil for input: %q", input)
+			}
+		})
+	}
+}
+
+func TestEncodeDecode_Op(t *testing.T) {
+	input := WALRecord{
+		Type:   RecordOp,
+		Key:    "queue",
+		Op:     "RPUSH",
+		Args:   []string{"job 1", "", "\n"},
+		Time:   1700000000123,
+		Expire: 1700000005000,
+	}
+
+	line, err := EncodeRecord(input)
+	if err != nil {
+		t.Fatalf("EncodeRecord failed: %v", err)
+	}
+
+	rec, err := DecodeRecord(line)
+	if err != nil {
+		t.Fatalf("DecodeRecord failed: %v", err)
+	}
+
+	if rec.Type != RecordOp || rec.Key != "queue" || rec.Op != "RPUSH" ||
+		rec.Time != input.Time || rec.Expire != input.Expire {
+		t.Fatalf("op mismatch: got %+v", rec)
+	}
+	if len(rec.Args) != 3 || rec.Args[0] != "job 1" || rec.Args[1] != "" || rec.Args[2] != "\n" {
+		t.Fatalf("args mismatch: got %q", rec.Args)
+	}
+
+	// An op may take no arguments
+	line, _ = EncodeRecord(WALRecord{Type: RecordOp, Key: "queue", Op: "LPOP", Time: 1})
+	if rec, err := DecodeRecord(line); err != nil || rec.Op != "LPOP" || len(rec.Args) != 0 {
+		t.Fatalf("expected an op without arguments to round trip, got %+v (%v)", rec, err)
+	}
+}
+
+func TestOp_Errors(t *testing.T) {
+	for _, rec := range []WALRecord{
+		{Type: RecordOp, Op: "LPOP"},
+		{Type: RecordOp, Key: "k"},
+		{Type: RecordOp, Key: "k", Op: "L POP"},
+		{Type: RecordOp, Key: "k", Op: "LPOP", Time: -1},
+	} {
+		if _, err := EncodeRecord(rec); err == nil {
+			t.Errorf("expected %+v to fail encoding", rec)
+		}
+	}
+
+	for _, line := range []string{
+		"OP k LPOP 1",
+		"OP k LPOP x 0",
+		"OP k LPOP 1 -5",
+		"OP k RPUSH 1 0 !!",
+	} {
+		if _, err := DecodeRecord(line); err == nil {
+			t.Errorf("expected %q to fail decoding", line)
+		}
+	}
+}
+
+func TestEncodeDecode_Checkpoint(t *testing.T) {
+	line, err := EncodeRecord(WALRecord{Type: RecordCheckpoint, Checkpoint: 1<<63 + 5})
+	if err != nil {
+		t.Fatalf("EncodeRecord failed: %v", err)
+	}
+	rec, err := DecodeRecord(line)
+	if err != nil || rec.Type != RecordCheckpoint || rec.Checkpoint != 1<<63+5 {
+		t.Fatalf("expected the checkpoint to round trip, got %+v (%v)", rec, err)
+	}
+
+	if _, err := EncodeRecord(WALRecord{Type: RecordCheckpoint}); err == nil {
+		t.Errorf("expected a checkpoint without id to fail encoding")
+	}
+	for _, line := range []string{"CHECKPOINT", "CHECKPOINT 0", "CHECKPOINT -1", "CHECKPOINT 1 2"} {
+		if _, err := DecodeRecord(line); err == nil {
+			t.Errorf("expected %q to fail decoding", line)
+		}
+	}
+}
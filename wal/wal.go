@@ -3,6 +3,7 @@ package wal
 import (
 	"bufio"
 	"errors"
+	"io"
 	"os"
 	"strings"
 	"sync"
@@ -45,6 +46,10 @@ type WAL interface {
 	// Append records a mutating command to the log.
 	Append(record WALRecord) error
 
+	// AppendAtomic records a group of commands that Replay applies
+	// all-or-nothing. The group is written contiguously and synced once.
+	AppendAtomic(records []WALRecord) error
+
 	// Replay replays all logged commands in order by invoking apply.
 	// Replay must be called before accepting new writes.
 	Replay(apply func(WALRecord) error) error
@@ -135,11 +140,24 @@ This increases throughput by parallelizing the CPU-intensive serialization,
 leaving the single-threaded worker free to focus solely on I/O syscalls.
 */
 func (w *wal) Append(record WALRecord) error {
+	// Markers are only written by AppendAtomic
+	if record.Type == RecordBegin || record.Type == RecordCommit {
+		return ErrInvalidRecord
+	}
+
 	payload, err := EncodeRecord(record)
 	if err != nil {
 		return err
 	}
 
+	return w.appendPayload(payload)
+}
+
+/*
+appendPayload hands an already encoded payload to the worker
+and waits for it to be written (and fsynced, per SyncPolicy).
+*/
+func (w *wal) appendPayload(payload string) error {
 	reply := make(chan response, 1)
 
 	select {
@@ -157,6 +175,38 @@ func (w *wal) Append(record WALRecord) error {
 	}
 }
 
+/*
+AppendAtomic durably records a group of mutations as one unit.
+
+The records are framed by BEGIN and COMMIT markers and handed to the
+worker as a single payload, so no other Append can interleave with
+them and a single fsync covers the whole group. Replay only applies
+the group once it has seen the COMMIT marker.
+*/
+func (w *wal) AppendAtomic(records []WALRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	var sb strings.Builder
+	sb.WriteString(commandBegin + "\n")
+	for _, record := range records {
+		// Markers are owned by the WAL; nested groups are not supported.
+		if record.Type == RecordBegin || record.Type == RecordCommit {
+			return ErrInvalidRecord
+		}
+
+		payload, err := EncodeRecord(record)
+		if err != nil {
+			return err
+		}
+		sb.WriteString(payload)
+	}
+	sb.WriteString(commandCommit + "\n")
+
+	return w.appendPayload(sb.String())
+}
+
 /*
 Close flushes and gracefully shuts down the WAL.
 
@@ -208,6 +258,14 @@ WAL recovery stops at the first invalid record.
 All prior records are considered durable and applied.
 Remaining bytes are ignored.
 
+Every record is encoded with a trailing newline, so a final line
+without one was torn by a crash mid-append and is discarded even if
+its prefix happens to decode. This keeps multi-key records atomic.
+
+Records between BEGIN and COMMIT markers are buffered and applied
+only once COMMIT is read. A group cut short by a crash (or by
+corruption) is discarded entirely, so replay never applies half of it.
+
 Performance Note:
 This is a blocking operation meant to run during the "Cold Start" phase.
 It does not use the worker goroutine as the system is not yet concurrent.
@@ -219,9 +277,22 @@ func (w *wal) Replay(apply func(WALRecord) error) error {
 	}
 	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
+	// pending buffers the records of an open BEGIN/COMMIT group
+	var pending []WALRecord
+	inGroup := false
+
+	reader := bufio.NewReader(file)
+	for {
+		raw, err := reader.ReadString('\n')
+		if errors.Is(err, io.EOF) {
+			// Clean end of log, or a torn final record
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		line := strings.TrimSpace(raw)
 		if line == "" {
 			continue
 		}
@@ -233,12 +304,65 @@ func (w *wal) Replay(apply func(WALRecord) error) error {
 			return nil
 		}
 
+		switch rec.Type {
+		case RecordBegin:
+			// Nested BEGIN = corruption = truncate
+			if inGroup {
+				return nil
+			}
+			inGroup = true
+			pending = pending[:0]
+			continue
+
+		case RecordCommit:
+			// COMMIT without BEGIN = corruption = truncate
+			if !inGroup {
+				return nil
+			}
+			inGroup = false
+			for _, r := range pending {
+				if err := apply(r); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		if inGroup {
+			pending = append(pending, rec)
+			continue
+		}
+
 		// apply failure = fatal error
 		if err := apply(rec); err != nil {
 			return err
 		}
 	}
-	return scanner.Err()
+}
+
+/*
+Checkpoint durably marks the current end of the log with id, fsynced
+whatever the SyncPolicy. A snapshot holding every record logged so far
+records the same id: until the log is rotated, replay uses the mark to
+skip the records the snapshot already holds.
+*/
+func (w *wal) Checkpoint(id uint64) error {
+	payload, err := EncodeRecord(WALRecord{Type: RecordCheckpoint, Checkpoint: id})
+	if err != nil {
+		return err
+	}
+	if err := w.appendPayload(payload); err != nil {
+		return err
+	}
+
+	reply := make(chan response, 1)
+	select {
+	case w.reqChan <- request{operation: opSync, reply: reply}:
+		resp := <-reply
+		return resp.err
+	case <-w.doneChan:
+		return ErrWALClosed
+	}
 }
 
 /*
@@ -332,6 +332,39 @@ func TestWAL_ReplayStopsOnCorruption(t *testing.T) {
 		t.Fatalf("expected 1 valid record before corruption, got %d", count)
 	}
 }
+
+func TestWAL_ReplayDiscardsTornFinalRecord(t *testing.T) {
+	f, err := os.CreateTemp("", "wal_torn_*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := f.Name()
+	defer os.Remove(path)
+
+	// The second record decodes, but lacks its newline terminator
+	_, _ = f.WriteString("SET a YQ==\n")
+	_, _ = f.WriteString("MSET b Yg==")
+	f.Close()
+
+	w, err := NewWAL(Config{Path: path, SyncPolicy: SyncEveryWrite})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	var keys []string
+	err = w.Replay(func(r WALRecord) error {
+		keys = append(keys, r.Key)
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("replay should succeed with truncation, got %v", err)
+	}
+	if len(keys) != 1 || keys[0] != "a" {
+		t.Fatalf("expected only the complete record, got %v", keys)
+	}
+}
 func TestWAL_AppendAfterCloseFastPath(t *testing.T) {
 	w, _, cleanup := newTempWAL(t, SyncEveryWrite)
 	defer cleanup()
@@ -474,3 +507,103 @@ func TestWAL_RotateRenameFailure(t *testing.T) {
 		t.Fatal("expected rotate failure")
 	}
 }
+
+func TestWAL_AppendAtomicReplay(t *testing.T) {
+	w, _, cleanup := newTempWAL(t, SyncEveryWrite)
+	defer cleanup()
+
+	err := w.AppendAtomic([]WALRecord{
+		{Type: RecordSet, Key: "a", Value: "1"},
+		{Type: RecordExpire, Key: "a", Expire: 10},
+	})
+	if err != nil {
+		t.Fatalf("append atomic failed: %v", err)
+	}
+
+	var got []WALRecord
+	err = w.Replay(func(r WALRecord) error {
+		got = append(got, r)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+
+	if len(got) != 2 || got[0].Type != RecordSet || got[1].Type != RecordExpire {
+		t.Fatalf("expected group records without markers, got %+v", got)
+	}
+}
+
+func TestWAL_AppendAtomicEmptyIsNoop(t *testing.T) {
+	w, path, cleanup := newTempWAL(t, SyncEveryWrite)
+	defer cleanup()
+
+	if err := w.AppendAtomic(nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 0 {
+		t.Fatalf("empty group must not write markers")
+	}
+}
+
+func TestWAL_MarkersAreReserved(t *testing.T) {
+	w, _, cleanup := newTempWAL(t, SyncEveryWrite)
+	defer cleanup()
+
+	if err := w.Append(WALRecord{Type: RecordBegin}); !errors.Is(err, ErrInvalidRecord) {
+		t.Fatalf("expected ErrInvalidRecord for raw marker, got %v", err)
+	}
+
+	err := w.AppendAtomic([]WALRecord{{Type: RecordCommit}})
+	if !errors.Is(err, ErrInvalidRecord) {
+		t.Fatalf("expected ErrInvalidRecord for nested marker, got %v", err)
+	}
+}
+
+func TestWAL_ReplayDiscardsIncompleteGroup(t *testing.T) {
+	tests := []struct {
+		name string
+		log  string
+	}{
+		{name: "MissingCommit", log: "SET a YQ==\nBEGIN\nSET b Yg==\n"},
+		{name: "NestedBegin", log: "SET a YQ==\nBEGIN\nSET b Yg==\nBEGIN\nCOMMIT\n"},
+		{name: "CommitWithoutBegin", log: "SET a YQ==\nCOMMIT\nSET b Yg==\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := os.CreateTemp("", "wal_group_*.log")
+			if err != nil {
+				t.Fatal(err)
+			}
+			path := f.Name()
+			defer os.Remove(path)
+
+			_, _ = f.WriteString(tt.log)
+			f.Close()
+
+			w, err := NewWAL(Config{Path: path, SyncPolicy: SyncEveryWrite})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer w.Close()
+
+			var keys []string
+			err = w.Replay(func(r WALRecord) error {
+				keys = append(keys, r.Key)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("replay should succeed with truncation, got %v", err)
+			}
+			if len(keys) != 1 || keys[0] != "a" {
+				t.Fatalf("expected only records before the broken group, got %v", keys)
+			}
+		})
+	}
+}